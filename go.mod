@@ -1,3 +1,5 @@
 module snapsync
 
 go 1.22
+
+require golang.org/x/crypto v0.33.0
@@ -3,11 +3,17 @@ package cli
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
 	"snapsync/internal/discovery"
+	apperrors "snapsync/internal/errors"
+	"snapsync/internal/identity"
+	"snapsync/internal/store"
 	"snapsync/internal/transfer"
 )
 
@@ -22,9 +28,21 @@ func (f fakeResolver) ResolveByID(_ context.Context, _ string) (discovery.Peer,
 	return discovery.Peer{}, nil
 }
 
+func stubLocalState(t *testing.T, root *RootCommand) *store.TrustStore {
+	t.Helper()
+	ts := store.NewTrustStore(filepath.Join(t.TempDir(), "trusted_peers.json"))
+	root.identity = func() (identity.Identity, error) { return identity.Generate("local") }
+	root.trust = func() (*store.TrustStore, error) { return ts, nil }
+	root.config = func() (store.Config, error) { return store.Config{}, nil }
+	controlDir := t.TempDir()
+	root.controlDir = func() (string, error) { return controlDir, nil }
+	return ts
+}
+
 func TestSendPeerIDResolvesAndCallsTransfer(t *testing.T) {
 	buf := &bytes.Buffer{}
-	root := NewRootCommand(buf, buf, strings.NewReader(""))
+	root := NewRootCommand(buf, buf, strings.NewReader("y\n"))
+	ts := stubLocalState(t, root)
 	root.resolver = fakeResolver{peers: []discovery.Peer{{ID: "peer1", Addresses: []string{"192.168.1.10"}, Port: 45999}}}
 	called := false
 	root.sendFunc = func(opts transfer.SenderOptions) error {
@@ -32,7 +50,13 @@ func TestSendPeerIDResolvesAndCallsTransfer(t *testing.T) {
 		if opts.Address != "192.168.1.10:45999" {
 			t.Fatalf("address mismatch got %q", opts.Address)
 		}
-		return nil
+		if opts.Identity == nil || opts.VerifyPeer == nil {
+			t.Fatal("expected encrypted send with receiver verification")
+		}
+		if err := opts.VerifyPeer(transfer.PeerIdentity{PeerID: "impostor"}); err == nil {
+			t.Fatal("expected mismatched receiver peer id to be refused")
+		}
+		return opts.VerifyPeer(transfer.PeerIdentity{PeerID: "peer1", Fingerprint: "aa11"})
 	}
 	root.SetArgs([]string{"send", "./file.bin", "--to", "peer1"})
 	if err := root.Execute(); err != nil {
@@ -41,11 +65,45 @@ func TestSendPeerIDResolvesAndCallsTransfer(t *testing.T) {
 	if !called {
 		t.Fatal("expected sendFunc to be called")
 	}
+	if !strings.Contains(buf.String(), "Its fingerprint is aa11") {
+		t.Fatalf("expected first-use fingerprint prompt, got %q", buf.String())
+	}
+	if rec, ok, _ := ts.Lookup("peer1"); !ok || rec.Fingerprint != "aa11" {
+		t.Fatalf("expected confirmed fingerprint to be pinned, got %+v ok=%v", rec, ok)
+	}
+}
+
+func TestSendRefusesUnconfirmedKey(t *testing.T) {
+	for _, tc := range []struct {
+		answer, path, to string
+	}{
+		{"n\n", "./file.bin", "peer1"},
+		{"y\n", transfer.StdinPath, "peer1"},
+		// A bare address authenticates nobody until its key is pinned.
+		{"n\n", "./file.bin", "192.168.1.10:45999"},
+		{"y\n", transfer.StdinPath, "192.168.1.10:45999"},
+	} {
+		buf := &bytes.Buffer{}
+		root := NewRootCommand(buf, buf, strings.NewReader(tc.answer))
+		ts := stubLocalState(t, root)
+		root.resolver = fakeResolver{peers: []discovery.Peer{{ID: "peer1", Addresses: []string{"192.168.1.10"}, Port: 45999}}}
+		root.sendFunc = func(opts transfer.SenderOptions) error {
+			return opts.VerifyPeer(transfer.PeerIdentity{PeerID: "peer1", Fingerprint: "ff00"})
+		}
+		root.SetArgs([]string{"send", tc.path, "--to", tc.to})
+		if err := root.Execute(); err == nil {
+			t.Fatalf("expected unconfirmed key for %s to %s to be refused", tc.path, tc.to)
+		}
+		if _, ok, _ := ts.Lookup("peer1"); ok {
+			t.Fatal("expected refused key to stay unpinned")
+		}
+	}
 }
 
 func TestSendHostPortBypassesResolver(t *testing.T) {
 	buf := &bytes.Buffer{}
 	root := NewRootCommand(buf, buf, strings.NewReader(""))
+	stubLocalState(t, root)
 	root.resolver = fakeResolver{peers: nil}
 	root.sendFunc = func(opts transfer.SenderOptions) error {
 		if opts.Address != "10.0.0.5:45999" {
@@ -72,3 +130,131 @@ func TestListPrintsPeers(t *testing.T) {
 		t.Fatalf("unexpected list output: %q", out)
 	}
 }
+
+func TestSendInsecureSkipsIdentity(t *testing.T) {
+	buf := &bytes.Buffer{}
+	root := NewRootCommand(buf, buf, strings.NewReader(""))
+	stubLocalState(t, root)
+	root.identity = func() (identity.Identity, error) {
+		t.Fatal("identity should not be loaded for --insecure")
+		return identity.Identity{}, nil
+	}
+	root.sendFunc = func(opts transfer.SenderOptions) error {
+		if opts.Identity != nil {
+			t.Fatal("expected plaintext send")
+		}
+		return nil
+	}
+	root.SetArgs([]string{"send", "./file.bin", "--to", "10.0.0.5:45999", "--insecure"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+}
+
+func TestSendCodeUsesPairing(t *testing.T) {
+	buf := &bytes.Buffer{}
+	root := NewRootCommand(buf, buf, strings.NewReader(""))
+	stubLocalState(t, root)
+	root.sendFunc = func(opts transfer.SenderOptions) error {
+		if opts.Code != "7-purple-tiger" || opts.Identity != nil {
+			t.Fatalf("expected code pairing without identity, got %#v", opts)
+		}
+		return nil
+	}
+	root.SetArgs([]string{"send", "./file.bin", "--to", "10.0.0.5:45999", "--code", "7-purple-tiger"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	root.SetArgs([]string{"send", "./file.bin", "--to", "10.0.0.5:45999", "--code", "purple"})
+	if err := root.Execute(); err == nil {
+		t.Fatal("expected malformed code to be rejected")
+	}
+}
+
+func TestGetResolvesSharingPeer(t *testing.T) {
+	buf := &bytes.Buffer{}
+	root := NewRootCommand(buf, buf, strings.NewReader(""))
+	stubLocalState(t, root)
+	root.resolver = fakeResolver{peers: []discovery.Peer{
+		{ID: "peer1", Addresses: []string{"192.168.1.10"}, Port: 45999},
+		{ID: "peer1", Role: discovery.RoleShare, Addresses: []string{"192.168.1.10"}, Port: 46000},
+	}}
+	called := false
+	root.get = func(opts transfer.GetOptions, name string) error {
+		called = true
+		if opts.Address != "192.168.1.10:46000" || name != "report.pdf" || opts.OutDir != "dl" || !opts.Resume {
+			t.Fatalf("unexpected get: %+v name=%q", opts, name)
+		}
+		if opts.Identity == nil || opts.VerifyPeer(transfer.PeerIdentity{PeerID: "impostor"}) == nil {
+			t.Fatal("expected encrypted get that verifies the sharing peer")
+		}
+		return nil
+	}
+	root.SetArgs([]string{"get", "peer1", "report.pdf", "--out", "dl"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if !called {
+		t.Fatal("expected get to be called")
+	}
+}
+
+func TestGetWithoutNameListsShares(t *testing.T) {
+	buf := &bytes.Buffer{}
+	root := NewRootCommand(buf, buf, strings.NewReader(""))
+	stubLocalState(t, root)
+	root.shares = func(opts transfer.GetOptions) ([]transfer.ShareEntry, error) {
+		if opts.Address != "10.0.0.5:46000" {
+			t.Fatalf("address mismatch got %q", opts.Address)
+		}
+		return []transfer.ShareEntry{{Name: "photos", Size: 2048, Dir: true}}, nil
+	}
+	root.SetArgs([]string{"get", "10.0.0.5:46000"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if out := buf.String(); !strings.Contains(out, "photos") || !strings.Contains(out, "dir") {
+		t.Fatalf("unexpected share list: %q", out)
+	}
+}
+
+func TestSendFanoutResolvesTargetsAndReportsFailures(t *testing.T) {
+	buf := &bytes.Buffer{}
+	root := NewRootCommand(buf, buf, strings.NewReader(""))
+	stubLocalState(t, root)
+	root.resolver = fakeResolver{peers: []discovery.Peer{
+		{ID: "lab-01", Addresses: []string{"192.168.1.11"}, Port: 45999},
+		{ID: "lab-02", Addresses: []string{"192.168.1.12"}, Port: 45999},
+		{ID: "lab-03", Role: discovery.RoleShare, Addresses: []string{"192.168.1.13"}, Port: 46000},
+		{ID: "desk", Addresses: []string{"192.168.1.20"}, Port: 45999},
+	}}
+	var got []transfer.FanoutTarget
+	root.fanout = func(opts transfer.SenderOptions, targets []transfer.FanoutTarget) ([]transfer.FanoutResult, error) {
+		got = targets
+		results := make([]transfer.FanoutResult, len(targets))
+		for i, target := range targets {
+			results[i] = transfer.FanoutResult{Label: target.Label, Address: target.Address, Sent: 10}
+		}
+		results[1].Err = fmt.Errorf("dial receiver: %w", apperrors.ErrNetwork)
+		return results, nil
+	}
+	root.SetArgs([]string{"send", "./bundle.tar", "--to", "lab-*", "--to", "10.0.0.5:45999", "--to", "lab-01"})
+	err := root.Execute()
+	if !errors.Is(err, apperrors.ErrNetwork) {
+		t.Fatalf("expected failure of one receiver to fail the command, got %v", err)
+	}
+	if len(got) != 3 || got[0].Address != "192.168.1.11:45999" || got[1].Address != "192.168.1.12:45999" || got[2].Label != "10.0.0.5:45999" {
+		t.Fatalf("unexpected targets %+v", got)
+	}
+	if got[0].VerifyPeer(transfer.PeerIdentity{PeerID: "lab-02"}) == nil {
+		t.Fatal("expected each target to verify its own peer id")
+	}
+	if out := buf.String(); !strings.Contains(out, "lab-02") || !strings.Contains(out, "failed: dial receiver") || !strings.Contains(out, "ok, 10 bytes sent") {
+		t.Fatalf("unexpected outcome table: %q", out)
+	}
+
+	root.SetArgs([]string{"send", "./bundle.tar", "--all", "--delta"})
+	if err := root.Execute(); !errors.Is(err, apperrors.ErrUsage) {
+		t.Fatalf("expected --delta to be refused for fan-out, got %v", err)
+	}
+}
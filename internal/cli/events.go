@@ -0,0 +1,72 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+
+	"snapsync/internal/progress"
+	"snapsync/internal/transfer"
+)
+
+// progressOutput picks how a transfer command reports to out. With --json, events
+// go to stdout and messages move to stderr. On a terminal, a live view shows every
+// active transfer, with messages printed above it. Otherwise progress is the plain
+// status line. It returns the --json event stream, or nil, the observer, and the
+// writer for messages.
+func (r *RootCommand) progressOutput(jsonOut bool, out io.Writer) (*progress.JSONStream, progress.Observer, io.Writer) {
+	if jsonOut {
+		events := progress.NewJSONStream(r.out)
+		return events, events, r.errOut
+	}
+	if isTerminal(out) {
+		tty := progress.NewTTY(out)
+		return nil, tty, tty
+	}
+	return nil, progress.NewPlain(out), out
+}
+
+// isTerminal reports whether w is a terminal the live view can draw on. Windows
+// consoles and dumb terminals get the plain status line.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok || runtime.GOOS == "windows" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// emitError reports a failed command as the final event of the stream.
+func emitError(events *progress.JSONStream, direction string, err error) {
+	if err == nil {
+		return
+	}
+	e := progress.ErrorEvent(err)
+	e.Direction = direction
+	events.Observe(e)
+}
+
+// fanoutEvents emits an error event for each failed receiver in place of the
+// fan-out result table.
+func fanoutEvents(events *progress.JSONStream, results []transfer.FanoutResult) error {
+	var firstErr error
+	failed := 0
+	for _, res := range results {
+		if res.Err == nil {
+			continue
+		}
+		e := progress.ErrorEvent(res.Err)
+		e.Direction, e.Peer = "sending", res.Label
+		events.Observe(e)
+		failed++
+		if firstErr == nil {
+			firstErr = res.Err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d receivers failed: %w", failed, len(results), firstErr)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package cli
+
+import (
+	"context"
+	"encoding/hex"
+
+	"snapsync/internal/hooks"
+	"snapsync/internal/transfer"
+)
+
+// preAcceptHook runs command for each offer; a failing command vetoes it.
+func preAcceptHook(command string) func(transfer.OfferedFile) error {
+	if command == "" {
+		return nil
+	}
+	return func(f transfer.OfferedFile) error {
+		ctx, cancel := context.WithTimeout(context.Background(), hooks.PreAcceptTimeout)
+		defer cancel()
+		return hooks.Run(ctx, command, hookEvent(hooks.PreAccept, f))
+	}
+}
+
+// postReceiveHook runs command for each finalized file.
+func postReceiveHook(command string) func(transfer.ReceivedFile) error {
+	if command == "" {
+		return nil
+	}
+	return func(f transfer.ReceivedFile) error {
+		e := hookEvent(hooks.PostReceive, f.OfferedFile)
+		e.Path, e.Hash, e.Digest, e.DurationMS = f.Path, f.Algorithm.String(), hex.EncodeToString(f.Digest), f.Duration.Milliseconds()
+		ctx, cancel := context.WithTimeout(context.Background(), hooks.PostReceiveTimeout)
+		defer cancel()
+		return hooks.Run(ctx, command, e)
+	}
+}
+
+func hookEvent(hook string, f transfer.OfferedFile) hooks.Event {
+	size := int64(-1)
+	if f.Size != transfer.UnknownSize {
+		size = int64(f.Size)
+	}
+	return hooks.Event{Hook: hook, Name: f.Name, Size: size, Peer: f.Peer, PeerID: f.PeerID, Fingerprint: f.Fingerprint}
+}
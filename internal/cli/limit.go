@@ -0,0 +1,152 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	apperrors "snapsync/internal/errors"
+	"snapsync/internal/store"
+	"snapsync/internal/throttle"
+)
+
+func (r *RootCommand) printLimitHelp() error {
+	const msg = `Usage:
+  snapsync limit           (show the bandwidth limit of every running transfer)
+  snapsync limit <rate>    (change it, e.g. 5MB/s or unlimited)
+`
+	_, err := fmt.Fprint(r.out, msg)
+	return err
+}
+
+// runLimit reports or changes the rate of every running send, recv, serve, and get
+// through their control sockets.
+func (r *RootCommand) runLimit(args []string) error {
+	if len(args) == 1 && (args[0] == "--help" || args[0] == "-h") {
+		return r.printLimitHelp()
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("limit accepts at most one rate: %w", apperrors.ErrUsage)
+	}
+	rate := ""
+	if len(args) == 1 {
+		if _, err := throttle.ParseRate(args[0]); err != nil {
+			return err
+		}
+		rate = args[0]
+	}
+	dir, err := r.controlDir()
+	if err != nil {
+		return fmt.Errorf("resolve control directory: %w", err)
+	}
+	sockets, err := filepath.Glob(filepath.Join(dir, "*.sock"))
+	if err != nil {
+		return fmt.Errorf("list control sockets: %w", err)
+	}
+	found := 0
+	for _, path := range sockets {
+		reply, err := throttle.Control(path, rate)
+		if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, os.ErrNotExist) {
+			// The process exited, maybe without removing its socket.
+			_ = os.Remove(path)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if found == 0 {
+			if _, err := fmt.Fprintf(r.out, "%-8s %s\n", "PID", "LIMIT"); err != nil {
+				return fmt.Errorf("write limit header: %w", err)
+			}
+		}
+		found++
+		if _, err := fmt.Fprintf(r.out, "%-8s %s\n", strings.TrimSuffix(filepath.Base(path), ".sock"), reply); err != nil {
+			return fmt.Errorf("write limit row: %w", err)
+		}
+	}
+	if found == 0 {
+		_, err := fmt.Fprintln(r.out, "No running transfers.")
+		return err
+	}
+	return nil
+}
+
+// limitFlag registers --limit, shared by send, recv, serve, and get.
+func limitFlag(fs *flag.FlagSet) *string {
+	return fs.String("limit", "", "bandwidth cap such as 20MB/s; defaults to the config schedule, else unlimited")
+}
+
+// rateLimiter returns the limiter for one transfer command: limit when given,
+// otherwise one following the config schedule, which is unlimited when there is
+// none. The limiter listens on a control socket so `snapsync limit` can change it
+// while the command runs; the returned func stops the schedule and the socket.
+func (r *RootCommand) rateLimiter(limit string) (*throttle.Limiter, func(), error) {
+	var schedule throttle.Schedule
+	var rate int64
+	if limit != "" {
+		parsed, err := throttle.ParseRate(limit)
+		if err != nil {
+			return nil, nil, err
+		}
+		rate = parsed
+	} else {
+		cfg, err := r.config()
+		if err != nil {
+			return nil, nil, fmt.Errorf("load config: %w", err)
+		}
+		if schedule, err = limitSchedule(cfg); err != nil {
+			return nil, nil, err
+		}
+		rate = schedule.RateAt(time.Now())
+	}
+	l := throttle.NewLimiter(rate)
+	ctx, cancel := context.WithCancel(context.Background())
+	go l.Follow(ctx, schedule)
+	closeControl := r.listenControl(l)
+	return l, func() {
+		cancel()
+		closeControl()
+	}, nil
+}
+
+// limitSchedule turns the config's limit_schedule into a schedule.
+func limitSchedule(cfg store.Config) (throttle.Schedule, error) {
+	windows := make([]throttle.Window, 0, len(cfg.LimitSchedule))
+	for _, w := range cfg.LimitSchedule {
+		window, err := throttle.ParseWindow(w.After, w.Limit)
+		if err != nil {
+			return throttle.Schedule{}, fmt.Errorf("config limit_schedule entry after %q: %w", w.After, err)
+		}
+		windows = append(windows, window)
+	}
+	return throttle.NewSchedule(windows), nil
+}
+
+// listenControl serves l on this process's control socket. Without one the
+// transfer still runs; only runtime changes are lost.
+func (r *RootCommand) listenControl(l *throttle.Limiter) func() {
+	dir, err := r.controlDir()
+	if err == nil {
+		err = os.MkdirAll(dir, 0o700)
+	}
+	var ln net.Listener
+	if err == nil {
+		// A socket already named for this pid was left by an earlier process.
+		path := filepath.Join(dir, fmt.Sprintf("%d.sock", os.Getpid()))
+		_ = os.Remove(path)
+		ln, err = net.Listen("unix", path)
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(r.errOut, "Runtime limit changes unavailable: %v\n", err)
+		return func() {}
+	}
+	go throttle.ServeControl(ln, l)
+	return func() { _ = ln.Close() }
+}
@@ -11,12 +11,22 @@ import (
 	"io"
 	"net"
 	"os"
+	"os/signal"
+	"path"
 	"path/filepath"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"snapsync/internal/discovery"
 	apperrors "snapsync/internal/errors"
+	"snapsync/internal/hash"
+	"snapsync/internal/identity"
+	"snapsync/internal/pake"
+	"snapsync/internal/policy"
+	"snapsync/internal/progress"
+	"snapsync/internal/store"
 	"snapsync/internal/transfer"
 )
 
@@ -34,20 +44,35 @@ type RootCommand struct {
 	out      io.Writer
 	errOut   io.Writer
 	in       io.Reader
+	input    *bufio.Reader
 	commands []Command
 	args     []string
 	resolver discovery.Resolver
 	sendFunc func(transfer.SenderOptions) error
+	fanout   func(transfer.SenderOptions, []transfer.FanoutTarget) ([]transfer.FanoutResult, error)
+	serve    func(context.Context, transfer.ReceiverOptions) error
+	share    func(context.Context, transfer.ShareOptions) error
+	get      func(transfer.GetOptions, string) error
+	shares   func(transfer.GetOptions) ([]transfer.ShareEntry, error)
+	identity func() (identity.Identity, error)
+	trust    func() (*store.TrustStore, error)
+	// config loads per-user settings; controlDir locates the limit control sockets.
+	config     func() (store.Config, error)
+	controlDir func() (string, error)
 }
 
 // NewRootCommand creates the SnapSync root command.
 func NewRootCommand(out io.Writer, errOut io.Writer, in io.Reader) *RootCommand {
-	root := &RootCommand{out: out, errOut: errOut, in: in, resolver: discovery.MDNSResolver{}, sendFunc: transfer.Send}
+	root := &RootCommand{out: out, errOut: errOut, in: in, resolver: discovery.MDNSResolver{}, sendFunc: transfer.Send, fanout: transfer.SendFanout, serve: transfer.Serve, share: transfer.Share, get: transfer.Get, shares: transfer.ListShares, identity: loadLocalIdentity, trust: store.OpenTrustStore, config: store.LoadConfig, controlDir: store.ControlDir}
 	root.commands = []Command{
 		NewVersionCommand(out),
 		{name: "send", run: root.runSend},
 		{name: "recv", run: root.runRecv},
 		{name: "list", run: root.runList},
+		{name: "trust", run: root.runTrust},
+		{name: "serve", run: root.runServe},
+		{name: "get", run: root.runGet},
+		{name: "limit", run: root.runLimit},
 	}
 	return root
 }
@@ -74,6 +99,14 @@ func (r *RootCommand) Execute() error {
 		return r.commands[2].run(r.args[1:])
 	case "list":
 		return r.commands[3].run(r.args[1:])
+	case "trust":
+		return r.commands[4].run(r.args[1:])
+	case "serve":
+		return r.commands[5].run(r.args[1:])
+	case "get":
+		return r.commands[6].run(r.args[1:])
+	case "limit":
+		return r.commands[7].run(r.args[1:])
 	default:
 		if _, err := fmt.Fprintf(r.errOut, "unknown command %q\n", r.args[0]); err != nil {
 			return fmt.Errorf("write unknown command error: %w", err)
@@ -86,7 +119,7 @@ func (r *RootCommand) Execute() error {
 }
 
 func (r *RootCommand) printHelp() error {
-	const help = "SnapSync is a LAN file transfer tool\n\nUsage:\n  snapsync [command]\n\nAvailable Commands:\n  list     List discovered peers\n  recv     Receive a file over TCP\n  send     Send a file over TCP\n  version  Print version information\n\nFlags:\n  -h, --help  help for snapsync\n"
+	const help = "SnapSync is a LAN file transfer tool\n\nUsage:\n  snapsync [command]\n\nAvailable Commands:\n  get      Pull a shared file or directory from a peer\n  limit    Show or change the bandwidth limit of running transfers\n  list     List discovered peers\n  recv     Receive a file over TCP\n  send     Send a file or directory over TCP\n  serve    Share files and directories for peers to pull\n  trust    Manage trusted peers\n  version  Print version information\n\nFlags:\n  -h, --help  help for snapsync\n"
 	if _, err := fmt.Fprint(r.out, help); err != nil {
 		return fmt.Errorf("write help output: %w", err)
 	}
@@ -95,7 +128,7 @@ func (r *RootCommand) printHelp() error {
 
 func (r *RootCommand) printSendHelp() error {
 	const msg = `Usage:
-  snapsync send <path> --to <peer-id|host:port> [--timeout 2s] [--name name] [--no-resume]
+  snapsync send <path|-> --to <peer-id|glob|host:port>... | --all [--timeout 2s] [--name name] [--no-resume] [--insecure] [--code <pairing-code>] [--hash blake3|sha256|xxh3] [--streams 1] [--compress] [--delta] [--retries 0] [--retry-backoff 1s] [--limit 20MB/s] [--handshake-timeout 10s] [--idle-timeout 30s] [--total-timeout 0] [--json]
 `
 	_, err := fmt.Fprint(r.out, msg)
 	return err
@@ -103,7 +136,24 @@ func (r *RootCommand) printSendHelp() error {
 
 func (r *RootCommand) printRecvHelp() error {
 	const msg = `Usage:
-  snapsync recv --listen :45999 --out <dir> [--accept] [--no-discovery] [--no-resume] [--keep-partial] [--force-restart] [--break-lock]
+  snapsync recv --listen :45999 --out <dir>|--stdout [--accept] [--no-discovery] [--no-resume] [--keep-partial] [--force-restart] [--break-lock] [--allow-insecure] [--code] [--untrusted prompt|reject] [--policy rules.json] [--pre-accept cmd] [--post-receive cmd] [--serve] [--max-concurrent 4] [--preserve mode,mtime] [--limit 20MB/s] [--handshake-timeout 10s] [--idle-timeout 30s] [--total-timeout 0] [--json]
+`
+	_, err := fmt.Fprint(r.out, msg)
+	return err
+}
+
+func (r *RootCommand) printServeHelp() error {
+	const msg = `Usage:
+  snapsync serve <path...> --listen :46000 [--accept] [--untrusted prompt|reject] [--name name] [--no-discovery] [--allow-insecure] [--max-concurrent 4] [--compress] [--delta] [--limit 20MB/s] [--handshake-timeout 10s] [--idle-timeout 30s] [--total-timeout 0]
+`
+	_, err := fmt.Fprint(r.out, msg)
+	return err
+}
+
+func (r *RootCommand) printGetHelp() error {
+	const msg = `Usage:
+  snapsync get <peer-id|host:port> [name] --out <dir> [--timeout 2s] [--insecure] [--hash blake3|sha256|xxh3] [--no-resume] [--keep-partial] [--force-restart] [--break-lock] [--overwrite] [--preserve mode,mtime] [--limit 20MB/s] [--handshake-timeout 10s] [--idle-timeout 30s] [--total-timeout 0]
+  snapsync get <peer-id|host:port>   (list the peer's shares)
 `
 	_, err := fmt.Fprint(r.out, msg)
 	return err
@@ -117,60 +167,313 @@ func (r *RootCommand) printListHelp() error {
 	return err
 }
 
-func (r *RootCommand) runSend(args []string) error {
+func (r *RootCommand) runSend(args []string) (err error) {
 	if len(args) == 1 && (args[0] == "--help" || args[0] == "-h") {
 		return r.printSendHelp()
 	}
 	if len(args) == 0 {
-		return fmt.Errorf("send requires a file path argument: %w", apperrors.ErrUsage)
+		return fmt.Errorf("send requires a file or directory path argument: %w", apperrors.ErrUsage)
 	}
 	path := filepath.Clean(args[0])
 	fs := flag.NewFlagSet("send", flag.ContinueOnError)
 	fs.SetOutput(io.Discard)
-	to := fs.String("to", "", "receiver host:port or peer id")
+	var to stringList
+	fs.Var(&to, "to", "receiver host:port, peer id, or peer id glob; repeat for several receivers")
+	all := fs.Bool("all", false, "send to every discovered receiver")
 	name := fs.String("name", "", "override transfer filename")
 	timeout := fs.Duration("timeout", 2*time.Second, "discovery timeout")
 	noResume := fs.Bool("no-resume", false, "disable resume")
+	insecure := fs.Bool("insecure", false, "send without encryption and peer authentication")
+	code := fs.String("code", "", "pairing code printed by recv --code")
+	hashName := fs.String("hash", hash.Default.String(), "integrity hash: blake3, sha256, or xxh3")
+	streams := fs.Int("streams", 1, "parallel connections per large file")
+	compress := fs.Bool("compress", false, "compress data on the wire when the receiver supports it")
+	delta := fs.Bool("delta", false, "send only the blocks that differ from the receiver's existing copy")
+	retries := fs.Int("retries", 0, "redial and resume this many times after a network failure")
+	retryBackoff := fs.Duration("retry-backoff", transfer.DefaultRetryBackoff, "delay before the first retry, doubled per attempt")
+	jsonOut := fs.Bool("json", false, "write NDJSON events to stdout and messages to stderr")
+	timeouts := timeoutFlags(fs)
+	limit := limitFlag(fs)
 	if err := fs.Parse(args[1:]); err != nil {
 		return fmt.Errorf("parse send flags: %w: %w", err, apperrors.ErrUsage)
 	}
+	events, observer, msgOut := r.progressOutput(*jsonOut, r.out)
+	defer func() { emitError(events, "sending", err) }()
 	if len(fs.Args()) > 0 {
 		return fmt.Errorf("send accepts one path followed by flags: %w", apperrors.ErrUsage)
 	}
-	if *to == "" {
-		return fmt.Errorf("send requires --to: %w", apperrors.ErrUsage)
+	if len(to) == 0 && !*all {
+		return fmt.Errorf("send requires --to or --all: %w", apperrors.ErrUsage)
+	}
+	alg, err := hash.ParseAlgorithm(*hashName)
+	if err != nil {
+		return err
+	}
+	if *streams < 1 || *streams > transfer.MaxStreams {
+		return fmt.Errorf("--streams must be between 1 and %d: %w", transfer.MaxStreams, apperrors.ErrUsage)
+	}
+	if *retries < 0 || *retryBackoff <= 0 {
+		return fmt.Errorf("--retries must not be negative and --retry-backoff must be positive: %w", apperrors.ErrUsage)
+	}
+	if err := validateTimeouts(*timeouts); err != nil {
+		return err
+	}
+	limiter, stopLimit, err := r.rateLimiter(*limit)
+	if err != nil {
+		return err
+	}
+	defer stopLimit()
+	cancel, stopCancel := r.interruptible()
+	defer stopCancel()
+
+	if *all || len(to) > 1 || isPeerGlob(to[0]) {
+		return r.runFanout(path, to, *all, *timeout, *insecure, *code, transfer.SenderOptions{Path: path, OverrideName: *name, Out: msgOut, Resume: !*noResume, Hash: alg, Streams: *streams, Compress: *compress, Delta: *delta, Retries: *retries, Timeouts: *timeouts, Limit: limiter, Cancel: cancel, Observer: observer})
+	}
+	address, err := r.resolveAddress(to[0], *timeout, discovery.RoleReceiver)
+	if err != nil {
+		return err
 	}
 
-	address := *to
-	if !strings.Contains(*to, ":") {
-		peer, err := r.resolver.Browse(context.Background(), *timeout)
+	opts := transfer.SenderOptions{Path: path, Address: address, OverrideName: *name, Out: msgOut, Resume: !*noResume, Hash: alg, Streams: *streams, Compress: *compress, Delta: *delta, Input: r.in, Retries: *retries, RetryBackoff: *retryBackoff, Timeouts: *timeouts, Limit: limiter, Cancel: cancel, Observer: observer}
+	switch {
+	case *code != "":
+		if !pake.ValidCode(*code) {
+			return fmt.Errorf("invalid pairing code %q: %w", *code, apperrors.ErrUsage)
+		}
+		opts.Code = *code
+	case !*insecure:
+		local, verifier, err := r.dialIdentity(msgOut, path != transfer.StdinPath)
 		if err != nil {
-			return fmt.Errorf("discover peers: %w", err)
-		}
-		found := false
-		for _, p := range peer {
-			if p.ID == *to {
-				best := p.PreferredAddress()
-				if best == "" {
-					return fmt.Errorf("peer %q has no usable address: %w", p.ID, apperrors.ErrNetwork)
-				}
-				address = net.JoinHostPort(best, fmt.Sprintf("%d", p.Port))
-				found = true
-				break
+			return err
+		}
+		opts.Identity, opts.VerifyPeer = local, verifier(to[0], address)
+	}
+	if err := r.sendFunc(opts); err != nil {
+		return err
+	}
+	return nil
+}
+
+// runFanout sends to every receiver the --to values and --all select, then prints
+// one outcome row per receiver, or emits an error event per failed receiver when
+// opts reports to a JSON event stream.
+func (r *RootCommand) runFanout(source string, to []string, all bool, timeout time.Duration, insecure bool, code string, opts transfer.SenderOptions) error {
+	switch {
+	case source == transfer.StdinPath:
+		return fmt.Errorf("stdin can only be sent to a single receiver: %w", apperrors.ErrUsage)
+	case code != "":
+		return fmt.Errorf("--code pairs with a single receiver: %w", apperrors.ErrUsage)
+	case opts.Streams > 1 || opts.Delta || opts.Retries > 0:
+		return fmt.Errorf("--streams, --delta, and --retries apply to a single receiver: %w", apperrors.ErrUsage)
+	}
+	targets, err := r.resolveTargets(to, all, timeout)
+	if err != nil {
+		return err
+	}
+	if !insecure {
+		local, verifier, err := r.dialIdentity(opts.Out, true)
+		if err != nil {
+			return err
+		}
+		opts.Identity = local
+		for i := range targets {
+			targets[i].VerifyPeer = verifier(targets[i].Label, targets[i].Address)
+		}
+	}
+	results, err := r.fanout(opts, targets)
+	if err != nil {
+		return err
+	}
+	if events, ok := opts.Observer.(*progress.JSONStream); ok {
+		return fanoutEvents(events, results)
+	}
+
+	if _, err := fmt.Fprintf(r.out, "%-14s %-22s %s\n", "PEER", "ADDRESS", "RESULT"); err != nil {
+		return fmt.Errorf("write fan-out header: %w", err)
+	}
+	var firstErr error
+	failed := 0
+	for _, res := range results {
+		outcome := fmt.Sprintf("ok, %d bytes sent", res.Sent)
+		if res.Err != nil {
+			outcome = "failed: " + res.Err.Error()
+			failed++
+			if firstErr == nil {
+				firstErr = res.Err
 			}
 		}
-		if !found {
-			return fmt.Errorf("peer id %q not found: %w", *to, apperrors.ErrNetwork)
+		if _, err := fmt.Fprintf(r.out, "%-14s %-22s %s\n", res.Label, res.Address, outcome); err != nil {
+			return fmt.Errorf("write fan-out row: %w", err)
 		}
 	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d receivers failed: %w", failed, len(results), firstErr)
+	}
+	return nil
+}
 
-	if err := r.sendFunc(transfer.SenderOptions{Path: path, Address: address, OverrideName: *name, Out: r.out, Resume: !*noResume}); err != nil {
-		return err
+// resolveTargets turns --to values into fan-out targets. Each value is host:port,
+// a peer id, or a peer id glob matched against discovered receivers; all adds
+// every discovered receiver. Duplicate addresses are sent to once.
+func (r *RootCommand) resolveTargets(to []string, all bool, timeout time.Duration) ([]transfer.FanoutTarget, error) {
+	var receivers []discovery.Peer
+	browsed := false
+	browse := func() ([]discovery.Peer, error) {
+		if browsed {
+			return receivers, nil
+		}
+		peers, err := r.resolver.Browse(context.Background(), timeout)
+		if err != nil {
+			return nil, fmt.Errorf("discover peers: %w", err)
+		}
+		for _, p := range peers {
+			if p.HasRole(discovery.RoleReceiver) {
+				receivers = append(receivers, p)
+			}
+		}
+		browsed = true
+		return receivers, nil
+	}
+
+	var targets []transfer.FanoutTarget
+	seen := map[string]bool{}
+	add := func(p discovery.Peer) error {
+		best := p.PreferredAddress()
+		if best == "" {
+			return fmt.Errorf("peer %q has no usable address: %w", p.ID, apperrors.ErrNetwork)
+		}
+		address := net.JoinHostPort(best, fmt.Sprintf("%d", p.Port))
+		if !seen[address] {
+			seen[address] = true
+			targets = append(targets, transfer.FanoutTarget{Label: p.ID, Address: address})
+		}
+		return nil
+	}
+	if all {
+		peers, err := browse()
+		if err != nil {
+			return nil, err
+		}
+		if len(peers) == 0 {
+			return nil, fmt.Errorf("no receivers discovered: %w", apperrors.ErrNetwork)
+		}
+		for _, p := range peers {
+			if err := add(p); err != nil {
+				return nil, err
+			}
+		}
 	}
+	for _, value := range to {
+		if strings.Contains(value, ":") {
+			if !seen[value] {
+				seen[value] = true
+				targets = append(targets, transfer.FanoutTarget{Label: value, Address: value})
+			}
+			continue
+		}
+		peers, err := browse()
+		if err != nil {
+			return nil, err
+		}
+		matched := false
+		for _, p := range peers {
+			ok, err := path.Match(value, p.ID)
+			if err != nil {
+				return nil, fmt.Errorf("invalid peer id pattern %q: %w", value, apperrors.ErrUsage)
+			}
+			if ok {
+				matched = true
+				if err := add(p); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("peer id %q not found: %w", value, apperrors.ErrNetwork)
+		}
+	}
+	return targets, nil
+}
+
+// isPeerGlob reports whether a --to value is a peer id pattern rather than one peer.
+func isPeerGlob(value string) bool {
+	return !strings.Contains(value, ":") && strings.ContainsAny(value, "*?[")
+}
+
+// stringList is a flag that may be repeated.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
 	return nil
 }
 
-func (r *RootCommand) runRecv(args []string) error {
+// resolveAddress returns to itself when it is host:port, or the address of the
+// discovered peer with that id and role.
+func (r *RootCommand) resolveAddress(to string, timeout time.Duration, role string) (string, error) {
+	if strings.Contains(to, ":") {
+		return to, nil
+	}
+	peers, err := r.resolver.Browse(context.Background(), timeout)
+	if err != nil {
+		return "", fmt.Errorf("discover peers: %w", err)
+	}
+	for _, p := range peers {
+		if p.ID == to && p.HasRole(role) {
+			best := p.PreferredAddress()
+			if best == "" {
+				return "", fmt.Errorf("peer %q has no usable address: %w", p.ID, apperrors.ErrNetwork)
+			}
+			return net.JoinHostPort(best, fmt.Sprintf("%d", p.Port)), nil
+		}
+	}
+	return "", fmt.Errorf("peer id %q not found: %w", to, apperrors.ErrNetwork)
+}
+
+// dialIdentity loads the local identity and returns it with a verifier factory.
+// The verifier for a dialed address checks the peer against the trust store and,
+// when to was a peer id rather than the address itself, against that id. Peers
+// choose their own ids and anyone can answer on an address, so a key the trust
+// store does not pin yet is only accepted once the user confirms its fingerprint
+// on w, which pins it. Without confirm, as when stdin carries the file, such a
+// key is refused.
+func (r *RootCommand) dialIdentity(w io.Writer, confirm bool) (*identity.Identity, func(to, address string) func(transfer.PeerIdentity) error, error) {
+	local, err := r.identity()
+	if err != nil {
+		return nil, nil, fmt.Errorf("load local identity: %w", err)
+	}
+	ts, err := r.trust()
+	if err != nil {
+		return nil, nil, fmt.Errorf("open trust store: %w", err)
+	}
+	checkTrust := trustChecker(ts)
+	var asking sync.Mutex
+	return &local, func(to, address string) func(transfer.PeerIdentity) error {
+		want := ""
+		if address != to {
+			want = to
+		}
+		return func(p transfer.PeerIdentity) error {
+			if want != "" && p.PeerID != want {
+				return fmt.Errorf("peer identifies as %q, expected %q", p.PeerID, want)
+			}
+			trusted, err := checkTrust(p)
+			if err != nil || trusted {
+				return err
+			}
+			if !confirm {
+				return fmt.Errorf("peer %s is not trusted yet; check fingerprint %s and run snapsync trust add %s %s", p.PeerID, p.Fingerprint, p.PeerID, p.Fingerprint)
+			}
+			asking.Lock()
+			defer asking.Unlock()
+			return r.confirmPeer(ts, w, p)
+		}
+	}, nil
+}
+
+func (r *RootCommand) runRecv(args []string) (err error) {
 	if len(args) == 1 && (args[0] == "--help" || args[0] == "-h") {
 		return r.printRecvHelp()
 	}
@@ -186,58 +489,381 @@ func (r *RootCommand) runRecv(args []string) error {
 	keepPartial := fs.Bool("keep-partial", false, "keep partial files on failure")
 	forceRestart := fs.Bool("force-restart", false, "force restart when resume session mismatches")
 	breakLock := fs.Bool("break-lock", false, "break existing lock file before receiving")
+	allowInsecure := fs.Bool("allow-insecure", false, "accept unencrypted, unauthenticated senders")
+	pairing := fs.Bool("code", false, "require senders to enter a generated pairing code")
+	untrusted := fs.String("untrusted", "prompt", "handling for senders not in the trust store: prompt or reject")
+	policyPath := fs.String("policy", "", "JSON file of rules that refuse peers and files before any prompt")
+	preAccept := fs.String("pre-accept", "", "shell command run for each offer; a non-zero exit refuses it")
+	postReceive := fs.String("post-receive", "", "shell command run for each received file")
+	serve := fs.Bool("serve", false, "keep receiving transfers until interrupted")
+	maxConcurrent := fs.Int("max-concurrent", transfer.DefaultMaxConcurrent, "maximum simultaneous transfers with --serve")
+	toStdout := fs.Bool("stdout", false, "write the received file to stdout instead of --out")
+	preserveList := fs.String("preserve", "mode,mtime", "file attributes to keep: mode, mtime, owner, all, or none")
+	jsonOut := fs.Bool("json", false, "write NDJSON events to stdout and messages to stderr")
+	timeouts := timeoutFlags(fs)
+	limit := limitFlag(fs)
 	if err := fs.Parse(args); err != nil {
 		return fmt.Errorf("parse recv flags: %w: %w", err, apperrors.ErrUsage)
 	}
-	if *listen == "" || *outDir == "" {
-		return fmt.Errorf("recv requires --listen and --out: %w", apperrors.ErrUsage)
+	if *listen == "" || (*outDir == "") == !*toStdout {
+		return fmt.Errorf("recv requires --listen and one of --out or --stdout: %w", apperrors.ErrUsage)
 	}
-
-	peerID, err := discovery.LocalPeerID()
+	if *toStdout && *serve {
+		return fmt.Errorf("--stdout receives a single transfer and cannot be combined with --serve: %w", apperrors.ErrUsage)
+	}
+	if *toStdout && *jsonOut {
+		return fmt.Errorf("--stdout and --json both write to stdout: %w", apperrors.ErrUsage)
+	}
+	// With --stdout the file owns stdout, so every message goes to stderr.
+	msgOut := r.out
+	if *toStdout {
+		msgOut = r.errOut
+	}
+	events, observer, msgOut := r.progressOutput(*jsonOut, msgOut)
+	defer func() { emitError(events, "receiving", err) }()
+	preserve, err := transfer.ParsePreserve(*preserveList)
 	if err != nil {
-		return fmt.Errorf("load local peer id: %w", err)
+		return err
 	}
-	display := *alias
-	if display == "" {
-		h, _ := os.Hostname()
-		display = h
+	if *untrusted != "prompt" && *untrusted != "reject" {
+		return fmt.Errorf("--untrusted must be prompt or reject: %w", apperrors.ErrUsage)
 	}
-	instance := display
-	if instance == "" {
-		instance = "snapsync"
+	if *maxConcurrent < 1 {
+		return fmt.Errorf("--max-concurrent must be at least 1: %w", apperrors.ErrUsage)
+	}
+	var rules *policy.Policy
+	if *policyPath != "" {
+		if rules, err = policy.Load(*policyPath); err != nil {
+			return err
+		}
+	}
+	if *serve && *pairing {
+		return fmt.Errorf("--code pairs a single transfer and cannot be combined with --serve: %w", apperrors.ErrUsage)
+	}
+	if err := validateTimeouts(*timeouts); err != nil {
+		return err
+	}
+	limiter, stopLimit, err := r.rateLimiter(*limit)
+	if err != nil {
+		return err
+	}
+	defer stopLimit()
+	ts, err := r.trust()
+	if err != nil {
+		return fmt.Errorf("open trust store: %w", err)
+	}
+
+	local, err := r.identity()
+	if err != nil {
+		return fmt.Errorf("load local identity: %w", err)
 	}
 
 	opts := transfer.ReceiverOptions{
-		Listen:       *listen,
-		OutDir:       filepath.Clean(*outDir),
+		Listen:            *listen,
+		OutDir:            *outDir,
+		Overwrite:         *overwrite,
+		AutoAccept:        *autoAccept,
+		Prompt:            r.promptAccept(msgOut),
+		Out:               msgOut,
+		Resume:            !*noResume,
+		KeepPartial:       *keepPartial,
+		ForceRestart:      *forceRestart,
+		BreakLock:         *breakLock,
+		Identity:          &local,
+		RequireEncryption: !*allowInsecure,
+		Trust:             trustChecker(ts),
+		RejectUntrusted:   *untrusted == "reject",
+		MaxConcurrent:     *maxConcurrent,
+		Preserve:          preserve,
+		Timeouts:          *timeouts,
+		Limit:             limiter,
+		Policy:            rules,
+		BeforeAccept:      preAcceptHook(*preAccept),
+		AfterReceive:      postReceiveHook(*postReceive),
+		Observer:          observer,
+	}
+	if *toStdout {
+		opts.Sink = r.out
+	} else {
+		opts.OutDir = filepath.Clean(*outDir)
+	}
+	_, _ = fmt.Fprintf(msgOut, "peer %s fingerprint %s\n", local.PeerID, local.Fingerprint())
+	if *pairing {
+		pairingCode, codeErr := pake.NewCode()
+		if codeErr != nil {
+			return fmt.Errorf("generate pairing code: %w", codeErr)
+		}
+		opts.Code = pairingCode
+		_, _ = fmt.Fprintf(msgOut, "Pairing code: %s\n", pairingCode)
+	}
+	if !*noDiscovery {
+		opts.OnListening = advertise(local.PeerID, *alias, discovery.RoleReceiver)
+	}
+	if *serve {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		return r.serve(ctx, opts)
+	}
+	cancel, stopCancel := r.interruptible()
+	defer stopCancel()
+	opts.Cancel = cancel
+	if err := transfer.ReceiveOnce(opts); err != nil {
+		return err
+	}
+	return nil
+}
+
+// interruptible returns a channel closed by the first Ctrl-C or SIGTERM, so a
+// transfer can tell its peer why it stopped. A second signal kills the process.
+func (r *RootCommand) interruptible() (<-chan struct{}, func()) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	cancel := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-sigs:
+			signal.Stop(sigs)
+			close(cancel)
+			_, _ = fmt.Fprintln(r.errOut, "\nCancelling; press Ctrl-C again to quit immediately.")
+		case <-done:
+		}
+	}()
+	return cancel, func() {
+		signal.Stop(sigs)
+		close(done)
+	}
+}
+
+func (r *RootCommand) runServe(args []string) error {
+	if len(args) == 1 && (args[0] == "--help" || args[0] == "-h") {
+		return r.printServeHelp()
+	}
+	var paths []string
+	for len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		paths = append(paths, filepath.Clean(args[0]))
+		args = args[1:]
+	}
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	listen := fs.String("listen", "", "listen address")
+	acceptAll := fs.Bool("accept", false, "serve every peer without asking")
+	untrusted := fs.String("untrusted", "prompt", "handling for peers not in the trust store: prompt or reject")
+	alias := fs.String("name", "", "advertised discovery name")
+	noDiscovery := fs.Bool("no-discovery", false, "disable mDNS advertisement")
+	allowInsecure := fs.Bool("allow-insecure", false, "serve unencrypted, unauthenticated peers")
+	maxConcurrent := fs.Int("max-concurrent", transfer.DefaultMaxConcurrent, "maximum simultaneous transfers")
+	compress := fs.Bool("compress", false, "compress data on the wire when the peer supports it")
+	delta := fs.Bool("delta", false, "send only the blocks that differ from the peer's existing copy")
+	timeouts := timeoutFlags(fs)
+	limit := limitFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("parse serve flags: %w: %w", err, apperrors.ErrUsage)
+	}
+	if len(paths) == 0 || len(fs.Args()) > 0 {
+		return fmt.Errorf("serve requires shared paths followed by flags: %w", apperrors.ErrUsage)
+	}
+	if *listen == "" {
+		return fmt.Errorf("serve requires --listen: %w", apperrors.ErrUsage)
+	}
+	if *untrusted != "prompt" && *untrusted != "reject" {
+		return fmt.Errorf("--untrusted must be prompt or reject: %w", apperrors.ErrUsage)
+	}
+	if *maxConcurrent < 1 {
+		return fmt.Errorf("--max-concurrent must be at least 1: %w", apperrors.ErrUsage)
+	}
+	if err := validateTimeouts(*timeouts); err != nil {
+		return err
+	}
+	limiter, stopLimit, err := r.rateLimiter(*limit)
+	if err != nil {
+		return err
+	}
+	defer stopLimit()
+	ts, err := r.trust()
+	if err != nil {
+		return fmt.Errorf("open trust store: %w", err)
+	}
+	local, err := r.identity()
+	if err != nil {
+		return fmt.Errorf("load local identity: %w", err)
+	}
+
+	_, observer, msgOut := r.progressOutput(false, r.out)
+	opts := transfer.ShareOptions{
+		Listen:            *listen,
+		Paths:             paths,
+		Out:               msgOut,
+		Observer:          observer,
+		Identity:          &local,
+		RequireEncryption: !*allowInsecure,
+		Trust:             trustChecker(ts),
+		AcceptAll:         *acceptAll,
+		RejectUntrusted:   *untrusted == "reject",
+		MaxConcurrent:     *maxConcurrent,
+		Compress:          *compress,
+		Delta:             *delta,
+		Timeouts:          *timeouts,
+		Limit:             limiter,
+	}
+	if *untrusted == "prompt" {
+		opts.Prompt = r.prompt(msgOut, "Send %s (%s) to %s? [y/N] ")
+	}
+	if !*noDiscovery {
+		opts.OnListening = advertise(local.PeerID, *alias, discovery.RoleShare)
+	}
+	_, _ = fmt.Fprintf(msgOut, "peer %s fingerprint %s\n", local.PeerID, local.Fingerprint())
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return r.share(ctx, opts)
+}
+
+func (r *RootCommand) runGet(args []string) error {
+	if len(args) == 1 && (args[0] == "--help" || args[0] == "-h") {
+		return r.printGetHelp()
+	}
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return fmt.Errorf("get requires a peer id or host:port argument: %w", apperrors.ErrUsage)
+	}
+	from, name := args[0], ""
+	args = args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	fs := flag.NewFlagSet("get", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	outDir := fs.String("out", "", "output directory")
+	timeout := fs.Duration("timeout", 2*time.Second, "discovery timeout")
+	insecure := fs.Bool("insecure", false, "connect without encryption and peer authentication")
+	hashName := fs.String("hash", hash.Default.String(), "integrity hash: blake3, sha256, or xxh3")
+	overwrite := fs.Bool("overwrite", false, "overwrite existing file")
+	noResume := fs.Bool("no-resume", false, "disable resume")
+	keepPartial := fs.Bool("keep-partial", false, "keep partial files on failure")
+	forceRestart := fs.Bool("force-restart", false, "force restart when resume session mismatches")
+	breakLock := fs.Bool("break-lock", false, "break existing lock file before receiving")
+	preserveList := fs.String("preserve", "mode,mtime", "file attributes to keep: mode, mtime, owner, all, or none")
+	timeouts := timeoutFlags(fs)
+	limit := limitFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("parse get flags: %w: %w", err, apperrors.ErrUsage)
+	}
+	if len(fs.Args()) > 0 {
+		return fmt.Errorf("get accepts a peer and an optional name followed by flags: %w", apperrors.ErrUsage)
+	}
+	if name != "" && *outDir == "" {
+		return fmt.Errorf("get requires --out when pulling a file: %w", apperrors.ErrUsage)
+	}
+	alg, err := hash.ParseAlgorithm(*hashName)
+	if err != nil {
+		return err
+	}
+	preserve, err := transfer.ParsePreserve(*preserveList)
+	if err != nil {
+		return err
+	}
+	if err := validateTimeouts(*timeouts); err != nil {
+		return err
+	}
+	address, err := r.resolveAddress(from, *timeout, discovery.RoleShare)
+	if err != nil {
+		return err
+	}
+	limiter, stopLimit, err := r.rateLimiter(*limit)
+	if err != nil {
+		return err
+	}
+	defer stopLimit()
+
+	_, observer, msgOut := r.progressOutput(false, r.out)
+	opts := transfer.GetOptions{
+		Address:      address,
 		Overwrite:    *overwrite,
-		AutoAccept:   *autoAccept,
-		Prompt:       r.promptAccept,
-		Out:          r.out,
 		Resume:       !*noResume,
 		KeepPartial:  *keepPartial,
 		ForceRestart: *forceRestart,
 		BreakLock:    *breakLock,
+		Hash:         alg,
+		Preserve:     preserve,
+		Out:          msgOut,
+		Timeouts:     *timeouts,
+		Limit:        limiter,
+		Observer:     observer,
 	}
-	if !*noDiscovery {
-		opts.OnListening = func(addr net.Addr) (func(), error) {
-			port := 0
-			if tcp, ok := addr.(*net.TCPAddr); ok {
-				port = tcp.Port
-			}
-			adv, advErr := discovery.StartAdvertise(discovery.AdvertiseConfig{InstanceName: instance, PeerID: peerID, DisplayName: display, Port: port})
-			if advErr != nil {
-				return nil, fmt.Errorf("start discovery advertisement: %w", advErr)
-			}
-			return adv.Stop, nil
+	if !*insecure {
+		local, verifier, err := r.dialIdentity(msgOut, true)
+		if err != nil {
+			return err
 		}
+		opts.Identity, opts.VerifyPeer = local, verifier(from, address)
 	}
-	if err := transfer.ReceiveOnce(opts); err != nil {
+	if name != "" {
+		opts.OutDir = filepath.Clean(*outDir)
+		cancel, stopCancel := r.interruptible()
+		defer stopCancel()
+		opts.Cancel = cancel
+		return r.get(opts, name)
+	}
+	entries, err := r.shares(opts)
+	if err != nil {
 		return err
 	}
+	if _, err := fmt.Fprintf(r.out, "%-24s %12s  %s\n", "NAME", "SIZE", "TYPE"); err != nil {
+		return fmt.Errorf("write share list header: %w", err)
+	}
+	for _, e := range entries {
+		kind := "file"
+		if e.Dir {
+			kind = "dir"
+		}
+		if _, err := fmt.Fprintf(r.out, "%-24s %12d  %s\n", e.Name, e.Size, kind); err != nil {
+			return fmt.Errorf("write share list row: %w", err)
+		}
+	}
 	return nil
 }
 
+// timeoutFlags registers the connection timeout flags shared by send, recv, serve,
+// and get.
+func timeoutFlags(fs *flag.FlagSet) *transfer.Timeouts {
+	t := &transfer.Timeouts{}
+	fs.DurationVar(&t.Handshake, "handshake-timeout", transfer.DefaultHandshakeTimeout, "limit for connecting and negotiating with the peer")
+	fs.DurationVar(&t.Idle, "idle-timeout", transfer.DefaultIdleTimeout, "give up when the peer sends or takes nothing for this long")
+	fs.DurationVar(&t.Total, "total-timeout", 0, "limit for a whole connection; 0 means none")
+	return t
+}
+
+func validateTimeouts(t transfer.Timeouts) error {
+	if t.Handshake <= 0 || t.Idle <= 0 || t.Total < 0 {
+		return fmt.Errorf("--handshake-timeout and --idle-timeout must be positive and --total-timeout must not be negative: %w", apperrors.ErrUsage)
+	}
+	return nil
+}
+
+// advertise returns an on-listening callback that announces the local peer in role
+// over mDNS under alias, or the host name.
+func advertise(peerID, alias, role string) func(net.Addr) (func(), error) {
+	display := alias
+	if display == "" {
+		h, _ := os.Hostname()
+		display = h
+	}
+	instance := display
+	if instance == "" {
+		instance = "snapsync"
+	}
+	return func(addr net.Addr) (func(), error) {
+		port := 0
+		if tcp, ok := addr.(*net.TCPAddr); ok {
+			port = tcp.Port
+		}
+		adv, advErr := discovery.StartAdvertise(discovery.AdvertiseConfig{InstanceName: instance, PeerID: peerID, DisplayName: display, Port: port, Role: role})
+		if advErr != nil {
+			return nil, fmt.Errorf("start discovery advertisement: %w", advErr)
+		}
+		return adv.Stop, nil
+	}
+}
+
 func (r *RootCommand) runList(args []string) error {
 	if len(args) == 1 && (args[0] == "--help" || args[0] == "-h") {
 		return r.printListHelp()
@@ -262,32 +888,64 @@ func (r *RootCommand) runList(args []string) error {
 		}
 		return nil
 	}
-	if _, err := fmt.Fprintln(r.out, "ID           NAME          ADDRESSES              PORT  AGE"); err != nil {
+	if _, err := fmt.Fprintln(r.out, "ID           NAME          ROLE   ADDRESSES              PORT  AGE"); err != nil {
 		return fmt.Errorf("write list header: %w", err)
 	}
 	now := time.Now()
 	for _, p := range peers {
 		age := now.Sub(p.LastSeen).Truncate(100 * time.Millisecond)
-		if _, err := fmt.Fprintf(r.out, "%-12s %-13s %-22s %-5d %s\n", p.ID, p.Name, strings.Join(p.Addresses, ", "), p.Port, age); err != nil {
+		role := p.Role
+		if role == "" {
+			role = discovery.RoleReceiver
+		}
+		if _, err := fmt.Fprintf(r.out, "%-12s %-13s %-6s %-22s %-5d %s\n", p.ID, p.Name, role, strings.Join(p.Addresses, ", "), p.Port, age); err != nil {
 			return fmt.Errorf("write list row: %w", err)
 		}
 	}
 	return nil
 }
 
-func (r *RootCommand) promptAccept(name string, size uint64, peer string) (bool, error) {
-	if _, err := fmt.Fprintf(r.out, "Accept file %s (%d bytes) from %s? [y/N] ", name, size, peer); err != nil {
-		return false, fmt.Errorf("write accept prompt: %w", err)
+// promptAccept asks on w, and reads the answer from the command input.
+func (r *RootCommand) promptAccept(w io.Writer) transfer.PromptFunc {
+	return r.prompt(w, "Accept file %s (%s) from %s? [y/N] ")
+}
+
+// prompt asks question, formatted with the name, size, and peer, on w and reads a
+// yes or no answer from the command input.
+func (r *RootCommand) prompt(w io.Writer, question string) transfer.PromptFunc {
+	return func(name string, size uint64, peer string) (bool, error) {
+		sizeText := fmt.Sprintf("%d bytes", size)
+		if size == transfer.UnknownSize {
+			sizeText = "streamed, size unknown"
+		}
+		if _, err := fmt.Fprintf(w, question, name, sizeText, peer); err != nil {
+			return false, fmt.Errorf("write accept prompt: %w", err)
+		}
+		return r.answer()
+	}
+}
+
+// answer reads a yes or no answer from the command input.
+func (r *RootCommand) answer() (bool, error) {
+	if r.input == nil {
+		r.input = bufio.NewReader(r.in)
 	}
-	reader := bufio.NewReader(r.in)
-	line, err := reader.ReadString('\n')
+	line, err := r.input.ReadString('\n')
 	if err != nil && !errors.Is(err, io.EOF) {
-		return false, fmt.Errorf("read accept prompt input: %w", err)
+		return false, fmt.Errorf("read prompt input: %w", err)
 	}
 	value := strings.TrimSpace(strings.ToLower(line))
 	return value == "y" || value == "yes", nil
 }
 
+func loadLocalIdentity() (identity.Identity, error) {
+	peerID, err := discovery.LocalPeerID()
+	if err != nil {
+		return identity.Identity{}, fmt.Errorf("load local peer id: %w", err)
+	}
+	return identity.LoadOrCreate(peerID)
+}
+
 // NewOSRootCommand creates a command wired to process standard streams.
 func NewOSRootCommand() *RootCommand {
 	return NewRootCommand(os.Stdout, os.Stderr, os.Stdin)
@@ -2,8 +2,22 @@ package cli
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
+
+	apperrors "snapsync/internal/errors"
+	"snapsync/internal/hash"
+	"snapsync/internal/policy"
+	"snapsync/internal/progress"
+	"snapsync/internal/store"
+	"snapsync/internal/transfer"
 )
 
 func TestRootCommandIncludesRequiredSubcommands(t *testing.T) {
@@ -14,7 +28,7 @@ func TestRootCommandIncludesRequiredSubcommands(t *testing.T) {
 	for _, command := range root.Commands() {
 		names[command.Name()] = true
 	}
-	for _, required := range []string{"version", "send", "recv", "list"} {
+	for _, required := range []string{"version", "send", "recv", "list", "trust", "limit"} {
 		if !names[required] {
 			t.Fatalf("expected root command to include %q subcommand", required)
 		}
@@ -50,3 +64,382 @@ func TestSendHelpIncludesRequiredFlags(t *testing.T) {
 		}
 	}
 }
+
+func TestRecvServeUsesDaemonWithConcurrencyLimit(t *testing.T) {
+	buf := &bytes.Buffer{}
+	root := NewRootCommand(buf, buf, strings.NewReader(""))
+	stubLocalState(t, root)
+	called := false
+	root.serve = func(_ context.Context, opts transfer.ReceiverOptions) error {
+		called = true
+		if opts.MaxConcurrent != 8 {
+			t.Fatalf("expected max concurrency 8, got %d", opts.MaxConcurrent)
+		}
+		return nil
+	}
+	root.SetArgs([]string{"recv", "--listen", "127.0.0.1:0", "--out", t.TempDir(), "--no-discovery", "--serve", "--max-concurrent", "8"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if !called {
+		t.Fatal("expected serve to be called")
+	}
+}
+
+func TestRecvServeRejectsPairingCode(t *testing.T) {
+	buf := &bytes.Buffer{}
+	root := NewRootCommand(buf, buf, strings.NewReader(""))
+	stubLocalState(t, root)
+	root.SetArgs([]string{"recv", "--listen", "127.0.0.1:0", "--out", t.TempDir(), "--serve", "--code"})
+	if err := root.Execute(); !errors.Is(err, apperrors.ErrUsage) {
+		t.Fatalf("expected usage error, got %v", err)
+	}
+}
+
+func TestRecvPreserveFlag(t *testing.T) {
+	buf := &bytes.Buffer{}
+	root := NewRootCommand(buf, buf, strings.NewReader(""))
+	stubLocalState(t, root)
+	var got []uint8
+	root.serve = func(_ context.Context, opts transfer.ReceiverOptions) error {
+		got = append(got, opts.Preserve)
+		return nil
+	}
+	for _, args := range [][]string{{}, {"--preserve", "owner"}, {"--preserve", "none"}} {
+		root.SetArgs(append([]string{"recv", "--listen", "127.0.0.1:0", "--out", t.TempDir(), "--no-discovery", "--serve"}, args...))
+		if err := root.Execute(); err != nil {
+			t.Fatalf("Execute(%v) error = %v", args, err)
+		}
+	}
+	if len(got) != 3 || got[0] != transfer.AttrMode|transfer.AttrModTime || got[1] != transfer.AttrOwner || got[2] != 0 {
+		t.Fatalf("unexpected preserve options %v", got)
+	}
+	root.SetArgs([]string{"recv", "--listen", "127.0.0.1:0", "--out", t.TempDir(), "--serve", "--preserve", "xattrs"})
+	if err := root.Execute(); !errors.Is(err, apperrors.ErrUsage) {
+		t.Fatalf("expected usage error for unknown attribute, got %v", err)
+	}
+}
+
+func TestRecvPolicyFlag(t *testing.T) {
+	buf := &bytes.Buffer{}
+	root := NewRootCommand(buf, buf, strings.NewReader(""))
+	stubLocalState(t, root)
+	var got *policy.Policy
+	root.serve = func(_ context.Context, opts transfer.ReceiverOptions) error {
+		got = opts.Policy
+		return nil
+	}
+	path := filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(path, []byte(`{"deny_extensions": ["exe"]}`), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	root.SetArgs([]string{"recv", "--listen", "127.0.0.1:0", "--out", t.TempDir(), "--no-discovery", "--serve", "--policy", path})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if got == nil || got.AllowFile("setup.exe", 1, true) == nil {
+		t.Fatalf("policy not loaded from %s", path)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"allow_subnets": ["10.0.0.0/33"]}`), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	root.SetArgs([]string{"recv", "--listen", "127.0.0.1:0", "--out", t.TempDir(), "--serve", "--policy", path})
+	if err := root.Execute(); !errors.Is(err, apperrors.ErrUsage) {
+		t.Fatalf("expected usage error for an invalid policy, got %v", err)
+	}
+}
+
+func TestRecvHookFlags(t *testing.T) {
+	buf := &bytes.Buffer{}
+	root := NewRootCommand(buf, buf, strings.NewReader(""))
+	stubLocalState(t, root)
+	var got transfer.ReceiverOptions
+	root.serve = func(_ context.Context, opts transfer.ReceiverOptions) error {
+		got = opts
+		return nil
+	}
+	root.SetArgs([]string{"recv", "--listen", "127.0.0.1:0", "--out", t.TempDir(), "--no-discovery", "--serve"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if got.BeforeAccept != nil || got.AfterReceive != nil {
+		t.Fatal("hooks set without --pre-accept or --post-receive")
+	}
+	root.SetArgs([]string{"recv", "--listen", "127.0.0.1:0", "--out", t.TempDir(), "--no-discovery", "--serve", "--pre-accept", "exit 0", "--post-receive", "exit 0"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if got.BeforeAccept == nil || got.AfterReceive == nil {
+		t.Fatal("expected both hooks to be set")
+	}
+}
+
+func TestSendStreamsFlag(t *testing.T) {
+	buf := &bytes.Buffer{}
+	root := NewRootCommand(buf, buf, strings.NewReader(""))
+	stubLocalState(t, root)
+	var got int
+	root.sendFunc = func(opts transfer.SenderOptions) error {
+		got = opts.Streams
+		return nil
+	}
+	root.SetArgs([]string{"send", "./file.bin", "--to", "10.0.0.5:45999", "--streams", "8"})
+	if err := root.Execute(); err != nil || got != 8 {
+		t.Fatalf("expected 8 streams, got %d err=%v", got, err)
+	}
+	root.SetArgs([]string{"send", "./file.bin", "--to", "10.0.0.5:45999", "--streams", "0"})
+	if err := root.Execute(); !errors.Is(err, apperrors.ErrUsage) {
+		t.Fatalf("expected usage error for zero streams, got %v", err)
+	}
+}
+
+func TestSendRetryFlags(t *testing.T) {
+	buf := &bytes.Buffer{}
+	root := NewRootCommand(buf, buf, strings.NewReader(""))
+	stubLocalState(t, root)
+	var got transfer.SenderOptions
+	root.sendFunc = func(opts transfer.SenderOptions) error {
+		got = opts
+		return nil
+	}
+	root.SetArgs([]string{"send", "./file.bin", "--to", "10.0.0.5:45999", "--retries", "3", "--retry-backoff", "250ms"})
+	if err := root.Execute(); err != nil || got.Retries != 3 || got.RetryBackoff != 250*time.Millisecond {
+		t.Fatalf("expected 3 retries at 250ms, got %d/%s err=%v", got.Retries, got.RetryBackoff, err)
+	}
+	root.SetArgs([]string{"send", "./file.bin", "--to", "10.0.0.5:45999", "--retries", "-1"})
+	if err := root.Execute(); !errors.Is(err, apperrors.ErrUsage) {
+		t.Fatalf("expected usage error for negative retries, got %v", err)
+	}
+}
+
+func TestTimeoutFlags(t *testing.T) {
+	buf := &bytes.Buffer{}
+	root := NewRootCommand(buf, buf, strings.NewReader(""))
+	stubLocalState(t, root)
+	var sent, served transfer.Timeouts
+	root.sendFunc = func(opts transfer.SenderOptions) error {
+		sent = opts.Timeouts
+		return nil
+	}
+	root.serve = func(_ context.Context, opts transfer.ReceiverOptions) error {
+		served = opts.Timeouts
+		return nil
+	}
+	root.SetArgs([]string{"send", "./file.bin", "--to", "10.0.0.5:45999", "--idle-timeout", "5s", "--total-timeout", "1h"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("send Execute() error = %v", err)
+	}
+	if sent != (transfer.Timeouts{Handshake: transfer.DefaultHandshakeTimeout, Idle: 5 * time.Second, Total: time.Hour}) {
+		t.Fatalf("unexpected send timeouts %+v", sent)
+	}
+	root.SetArgs([]string{"recv", "--listen", "127.0.0.1:0", "--out", t.TempDir(), "--no-discovery", "--serve", "--handshake-timeout", "2s"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("recv Execute() error = %v", err)
+	}
+	if served != (transfer.Timeouts{Handshake: 2 * time.Second, Idle: transfer.DefaultIdleTimeout}) {
+		t.Fatalf("unexpected recv timeouts %+v", served)
+	}
+	root.SetArgs([]string{"send", "./file.bin", "--to", "10.0.0.5:45999", "--idle-timeout", "0s"})
+	if err := root.Execute(); !errors.Is(err, apperrors.ErrUsage) {
+		t.Fatalf("expected usage error for zero idle timeout, got %v", err)
+	}
+}
+
+func TestLimitFlagScheduleAndCommand(t *testing.T) {
+	buf := &bytes.Buffer{}
+	root := NewRootCommand(buf, buf, strings.NewReader(""))
+	stubLocalState(t, root)
+	var sent int64
+	root.sendFunc = func(opts transfer.SenderOptions) error {
+		sent = opts.Limit.Rate()
+		return nil
+	}
+	root.SetArgs([]string{"send", "./file.bin", "--to", "10.0.0.5:45999", "--limit", "20MB/s"})
+	if err := root.Execute(); err != nil || sent != 20<<20 {
+		t.Fatalf("expected a 20MB/s limit, got %d err=%v", sent, err)
+	}
+	root.SetArgs([]string{"send", "./file.bin", "--to", "10.0.0.5:45999", "--limit", "fast"})
+	if err := root.Execute(); !errors.Is(err, apperrors.ErrUsage) {
+		t.Fatalf("expected usage error for a bad limit, got %v", err)
+	}
+
+	// Without --limit the config schedule applies, and `limit` changes it live.
+	root.config = func() (store.Config, error) {
+		return store.Config{LimitSchedule: []store.LimitWindow{{After: "00:00", Limit: "1MB/s"}}}, nil
+	}
+	control := &bytes.Buffer{}
+	ctl := NewRootCommand(control, control, strings.NewReader(""))
+	ctl.controlDir = root.controlDir
+	var scheduled, changed int64
+	root.serve = func(_ context.Context, opts transfer.ReceiverOptions) error {
+		scheduled = opts.Limit.Rate()
+		ctl.SetArgs([]string{"limit", "5MB/s"})
+		if err := ctl.Execute(); err != nil {
+			return err
+		}
+		changed = opts.Limit.Rate()
+		return nil
+	}
+	root.SetArgs([]string{"recv", "--listen", "127.0.0.1:0", "--out", t.TempDir(), "--no-discovery", "--serve"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("recv Execute() error = %v", err)
+	}
+	if scheduled != 1<<20 || changed != 5<<20 || !strings.Contains(control.String(), "5MB/s") {
+		t.Fatalf("scheduled %d changed %d output %q", scheduled, changed, control.String())
+	}
+	control.Reset()
+	ctl.SetArgs([]string{"limit"})
+	if err := ctl.Execute(); err != nil || !strings.Contains(control.String(), "No running transfers.") {
+		t.Fatalf("expected no running transfers, got %q err=%v", control.String(), err)
+	}
+}
+
+func TestSendCompressFlag(t *testing.T) {
+	buf := &bytes.Buffer{}
+	root := NewRootCommand(buf, buf, strings.NewReader(""))
+	stubLocalState(t, root)
+	var got []bool
+	root.sendFunc = func(opts transfer.SenderOptions) error {
+		got = append(got, opts.Compress && opts.Delta)
+		return nil
+	}
+	for _, args := range [][]string{{}, {"--compress", "--delta"}} {
+		root.SetArgs(append([]string{"send", "./file.bin", "--to", "10.0.0.5:45999"}, args...))
+		if err := root.Execute(); err != nil {
+			t.Fatalf("Execute(%v) error = %v", args, err)
+		}
+	}
+	if len(got) != 2 || got[0] || !got[1] {
+		t.Fatalf("unexpected compress options %v", got)
+	}
+}
+
+func TestSendJSONWritesEventsToStdout(t *testing.T) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	root := NewRootCommand(stdout, stderr, strings.NewReader(""))
+	stubLocalState(t, root)
+	root.sendFunc = func(opts transfer.SenderOptions) error {
+		opts.Observer.Observe(progress.Event{Type: progress.EventOffer, Name: "file.bin", Total: 4})
+		_, _ = fmt.Fprintln(opts.Out, "Connected")
+		return fmt.Errorf("dial receiver: %w", apperrors.ErrNetwork)
+	}
+	root.SetArgs([]string{"send", "./file.bin", "--to", "10.0.0.5:45999", "--json"})
+	if err := root.Execute(); !errors.Is(err, apperrors.ErrNetwork) {
+		t.Fatalf("expected network error, got %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(stderr.String(), "Connected") {
+		t.Fatalf("expected two events on stdout and messages on stderr, got %q and %q", stdout.String(), stderr.String())
+	}
+	var last progress.Event
+	if err := json.Unmarshal([]byte(lines[1]), &last); err != nil {
+		t.Fatalf("decode event: %v", err)
+	}
+	if last.Type != progress.EventError || last.Class != "network" || last.ExitCode != 3 || last.Direction != "sending" {
+		t.Fatalf("unexpected error event %+v", last)
+	}
+}
+
+func TestProgressOutputFallsBackToPlain(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	root := NewRootCommand(stdout, &bytes.Buffer{}, strings.NewReader(""))
+	events, observer, msgOut := root.progressOutput(false, stdout)
+	if _, tty := observer.(*progress.TTY); events != nil || tty || msgOut != stdout {
+		t.Fatalf("expected plain output for a non-terminal, got %T", observer)
+	}
+	observer.Observe(progress.Event{Type: progress.EventProgress, Direction: "sending", Bytes: 1, Total: 2})
+	if !strings.HasPrefix(stdout.String(), "\rsending 1.0B/2.0B") {
+		t.Fatalf("unexpected plain status %q", stdout.String())
+	}
+}
+
+func TestRecvJSONRejectsStdout(t *testing.T) {
+	buf := &bytes.Buffer{}
+	root := NewRootCommand(buf, buf, strings.NewReader(""))
+	stubLocalState(t, root)
+	root.SetArgs([]string{"recv", "--listen", ":0", "--stdout", "--json"})
+	if err := root.Execute(); !errors.Is(err, apperrors.ErrUsage) {
+		t.Fatalf("expected usage error, got %v", err)
+	}
+}
+
+func TestSendStdinPassesCommandInput(t *testing.T) {
+	buf := &bytes.Buffer{}
+	in := strings.NewReader("piped data")
+	root := NewRootCommand(buf, buf, in)
+	stubLocalState(t, root)
+	var got transfer.SenderOptions
+	root.sendFunc = func(opts transfer.SenderOptions) error {
+		got = opts
+		return nil
+	}
+	root.SetArgs([]string{"send", "-", "--to", "10.0.0.5:45999", "--name", "dump.sql"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if got.Path != transfer.StdinPath || got.Input != in || got.OverrideName != "dump.sql" {
+		t.Fatalf("unexpected stdin send options %+v", got)
+	}
+}
+
+func TestRecvStdoutRequiresSingleDestination(t *testing.T) {
+	for _, args := range [][]string{
+		{"recv", "--listen", "127.0.0.1:0"},
+		{"recv", "--listen", "127.0.0.1:0", "--stdout", "--out", "dir"},
+		{"recv", "--listen", "127.0.0.1:0", "--stdout", "--serve"},
+	} {
+		buf := &bytes.Buffer{}
+		root := NewRootCommand(buf, buf, strings.NewReader(""))
+		stubLocalState(t, root)
+		root.SetArgs(args)
+		if err := root.Execute(); !errors.Is(err, apperrors.ErrUsage) {
+			t.Fatalf("Execute(%v) expected usage error, got %v", args, err)
+		}
+	}
+}
+
+func TestSendHashFlagSelectsAlgorithm(t *testing.T) {
+	buf := &bytes.Buffer{}
+	root := NewRootCommand(buf, buf, strings.NewReader(""))
+	stubLocalState(t, root)
+	var got hash.Algorithm
+	root.sendFunc = func(opts transfer.SenderOptions) error {
+		got = opts.Hash
+		return nil
+	}
+	root.SetArgs([]string{"send", "./file.bin", "--to", "10.0.0.5:45999"})
+	if err := root.Execute(); err != nil || got != hash.BLAKE3 {
+		t.Fatalf("expected default blake3, got %s err=%v", got, err)
+	}
+	root.SetArgs([]string{"send", "./file.bin", "--to", "10.0.0.5:45999", "--hash", "xxh3"})
+	if err := root.Execute(); err != nil || got != hash.XXH3 {
+		t.Fatalf("expected xxh3, got %s err=%v", got, err)
+	}
+	root.SetArgs([]string{"send", "./file.bin", "--to", "10.0.0.5:45999", "--hash", "md5"})
+	if err := root.Execute(); !errors.Is(err, apperrors.ErrUsage) {
+		t.Fatalf("expected usage error for unknown hash, got %v", err)
+	}
+}
+
+func TestServeSharesPaths(t *testing.T) {
+	buf := &bytes.Buffer{}
+	root := NewRootCommand(buf, buf, strings.NewReader(""))
+	stubLocalState(t, root)
+	var got transfer.ShareOptions
+	root.share = func(_ context.Context, opts transfer.ShareOptions) error {
+		got = opts
+		return nil
+	}
+	root.SetArgs([]string{"serve", "a.txt", "photos/", "--listen", "127.0.0.1:0", "--no-discovery", "--untrusted", "reject"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if len(got.Paths) != 2 || got.Paths[1] != "photos" || got.Prompt != nil || got.AcceptAll || !got.RequireEncryption || got.Identity == nil {
+		t.Fatalf("unexpected share options %+v", got)
+	}
+	root.SetArgs([]string{"serve", "--listen", "127.0.0.1:0"})
+	if err := root.Execute(); !errors.Is(err, apperrors.ErrUsage) {
+		t.Fatalf("expected usage error without paths, got %v", err)
+	}
+}
@@ -0,0 +1,146 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	apperrors "snapsync/internal/errors"
+	"snapsync/internal/identity"
+	"snapsync/internal/store"
+	"snapsync/internal/transfer"
+)
+
+func (r *RootCommand) printTrustHelp() error {
+	const msg = `Usage:
+  snapsync trust add <peer-id> <fingerprint> [--name name]
+  snapsync trust list [--json]
+  snapsync trust remove <peer-id>
+`
+	_, err := fmt.Fprint(r.out, msg)
+	return err
+}
+
+func (r *RootCommand) runTrust(args []string) error {
+	if len(args) == 0 || args[0] == "--help" || args[0] == "-h" {
+		return r.printTrustHelp()
+	}
+	ts, err := r.trust()
+	if err != nil {
+		return fmt.Errorf("open trust store: %w", err)
+	}
+	switch args[0] {
+	case "add":
+		return r.runTrustAdd(ts, args[1:])
+	case "list":
+		return r.runTrustList(ts, args[1:])
+	case "remove":
+		if len(args) != 2 {
+			return fmt.Errorf("trust remove requires one peer id: %w", apperrors.ErrUsage)
+		}
+		removed, err := ts.Remove(args[1])
+		if err != nil {
+			return fmt.Errorf("remove trusted peer: %w", err)
+		}
+		if !removed {
+			return fmt.Errorf("peer %q is not trusted: %w", args[1], apperrors.ErrUsage)
+		}
+		_, err = fmt.Fprintf(r.out, "Removed trusted peer %s\n", args[1])
+		return err
+	default:
+		return fmt.Errorf("unknown trust subcommand %q: %w", args[0], apperrors.ErrUsage)
+	}
+}
+
+func (r *RootCommand) runTrustAdd(ts *store.TrustStore, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("trust add requires a peer id and fingerprint: %w", apperrors.ErrUsage)
+	}
+	fs := flag.NewFlagSet("trust add", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	name := fs.String("name", "", "display name for the peer")
+	if err := fs.Parse(args[2:]); err != nil {
+		return fmt.Errorf("parse trust add flags: %w: %w", err, apperrors.ErrUsage)
+	}
+	fingerprint, err := identity.ParseFingerprint(args[1])
+	if err != nil {
+		return fmt.Errorf("trust add: %w: %w", err, apperrors.ErrUsage)
+	}
+	peer := store.TrustedPeer{PeerID: args[0], Fingerprint: fingerprint, Name: *name}
+	if err := ts.Add(peer); err != nil {
+		return fmt.Errorf("add trusted peer: %w", err)
+	}
+	_, err = fmt.Fprintf(r.out, "Trusted peer %s (fingerprint %s)\n", peer.PeerID, peer.Fingerprint)
+	return err
+}
+
+func (r *RootCommand) runTrustList(ts *store.TrustStore, args []string) error {
+	fs := flag.NewFlagSet("trust list", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	jsonOut := fs.Bool("json", false, "print trusted peers as NDJSON")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("parse trust list flags: %w: %w", err, apperrors.ErrUsage)
+	}
+	peers, err := ts.List()
+	if err != nil {
+		return fmt.Errorf("list trusted peers: %w", err)
+	}
+	if *jsonOut {
+		enc := json.NewEncoder(r.out)
+		for _, p := range peers {
+			if err := enc.Encode(p); err != nil {
+				return fmt.Errorf("encode trusted peer output: %w", err)
+			}
+		}
+		return nil
+	}
+	if _, err := fmt.Fprintln(r.out, "ID           NAME          FINGERPRINT                       FIRST SEEN            LAST SEEN"); err != nil {
+		return fmt.Errorf("write trust list header: %w", err)
+	}
+	for _, p := range peers {
+		if _, err := fmt.Fprintf(r.out, "%-12s %-13s %-33s %-21s %s\n", p.PeerID, p.Name, p.Fingerprint, p.FirstSeen.Format(time.RFC3339), p.LastSeen.Format(time.RFC3339)); err != nil {
+			return fmt.Errorf("write trust list row: %w", err)
+		}
+	}
+	return nil
+}
+
+// confirmPeer shows the fingerprint of a peer whose id is not pinned yet and, if
+// the user confirms it, pins the key in ts.
+func (r *RootCommand) confirmPeer(ts *store.TrustStore, w io.Writer, p transfer.PeerIdentity) error {
+	if _, err := fmt.Fprintf(w, "Peer %s is not in the trust store. Its fingerprint is %s. Trust it? [y/N] ", p.PeerID, p.Fingerprint); err != nil {
+		return fmt.Errorf("write trust prompt: %w", err)
+	}
+	ok, err := r.answer()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("fingerprint %s for peer %s was not confirmed", p.Fingerprint, p.PeerID)
+	}
+	if err := ts.Add(store.TrustedPeer{PeerID: p.PeerID, Fingerprint: p.Fingerprint}); err != nil {
+		return fmt.Errorf("add trusted peer: %w", err)
+	}
+	return nil
+}
+
+// trustChecker trusts peers whose authenticated fingerprint matches their stored record.
+// A known peer ID presenting a different key is refused outright.
+func trustChecker(ts *store.TrustStore) transfer.TrustFunc {
+	return func(p transfer.PeerIdentity) (bool, error) {
+		rec, ok, err := ts.Lookup(p.PeerID)
+		if err != nil {
+			return false, fmt.Errorf("lookup trusted peer: %w", err)
+		}
+		if !ok {
+			return false, nil
+		}
+		if rec.Fingerprint != p.Fingerprint {
+			return false, fmt.Errorf("peer %s presented fingerprint %s, trusted fingerprint is %s", p.PeerID, p.Fingerprint, rec.Fingerprint)
+		}
+		_ = ts.Touch(p.PeerID, time.Now())
+		return true, nil
+	}
+}
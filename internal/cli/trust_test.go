@@ -0,0 +1,72 @@
+package cli
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	apperrors "snapsync/internal/errors"
+	"snapsync/internal/transfer"
+)
+
+func TestTrustAddListRemove(t *testing.T) {
+	buf := &bytes.Buffer{}
+	root := NewRootCommand(buf, buf, strings.NewReader(""))
+	stubLocalState(t, root)
+
+	root.SetArgs([]string{"trust", "add", "abc123def456", "00112233445566778899aabbccddeeff", "--name", "Laptop"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("trust add error = %v", err)
+	}
+	buf.Reset()
+	root.SetArgs([]string{"trust", "list"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("trust list error = %v", err)
+	}
+	if !strings.Contains(buf.String(), "abc123def456") || !strings.Contains(buf.String(), "Laptop") {
+		t.Fatalf("unexpected trust list output: %q", buf.String())
+	}
+	root.SetArgs([]string{"trust", "remove", "abc123def456"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("trust remove error = %v", err)
+	}
+	root.SetArgs([]string{"trust", "remove", "abc123def456"})
+	if err := root.Execute(); err == nil {
+		t.Fatal("expected removing an unknown peer to fail")
+	}
+}
+
+func TestTrustCheckerMatchesFingerprint(t *testing.T) {
+	root := NewRootCommand(&bytes.Buffer{}, &bytes.Buffer{}, strings.NewReader(""))
+	ts := stubLocalState(t, root)
+	const fp = "00112233445566778899aabbccddeeff"
+	// A fingerprint pasted in upper case with separators is stored as the handshake reports it.
+	root.SetArgs([]string{"trust", "add", "peer1", "00:11:22:33:44:55:66:77:88:99:AA:BB:CC:DD:EE:FF"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("trust add error = %v", err)
+	}
+	check := trustChecker(ts)
+	if ok, err := check(transfer.PeerIdentity{PeerID: "peer1", Fingerprint: fp}); !ok || err != nil {
+		t.Fatalf("expected trusted peer, got %v %v", ok, err)
+	}
+	if ok, err := check(transfer.PeerIdentity{PeerID: "peer2", Fingerprint: fp}); ok || err != nil {
+		t.Fatalf("expected unknown peer to be untrusted without error, got %v %v", ok, err)
+	}
+	if _, err := check(transfer.PeerIdentity{PeerID: "peer1", Fingerprint: "ffeeddccbbaa99887766554433221100"}); err == nil {
+		t.Fatal("expected key mismatch for known peer to fail")
+	}
+}
+
+func TestTrustAddRejectsMalformedFingerprint(t *testing.T) {
+	root := NewRootCommand(&bytes.Buffer{}, &bytes.Buffer{}, strings.NewReader(""))
+	ts := stubLocalState(t, root)
+	for _, fp := range []string{"ff00", "not-a-fingerprint", ""} {
+		root.SetArgs([]string{"trust", "add", "peer1", fp})
+		if err := root.Execute(); apperrors.ExitCode(err) != 2 {
+			t.Fatalf("trust add %q: expected usage error, got %v", fp, err)
+		}
+	}
+	if _, ok, _ := ts.Lookup("peer1"); ok {
+		t.Fatal("malformed fingerprint was pinned")
+	}
+}
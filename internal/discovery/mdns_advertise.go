@@ -22,6 +22,8 @@ type AdvertiseConfig struct {
 	PeerID       string
 	DisplayName  string
 	Port         int
+	// Role is RoleReceiver or RoleShare; empty advertises a receiver.
+	Role string
 }
 
 // StartAdvertise starts mDNS advertisement.
@@ -63,7 +65,11 @@ func runAdvertiser(ctx context.Context, cfg AdvertiseConfig) {
 	instance := sanitizeLabel(cfg.InstanceName)
 	target := sanitizeLabel(host) + ".local"
 	service := ServiceType + ".local"
-	txt := []string{"ver=1", "id=" + cfg.PeerID, "name=" + cfg.DisplayName, "features=direct"}
+	role := cfg.Role
+	if role == "" {
+		role = RoleReceiver
+	}
+	txt := []string{"ver=1", "id=" + cfg.PeerID, "name=" + cfg.DisplayName, "features=direct", "role=" + role}
 	announce := buildAnnouncement(instance, service, target, cfg.Port, txt)
 	queryName := service
 	buf := make([]byte, 65535)
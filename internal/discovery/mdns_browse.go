@@ -36,7 +36,8 @@ func (r MDNSResolver) Browse(ctx context.Context, timeout time.Duration) ([]Peer
 		n, _, readErr := conn.ReadFromUDP(buf)
 		if readErr == nil && n > 0 {
 			if peer, ok := parseAnnouncement(buf[:n]); ok {
-				seen[peer.ID] = peer
+				// One host may both receive and share, on different ports.
+				seen[peer.ID+"/"+peer.Role] = peer
 			}
 		}
 		select {
@@ -105,7 +106,7 @@ func parseAnnouncement(packet []byte) (Peer, bool) {
 	if err != nil {
 		return Peer{}, false
 	}
-	var id, name string
+	var id, name, role string
 	var port int
 	addrs := []net.IP{}
 	for _, record := range rrs {
@@ -117,6 +118,7 @@ func parseAnnouncement(packet []byte) (Peer, bool) {
 			}
 			id = fields["id"]
 			name = fields["name"]
+			role = fields["role"]
 		case 33:
 			if len(record.RData) < 7 {
 				continue
@@ -134,7 +136,12 @@ func parseAnnouncement(packet []byte) (Peer, bool) {
 	if name == "" {
 		name = "snapsync-peer"
 	}
-	return NewPeer(id, name, addrs, port, time.Now()), true
+	if role == "" {
+		role = RoleReceiver
+	}
+	peer := NewPeer(id, name, addrs, port, time.Now())
+	peer.Role = role
+	return peer, true
 }
 
 func parseRRs(packet []byte) ([]rr, error) {
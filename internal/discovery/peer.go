@@ -23,10 +23,19 @@ const (
 	ServiceDomain = "local."
 )
 
-// Peer describes one discovered SnapSync receiver.
+// Roles a peer advertises in its TXT record.
+const (
+	// RoleReceiver accepts pushed transfers from `snapsync send`.
+	RoleReceiver = "recv"
+	// RoleShare serves files for `snapsync get` to pull.
+	RoleShare = "share"
+)
+
+// Peer describes one discovered SnapSync receiver or share.
 type Peer struct {
 	ID        string
 	Name      string
+	Role      string
 	Addresses []string
 	Port      int
 	LastSeen  time.Time
@@ -63,6 +72,14 @@ func NewPeer(id, name string, addresses []net.IP, port int, seen time.Time) Peer
 	return Peer{ID: id, Name: name, Addresses: parts, Port: port, LastSeen: seen}
 }
 
+// HasRole reports whether the peer advertises role. Peers that predate roles are receivers.
+func (p Peer) HasRole(role string) bool {
+	if p.Role == "" {
+		return role == RoleReceiver
+	}
+	return p.Role == role
+}
+
 // PreferredAddress returns best-effort address for connecting.
 func (p Peer) PreferredAddress() string {
 	for _, addr := range p.Addresses {
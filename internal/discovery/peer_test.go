@@ -28,10 +28,15 @@ func TestParseTXTAndAnnouncement(t *testing.T) {
 	if !ok {
 		t.Fatal("expected valid announcement parse")
 	}
-	if peer.ID != "a1b2c3d4e5f6" || peer.Port != 45999 || peer.Name != "Laptop" {
+	if peer.ID != "a1b2c3d4e5f6" || peer.Port != 45999 || peer.Name != "Laptop" || peer.Role != RoleReceiver {
 		t.Fatalf("unexpected peer: %#v", peer)
 	}
 	if len(peer.Addresses) == 0 || net.ParseIP(peer.Addresses[0]) == nil {
 		t.Fatalf("expected parseable address, got %#v", peer.Addresses)
 	}
+
+	pkt = buildAnnouncement("Laptop", ServiceType+".local", "host.local", 46000, []string{"ver=1", "id=a1b2c3d4e5f6", "name=Laptop", "role=share"})
+	if peer, ok = parseAnnouncement(pkt); !ok || !peer.HasRole(RoleShare) || peer.HasRole(RoleReceiver) {
+		t.Fatalf("expected share role, got %#v", peer)
+	}
 }
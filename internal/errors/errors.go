@@ -18,28 +18,63 @@ var (
 	ErrIntegrity = sterrors.New("integrity error")
 	// ErrLockBusy indicates output lock contention.
 	ErrLockBusy = sterrors.New("lock busy")
+	// ErrAuth indicates peer authentication or secure channel failures.
+	ErrAuth = sterrors.New("authentication failed")
+	// ErrPairing indicates a short-code pairing exchange failed, usually a wrong code.
+	ErrPairing = sterrors.New("pairing code mismatch")
+	// ErrCancelled indicates a user on either side cancelled the transfer.
+	ErrCancelled = sterrors.New("transfer cancelled")
+	// ErrDiskFull indicates the receiver ran out of disk space.
+	ErrDiskFull = sterrors.New("disk full")
+	// ErrPolicy indicates a transfer was stopped for violating a peer's policy.
+	ErrPolicy = sterrors.New("policy violation")
 )
 
+// classes maps sentinels to exit codes and class names, in match order. A cancel
+// reason outranks the rejection or network error it arrives with.
+var classes = []struct {
+	err  error
+	code int
+	name string
+}{
+	{ErrCancelled, 10, "cancelled"},
+	{ErrDiskFull, 11, "disk_full"},
+	{ErrPolicy, 12, "policy"},
+	{ErrUsage, 2, "usage"},
+	{ErrNetwork, 3, "network"},
+	{ErrInvalidProtocol, 4, "protocol"},
+	{ErrRejected, 5, "rejected"},
+	{ErrIntegrity, 6, "integrity"},
+	{ErrLockBusy, 7, "lock_busy"},
+	{ErrAuth, 8, "auth"},
+	{ErrPairing, 9, "pairing"},
+	{ErrIO, 1, "io"},
+}
+
 // ExitCode maps an error to a process exit code.
 func ExitCode(err error) int {
 	if err == nil {
 		return 0
 	}
+	for _, c := range classes {
+		if sterrors.Is(err, c.err) {
+			return c.code
+		}
+	}
+	return 1
+}
 
-	switch {
-	case sterrors.Is(err, ErrUsage):
-		return 2
-	case sterrors.Is(err, ErrNetwork):
-		return 3
-	case sterrors.Is(err, ErrInvalidProtocol):
-		return 4
-	case sterrors.Is(err, ErrRejected):
-		return 5
-	case sterrors.Is(err, ErrIntegrity):
-		return 6
-	case sterrors.Is(err, ErrLockBusy):
-		return 7
-	default:
-		return 1
+// Class names the class of err that decides its exit code, such as "network" or
+// "disk_full", for machine-readable output. Errors without a sentinel are
+// "internal", and a nil error has no class.
+func Class(err error) string {
+	if err == nil {
+		return ""
+	}
+	for _, c := range classes {
+		if sterrors.Is(err, c.err) {
+			return c.name
+		}
 	}
+	return "internal"
 }
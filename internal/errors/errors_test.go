@@ -0,0 +1,42 @@
+package errors
+
+import (
+	sterrors "errors"
+	"fmt"
+	"testing"
+)
+
+func TestExitCodeAndClass(t *testing.T) {
+	tests := []struct {
+		err   error
+		code  int
+		class string
+	}{
+		{nil, 0, ""},
+		{sterrors.New("boom"), 1, "internal"},
+		{fmt.Errorf("write: %w", ErrIO), 1, "io"},
+		{ErrUsage, 2, "usage"},
+		{fmt.Errorf("dial: %w", ErrNetwork), 3, "network"},
+		{ErrInvalidProtocol, 4, "protocol"},
+		{fmt.Errorf("untrusted peer: %w", ErrRejected), 5, "rejected"},
+		{ErrIntegrity, 6, "integrity"},
+		{ErrLockBusy, 7, "lock_busy"},
+		{ErrAuth, 8, "auth"},
+		{ErrPairing, 9, "pairing"},
+		{ErrCancelled, 10, "cancelled"},
+		{ErrDiskFull, 11, "disk_full"},
+		{ErrPolicy, 12, "policy"},
+		// A cancel reason outranks the error it arrives with.
+		{fmt.Errorf("peer cancelled: %w: %w", ErrCancelled, ErrNetwork), 10, "cancelled"},
+		{fmt.Errorf("refused: %w: %w", ErrPolicy, ErrRejected), 12, "policy"},
+		{fmt.Errorf("write: %w: %w", ErrDiskFull, ErrIO), 11, "disk_full"},
+	}
+	for _, tc := range tests {
+		if got := ExitCode(tc.err); got != tc.code {
+			t.Errorf("ExitCode(%v) = %d, want %d", tc.err, got, tc.code)
+		}
+		if got := Class(tc.err); got != tc.class {
+			t.Errorf("Class(%v) = %q, want %q", tc.err, got, tc.class)
+		}
+	}
+}
@@ -1,27 +1,305 @@
-// Package hash provides streaming integrity hashing helpers.
 package hash
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
-	"hash"
+	"encoding/binary"
+	stdhash "hash"
+	"math/bits"
+	"runtime"
+	"sync"
 )
 
-// Hasher wraps incremental hashing for transfer integrity.
-type Hasher struct {
-	h hash.Hash
+// BLAKE3 hash mode with a 32-byte output, following the reference implementation.
+
+const (
+	blake3Size     = 32
+	blake3BlockLen = 64
+	blake3ChunkLen = 1024
+
+	// parallelChunks is the minimum number of whole chunks each hashing goroutine gets.
+	parallelChunks = 64
+
+	flagChunkStart = 1 << 0
+	flagChunkEnd   = 1 << 1
+	flagParent     = 1 << 2
+	flagRoot       = 1 << 3
+)
+
+var blake3IV = [8]uint32{
+	0x6A09E667, 0xBB67AE85, 0x3C6EF372, 0xA54FF53A, 0x510E527F, 0x9B05688C, 0x1F83D9AB, 0x5BE0CD19,
 }
 
-// New creates a new 32-byte digest hasher.
-func New() (*Hasher, error) {
-	return &Hasher{h: sha256.New()}, nil
+func g(a, b, c, d, x, y uint32) (uint32, uint32, uint32, uint32) {
+	a += b + x
+	d = bits.RotateLeft32(d^a, -16)
+	c += d
+	b = bits.RotateLeft32(b^c, -12)
+	a += b + y
+	d = bits.RotateLeft32(d^a, -8)
+	c += d
+	b = bits.RotateLeft32(b^c, -7)
+	return a, b, c, d
 }
 
-// Write adds data to the hash state.
-func (h *Hasher) Write(p []byte) (int, error) { return h.h.Write(p) }
+// compress runs the BLAKE3 compression function and returns the full 16-word state.
+func compress(cv *[8]uint32, m *[16]uint32, counter uint64, blockLen, flags uint32) [16]uint32 {
+	v0, v1, v2, v3, v4, v5, v6, v7 := cv[0], cv[1], cv[2], cv[3], cv[4], cv[5], cv[6], cv[7]
+	v8, v9, v10, v11 := blake3IV[0], blake3IV[1], blake3IV[2], blake3IV[3]
+	v12, v13, v14, v15 := uint32(counter), uint32(counter>>32), blockLen, flags
+	// The seven rounds are unrolled with the message schedule already permuted.
+	// Round 1.
+	v0, v4, v8, v12 = g(v0, v4, v8, v12, m[0], m[1])
+	v1, v5, v9, v13 = g(v1, v5, v9, v13, m[2], m[3])
+	v2, v6, v10, v14 = g(v2, v6, v10, v14, m[4], m[5])
+	v3, v7, v11, v15 = g(v3, v7, v11, v15, m[6], m[7])
+	v0, v5, v10, v15 = g(v0, v5, v10, v15, m[8], m[9])
+	v1, v6, v11, v12 = g(v1, v6, v11, v12, m[10], m[11])
+	v2, v7, v8, v13 = g(v2, v7, v8, v13, m[12], m[13])
+	v3, v4, v9, v14 = g(v3, v4, v9, v14, m[14], m[15])
+	// Round 2.
+	v0, v4, v8, v12 = g(v0, v4, v8, v12, m[2], m[6])
+	v1, v5, v9, v13 = g(v1, v5, v9, v13, m[3], m[10])
+	v2, v6, v10, v14 = g(v2, v6, v10, v14, m[7], m[0])
+	v3, v7, v11, v15 = g(v3, v7, v11, v15, m[4], m[13])
+	v0, v5, v10, v15 = g(v0, v5, v10, v15, m[1], m[11])
+	v1, v6, v11, v12 = g(v1, v6, v11, v12, m[12], m[5])
+	v2, v7, v8, v13 = g(v2, v7, v8, v13, m[9], m[14])
+	v3, v4, v9, v14 = g(v3, v4, v9, v14, m[15], m[8])
+	// Round 3.
+	v0, v4, v8, v12 = g(v0, v4, v8, v12, m[3], m[4])
+	v1, v5, v9, v13 = g(v1, v5, v9, v13, m[10], m[12])
+	v2, v6, v10, v14 = g(v2, v6, v10, v14, m[13], m[2])
+	v3, v7, v11, v15 = g(v3, v7, v11, v15, m[7], m[14])
+	v0, v5, v10, v15 = g(v0, v5, v10, v15, m[6], m[5])
+	v1, v6, v11, v12 = g(v1, v6, v11, v12, m[9], m[0])
+	v2, v7, v8, v13 = g(v2, v7, v8, v13, m[11], m[15])
+	v3, v4, v9, v14 = g(v3, v4, v9, v14, m[8], m[1])
+	// Round 4.
+	v0, v4, v8, v12 = g(v0, v4, v8, v12, m[10], m[7])
+	v1, v5, v9, v13 = g(v1, v5, v9, v13, m[12], m[9])
+	v2, v6, v10, v14 = g(v2, v6, v10, v14, m[14], m[3])
+	v3, v7, v11, v15 = g(v3, v7, v11, v15, m[13], m[15])
+	v0, v5, v10, v15 = g(v0, v5, v10, v15, m[4], m[0])
+	v1, v6, v11, v12 = g(v1, v6, v11, v12, m[11], m[2])
+	v2, v7, v8, v13 = g(v2, v7, v8, v13, m[5], m[8])
+	v3, v4, v9, v14 = g(v3, v4, v9, v14, m[1], m[6])
+	// Round 5.
+	v0, v4, v8, v12 = g(v0, v4, v8, v12, m[12], m[13])
+	v1, v5, v9, v13 = g(v1, v5, v9, v13, m[9], m[11])
+	v2, v6, v10, v14 = g(v2, v6, v10, v14, m[15], m[10])
+	v3, v7, v11, v15 = g(v3, v7, v11, v15, m[14], m[8])
+	v0, v5, v10, v15 = g(v0, v5, v10, v15, m[7], m[2])
+	v1, v6, v11, v12 = g(v1, v6, v11, v12, m[5], m[3])
+	v2, v7, v8, v13 = g(v2, v7, v8, v13, m[0], m[1])
+	v3, v4, v9, v14 = g(v3, v4, v9, v14, m[6], m[4])
+	// Round 6.
+	v0, v4, v8, v12 = g(v0, v4, v8, v12, m[9], m[14])
+	v1, v5, v9, v13 = g(v1, v5, v9, v13, m[11], m[5])
+	v2, v6, v10, v14 = g(v2, v6, v10, v14, m[8], m[12])
+	v3, v7, v11, v15 = g(v3, v7, v11, v15, m[15], m[1])
+	v0, v5, v10, v15 = g(v0, v5, v10, v15, m[13], m[3])
+	v1, v6, v11, v12 = g(v1, v6, v11, v12, m[0], m[10])
+	v2, v7, v8, v13 = g(v2, v7, v8, v13, m[2], m[6])
+	v3, v4, v9, v14 = g(v3, v4, v9, v14, m[4], m[7])
+	// Round 7.
+	v0, v4, v8, v12 = g(v0, v4, v8, v12, m[11], m[15])
+	v1, v5, v9, v13 = g(v1, v5, v9, v13, m[5], m[0])
+	v2, v6, v10, v14 = g(v2, v6, v10, v14, m[1], m[9])
+	v3, v7, v11, v15 = g(v3, v7, v11, v15, m[8], m[6])
+	v0, v5, v10, v15 = g(v0, v5, v10, v15, m[14], m[10])
+	v1, v6, v11, v12 = g(v1, v6, v11, v12, m[2], m[12])
+	v2, v7, v8, v13 = g(v2, v7, v8, v13, m[3], m[4])
+	v3, v4, v9, v14 = g(v3, v4, v9, v14, m[7], m[13])
+	return [16]uint32{
+		v0 ^ v8, v1 ^ v9, v2 ^ v10, v3 ^ v11, v4 ^ v12, v5 ^ v13, v6 ^ v14, v7 ^ v15,
+		v8 ^ cv[0], v9 ^ cv[1], v10 ^ cv[2], v11 ^ cv[3], v12 ^ cv[4], v13 ^ cv[5], v14 ^ cv[6], v15 ^ cv[7],
+	}
+}
 
-// Sum returns raw 32-byte digest.
-func (h *Hasher) Sum() []byte { return h.h.Sum(nil) }
+func blockWords(block []byte) [16]uint32 {
+	var m [16]uint32
+	for i := range m {
+		m[i] = binary.LittleEndian.Uint32(block[4*i : 4*i+4])
+	}
+	return m
+}
+
+// blake3Output is a deferred compression that yields either a chaining value or root bytes.
+type blake3Output struct {
+	cv       [8]uint32
+	block    [16]uint32
+	counter  uint64
+	blockLen uint32
+	flags    uint32
+}
+
+func (o *blake3Output) chainingValue() [8]uint32 {
+	state := compress(&o.cv, &o.block, o.counter, o.blockLen, o.flags)
+	var cv [8]uint32
+	copy(cv[:], state[:8])
+	return cv
+}
 
-// SumHex returns lowercase hex digest.
-func (h *Hasher) SumHex() string { return hex.EncodeToString(h.Sum()) }
+func (o *blake3Output) root(dst []byte) []byte {
+	state := compress(&o.cv, &o.block, 0, o.blockLen, o.flags|flagRoot)
+	for _, w := range state[:8] {
+		dst = binary.LittleEndian.AppendUint32(dst, w)
+	}
+	return dst
+}
+
+func parentOutput(left, right [8]uint32) blake3Output {
+	o := blake3Output{cv: blake3IV, blockLen: blake3BlockLen, flags: flagParent}
+	copy(o.block[:8], left[:])
+	copy(o.block[8:], right[:])
+	return o
+}
+
+// chunkState hashes one 1 KiB chunk block by block.
+type chunkState struct {
+	cv               [8]uint32
+	counter          uint64
+	block            [blake3BlockLen]byte
+	blockLen         int
+	blocksCompressed int
+}
+
+func (c *chunkState) len() int { return blake3BlockLen*c.blocksCompressed + c.blockLen }
+
+func (c *chunkState) startFlag() uint32 {
+	if c.blocksCompressed == 0 {
+		return flagChunkStart
+	}
+	return 0
+}
+
+func (c *chunkState) update(p []byte) {
+	for len(p) > 0 {
+		if c.blockLen == blake3BlockLen {
+			c.compressBlock(c.block[:])
+			c.block = [blake3BlockLen]byte{}
+			c.blockLen = 0
+		}
+		// A whole block followed by more input is never the chunk's last, so compress it in place.
+		if c.blockLen == 0 && len(p) > blake3BlockLen {
+			c.compressBlock(p[:blake3BlockLen])
+			p = p[blake3BlockLen:]
+			continue
+		}
+		n := copy(c.block[c.blockLen:], p)
+		c.blockLen += n
+		p = p[n:]
+	}
+}
+
+func (c *chunkState) compressBlock(block []byte) {
+	m := blockWords(block)
+	state := compress(&c.cv, &m, c.counter, blake3BlockLen, c.startFlag())
+	copy(c.cv[:], state[:8])
+	c.blocksCompressed++
+}
+
+func (c *chunkState) output() blake3Output {
+	return blake3Output{cv: c.cv, block: blockWords(c.block[:]), counter: c.counter, blockLen: uint32(c.blockLen), flags: c.startFlag() | flagChunkEnd}
+}
+
+// blake3Digest implements hash.Hash for BLAKE3 with a stack of completed subtree chaining values.
+type blake3Digest struct {
+	chunk    chunkState
+	stack    [54][8]uint32
+	stackLen int
+}
+
+func newBLAKE3() stdhash.Hash {
+	d := &blake3Digest{}
+	d.Reset()
+	return d
+}
+
+func (d *blake3Digest) Reset() {
+	d.chunk = chunkState{cv: blake3IV}
+	d.stackLen = 0
+}
+
+func (d *blake3Digest) Size() int { return blake3Size }
+
+func (d *blake3Digest) BlockSize() int { return blake3BlockLen }
+
+func (d *blake3Digest) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if d.chunk.len() == blake3ChunkLen {
+			out := d.chunk.output()
+			total := d.chunk.counter + 1
+			d.addChunkCV(out.chainingValue(), total)
+			d.chunk = chunkState{cv: blake3IV, counter: total}
+		}
+		if d.chunk.len() == 0 && len(p) > parallelChunks*blake3ChunkLen {
+			// Keep at least one byte back so the final chunk is never finalized early.
+			p = d.writeChunks(p[:(len(p)-1)/blake3ChunkLen*blake3ChunkLen], p)
+			continue
+		}
+		take := blake3ChunkLen - d.chunk.len()
+		if take > len(p) {
+			take = len(p)
+		}
+		d.chunk.update(p[:take])
+		p = p[take:]
+	}
+	return n, nil
+}
+
+// writeChunks hashes whole chunks across CPUs and merges their chaining values in order.
+// It returns the input that follows them.
+func (d *blake3Digest) writeChunks(chunks, p []byte) []byte {
+	count := len(chunks) / blake3ChunkLen
+	cvs := make([][8]uint32, count)
+	workers := runtime.GOMAXPROCS(0)
+	if workers > count/parallelChunks {
+		workers = count / parallelChunks
+	}
+	base := d.chunk.counter
+	var wg sync.WaitGroup
+	per := (count + workers - 1) / workers
+	for start := 0; start < count; start += per {
+		end := start + per
+		if end > count {
+			end = count
+		}
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				c := chunkState{cv: blake3IV, counter: base + uint64(i)}
+				c.update(chunks[i*blake3ChunkLen : (i+1)*blake3ChunkLen])
+				out := c.output()
+				cvs[i] = out.chainingValue()
+			}
+		}(start, end)
+	}
+	wg.Wait()
+	for i, cv := range cvs {
+		d.addChunkCV(cv, base+uint64(i)+1)
+	}
+	d.chunk = chunkState{cv: blake3IV, counter: base + uint64(count)}
+	return p[len(chunks):]
+}
+
+// addChunkCV merges completed subtrees; the trailing zero bits of total count the merges.
+func (d *blake3Digest) addChunkCV(cv [8]uint32, total uint64) {
+	for total&1 == 0 {
+		d.stackLen--
+		parent := parentOutput(d.stack[d.stackLen], cv)
+		cv = parent.chainingValue()
+		total >>= 1
+	}
+	d.stack[d.stackLen] = cv
+	d.stackLen++
+}
+
+func (d *blake3Digest) Sum(b []byte) []byte {
+	out := d.chunk.output()
+	for i := d.stackLen - 1; i >= 0; i-- {
+		out = parentOutput(d.stack[i], out.chainingValue())
+	}
+	return out.root(b)
+}
@@ -1,27 +1,74 @@
 package hash
 
-import "testing"
+import (
+	"encoding/hex"
+	"testing"
+)
 
-func TestKnownVector(t *testing.T) {
-	h, err := New()
-	if err != nil {
-		t.Fatalf("New() error = %v", err)
+// vectorInput returns the input used by the official BLAKE3 and XXH3 test vectors.
+func vectorInput(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i % 251)
 	}
-	_, _ = h.Write([]byte("abc"))
-	got := h.SumHex()
-	const want = "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
-	if got != want {
-		t.Fatalf("hash mismatch got %s want %s", got, want)
+	return b
+}
+
+var hashVectors = []struct {
+	n      int
+	blake3 string
+	xxh3   uint64
+}{
+	{0, "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262", 0x2d06800538d394c2},
+	{1, "2d3adedff11b61f14c886e35afa036736dcd87a74d27b5c1510225d0f592e213", 0xc44bdff4074eecdb},
+	{3, "e1be4d7a8ab5560aa4199eea339849ba8e293d55ca0a81006726d184519e647f", 0x5f4299fc161c9cbb},
+	{16, "a6a492965517a830cb75fdb713465aa465f2f098233896fea44c1d98268bf9e3", 0x8355e3a6f61770db},
+	{17, "8462aa7be93b09fda7b93cf9f9cddb703f6dd2cc0c8edd5f9eee092edf8abf0c", 0x9ef341a99de37328},
+	{64, "4eed7141ea4a5cd4b788606bd23f46e212af9cacebacdc7d1f4c6dc7f2511b98", 0x6187eb9089b0ed55},
+	{65, "de1e5fa0be70df6d2be8fffd0e99ceaa8eb6e8c93a63f2d8d1c30ecb6b263dee", 0x6928c76ce90422d0},
+	{128, "f17e570564b26578c33bb7f44643f539624b05df1a76c81f30acd548c44b45ef", 0x85c6174c7ff4c46b},
+	{129, "683aaae9f3c5ba37eaaf072aed0f9e30bac0865137bae68b1fde4ca2aebdcb12", 0xec7642b431ba3e5a},
+	{200, "f9c991a91ce818ab00f3bf22cef993a2f8d9ab0206f2b9efcef063bb19046966", 0xf42a8864feaf0703},
+	{240, "45e1a0dc23dbe51733d7269a3c0f519c2a63b0718835b2b537677eba734db0d8", 0x375a384d957fe865},
+	{241, "749b36ae651c22e8567db692a6876e0ca4fd3daeb7aa8fa3ab2f642ccc69a8f6", 0x02e8cd95421c6d02},
+	{1023, "10108970eeda3eb932baac1428c7a2163b0e924c9a9e25b35bba72b28f70bd11", 0xd3d91d80ac495685},
+	{1024, "42214739f095a406f3fc83deb889744ac00df831c10daa55189b5d121c855af7", 0xe5d78bafa45b2aa5},
+	{1025, "d00278ae47eb27b34faecf67b4fe263f82d5412916c1ffd97c8cb7fb814b8444", 0xe95c42288f28186e},
+	{2048, "e776b6028c7cd22a4d0ba182a8bf62205d2ef576467e838ed6f2529b85fba24a", 0x25339063db861586},
+	{2049, "5f4d72f40d7a5f82b15ca2b2e44b1de3c2ef86c426c95c1af0b6879522563030", 0x6c9600c0e506e2ae},
+	{3072, "b98cb0ff3623be03326b373de6b9095218513e64f1ee2edd2525c7ad1e5cffd2", 0x4adb90b35034df6b},
+	{4096, "015094013f57a5277b59d8475c0501042c0b642e531b0a1c8f58d2163229e969", 0x7135ffa504f1bc71},
+	{8192, "aae792484c8efe4f19e2ca7d371d8c467ffb10748d8a5a1ae579948f718a2a63", 0x40a71c16bbe37322},
+	{100000, "d93c23eedaf165a7e0be908ba86f1a7a520d568d2d13cde787c8580c5c72cc54", 0x42c23aeead96750d},
+	{3145733, "a7bb55bed0c04f58879d1fc1cafb27e14e931f4411fe63baf5b2d5a60357bffb", 0x36b06219b3b11d64},
+}
+
+func TestBLAKE3Vectors(t *testing.T) {
+	for _, v := range hashVectors {
+		h, _ := New(BLAKE3)
+		_, _ = h.Write(vectorInput(v.n))
+		if got := h.SumHex(); got != v.blake3 {
+			t.Fatalf("blake3(%d) = %s want %s", v.n, got, v.blake3)
+		}
 	}
 }
 
-func TestStreamingEqualsSingleWrite(t *testing.T) {
-	a, _ := New()
-	_, _ = a.Write([]byte("hello world"))
-	b, _ := New()
-	_, _ = b.Write([]byte("hello "))
-	_, _ = b.Write([]byte("world"))
-	if a.SumHex() != b.SumHex() {
-		t.Fatalf("streaming mismatch got %s vs %s", a.SumHex(), b.SumHex())
+func TestBLAKE3OddWriteSizesMatchSingleWrite(t *testing.T) {
+	input := vectorInput(70000)
+	single, _ := New(BLAKE3)
+	_, _ = single.Write(input)
+	want := single.Sum()
+	for _, step := range []int{1, 63, 64, 65, 1023, 1024, 1025, 4097} {
+		h, _ := New(BLAKE3)
+		for off := 0; off < len(input); off += step {
+			end := off + step
+			if end > len(input) {
+				end = len(input)
+			}
+			_, _ = h.Write(input[off:end])
+		}
+		if got := h.Sum(); hex.EncodeToString(got) != hex.EncodeToString(want) {
+			t.Fatalf("step %d: streaming digest mismatch", step)
+		}
 	}
 }
@@ -0,0 +1,100 @@
+// Package hash provides streaming integrity hashing helpers.
+package hash
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	stdhash "hash"
+	"strings"
+
+	apperrors "snapsync/internal/errors"
+)
+
+// Algorithm identifies a digest algorithm on the wire.
+type Algorithm uint8
+
+const (
+	// SHA256 is SHA-256. Legacy v1 peers only speak this algorithm.
+	SHA256 Algorithm = 1
+	// BLAKE3 is the 256-bit BLAKE3 hash and the default.
+	BLAKE3 Algorithm = 2
+	// XXH3 is the 64-bit XXH3 checksum. It is fast but not collision resistant,
+	// so it only guards against accidental corruption.
+	XXH3 Algorithm = 3
+)
+
+// Default is the algorithm used when none is configured.
+const Default = BLAKE3
+
+// MaxSize is the largest digest size of any registered algorithm.
+const MaxSize = 32
+
+type algorithmInfo struct {
+	name string
+	size int
+	new  func() stdhash.Hash
+}
+
+var registry = map[Algorithm]algorithmInfo{
+	SHA256: {name: "sha256", size: sha256.Size, new: sha256.New},
+	BLAKE3: {name: "blake3", size: blake3Size, new: newBLAKE3},
+	XXH3:   {name: "xxh3", size: xxh3Size, new: newXXH3},
+}
+
+// Algorithms lists registered algorithms in default preference order.
+func Algorithms() []Algorithm { return []Algorithm{BLAKE3, SHA256, XXH3} }
+
+// Supported reports whether the algorithm is registered.
+func (a Algorithm) Supported() bool {
+	_, ok := registry[a]
+	return ok
+}
+
+// String returns the algorithm name used in CLI flags and output.
+func (a Algorithm) String() string {
+	if info, ok := registry[a]; ok {
+		return info.name
+	}
+	return fmt.Sprintf("hash(%d)", uint8(a))
+}
+
+// Size returns the digest size in bytes, or zero for unknown algorithms.
+func (a Algorithm) Size() int { return registry[a].size }
+
+// ParseAlgorithm resolves an algorithm name such as "blake3".
+func ParseAlgorithm(name string) (Algorithm, error) {
+	for alg, info := range registry {
+		if strings.EqualFold(name, info.name) {
+			return alg, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown hash algorithm %q (want blake3, sha256, or xxh3): %w", name, apperrors.ErrUsage)
+}
+
+// Hasher wraps incremental hashing for transfer integrity.
+type Hasher struct {
+	alg Algorithm
+	h   stdhash.Hash
+}
+
+// New creates a hasher for the given algorithm.
+func New(alg Algorithm) (*Hasher, error) {
+	info, ok := registry[alg]
+	if !ok {
+		return nil, fmt.Errorf("unsupported hash algorithm %d: %w", uint8(alg), apperrors.ErrInvalidProtocol)
+	}
+	return &Hasher{alg: alg, h: info.new()}, nil
+}
+
+// Algorithm returns the digest algorithm.
+func (h *Hasher) Algorithm() Algorithm { return h.alg }
+
+// Write adds data to the hash state.
+func (h *Hasher) Write(p []byte) (int, error) { return h.h.Write(p) }
+
+// Sum returns the raw digest.
+func (h *Hasher) Sum() []byte { return h.h.Sum(nil) }
+
+// SumHex returns lowercase hex digest.
+func (h *Hasher) SumHex() string { return hex.EncodeToString(h.Sum()) }
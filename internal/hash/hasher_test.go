@@ -0,0 +1,47 @@
+package hash
+
+import "testing"
+
+func TestKnownVector(t *testing.T) {
+	h, err := New(SHA256)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	_, _ = h.Write([]byte("abc"))
+	got := h.SumHex()
+	const want = "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+	if got != want {
+		t.Fatalf("hash mismatch got %s want %s", got, want)
+	}
+}
+
+func TestStreamingEqualsSingleWrite(t *testing.T) {
+	for _, alg := range Algorithms() {
+		a, _ := New(alg)
+		_, _ = a.Write([]byte("hello world"))
+		b, _ := New(alg)
+		_, _ = b.Write([]byte("hello "))
+		_, _ = b.Write([]byte("world"))
+		if a.SumHex() != b.SumHex() {
+			t.Fatalf("%s streaming mismatch got %s vs %s", alg, a.SumHex(), b.SumHex())
+		}
+		if len(a.Sum()) != alg.Size() {
+			t.Fatalf("%s digest length %d want %d", alg, len(a.Sum()), alg.Size())
+		}
+	}
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	for _, alg := range Algorithms() {
+		got, err := ParseAlgorithm(alg.String())
+		if err != nil || got != alg {
+			t.Fatalf("ParseAlgorithm(%q) = %v, %v", alg.String(), got, err)
+		}
+	}
+	if _, err := ParseAlgorithm("md5"); err == nil {
+		t.Fatal("expected unknown algorithm to fail")
+	}
+	if _, err := New(Algorithm(0)); err == nil {
+		t.Fatal("expected New to reject unknown algorithm")
+	}
+}
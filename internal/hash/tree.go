@@ -0,0 +1,89 @@
+package hash
+
+import "fmt"
+
+// Tree hashes a stream as fixed-size blocks. Each block digest is a leaf, and leaves
+// combine pairwise as H(0x01 || left || right) into a root; an odd node is promoted
+// unchanged. A single-block stream therefore has the plain digest as its root.
+type Tree struct {
+	alg       Algorithm
+	blockSize uint64
+	block     *Hasher
+	filled    uint64
+	leaves    [][]byte
+}
+
+// NewTree starts a tree after the given already-verified prefix leaves.
+// A zero blockSize hashes the whole stream as one block.
+func NewTree(alg Algorithm, blockSize uint64, prefix [][]byte) (*Tree, error) {
+	block, err := New(alg)
+	if err != nil {
+		return nil, err
+	}
+	for i, leaf := range prefix {
+		if len(leaf) != alg.Size() {
+			return nil, fmt.Errorf("prefix leaf %d has %d bytes, want %d", i, len(leaf), alg.Size())
+		}
+	}
+	return &Tree{alg: alg, blockSize: blockSize, block: block, leaves: append([][]byte(nil), prefix...)}, nil
+}
+
+// Write hashes p, completing a leaf each time a block fills.
+func (t *Tree) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		take := len(p)
+		if t.blockSize > 0 && uint64(take) > t.blockSize-t.filled {
+			take = int(t.blockSize - t.filled)
+		}
+		_, _ = t.block.Write(p[:take])
+		t.filled += uint64(take)
+		p = p[take:]
+		if t.blockSize > 0 && t.filled == t.blockSize {
+			t.closeBlock()
+		}
+	}
+	return n, nil
+}
+
+// Finish closes the trailing partial block. An empty stream gets one empty leaf.
+func (t *Tree) Finish() {
+	if t.filled > 0 || len(t.leaves) == 0 {
+		t.closeBlock()
+	}
+}
+
+func (t *Tree) closeBlock() {
+	t.leaves = append(t.leaves, t.block.Sum())
+	t.block, _ = New(t.alg)
+	t.filled = 0
+}
+
+// Leaves returns the completed leaf digests.
+func (t *Tree) Leaves() [][]byte { return t.leaves }
+
+// Root combines the completed leaves. Call Finish first.
+func (t *Tree) Root() []byte { return TreeRoot(t.alg, t.leaves) }
+
+// TreeRoot combines leaf digests into the tree root.
+func TreeRoot(alg Algorithm, leaves [][]byte) []byte {
+	if len(leaves) == 0 {
+		return nil
+	}
+	level := leaves
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i+1 < len(level); i += 2 {
+			h, _ := New(alg)
+			_, _ = h.Write([]byte{0x01})
+			_, _ = h.Write(level[i])
+			_, _ = h.Write(level[i+1])
+			next = append(next, h.Sum())
+		}
+		if len(level)%2 == 1 {
+			next = append(next, level[len(level)-1])
+		}
+		level = next
+	}
+	return level[0]
+}
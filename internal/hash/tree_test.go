@@ -0,0 +1,62 @@
+package hash
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTreeSingleBlockRootIsPlainDigest(t *testing.T) {
+	data := vectorInput(3000)
+	tree, _ := NewTree(BLAKE3, 4096, nil)
+	_, _ = tree.Write(data)
+	tree.Finish()
+	plain, _ := New(BLAKE3)
+	_, _ = plain.Write(data)
+	if !bytes.Equal(tree.Root(), plain.Sum()) {
+		t.Fatalf("single block root %x != digest %x", tree.Root(), plain.Sum())
+	}
+}
+
+func TestTreeResumesFromPrefixLeaves(t *testing.T) {
+	data := vectorInput(10*1000 + 7)
+	full, _ := NewTree(SHA256, 1000, nil)
+	for off := 0; off < len(data); off += 333 {
+		end := off + 333
+		if end > len(data) {
+			end = len(data)
+		}
+		_, _ = full.Write(data[off:end])
+	}
+	full.Finish()
+	if got := len(full.Leaves()); got != 11 {
+		t.Fatalf("expected 11 leaves, got %d", got)
+	}
+
+	resumed, err := NewTree(SHA256, 1000, full.Leaves()[:4])
+	if err != nil {
+		t.Fatalf("NewTree() error = %v", err)
+	}
+	_, _ = resumed.Write(data[4000:])
+	resumed.Finish()
+	if !bytes.Equal(resumed.Root(), full.Root()) {
+		t.Fatal("resumed tree root differs from full tree root")
+	}
+
+	data[5500] ^= 0xFF
+	corrupt, _ := NewTree(SHA256, 1000, nil)
+	_, _ = corrupt.Write(data)
+	corrupt.Finish()
+	for i := range corrupt.Leaves() {
+		if same := bytes.Equal(corrupt.Leaves()[i], full.Leaves()[i]); same == (i == 5) {
+			t.Fatalf("leaf %d: unexpected match state %v", i, same)
+		}
+	}
+}
+
+func TestTreeEmptyStreamHasOneLeaf(t *testing.T) {
+	tree, _ := NewTree(XXH3, 1024, nil)
+	tree.Finish()
+	if len(tree.Leaves()) != 1 || len(tree.Root()) != XXH3.Size() {
+		t.Fatalf("unexpected empty tree leaves=%d root=%x", len(tree.Leaves()), tree.Root())
+	}
+}
@@ -0,0 +1,240 @@
+package hash
+
+import (
+	"encoding/binary"
+	stdhash "hash"
+	"math/bits"
+)
+
+// XXH3 64-bit with seed 0 and the default secret, following the reference implementation.
+
+const (
+	xxh3Size = 8
+
+	prime32_1 = 0x9E3779B1
+	prime32_2 = 0x85EBCA77
+	prime32_3 = 0xC2B2AE3D
+	prime64_1 = 0x9E3779B185EBCA87
+	prime64_2 = 0xC2B2AE3D27D4EB4F
+	prime64_3 = 0x165667B19E3779F9
+	prime64_4 = 0x85EBCA77C2B2AE63
+	prime64_5 = 0x27D4EB2F165667C5
+	primeMX1  = 0x165667919E3779F9
+	primeMX2  = 0x9FB21C651E98DF25
+
+	xxhStripeLen        = 64
+	xxhSecretConsume    = 8
+	xxhMidSizeMax       = 240
+	xxhMidSizeStart     = 3
+	xxhMidSizeLast      = 17
+	xxhLastAccStart     = 7
+	xxhMergeAccsStart   = 11
+	xxhSecretSizeMin    = 136
+	xxhStripesPerBlock  = (len(xxhSecret) - xxhStripeLen) / xxhSecretConsume
+	xxhInternalBuffer   = 256
+	xxhStripesPerBuffer = xxhInternalBuffer / xxhStripeLen
+)
+
+var xxhSecret = [192]byte{
+	0xb8, 0xfe, 0x6c, 0x39, 0x23, 0xa4, 0x4b, 0xbe, 0x7c, 0x01, 0x81, 0x2c, 0xf7, 0x21, 0xad, 0x1c,
+	0xde, 0xd4, 0x6d, 0xe9, 0x83, 0x90, 0x97, 0xdb, 0x72, 0x40, 0xa4, 0xa4, 0xb7, 0xb3, 0x67, 0x1f,
+	0xcb, 0x79, 0xe6, 0x4e, 0xcc, 0xc0, 0xe5, 0x78, 0x82, 0x5a, 0xd0, 0x7d, 0xcc, 0xff, 0x72, 0x21,
+	0xb8, 0x08, 0x46, 0x74, 0xf7, 0x43, 0x24, 0x8e, 0xe0, 0x35, 0x90, 0xe6, 0x81, 0x3a, 0x26, 0x4c,
+	0x3c, 0x28, 0x52, 0xbb, 0x91, 0xc3, 0x00, 0xcb, 0x88, 0xd0, 0x65, 0x8b, 0x1b, 0x53, 0x2e, 0xa3,
+	0x71, 0x64, 0x48, 0x97, 0xa2, 0x0d, 0xf9, 0x4e, 0x38, 0x19, 0xef, 0x46, 0xa9, 0xde, 0xac, 0xd8,
+	0xa8, 0xfa, 0x76, 0x3f, 0xe3, 0x9c, 0x34, 0x3f, 0xf9, 0xdc, 0xbb, 0xc7, 0xc7, 0x0b, 0x4f, 0x1d,
+	0x8a, 0x51, 0xe0, 0x4b, 0xcd, 0xb4, 0x59, 0x31, 0xc8, 0x9f, 0x7e, 0xc9, 0xd9, 0x78, 0x73, 0x64,
+	0xea, 0xc5, 0xac, 0x83, 0x34, 0xd3, 0xeb, 0xc3, 0xc5, 0x81, 0xa0, 0xff, 0xfa, 0x13, 0x63, 0xeb,
+	0x17, 0x0d, 0xdd, 0x51, 0xb7, 0xf0, 0xda, 0x49, 0xd3, 0x16, 0x55, 0x26, 0x29, 0xd4, 0x68, 0x9e,
+	0x2b, 0x16, 0xbe, 0x58, 0x7d, 0x47, 0xa1, 0xfc, 0x8f, 0xf8, 0xb8, 0xd1, 0x7a, 0xd0, 0x31, 0xce,
+	0x45, 0xcb, 0x3a, 0x8f, 0x95, 0x16, 0x04, 0x28, 0xaf, 0xd7, 0xfb, 0xca, 0xbb, 0x4b, 0x40, 0x7e,
+}
+
+func le32(b []byte) uint32 { return binary.LittleEndian.Uint32(b) }
+
+func le64(b []byte) uint64 { return binary.LittleEndian.Uint64(b) }
+
+func mulFold64(a, b uint64) uint64 {
+	hi, lo := bits.Mul64(a, b)
+	return hi ^ lo
+}
+
+func xxh64Avalanche(h uint64) uint64 {
+	h ^= h >> 33
+	h *= prime64_2
+	h ^= h >> 29
+	h *= prime64_3
+	h ^= h >> 32
+	return h
+}
+
+func xxh3Avalanche(h uint64) uint64 {
+	h ^= h >> 37
+	h *= primeMX1
+	h ^= h >> 32
+	return h
+}
+
+func rrmxmx(h, length uint64) uint64 {
+	h ^= bits.RotateLeft64(h, 49) ^ bits.RotateLeft64(h, 24)
+	h *= primeMX2
+	h ^= (h >> 35) + length
+	h *= primeMX2
+	return h ^ (h >> 28)
+}
+
+func mix16(p, secret []byte) uint64 {
+	return mulFold64(le64(p)^le64(secret), le64(p[8:])^le64(secret[8:]))
+}
+
+// xxh3Short hashes inputs of at most xxhMidSizeMax bytes in one shot.
+func xxh3Short(p []byte) uint64 {
+	s := xxhSecret[:]
+	n := uint64(len(p))
+	switch {
+	case n == 0:
+		return xxh64Avalanche(le64(s[56:]) ^ le64(s[64:]))
+	case n <= 3:
+		combined := uint32(p[0])<<16 | uint32(p[n>>1])<<24 | uint32(p[n-1]) | uint32(n)<<8
+		return xxh64Avalanche(uint64(combined) ^ uint64(le32(s)^le32(s[4:])))
+	case n <= 8:
+		input := uint64(le32(p[n-4:])) + uint64(le32(p))<<32
+		return rrmxmx(input^(le64(s[8:])^le64(s[16:])), n)
+	case n <= 16:
+		lo := le64(p) ^ (le64(s[24:]) ^ le64(s[32:]))
+		hi := le64(p[n-8:]) ^ (le64(s[40:]) ^ le64(s[48:]))
+		return xxh3Avalanche(n + bits.ReverseBytes64(lo) + hi + mulFold64(lo, hi))
+	case n <= 128:
+		acc := n * prime64_1
+		if n > 32 {
+			if n > 64 {
+				if n > 96 {
+					acc += mix16(p[48:], s[96:])
+					acc += mix16(p[n-64:], s[112:])
+				}
+				acc += mix16(p[32:], s[64:])
+				acc += mix16(p[n-48:], s[80:])
+			}
+			acc += mix16(p[16:], s[32:])
+			acc += mix16(p[n-32:], s[48:])
+		}
+		acc += mix16(p, s)
+		acc += mix16(p[n-16:], s[16:])
+		return xxh3Avalanche(acc)
+	default:
+		acc := n * prime64_1
+		rounds := int(n / 16)
+		for i := 0; i < 8; i++ {
+			acc += mix16(p[16*i:], s[16*i:])
+		}
+		acc = xxh3Avalanche(acc)
+		for i := 8; i < rounds; i++ {
+			acc += mix16(p[16*i:], s[16*(i-8)+xxhMidSizeStart:])
+		}
+		acc += mix16(p[n-16:], s[xxhSecretSizeMin-xxhMidSizeLast:])
+		return xxh3Avalanche(acc)
+	}
+}
+
+func accumulate512(acc *[8]uint64, p, secret []byte) {
+	for i := 0; i < 8; i++ {
+		v := le64(p[8*i:])
+		k := v ^ le64(secret[8*i:])
+		acc[i^1] += v
+		acc[i] += uint64(uint32(k)) * (k >> 32)
+	}
+}
+
+func scrambleAcc(acc *[8]uint64, secret []byte) {
+	for i := 0; i < 8; i++ {
+		a := acc[i]
+		a ^= a >> 47
+		a ^= le64(secret[8*i:])
+		acc[i] = a * prime32_1
+	}
+}
+
+// xxh3Digest implements hash.Hash for 64-bit XXH3, buffering input in whole stripes.
+type xxh3Digest struct {
+	acc      [8]uint64
+	buf      [xxhInternalBuffer]byte
+	buffered int
+	stripes  int
+	total    uint64
+}
+
+func newXXH3() stdhash.Hash {
+	d := &xxh3Digest{}
+	d.Reset()
+	return d
+}
+
+func (d *xxh3Digest) Reset() {
+	d.acc = [8]uint64{prime32_3, prime64_1, prime64_2, prime64_3, prime64_4, prime32_2, prime64_5, prime32_1}
+	d.buffered = 0
+	d.stripes = 0
+	d.total = 0
+}
+
+func (d *xxh3Digest) Size() int { return xxh3Size }
+
+func (d *xxh3Digest) BlockSize() int { return xxhStripeLen }
+
+// consumeStripes accumulates stripes and scrambles at every block boundary.
+func (d *xxh3Digest) consumeStripes(acc *[8]uint64, stripesSoFar *int, p []byte, stripes int) {
+	secret := xxhSecret[:]
+	for i := 0; i < stripes; i++ {
+		accumulate512(acc, p[i*xxhStripeLen:], secret[*stripesSoFar*xxhSecretConsume:])
+		*stripesSoFar++
+		if *stripesSoFar == xxhStripesPerBlock {
+			scrambleAcc(acc, secret[len(secret)-xxhStripeLen:])
+			*stripesSoFar = 0
+		}
+	}
+}
+
+func (d *xxh3Digest) Write(p []byte) (int, error) {
+	n := len(p)
+	d.total += uint64(n)
+	for len(p) > 0 {
+		// The buffer is only consumed once more input arrives, so Sum always sees the final stripe.
+		if d.buffered == xxhInternalBuffer {
+			d.consumeStripes(&d.acc, &d.stripes, d.buf[:], xxhStripesPerBuffer)
+			d.buffered = 0
+		}
+		c := copy(d.buf[d.buffered:], p)
+		d.buffered += c
+		p = p[c:]
+	}
+	return n, nil
+}
+
+func (d *xxh3Digest) Sum(b []byte) []byte {
+	return binary.BigEndian.AppendUint64(b, d.sum64())
+}
+
+func (d *xxh3Digest) sum64() uint64 {
+	if d.total <= xxhMidSizeMax {
+		return xxh3Short(d.buf[:d.buffered])
+	}
+	secret := xxhSecret[:]
+	acc := d.acc
+	stripesSoFar := d.stripes
+	var last [xxhStripeLen]byte
+	if d.buffered >= xxhStripeLen {
+		d.consumeStripes(&acc, &stripesSoFar, d.buf[:], (d.buffered-1)/xxhStripeLen)
+		copy(last[:], d.buf[d.buffered-xxhStripeLen:d.buffered])
+	} else {
+		catchup := xxhStripeLen - d.buffered
+		copy(last[:], d.buf[xxhInternalBuffer-catchup:])
+		copy(last[catchup:], d.buf[:d.buffered])
+	}
+	accumulate512(&acc, last[:], secret[len(secret)-xxhStripeLen-xxhLastAccStart:])
+
+	result := d.total * prime64_1
+	for i := 0; i < 4; i++ {
+		ms := secret[xxhMergeAccsStart+16*i:]
+		result += mulFold64(acc[2*i]^le64(ms), acc[2*i+1]^le64(ms[8:]))
+	}
+	return xxh3Avalanche(result)
+}
@@ -0,0 +1,28 @@
+package hash
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestXXH3Vectors(t *testing.T) {
+	for _, v := range hashVectors {
+		for _, step := range []int{v.n + 1, 1, 63, 64, 255, 256, 257, 1000} {
+			if v.n > 10000 && step < 255 {
+				continue
+			}
+			h, _ := New(XXH3)
+			input := vectorInput(v.n)
+			for off := 0; off < len(input); off += step {
+				end := off + step
+				if end > len(input) {
+					end = len(input)
+				}
+				_, _ = h.Write(input[off:end])
+			}
+			if got := binary.BigEndian.Uint64(h.Sum()); got != v.xxh3 {
+				t.Fatalf("xxh3(%d) step %d = %016x want %016x", v.n, step, got, v.xxh3)
+			}
+		}
+	}
+}
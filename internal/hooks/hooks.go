@@ -0,0 +1,116 @@
+// Package hooks runs user commands around received files: a pre-accept hook that
+// can veto an offer and a post-receive hook that sees each finalized file.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Hook names, passed to commands as SNAPSYNC_HOOK and "hook".
+const (
+	PreAccept   = "pre-accept"
+	PostReceive = "post-receive"
+)
+
+// PreAcceptTimeout bounds a pre-accept hook, which the sender waits on. A hook
+// that runs longer is killed and vetoes the offer.
+const PreAcceptTimeout = 30 * time.Second
+
+// PostReceiveTimeout bounds a post-receive hook. Hooks run one after another once
+// their connection's transfers are over, so a stuck hook delays the rest and keeps
+// the connection open. A hook that runs longer is killed and reported as failed.
+const PostReceiveTimeout = 5 * time.Minute
+
+// waitDelay bounds how long Run waits for output after a killed hook, since
+// commands the shell started may still hold the output pipe open.
+const waitDelay = time.Second
+
+// maxReason caps how much hook output is quoted in errors and veto reasons.
+const maxReason = 256
+
+// Event is what a hook command is told, as SNAPSYNC_* environment variables and
+// as one JSON object on stdin. Size is -1 for a stream of unknown length; Path,
+// Hash, Digest, and DurationMS are only set after a file is received.
+type Event struct {
+	Hook        string `json:"hook"`
+	Name        string `json:"name"`
+	Size        int64  `json:"size"`
+	Peer        string `json:"peer"`
+	PeerID      string `json:"peer_id,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Path        string `json:"path,omitempty"`
+	Hash        string `json:"hash,omitempty"`
+	Digest      string `json:"digest,omitempty"`
+	DurationMS  int64  `json:"duration_ms,omitempty"`
+}
+
+// Run runs command through the platform shell with e. A non-zero exit is an error
+// quoting the start of the command's output, which a pre-accept hook can use to
+// explain its veto.
+func Run(ctx context.Context, command string, e Event) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("encode %s event: %w", e.Hook, err)
+	}
+	cmd := shell(ctx, command)
+	cmd.WaitDelay = waitDelay
+	cmd.Env = append(os.Environ(), e.env()...)
+	cmd.Stdin = bytes.NewReader(payload)
+	out, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return fmt.Errorf("%s hook: %w", e.Hook, ctx.Err())
+	}
+	if err != nil {
+		if reason := summarize(out); reason != "" {
+			return fmt.Errorf("%s hook: %s (%w)", e.Hook, reason, err)
+		}
+		return fmt.Errorf("%s hook: %w", e.Hook, err)
+	}
+	return nil
+}
+
+func shell(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "/bin/sh", "-c", command)
+}
+
+func (e Event) env() []string {
+	env := []string{
+		"SNAPSYNC_HOOK=" + e.Hook,
+		"SNAPSYNC_NAME=" + e.Name,
+		"SNAPSYNC_SIZE=" + strconv.FormatInt(e.Size, 10),
+		"SNAPSYNC_PEER=" + e.Peer,
+		"SNAPSYNC_PEER_ID=" + e.PeerID,
+		"SNAPSYNC_FINGERPRINT=" + e.Fingerprint,
+	}
+	if e.Hook == PostReceive {
+		env = append(env,
+			"SNAPSYNC_PATH="+e.Path,
+			"SNAPSYNC_HASH="+e.Hash,
+			"SNAPSYNC_DIGEST="+e.Digest,
+			"SNAPSYNC_DURATION_MS="+strconv.FormatInt(e.DurationMS, 10),
+		)
+	}
+	return env
+}
+
+// summarize returns the first line of out, shortened to maxReason bytes.
+func summarize(out []byte) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	line = strings.TrimSpace(line)
+	if len(line) > maxReason {
+		line = line[:maxReason] + "..."
+	}
+	return line
+}
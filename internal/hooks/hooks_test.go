@@ -0,0 +1,64 @@
+package hooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunPassesEventThroughEnvAndStdin(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook commands here use /bin/sh")
+	}
+	dir := t.TempDir()
+	envOut, jsonOut := filepath.Join(dir, "env"), filepath.Join(dir, "event.json")
+	command := `printf '%s|%s|%s|%s' "$SNAPSYNC_HOOK" "$SNAPSYNC_PATH" "$SNAPSYNC_SIZE" "$SNAPSYNC_DIGEST" > ` + envOut + ` && cat > ` + jsonOut
+	e := Event{Hook: PostReceive, Name: "a.txt", Size: 5, Peer: "lab@10.0.0.2:1", Path: "/out/a.txt", Hash: "sha256", Digest: "abcd", DurationMS: 12}
+	if err := Run(context.Background(), command, e); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got, _ := os.ReadFile(envOut); string(got) != "post-receive|/out/a.txt|5|abcd" {
+		t.Fatalf("hook environment = %q", got)
+	}
+	var decoded Event
+	data, _ := os.ReadFile(jsonOut)
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded != e {
+		t.Fatalf("hook stdin = %s, %v", data, err)
+	}
+}
+
+func TestRunReportsFailureOutput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook commands here use /bin/sh")
+	}
+	err := Run(context.Background(), "echo 'quota exceeded for lab' >&2; exit 3", Event{Hook: PreAccept, Name: "a.txt", Size: -1})
+	if err == nil || !strings.Contains(err.Error(), "pre-accept hook: quota exceeded for lab") {
+		t.Fatalf("expected failure quoting the output, got %v", err)
+	}
+	if err := Run(context.Background(), "exit 0", Event{Hook: PreAccept}); err != nil {
+		t.Fatalf("Run(exit 0) error = %v", err)
+	}
+}
+
+func TestRunKillsHookAtDeadline(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook commands here use /bin/sh")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	// The backgrounded sleep keeps the output pipe open after the shell is killed.
+	err := Run(ctx, "sleep 30 & wait", Event{Hook: PostReceive})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Run() returned after %s, want soon after the deadline", elapsed)
+	}
+}
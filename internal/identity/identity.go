@@ -0,0 +1,90 @@
+// Package identity manages the persistent peer signing identity.
+package identity
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"snapsync/internal/store"
+)
+
+// Identity pairs a peer ID with its long-term signing key.
+type Identity struct {
+	PeerID     string
+	PrivateKey ed25519.PrivateKey
+}
+
+// New builds an identity from a peer ID and an ed25519 seed.
+func New(peerID string, seed []byte) (Identity, error) {
+	if peerID == "" {
+		return Identity{}, fmt.Errorf("identity requires a peer id")
+	}
+	if len(seed) != ed25519.SeedSize {
+		return Identity{}, fmt.Errorf("invalid identity seed length %d", len(seed))
+	}
+	return Identity{PeerID: peerID, PrivateKey: ed25519.NewKeyFromSeed(seed)}, nil
+}
+
+// Generate creates an ephemeral identity that is not persisted.
+func Generate(peerID string) (Identity, error) {
+	seed := make([]byte, ed25519.SeedSize)
+	if _, err := rand.Read(seed); err != nil {
+		return Identity{}, fmt.Errorf("generate identity seed: %w", err)
+	}
+	return New(peerID, seed)
+}
+
+// LoadOrCreate loads the persisted identity key for peerID, creating one on first use.
+func LoadOrCreate(peerID string) (Identity, error) {
+	seed, err := store.LoadOrCreateIdentitySeed(func() ([]byte, error) {
+		buf := make([]byte, ed25519.SeedSize)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, fmt.Errorf("generate random bytes: %w", err)
+		}
+		return buf, nil
+	})
+	if err != nil {
+		return Identity{}, err
+	}
+	return New(peerID, seed)
+}
+
+// PublicKey returns the identity public key.
+func (i Identity) PublicKey() ed25519.PublicKey {
+	return i.PrivateKey.Public().(ed25519.PublicKey)
+}
+
+// Fingerprint returns the identity public key fingerprint.
+func (i Identity) Fingerprint() string {
+	return Fingerprint(i.PublicKey())
+}
+
+// fingerprintSize is how many bytes of the key digest a fingerprint shows.
+const fingerprintSize = 16
+
+// Fingerprint formats a short stable fingerprint for a public key.
+func Fingerprint(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:fingerprintSize])
+}
+
+// ParseFingerprint normalizes a fingerprint typed or pasted by a user, in either
+// case and with colons, dashes, or spaces between digits, to the form Fingerprint
+// returns.
+func ParseFingerprint(s string) (string, error) {
+	clean := strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '-', ' ':
+			return -1
+		}
+		return r
+	}, strings.ToLower(strings.TrimSpace(s)))
+	if _, err := hex.DecodeString(clean); err != nil || len(clean) != 2*fingerprintSize {
+		return "", fmt.Errorf("fingerprint %q is not %d hex digits", s, 2*fingerprintSize)
+	}
+	return clean, nil
+}
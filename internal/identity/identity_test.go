@@ -0,0 +1,39 @@
+package identity
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+)
+
+func TestNewIsDeterministicForSeed(t *testing.T) {
+	seed := bytes.Repeat([]byte{7}, 32)
+	a, err := New("peer1", seed)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	b, _ := New("peer1", seed)
+	if a.Fingerprint() != b.Fingerprint() {
+		t.Fatalf("fingerprint mismatch %s vs %s", a.Fingerprint(), b.Fingerprint())
+	}
+	if ok, _ := regexp.MatchString(`^[a-f0-9]{32}$`, a.Fingerprint()); !ok {
+		t.Fatalf("fingerprint format invalid: %q", a.Fingerprint())
+	}
+	if _, err := New("peer1", seed[:5]); err == nil {
+		t.Fatal("expected short seed failure")
+	}
+}
+
+func TestParseFingerprintNormalizesPastedValues(t *testing.T) {
+	const want = "00112233445566778899aabbccddeeff"
+	for _, in := range []string{want, "00112233445566778899AABBCCDDEEFF", "00:11:22:33:44:55:66:77:88:99:aa:bb:cc:dd:ee:ff", " 0011-2233 4455-6677 8899-AABB CCDD-EEFF "} {
+		if got, err := ParseFingerprint(in); err != nil || got != want {
+			t.Fatalf("ParseFingerprint(%q) = %q, %v", in, got, err)
+		}
+	}
+	for _, in := range []string{"", "ff00", want + "00", "00112233445566778899aabbccddeefg"} {
+		if _, err := ParseFingerprint(in); err == nil {
+			t.Fatalf("ParseFingerprint(%q) accepted an invalid fingerprint", in)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package pake
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"regexp"
+)
+
+var codeAdjectives = []string{
+	"amber", "azure", "bold", "brave", "bright", "calm", "clever", "coral",
+	"crimson", "crisp", "dusty", "eager", "early", "fancy", "fuzzy", "gentle",
+	"golden", "grand", "happy", "hidden", "honest", "icy", "jolly", "keen",
+	"kind", "lively", "lucky", "mellow", "misty", "modest", "noble", "olive",
+	"orange", "plain", "polite", "proud", "purple", "quick", "quiet", "rapid",
+	"rosy", "rusty", "sandy", "sharp", "shiny", "silent", "silver", "simple",
+	"sleepy", "smooth", "snowy", "solid", "spicy", "steady", "sunny", "swift",
+	"tidy", "tiny", "velvet", "violet", "warm", "wild", "witty", "young",
+}
+
+var codeNouns = []string{
+	"badger", "beaver", "bison", "camel", "cobra", "condor", "coyote", "crane",
+	"dingo", "dolphin", "eagle", "falcon", "ferret", "finch", "gecko", "gibbon",
+	"heron", "hippo", "ibis", "iguana", "jackal", "jaguar", "koala", "lemur",
+	"lion", "llama", "lynx", "magpie", "marten", "moose", "newt", "ocelot",
+	"otter", "owl", "panda", "parrot", "pelican", "puffin", "quail", "rabbit",
+	"raven", "salmon", "seal", "shark", "sloth", "sparrow", "squid", "stork",
+	"swan", "tapir", "tiger", "toucan", "trout", "turtle", "viper", "walrus",
+	"weasel", "whale", "wolf", "wombat", "yak", "zebra", "mole", "robin",
+}
+
+var codePattern = regexp.MustCompile(`^[0-9]{1,3}-[a-z]+-[a-z]+$`)
+
+// NewCode generates a short human-readable pairing code such as "7-purple-tiger".
+func NewCode() (string, error) {
+	num, err := rand.Int(rand.Reader, big.NewInt(99))
+	if err != nil {
+		return "", fmt.Errorf("generate pairing code number: %w", err)
+	}
+	adj, err := pick(codeAdjectives)
+	if err != nil {
+		return "", err
+	}
+	noun, err := pick(codeNouns)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d-%s-%s", num.Int64()+1, adj, noun), nil
+}
+
+// ValidCode reports whether code has the pairing code shape.
+func ValidCode(code string) bool {
+	return codePattern.MatchString(NormalizeCode(code))
+}
+
+func pick(words []string) (string, error) {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(len(words))))
+	if err != nil {
+		return "", fmt.Errorf("generate pairing code word: %w", err)
+	}
+	return words[i.Int64()], nil
+}
@@ -0,0 +1,150 @@
+// Package pake implements SPAKE2 short-code pairing over a 2048-bit MODP group.
+package pake
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// ElementSize is the encoded group element length in bytes.
+const ElementSize = 256
+
+// Role distinguishes the two SPAKE2 participants.
+type Role int
+
+const (
+	// RoleClient is the connecting side (sender).
+	RoleClient Role = iota
+	// RoleServer is the listening side (receiver).
+	RoleServer
+)
+
+// RFC 3526 group 14 prime. Its generator 2 spans the prime-order subgroup of size (p-1)/2.
+const groupPrimeHex = "FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD1" +
+	"29024E088A67CC74020BBEA63B139B22514A08798E3404DD" +
+	"EF9519B3CD3A431B302B0A6DF25F14374FE1356D6D51C245" +
+	"E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7ED" +
+	"EE386BFB5A899FA5AE9F24117C4B1FE649286651ECE45B3D" +
+	"C2007CB8A163BF0598DA48361C55D39A69163FA8FD24CF5F" +
+	"83655D23DCA3AD961C62F356208552BB9ED529077096966D" +
+	"670C354E4ABC9804F1746C08CA18217C32905E462E36CE3B" +
+	"E39E772C180E86039B2783A2EC07A28FB5C55DF06F4C52C9" +
+	"DE2BCBF6955817183995497CEA956AE515D2261898FA0510" +
+	"15728E5A8AACAA68FFFFFFFFFFFFFFFF"
+
+var (
+	groupP, _ = new(big.Int).SetString(groupPrimeHex, 16)
+	groupQ    = new(big.Int).Rsh(groupP, 1)
+	groupG    = big.NewInt(2)
+	pointM    = hashToGroup("snapsync-spake2-M")
+	pointN    = hashToGroup("snapsync-spake2-N")
+)
+
+// State holds one side of an in-progress SPAKE2 exchange.
+type State struct {
+	role Role
+	w    *big.Int
+	x    *big.Int
+	msg  []byte
+	done bool
+}
+
+// Start begins an exchange for the shared code and returns the local message.
+func Start(role Role, code string) (*State, []byte, error) {
+	x, err := rand.Int(rand.Reader, groupQ)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generate pake scalar: %w", err)
+	}
+	w := codeScalar(code)
+	blind := pointM
+	if role == RoleServer {
+		blind = pointN
+	}
+	elem := new(big.Int).Exp(groupG, x, groupP)
+	elem.Mul(elem, new(big.Int).Exp(blind, w, groupP)).Mod(elem, groupP)
+	msg := encodeElement(elem)
+	return &State{role: role, w: w, x: x, msg: msg}, msg, nil
+}
+
+// Finish consumes the peer message and returns the shared secret.
+// Both sides obtain the same secret only when they used the same code.
+func (s *State) Finish(peerMsg []byte) ([]byte, error) {
+	if s.done {
+		return nil, fmt.Errorf("pake exchange already finished")
+	}
+	if len(peerMsg) != ElementSize {
+		return nil, fmt.Errorf("invalid pake message length %d", len(peerMsg))
+	}
+	peer := new(big.Int).SetBytes(peerMsg)
+	if peer.Cmp(big.NewInt(1)) <= 0 || peer.Cmp(new(big.Int).Sub(groupP, big.NewInt(1))) >= 0 {
+		return nil, fmt.Errorf("pake element out of range")
+	}
+	if new(big.Int).Exp(peer, groupQ, groupP).Cmp(big.NewInt(1)) != 0 {
+		return nil, fmt.Errorf("pake element outside prime-order subgroup")
+	}
+	blind := pointN
+	if s.role == RoleServer {
+		blind = pointM
+	}
+	unblind := new(big.Int).Exp(blind, s.w, groupP)
+	unblind.ModInverse(unblind, groupP)
+	shared := new(big.Int).Mul(peer, unblind)
+	shared.Mod(shared, groupP)
+	shared.Exp(shared, s.x, groupP)
+	s.done = true
+
+	clientMsg, serverMsg := s.msg, peerMsg
+	if s.role == RoleServer {
+		clientMsg, serverMsg = peerMsg, s.msg
+	}
+	h := sha256.New()
+	_, _ = h.Write([]byte("snapsync-spake2-v1"))
+	_, _ = h.Write(clientMsg)
+	_, _ = h.Write(serverMsg)
+	_, _ = h.Write(encodeElement(shared))
+	return h.Sum(nil), nil
+}
+
+// Confirm returns the key confirmation tag for role over the finished transcript.
+func Confirm(secret []byte, role Role) []byte {
+	mac := hmac.New(sha256.New, secret)
+	_, _ = mac.Write([]byte("snapsync-spake2-confirm"))
+	_, _ = mac.Write([]byte{byte(role)})
+	return mac.Sum(nil)
+}
+
+// NormalizeCode canonicalizes user-entered codes.
+func NormalizeCode(code string) string {
+	return strings.ToLower(strings.TrimSpace(code))
+}
+
+func codeScalar(code string) *big.Int {
+	sum := sha256.Sum256([]byte("snapsync-spake2-code\x00" + NormalizeCode(code)))
+	w := new(big.Int).SetBytes(sum[:])
+	return w.Mod(w, groupQ)
+}
+
+// hashToGroup derives a subgroup element with unknown discrete log by squaring a hashed value.
+func hashToGroup(label string) *big.Int {
+	buf := make([]byte, 0, ElementSize)
+	for counter := uint32(0); len(buf) < ElementSize; counter++ {
+		var ctr [4]byte
+		binary.BigEndian.PutUint32(ctr[:], counter)
+		sum := sha256.Sum256(append([]byte(label), ctr[:]...))
+		buf = append(buf, sum[:]...)
+	}
+	v := new(big.Int).SetBytes(buf[:ElementSize])
+	v.Mod(v, groupP)
+	return v.Exp(v, big.NewInt(2), groupP)
+}
+
+func encodeElement(v *big.Int) []byte {
+	out := make([]byte, ElementSize)
+	v.FillBytes(out)
+	return out
+}
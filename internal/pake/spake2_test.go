@@ -0,0 +1,69 @@
+package pake
+
+import (
+	"bytes"
+	"testing"
+)
+
+func exchange(t *testing.T, clientCode, serverCode string) ([]byte, []byte) {
+	t.Helper()
+	client, clientMsg, err := Start(RoleClient, clientCode)
+	if err != nil {
+		t.Fatalf("Start(client) error = %v", err)
+	}
+	server, serverMsg, err := Start(RoleServer, serverCode)
+	if err != nil {
+		t.Fatalf("Start(server) error = %v", err)
+	}
+	a, err := client.Finish(serverMsg)
+	if err != nil {
+		t.Fatalf("client Finish() error = %v", err)
+	}
+	b, err := server.Finish(clientMsg)
+	if err != nil {
+		t.Fatalf("server Finish() error = %v", err)
+	}
+	return a, b
+}
+
+func TestMatchingCodesAgree(t *testing.T) {
+	a, b := exchange(t, "7-purple-tiger", " 7-Purple-Tiger ")
+	if !bytes.Equal(a, b) {
+		t.Fatal("expected shared secrets to match")
+	}
+	if !bytes.Equal(Confirm(a, RoleServer), Confirm(b, RoleServer)) {
+		t.Fatal("expected confirmation tags to match")
+	}
+}
+
+func TestMismatchedCodesDisagree(t *testing.T) {
+	a, b := exchange(t, "7-purple-tiger", "8-purple-tiger")
+	if bytes.Equal(a, b) {
+		t.Fatal("expected shared secrets to differ for different codes")
+	}
+}
+
+func TestFinishRejectsInvalidElements(t *testing.T) {
+	s, _, _ := Start(RoleClient, "1-calm-owl")
+	one := make([]byte, ElementSize)
+	one[ElementSize-1] = 1
+	if _, err := s.Finish(one); err == nil {
+		t.Fatal("expected identity element to be rejected")
+	}
+	if _, err := s.Finish([]byte{1, 2, 3}); err == nil {
+		t.Fatal("expected short element to be rejected")
+	}
+}
+
+func TestNewCodeShape(t *testing.T) {
+	code, err := NewCode()
+	if err != nil {
+		t.Fatalf("NewCode() error = %v", err)
+	}
+	if !ValidCode(code) {
+		t.Fatalf("generated code has unexpected shape: %q", code)
+	}
+	if ValidCode("purple tiger") {
+		t.Fatal("expected malformed code to be invalid")
+	}
+}
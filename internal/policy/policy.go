@@ -0,0 +1,208 @@
+// Package policy decides which incoming transfers an unattended receiver takes,
+// from declarative rules on the sending peer and on each offered file.
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	apperrors "snapsync/internal/errors"
+	"snapsync/internal/throttle"
+)
+
+// Rules is the JSON form of a receive policy. Empty lists allow everything.
+type Rules struct {
+	// MaxFileSize caps each offered file, such as 2GB; 1024-based units.
+	MaxFileSize string `json:"max_file_size,omitempty"`
+	// AllowNames and DenyNames are globs matched against each file's base name and
+	// its path within a directory transfer.
+	AllowNames []string `json:"allow_names,omitempty"`
+	DenyNames  []string `json:"deny_names,omitempty"`
+	// AllowExtensions and DenyExtensions match file extensions, with or without the
+	// leading dot and ignoring case.
+	AllowExtensions []string `json:"allow_extensions,omitempty"`
+	DenyExtensions  []string `json:"deny_extensions,omitempty"`
+	// AllowPeers lists peer IDs or fingerprints, and AllowSubnets CIDR ranges, that
+	// may send; a peer matching either is allowed. Peer IDs match only peers whose
+	// ID the trust store pins to their key.
+	AllowPeers   []string `json:"allow_peers,omitempty"`
+	AllowSubnets []string `json:"allow_subnets,omitempty"`
+	// PeerDirs maps a peer ID or fingerprint to a subdirectory of the output
+	// directory that receives everything the peer sends.
+	PeerDirs map[string]string `json:"peer_dirs,omitempty"`
+}
+
+// Policy is a validated set of rules.
+type Policy struct {
+	rules   Rules
+	maxSize uint64
+	subnets []*net.IPNet
+}
+
+// Peer is the sending side of a connection. ID and Fingerprint are empty for
+// unauthenticated peers. The ID is chosen by the peer itself, so rules match it
+// only when Verified reports that the trust store pins it to Fingerprint.
+type Peer struct {
+	ID          string
+	Fingerprint string
+	Verified    bool
+	Addr        net.Addr
+}
+
+// Load reads and validates a policy file.
+func Load(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read policy: %w: %w", err, apperrors.ErrUsage)
+	}
+	var rules Rules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("decode policy %s: %w: %w", path, err, apperrors.ErrUsage)
+	}
+	p, err := New(rules)
+	if err != nil {
+		return nil, fmt.Errorf("policy %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// New validates rules into a policy.
+func New(rules Rules) (*Policy, error) {
+	p := &Policy{rules: rules}
+	if rules.MaxFileSize != "" {
+		size, err := throttle.ParseSize(rules.MaxFileSize)
+		if err != nil || size == 0 {
+			return nil, fmt.Errorf("invalid max_file_size %q, want a size such as 2GB: %w", rules.MaxFileSize, apperrors.ErrUsage)
+		}
+		p.maxSize = uint64(size)
+	}
+	for _, glob := range append(append([]string(nil), rules.AllowNames...), rules.DenyNames...) {
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("invalid name glob %q: %w", glob, apperrors.ErrUsage)
+		}
+	}
+	for _, cidr := range rules.AllowSubnets {
+		_, subnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid subnet %q: %w", cidr, apperrors.ErrUsage)
+		}
+		p.subnets = append(p.subnets, subnet)
+	}
+	for peer, dir := range rules.PeerDirs {
+		if !filepath.IsLocal(dir) {
+			return nil, fmt.Errorf("output directory %q for peer %s must be relative and stay inside the output directory: %w", dir, peer, apperrors.ErrUsage)
+		}
+	}
+	return p, nil
+}
+
+// AllowPeer returns the reason peer may not send, or nil. Reasons are meant for
+// the sender to read; callers add ErrPolicy.
+func (p *Policy) AllowPeer(peer Peer) error {
+	if len(p.rules.AllowPeers) == 0 && len(p.subnets) == 0 {
+		return nil
+	}
+	for _, id := range p.rules.AllowPeers {
+		if id != "" && (id == peer.verifiedID() || id == peer.Fingerprint) {
+			return nil
+		}
+	}
+	ip := addrIP(peer.Addr)
+	for _, subnet := range p.subnets {
+		if ip != nil && subnet.Contains(ip) {
+			return nil
+		}
+	}
+	who := peer.ID
+	if who == "" {
+		who = "unauthenticated peer"
+	}
+	return fmt.Errorf("%s at %s is not an allowed peer", who, ip)
+}
+
+// AllowFile returns the reason the file name, a slash-separated path within its
+// transfer, may not be received at size bytes, or nil. Streams of unknown length
+// pass known as false and are refused under a size cap.
+func (p *Policy) AllowFile(name string, size uint64, known bool) error {
+	if p.maxSize > 0 && !known {
+		return fmt.Errorf("%s has an unknown size and max_file_size is %s", name, p.rules.MaxFileSize)
+	}
+	if p.maxSize > 0 && size > p.maxSize {
+		return fmt.Errorf("%s is %d bytes, over max_file_size %s", name, size, p.rules.MaxFileSize)
+	}
+	if glob, ok := matchName(p.rules.DenyNames, name); ok {
+		return fmt.Errorf("%s matches denied name %q", name, glob)
+	}
+	if _, ok := matchName(p.rules.AllowNames, name); !ok && len(p.rules.AllowNames) > 0 {
+		return fmt.Errorf("%s matches no allowed name", name)
+	}
+	ext := strings.ToLower(path.Ext(name))
+	if matchExt(p.rules.DenyExtensions, ext) {
+		return fmt.Errorf("%s has denied extension %q", name, ext)
+	}
+	if len(p.rules.AllowExtensions) > 0 && !matchExt(p.rules.AllowExtensions, ext) {
+		return fmt.Errorf("%s has extension %q, which is not allowed", name, ext)
+	}
+	return nil
+}
+
+// Subdir returns the output subdirectory for peer, or "" for the output directory
+// itself. A verified peer ID entry wins over a fingerprint entry.
+func (p *Policy) Subdir(peer Peer) string {
+	if dir, ok := p.rules.PeerDirs[peer.verifiedID()]; ok && peer.verifiedID() != "" {
+		return dir
+	}
+	if dir, ok := p.rules.PeerDirs[peer.Fingerprint]; ok && peer.Fingerprint != "" {
+		return dir
+	}
+	return ""
+}
+
+// verifiedID returns the peer's ID if the trust store vouches for it, or "".
+func (peer Peer) verifiedID() string {
+	if !peer.Verified {
+		return ""
+	}
+	return peer.ID
+}
+
+// matchName returns the first glob matching name or its base name.
+func matchName(globs []string, name string) (string, bool) {
+	for _, glob := range globs {
+		if ok, _ := path.Match(glob, name); ok {
+			return glob, true
+		}
+		if ok, _ := path.Match(glob, path.Base(name)); ok {
+			return glob, true
+		}
+	}
+	return "", false
+}
+
+func matchExt(exts []string, ext string) bool {
+	for _, e := range exts {
+		if ext != "" && strings.ToLower("."+strings.TrimPrefix(e, ".")) == ext {
+			return true
+		}
+	}
+	return false
+}
+
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case nil:
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
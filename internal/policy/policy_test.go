@@ -0,0 +1,113 @@
+package policy
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	apperrors "snapsync/internal/errors"
+)
+
+func TestAllowFile(t *testing.T) {
+	p, err := New(Rules{
+		MaxFileSize:     "1KB",
+		AllowNames:      []string{"*.txt", "*.jpg", "docs/*"},
+		DenyNames:       []string{"secret*"},
+		DenyExtensions:  []string{"jpg"},
+		AllowExtensions: []string{".TXT", "md"},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	cases := []struct {
+		name  string
+		size  uint64
+		known bool
+		ok    bool
+	}{
+		{"notes.txt", 1024, true, true},
+		{"dir/notes.txt", 10, true, true},
+		{"docs/readme.md", 10, true, true},
+		{"notes.txt", 1025, true, false},
+		{"notes.txt", 0, false, false},
+		{"secret.txt", 10, true, false},
+		{"photo.jpg", 10, true, false},
+		{"report.pdf", 10, true, false},
+		{"docs/report.pdf", 10, true, false},
+	}
+	for _, tc := range cases {
+		if err := p.AllowFile(tc.name, tc.size, tc.known); (err == nil) != tc.ok {
+			t.Fatalf("AllowFile(%q, %d, %v) = %v, want allowed %v", tc.name, tc.size, tc.known, err, tc.ok)
+		}
+	}
+
+	open, _ := New(Rules{})
+	if err := open.AllowFile("anything.bin", 1<<40, false); err != nil {
+		t.Fatalf("empty rules refused a file: %v", err)
+	}
+}
+
+func TestAllowPeerAndSubdir(t *testing.T) {
+	p, err := New(Rules{
+		AllowPeers:   []string{"lab-1", "ab:cd"},
+		AllowSubnets: []string{"10.1.0.0/16"},
+		PeerDirs:     map[string]string{"lab-1": "lab1", "ab:cd": "keyed/dir"},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	addr := func(ip string) net.Addr { return &net.TCPAddr{IP: net.ParseIP(ip), Port: 45999} }
+	cases := []struct {
+		peer Peer
+		ok   bool
+		dir  string
+	}{
+		{Peer{ID: "lab-1", Fingerprint: "12:34", Verified: true, Addr: addr("192.168.1.5")}, true, "lab1"},
+		// An unknown key claiming an allowed ID is refused.
+		{Peer{ID: "lab-1", Fingerprint: "ff:ff", Addr: addr("192.168.1.5")}, false, ""},
+		{Peer{ID: "other", Fingerprint: "ab:cd", Addr: addr("192.168.1.5")}, true, "keyed/dir"},
+		{Peer{Addr: addr("10.1.2.3")}, true, ""},
+		{Peer{ID: "other", Addr: addr("10.2.0.1")}, false, ""},
+		{Peer{}, false, ""},
+	}
+	for _, tc := range cases {
+		if err := p.AllowPeer(tc.peer); (err == nil) != tc.ok {
+			t.Fatalf("AllowPeer(%+v) = %v, want allowed %v", tc.peer, err, tc.ok)
+		}
+		if got := p.Subdir(tc.peer); got != tc.dir {
+			t.Fatalf("Subdir(%+v) = %q, want %q", tc.peer, got, tc.dir)
+		}
+	}
+}
+
+func TestLoadValidatesRules(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "rules.json")
+	if err := os.WriteFile(path, []byte(`{"max_file_size": "2GB", "deny_names": ["*.iso"]}`), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	p, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if p.AllowFile("big.bin", 3<<30, true) == nil || p.AllowFile("disk.iso", 1, true) == nil {
+		t.Fatal("loaded rules not applied")
+	}
+
+	for _, rules := range []Rules{
+		{MaxFileSize: "lots"},
+		{AllowNames: []string{"[unclosed"}},
+		{AllowSubnets: []string{"10.0.0.1"}},
+		{PeerDirs: map[string]string{"lab-1": "../escape"}},
+		{PeerDirs: map[string]string{"lab-1": "/abs"}},
+	} {
+		if _, err := New(rules); !errors.Is(err, apperrors.ErrUsage) {
+			t.Fatalf("New(%+v) = %v, want usage error", rules, err)
+		}
+	}
+	if _, err := Load(filepath.Join(dir, "missing.json")); !errors.Is(err, apperrors.ErrUsage) {
+		t.Fatalf("Load(missing) = %v, want usage error", err)
+	}
+}
@@ -0,0 +1,51 @@
+package progress
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	apperrors "snapsync/internal/errors"
+)
+
+// Event types written to a JSONStream.
+const (
+	EventOffer    = "offer"
+	EventAccepted = "accepted"
+	EventResume   = "resume"
+	EventProgress = "progress"
+	EventVerified = "verified"
+	EventDone     = "done"
+	EventError    = "error"
+)
+
+// JSONStream is an Observer that writes events as newline-delimited JSON. It is
+// safe for concurrent use, and a nil stream discards events.
+type JSONStream struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONStream creates a stream writing to w.
+func NewJSONStream(w io.Writer) *JSONStream {
+	return &JSONStream{enc: json.NewEncoder(w)}
+}
+
+// Observe writes e as one line, stamping its time when unset.
+func (s *JSONStream) Observe(e Event) {
+	if s == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_ = s.enc.Encode(e)
+}
+
+// ErrorEvent describes err with the class and exit code it maps to.
+func ErrorEvent(err error) Event {
+	return Event{Type: EventError, Error: err.Error(), Class: apperrors.Class(err), ExitCode: apperrors.ExitCode(err)}
+}
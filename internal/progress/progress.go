@@ -3,61 +3,89 @@ package progress
 
 import (
 	"fmt"
-	"io"
 	"time"
 )
 
-// Event describes transfer status at a point in time.
+// Event describes transfer status at a point in time. Its JSON form is one line
+// of a JSONStream; Total is 0 when the size is unknown.
 type Event struct {
-	Bytes        uint64
-	Total        uint64
-	InstantBps   float64
-	AverageBps   float64
-	ETA          time.Duration
-	Elapsed      time.Duration
-	Done         bool
-	OutputPath   string
-	Direction    string
-	LastChunkLen int
+	Type         string        `json:"type"`
+	Time         time.Time     `json:"time"`
+	Direction    string        `json:"direction,omitempty"`
+	Name         string        `json:"name,omitempty"`
+	Peer         string        `json:"peer,omitempty"`
+	Bytes        uint64        `json:"bytes"`
+	Total        uint64        `json:"total"`
+	Offset       uint64        `json:"offset,omitempty"`
+	InstantBps   float64       `json:"instant_bps,omitempty"`
+	AverageBps   float64       `json:"average_bps,omitempty"`
+	ETA          time.Duration `json:"eta_ns,omitempty"`
+	Elapsed      time.Duration `json:"elapsed_ns,omitempty"`
+	Done         bool          `json:"-"`
+	OutputPath   string        `json:"path,omitempty"`
+	LastChunkLen int           `json:"-"`
+	Algorithm    string        `json:"hash,omitempty"`
+	Digest       string        `json:"digest,omitempty"`
+	Error        string        `json:"error,omitempty"`
+	Class        string        `json:"class,omitempty"`
+	ExitCode     int           `json:"exit_code,omitempty"`
 }
 
-// Reporter emits human-readable progress updates.
+// Observer receives the events of transfers. Observers must be safe for
+// concurrent use: striped and fan-out sends report from several goroutines, and a
+// serving receiver runs transfers side by side.
+type Observer interface {
+	Observe(Event)
+}
+
+// ObserverFunc adapts a function to an Observer.
+type ObserverFunc func(Event)
+
+// Observe calls f(e).
+func (f ObserverFunc) Observe(e Event) { f(e) }
+
+// Discard is an Observer that ignores every event.
+var Discard Observer = ObserverFunc(func(Event) {})
+
+// Reporter turns the byte counts of one transfer into throttled progress events
+// and a final done event for an Observer.
 type Reporter struct {
-	w          io.Writer
-	total      uint64
-	direction  string
+	o          Observer
+	base       Event
 	start      time.Time
 	lastTick   time.Time
 	lastBytes  uint64
 	minTickGap time.Duration
 }
 
-// NewReporter creates a reporter with update throttling.
-func NewReporter(w io.Writer, direction string, total uint64) *Reporter {
+// NewReporter creates a reporter for the transfer base describes by its Direction,
+// Name, Peer, and Total. A zero Total means the size is unknown, as for streamed
+// input.
+func NewReporter(o Observer, base Event) *Reporter {
 	now := time.Now()
-	return &Reporter{w: w, total: total, direction: direction, start: now, lastTick: now, minTickGap: 150 * time.Millisecond}
+	return &Reporter{o: o, base: base, start: now, lastTick: now, minTickGap: 150 * time.Millisecond}
 }
 
-// Update prints progress at throttled intervals.
+// Update reports progress at throttled intervals.
 func (r *Reporter) Update(bytes uint64) {
 	now := time.Now()
-	if now.Sub(r.lastTick) < r.minTickGap && bytes < r.total {
+	if now.Sub(r.lastTick) < r.minTickGap && (r.base.Total == 0 || bytes < r.base.Total) {
 		return
 	}
-	e := r.buildEvent(bytes, now, false, "")
-	_, _ = fmt.Fprintf(r.w, "\r%s %s/%s inst:%s avg:%s eta:%s", r.direction, humanBytes(e.Bytes), humanBytes(e.Total), humanRate(e.InstantBps), humanRate(e.AverageBps), humanDuration(e.ETA))
+	r.o.Observe(r.buildEvent(EventProgress, bytes, now, ""))
 	r.lastTick = now
 	r.lastBytes = bytes
 }
 
-// Done prints final summary.
+// Elapsed returns the time since the reporter was created.
+func (r *Reporter) Elapsed() time.Duration { return time.Since(r.start) }
+
+// Done reports the completed transfer written to outPath.
 func (r *Reporter) Done(bytes uint64, outPath string) {
-	now := time.Now()
-	e := r.buildEvent(bytes, now, true, outPath)
-	_, _ = fmt.Fprintf(r.w, "\r%s complete %s in %s avg:%s out:%s\n", r.direction, humanBytes(e.Bytes), humanDuration(e.Elapsed), humanRate(e.AverageBps), outPath)
+	r.o.Observe(r.buildEvent(EventDone, bytes, time.Now(), outPath))
 }
 
-func (r *Reporter) buildEvent(bytes uint64, now time.Time, done bool, outPath string) Event {
+func (r *Reporter) buildEvent(kind string, bytes uint64, now time.Time, outPath string) Event {
 	elapsed := now.Sub(r.start)
 	if elapsed <= 0 {
 		elapsed = time.Millisecond
@@ -69,14 +97,17 @@ func (r *Reporter) buildEvent(bytes uint64, now time.Time, done bool, outPath st
 	inst := float64(bytes-r.lastBytes) / chunkDur.Seconds()
 	avg := float64(bytes) / elapsed.Seconds()
 	remaining := uint64(0)
-	if bytes < r.total {
-		remaining = r.total - bytes
+	if bytes < r.base.Total {
+		remaining = r.base.Total - bytes
 	}
 	eta := time.Duration(0)
 	if avg > 0 && remaining > 0 {
 		eta = time.Duration(float64(remaining)/avg) * time.Second
 	}
-	return Event{Bytes: bytes, Total: r.total, InstantBps: inst, AverageBps: avg, ETA: eta, Elapsed: elapsed, Done: done, OutputPath: outPath, Direction: r.direction}
+	e := r.base
+	e.Type, e.Time, e.Bytes, e.InstantBps, e.AverageBps, e.ETA, e.Elapsed = kind, now, bytes, inst, avg, eta, elapsed
+	e.Done, e.OutputPath = kind == EventDone, outPath
+	return e
 }
 
 func humanBytes(v uint64) string {
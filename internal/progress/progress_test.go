@@ -0,0 +1,114 @@
+package progress
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestReporterSendsEventsToObserver(t *testing.T) {
+	var events []Event
+	r := NewReporter(ObserverFunc(func(e Event) { events = append(events, e) }), Event{Direction: "sending", Name: "a.bin", Peer: "lab-1", Total: 100})
+	r.Update(10) // throttled
+	r.Update(100)
+	r.Done(100, "a.bin")
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want a progress and a done event", len(events))
+	}
+	if e := events[0]; e.Type != EventProgress || e.Bytes != 100 || e.Total != 100 || e.Name != "a.bin" || e.Peer != "lab-1" {
+		t.Fatalf("unexpected progress event %+v", e)
+	}
+	if e := events[1]; e.Type != EventDone || !e.Done || e.OutputPath != "a.bin" || e.Time.IsZero() {
+		t.Fatalf("unexpected done event %+v", e)
+	}
+}
+
+func TestPlainStatusLine(t *testing.T) {
+	var out bytes.Buffer
+	o := NewPlain(&out)
+	o.Observe(Event{Type: EventOffer, Name: "ignored"})
+	o.Observe(Event{Type: EventProgress, Direction: "receiving", Name: "a.bin", Bytes: 50, Total: 100})
+	if got := out.String(); !strings.HasPrefix(got, "\rreceiving 50.0B/100.0B inst:") {
+		t.Fatalf("unexpected plain status %q", got)
+	}
+}
+
+func TestTTYKeepsOneRowPerTransfer(t *testing.T) {
+	var out bytes.Buffer
+	tty := NewTTY(&out)
+	tty.Observe(Event{Type: EventProgress, Direction: "receiving", Name: "a.bin", Peer: "p1", Bytes: 50, Total: 100, InstantBps: 10})
+	tty.Observe(Event{Type: EventProgress, Direction: "receiving", Name: "b.bin", Peer: "p2", Bytes: 1, Total: 4, InstantBps: 10})
+	tty.Observe(Event{Type: EventProgress, Direction: "receiving", Name: "a.bin", Peer: "p1", Bytes: 75, Total: 100, InstantBps: 5})
+	if _, err := fmt.Fprintln(tty, "hello"); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	frames := strings.Split(out.String(), "\x1b[2A\r\x1b[J")
+	last := frames[len(frames)-1]
+	want := "hello\nreceiving a.bin                [############----]  75%     5.0B/s █▄         eta 0s\nreceiving b.bin                [####------------]  25%    10.0B/s █          eta 0s\n"
+	if last != want {
+		t.Fatalf("last frame = %q, want %q", last, want)
+	}
+
+	out.Reset()
+	tty.Observe(Event{Type: EventDone, Direction: "receiving", Name: "a.bin", Peer: "p1", Bytes: 100, OutputPath: "/in/a.bin"})
+	tty.Observe(Event{Type: EventError, Direction: "receiving", Peer: "p2"})
+	// The done event replaces both rows with its summary and the other row, and the
+	// error event erases that row and draws nothing.
+	want = "\x1b[2A\r\x1b[Jreceiving complete 100.0B in 0s avg:0.0B/s out:/in/a.bin\nreceiving b.bin"
+	if got := out.String(); !strings.HasPrefix(got, want) || !strings.HasSuffix(got, "\x1b[1A\r\x1b[J") {
+		t.Fatalf("finished and failed transfers still drawn: %q", got)
+	}
+}
+
+func TestTTYSeparatesPeersPullingOneFile(t *testing.T) {
+	var out bytes.Buffer
+	tty := NewTTY(&out)
+	tty.Observe(Event{Type: EventProgress, Direction: "sending", Name: "iso", Peer: "p1", Bytes: 50, Total: 100, InstantBps: 10})
+	tty.Observe(Event{Type: EventProgress, Direction: "sending", Name: "iso", Peer: "p2", Bytes: 25, Total: 100, InstantBps: 10})
+	if len(tty.rows) != 2 {
+		t.Fatalf("expected a row per peer, got %d", len(tty.rows))
+	}
+	tty.Observe(Event{Type: EventError, Direction: "sending", Peer: "p1"})
+	if len(tty.rows) != 1 || tty.rows[0].last.Peer != "p2" {
+		t.Fatalf("expected only p2's row after p1 failed, got %d rows", len(tty.rows))
+	}
+	tty.Observe(Event{Type: EventDone, Direction: "sending", Name: "iso", Peer: "p2", Bytes: 100})
+	if len(tty.rows) != 0 {
+		t.Fatalf("expected no rows after both pulls ended, got %d", len(tty.rows))
+	}
+}
+
+func TestTTYFitsRowsToNarrowTerminal(t *testing.T) {
+	e := Event{Type: EventProgress, Direction: "receiving", Name: "a-very-long-file-name-for-a-narrow-terminal.iso", Peer: "p1", Bytes: 50, Total: 100, InstantBps: 10}
+	for _, tc := range []struct {
+		width int
+		want  []string
+		gone  []string
+	}{
+		{width: 80, want: []string{"[########--------]", "eta 0s", "█"}},
+		{width: 70, want: []string{"[########--------]", "eta 0s"}, gone: []string{"█"}},
+		{width: 45, want: []string{"50%", "eta 0s"}, gone: []string{"[", "█"}},
+		{width: 20},
+	} {
+		var out bytes.Buffer
+		tty := NewTTY(&out)
+		tty.width = func() int { return tc.width }
+		tty.Observe(e)
+		row := strings.TrimSuffix(out.String(), "\n")
+		if n := utf8.RuneCountInString(row); n >= tc.width || strings.Contains(row, "\n") {
+			t.Fatalf("width %d: row %q is %d columns", tc.width, row, n)
+		}
+		for _, s := range tc.want {
+			if !strings.Contains(row, s) {
+				t.Fatalf("width %d: row %q lacks %q", tc.width, row, s)
+			}
+		}
+		for _, s := range tc.gone {
+			if strings.Contains(row, s) {
+				t.Fatalf("width %d: row %q still has %q", tc.width, row, s)
+			}
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// plain writes progress and done events as text; other events are left to the
+// transfer's own messages.
+type plain struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewPlain returns an Observer that rewrites one status line with carriage returns
+// as progress arrives and prints a summary line for each finished file.
+func NewPlain(w io.Writer) Observer {
+	return &plain{w: w}
+}
+
+func (p *plain) Observe(e Event) {
+	var line string
+	switch e.Type {
+	case EventProgress:
+		line = "\r" + plainStatus(e)
+	case EventDone:
+		line = "\r" + summary(e) + "\n"
+	default:
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, _ = io.WriteString(p.w, line)
+}
+
+func plainStatus(e Event) string {
+	if e.Total == 0 {
+		return fmt.Sprintf("%s %s inst:%s avg:%s", e.Direction, humanBytes(e.Bytes), humanRate(e.InstantBps), humanRate(e.AverageBps))
+	}
+	return fmt.Sprintf("%s %s/%s inst:%s avg:%s eta:%s", e.Direction, humanBytes(e.Bytes), humanBytes(e.Total), humanRate(e.InstantBps), humanRate(e.AverageBps), humanDuration(e.ETA))
+}
+
+// summary describes a done event.
+func summary(e Event) string {
+	return fmt.Sprintf("%s complete %s in %s avg:%s out:%s", e.Direction, humanBytes(e.Bytes), humanDuration(e.Elapsed), humanRate(e.AverageBps), e.OutputPath)
+}
@@ -0,0 +1,232 @@
+package progress
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	// nameWidth is the column a TTY row gives the file name.
+	nameWidth = 20
+	// minNameWidth is the narrowest name column a TTY row keeps before it drops
+	// the sparkline and then the bar to fit the terminal.
+	minNameWidth = 8
+	// barWidth is the number of cells in a TTY progress bar.
+	barWidth = 16
+	// sparkWidth is how many recent instant rates a TTY row's sparkline shows.
+	sparkWidth = 10
+)
+
+// sparkLevels draw a sparkline from the lowest rate to the highest.
+var sparkLevels = []rune("▁▂▃▄▅▆▇█")
+
+// TTY is an Observer for terminals that keeps one row per active transfer at the
+// bottom of the screen, each with a bar, percentage, rate sparkline, and ETA, and
+// redraws the rows in place as events arrive. A finished transfer leaves a summary
+// line above the rows. Messages written through the TTY also go above the rows,
+// so they never tear the view; a write that does not end a line, such as a
+// prompt, leaves the rows undrawn until the next event. Rows are fitted to the
+// terminal width on every redraw, so each one takes exactly one screen line.
+type TTY struct {
+	mu    sync.Mutex
+	w     io.Writer
+	width func() int // terminal columns, or 0 when unknown
+	rows  []*ttyRow
+	drawn int
+}
+
+// ttyRow is the view of one active transfer.
+type ttyRow struct {
+	last  Event
+	rates []float64
+}
+
+// NewTTY creates a TTY drawing on w, which should be a terminal that understands
+// ANSI cursor movement.
+func NewTTY(w io.Writer) *TTY {
+	t := &TTY{w: w, width: func() int { return 0 }}
+	if f, ok := w.(*os.File); ok {
+		t.width = func() int { return terminalWidth(f) }
+	}
+	return t
+}
+
+// Observe updates the view with progress, done, and error events.
+func (t *TTY) Observe(e Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch e.Type {
+	case EventProgress:
+		row := t.row(e)
+		row.last = e
+		row.rates = append(row.rates, e.InstantBps)
+		if len(row.rates) > sparkWidth {
+			row.rates = row.rates[1:]
+		}
+		t.redraw(nil)
+	case EventDone:
+		t.drop(func(r *ttyRow) bool { return sameTransfer(r.last, e) })
+		t.redraw([]byte(summary(e) + "\n"))
+	case EventError:
+		// A failed connection names only its peer, which ends that peer's rows.
+		if e.Peer != "" {
+			t.drop(func(r *ttyRow) bool { return r.last.Peer == e.Peer && r.last.Direction == e.Direction })
+			t.redraw(nil)
+		}
+	}
+}
+
+// Write prints p above the transfer rows.
+func (t *TTY) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.redraw(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// redraw erases the rows, writes msg in their place, and draws the rows again
+// below it unless msg leaves a line unfinished.
+func (t *TTY) redraw(msg []byte) error {
+	var buf bytes.Buffer
+	if t.drawn > 0 {
+		// Move up to the first row, then erase from there to the end of the screen.
+		fmt.Fprintf(&buf, "\x1b[%dA\r\x1b[J", t.drawn)
+		t.drawn = 0
+	}
+	buf.Write(msg)
+	if len(msg) == 0 || msg[len(msg)-1] == '\n' {
+		width := t.width()
+		for _, row := range t.rows {
+			buf.WriteString(row.line(width))
+			buf.WriteByte('\n')
+		}
+		t.drawn = len(t.rows)
+	}
+	_, err := t.w.Write(buf.Bytes())
+	return err
+}
+
+// row returns the row for e's transfer, adding it when the transfer is new.
+func (t *TTY) row(e Event) *ttyRow {
+	for _, row := range t.rows {
+		if sameTransfer(row.last, e) {
+			return row
+		}
+	}
+	row := &ttyRow{last: e}
+	t.rows = append(t.rows, row)
+	return row
+}
+
+func (t *TTY) drop(match func(*ttyRow) bool) {
+	kept := t.rows[:0]
+	for _, row := range t.rows {
+		if !match(row) {
+			kept = append(kept, row)
+		}
+	}
+	t.rows = kept
+}
+
+func sameTransfer(a, b Event) bool {
+	return a.Direction == b.Direction && a.Peer == b.Peer && a.Name == b.Name
+}
+
+// line renders the row in fewer than width columns, or at full size when width
+// is 0. The name shrinks first, then the sparkline and the bar are dropped, and
+// whatever still does not fit is cut off so the terminal never wraps the row.
+func (r *ttyRow) line(width int) string {
+	e := r.last
+	var fields []string
+	var optional []int // indexes of fields to drop, in order
+	if e.Total == 0 {
+		fields = []string{fmt.Sprintf("%9s", humanBytes(e.Bytes)), fmt.Sprintf("%10s", humanRate(e.InstantBps)), spark(r.rates), humanDuration(e.Elapsed)}
+		optional = []int{2}
+	} else {
+		fields = []string{bar(e.Bytes, e.Total, barWidth), fmt.Sprintf("%3.0f%%", percent(e.Bytes, e.Total)), fmt.Sprintf("%10s", humanRate(e.InstantBps)), spark(r.rates), "eta " + humanDuration(e.ETA)}
+		optional = []int{3, 0}
+	}
+	head := fmt.Sprintf("%-9s", e.Direction)
+	names := nameWidth
+	if width > 0 {
+		limit := width - 1
+		for {
+			used := utf8.RuneCountInString(head) + 1
+			for _, f := range fields {
+				if f != "" {
+					used += utf8.RuneCountInString(f) + 1
+				}
+			}
+			names = min(nameWidth, limit-used)
+			if names >= minNameWidth || len(optional) == 0 {
+				break
+			}
+			fields[optional[0]] = ""
+			optional = optional[1:]
+		}
+		names = max(names, minNameWidth)
+	}
+	parts := []string{head, fmt.Sprintf("%-*s", names, fit(e.Name, names))}
+	for _, f := range fields {
+		if f != "" {
+			parts = append(parts, f)
+		}
+	}
+	line := strings.Join(parts, " ")
+	if width > 0 {
+		if runes := []rune(line); len(runes) >= width {
+			line = string(runes[:max(width-1, 0)])
+		}
+	}
+	return line
+}
+
+// fit shortens s to width runes, marking the cut with "...".
+func fit(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) <= width {
+		return s
+	}
+	return string(runes[:width-3]) + "..."
+}
+
+// bar draws done of total as a bracketed bar width cells wide.
+func bar(done, total uint64, width int) string {
+	filled := width
+	if done < total {
+		filled = int(float64(done) / float64(total) * float64(width))
+	}
+	return "[" + strings.Repeat("#", filled) + strings.Repeat("-", width-filled) + "]"
+}
+
+func percent(done, total uint64) float64 {
+	if total == 0 || done >= total {
+		return 100
+	}
+	return float64(done) / float64(total) * 100
+}
+
+// spark draws rates relative to the highest of them, padded to sparkWidth.
+func spark(rates []float64) string {
+	peak := 0.0
+	for _, r := range rates {
+		peak = max(peak, r)
+	}
+	var b strings.Builder
+	for _, r := range rates {
+		level := 0
+		if peak > 0 && r > 0 {
+			level = int(r / peak * float64(len(sparkLevels)-1))
+		}
+		b.WriteRune(sparkLevels[level])
+	}
+	b.WriteString(strings.Repeat(" ", sparkWidth-len(rates)))
+	return b.String()
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package progress
+
+import "os"
+
+// terminalWidth is unknown on this platform, so TTY rows are not fitted.
+func terminalWidth(*os.File) int {
+	return 0
+}
@@ -0,0 +1,24 @@
+//go:build linux || darwin
+
+package progress
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// terminalWidth returns the column count of the terminal behind f, or 0 when f
+// is not a terminal.
+func terminalWidth(f *os.File) int {
+	var ws struct{ rows, cols, xpixel, ypixel uint16 }
+	req := uintptr(0x5413) // TIOCGWINSZ on Linux.
+	if runtime.GOOS == "darwin" {
+		req = 0x40087468
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, uintptr(unsafe.Pointer(&ws))); errno != 0 {
+		return 0
+	}
+	return int(ws.cols)
+}
@@ -18,8 +18,11 @@ type Paths struct {
 }
 
 // ResolvePaths finds stable destination paths for a transfer.
+// Slash-separated names resolve to nested paths below outDir.
 func ResolvePaths(outDir, originalName string, overwrite bool) (Paths, error) {
-	safe := sanitize.SafeFileName(originalName)
+	rel := sanitize.SafeRelativePath(originalName)
+	dir := filepath.Join(outDir, filepath.Dir(rel))
+	safe := filepath.Base(rel)
 	ext := filepath.Ext(safe)
 	stem := strings.TrimSuffix(safe, ext)
 
@@ -28,7 +31,7 @@ func ResolvePaths(outDir, originalName string, overwrite bool) (Paths, error) {
 		if i > 0 {
 			name = fmt.Sprintf("%s (%d)%s", stem, i, ext)
 		}
-		finalPath := filepath.Join(outDir, name)
+		finalPath := filepath.Join(dir, name)
 		partialPath := finalPath + ".partial"
 		metaPath := partialPath + ".snapsync"
 		lockPath := partialPath + ".lock"
@@ -51,7 +54,7 @@ func Finalize(paths Paths) error {
 	if err := os.Rename(paths.Partial, paths.Final); err != nil {
 		return fmt.Errorf("rename partial to final: %w", err)
 	}
-	_ = os.Remove(paths.Meta)
+	RemoveMeta(paths.Meta)
 	_ = os.Remove(paths.Lock)
 	return nil
 }
@@ -44,3 +44,15 @@ func TestResolvePathsCollision(t *testing.T) {
 		t.Fatalf("expected collision suffix, got %s", paths.Final)
 	}
 }
+
+func TestResolvePathsNestedName(t *testing.T) {
+	dir := t.TempDir()
+	paths, err := ResolvePaths(dir, "photos/2024/../img.jpg", false)
+	if err != nil {
+		t.Fatalf("ResolvePaths() error = %v", err)
+	}
+	want := filepath.Join(dir, "photos", "2024", "img.jpg")
+	if paths.Final != want {
+		t.Fatalf("final path got %s want %s", paths.Final, want)
+	}
+}
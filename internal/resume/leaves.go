@@ -0,0 +1,119 @@
+package resume
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// LeafPath returns the file beside metaPath that stores its block digests.
+func LeafPath(metaPath string) string {
+	return metaPath + ".leaves"
+}
+
+// LeafFile stores verified block digests as fixed-size records, one per block, so
+// recording a block costs one small write instead of rewriting the metadata. An
+// all-zero record marks a block that is not verified yet. Meta.LeafCount says how
+// many records are valid, so records must be synced before the metadata counting
+// them is saved.
+type LeafFile struct {
+	file *os.File
+	size int
+}
+
+// OpenLeafFile opens the digest file of metaPath for size-byte digests.
+func OpenLeafFile(metaPath string, size int) (*LeafFile, error) {
+	file, err := os.OpenFile(LeafPath(metaPath), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open leaf file: %w", err)
+	}
+	return &LeafFile{file: file, size: size}, nil
+}
+
+// Put writes the digest of block index, or clears it when leaf is nil.
+func (l *LeafFile) Put(index int, leaf []byte) error {
+	if leaf != nil && len(leaf) != l.size {
+		return fmt.Errorf("leaf %d has %d bytes, want %d", index, len(leaf), l.size)
+	}
+	record := make([]byte, l.size)
+	copy(record, leaf)
+	if _, err := l.file.WriteAt(record, int64(index)*int64(l.size)); err != nil {
+		return fmt.Errorf("write leaf %d: %w", index, err)
+	}
+	return nil
+}
+
+// PutAll replaces every record with leaves.
+func (l *LeafFile) PutAll(leaves [][]byte) error {
+	if err := l.file.Truncate(0); err != nil {
+		return fmt.Errorf("truncate leaf file: %w", err)
+	}
+	for i, leaf := range leaves {
+		if err := l.Put(i, leaf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sync flushes written records to disk.
+func (l *LeafFile) Sync() error {
+	if err := l.file.Sync(); err != nil {
+		return fmt.Errorf("sync leaf file: %w", err)
+	}
+	return nil
+}
+
+// Close closes the file.
+func (l *LeafFile) Close() error {
+	return l.file.Close()
+}
+
+// SaveLeaves stores leaves as the digest file of metaPath and syncs it.
+func SaveLeaves(metaPath string, leaves [][]byte, size int) error {
+	l, err := OpenLeafFile(metaPath, size)
+	if err != nil {
+		return err
+	}
+	if err := l.PutAll(leaves); err != nil {
+		_ = l.Close()
+		return err
+	}
+	if err := l.Sync(); err != nil {
+		_ = l.Close()
+		return err
+	}
+	return l.Close()
+}
+
+// loadLeaves reads count size-byte records from the digest file of metaPath.
+func loadLeaves(metaPath string, count, size int) ([][]byte, error) {
+	file, err := os.Open(LeafPath(metaPath))
+	if err != nil {
+		return nil, fmt.Errorf("open leaf file: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+	data := make([]byte, count*size)
+	if _, err := io.ReadFull(file, data); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("leaf file holds fewer than %d digests", count)
+		}
+		return nil, fmt.Errorf("read leaf file: %w", err)
+	}
+	empty := make([]byte, size)
+	leaves := make([][]byte, count)
+	for i := range leaves {
+		if record := data[i*size : (i+1)*size]; !bytes.Equal(record, empty) {
+			leaves[i] = record
+		}
+	}
+	return leaves, nil
+}
+
+// RemoveMeta deletes metaPath and its digest file.
+func RemoveMeta(metaPath string) {
+	_ = os.Remove(metaPath)
+	_ = os.Remove(LeafPath(metaPath))
+}
@@ -18,9 +18,27 @@ type Meta struct {
 	ReceivedOffset uint64 `json:"received_offset"`
 	OriginalName   string `json:"original_name"`
 	SessionID      string `json:"session_id"`
+	// Algorithm, BlockSize, and Leaves record verified block digests so a resumed
+	// transfer can continue the tree hash without rereading the partial file. Leaves
+	// live in a LeafFile; the metadata only records how many there are and how big.
+	Algorithm uint8    `json:"algorithm,omitempty"`
+	BlockSize uint64   `json:"block_size,omitempty"`
+	Leaves    [][]byte `json:"-"`
+	LeafCount int      `json:"leaf_count,omitempty"`
+	LeafSize  int      `json:"leaf_size,omitempty"`
+	// Ranges records per-stream progress of a striped transfer. Leaves then has one
+	// entry per block, with nil for blocks not yet verified.
+	Ranges []Range `json:"ranges,omitempty"`
 }
 
-// LoadMeta loads a metadata file.
+// Range is one striped byte range and the offset up to which it is verified.
+type Range struct {
+	Start  uint64 `json:"start"`
+	End    uint64 `json:"end"`
+	Offset uint64 `json:"offset"`
+}
+
+// LoadMeta loads a metadata file and the block digests it counts.
 func LoadMeta(path string) (Meta, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -33,12 +51,29 @@ func LoadMeta(path string) (Meta, error) {
 	if meta.Version != MetaVersion {
 		return Meta{}, fmt.Errorf("unsupported meta version %d", meta.Version)
 	}
+	if meta.LeafCount > 0 && meta.LeafSize > 0 {
+		leaves, err := loadLeaves(path, meta.LeafCount, meta.LeafSize)
+		if err != nil {
+			return Meta{}, err
+		}
+		meta.Leaves = leaves
+	} else if meta.LeafCount > 0 {
+		meta.Leaves = make([][]byte, meta.LeafCount)
+	}
 	return meta, nil
 }
 
-// SaveMetaAtomic writes metadata atomically to target path.
+// SaveMetaAtomic writes metadata atomically to target path. It counts meta.Leaves
+// but does not write them; they must already be synced to the LeafFile.
 func SaveMetaAtomic(path string, meta Meta) error {
 	meta.Version = MetaVersion
+	meta.LeafCount, meta.LeafSize = len(meta.Leaves), 0
+	for _, leaf := range meta.Leaves {
+		if leaf != nil {
+			meta.LeafSize = len(leaf)
+			break
+		}
+	}
 	data, err := json.Marshal(meta)
 	if err != nil {
 		return fmt.Errorf("encode meta file: %w", err)
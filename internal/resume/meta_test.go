@@ -1,8 +1,10 @@
 package resume
 
 import (
+	"bytes"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -30,3 +32,36 @@ func TestLoadMetaRejectsCorrupted(t *testing.T) {
 		t.Fatal("expected LoadMeta to fail for corrupted file")
 	}
 }
+
+func TestLeavesLiveBesideMeta(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.partial.snapsync")
+	leaves := [][]byte{bytes.Repeat([]byte{1}, 4), nil, bytes.Repeat([]byte{3}, 4)}
+	if err := SaveLeaves(path, leaves, 4); err != nil {
+		t.Fatalf("SaveLeaves() error = %v", err)
+	}
+	if err := SaveMetaAtomic(path, Meta{ExpectedSize: 100, Leaves: leaves}); err != nil {
+		t.Fatalf("SaveMetaAtomic() error = %v", err)
+	}
+	if data, _ := os.ReadFile(path); strings.Contains(string(data), `"leaves"`) {
+		t.Fatalf("metadata still embeds the digests: %s", data)
+	}
+	got, err := LoadMeta(path)
+	if err != nil {
+		t.Fatalf("LoadMeta() error = %v", err)
+	}
+	if len(got.Leaves) != 3 || !bytes.Equal(got.Leaves[0], leaves[0]) || got.Leaves[1] != nil || !bytes.Equal(got.Leaves[2], leaves[2]) {
+		t.Fatalf("unexpected leaves %v", got.Leaves)
+	}
+
+	// Metadata never counts more digests than the leaf file holds.
+	if err := SaveLeaves(path, leaves[:1], 4); err != nil {
+		t.Fatalf("SaveLeaves(short) error = %v", err)
+	}
+	if _, err := LoadMeta(path); err == nil {
+		t.Fatal("expected LoadMeta to fail for a truncated leaf file")
+	}
+	RemoveMeta(path)
+	if _, err := os.Stat(LeafPath(path)); !os.IsNotExist(err) {
+		t.Fatalf("leaf file left behind: %v", err)
+	}
+}
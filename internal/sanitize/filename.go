@@ -32,6 +32,24 @@ func SafeFileName(name string) string {
 	return base
 }
 
+// SafeRelativePath sanitizes a slash-separated relative path component by component.
+// Empty, "." and ".." components are dropped so the result never escapes its base directory.
+func SafeRelativePath(name string) string {
+	parts := strings.Split(strings.TrimSpace(name), "/")
+	clean := make([]string, 0, len(parts))
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" || part == "." || part == ".." {
+			continue
+		}
+		clean = append(clean, SafeFileName(part))
+	}
+	if len(clean) == 0 {
+		return "file"
+	}
+	return filepath.Join(clean...)
+}
+
 // ResolveCollisionPath returns available output path, applying (n) suffix when needed.
 func ResolveCollisionPath(dir, name string, overwrite bool) (string, error) {
 	safe := SafeFileName(name)
@@ -31,3 +31,14 @@ func TestResolveCollisionPath(t *testing.T) {
 		t.Fatalf("expected collision suffix, got %q", got)
 	}
 }
+
+func TestSafeRelativePathKeepsTreeAndDropsTraversal(t *testing.T) {
+	got := SafeRelativePath("photos/../2024/./trip:1/img?.jpg")
+	want := filepath.Join("photos", "2024", "trip_1", "img_.jpg")
+	if got != want {
+		t.Fatalf("SafeRelativePath() = %q want %q", got, want)
+	}
+	if got := SafeRelativePath("../.."); got != "file" {
+		t.Fatalf("expected fallback name, got %q", got)
+	}
+}
@@ -0,0 +1,53 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Config holds optional per-user settings from config.json.
+type Config struct {
+	// LimitSchedule sets bandwidth limits by time of day for transfers started
+	// without an explicit limit.
+	LimitSchedule []LimitWindow `json:"limit_schedule,omitempty"`
+}
+
+// LimitWindow applies Limit from After, a local HH:MM time, until the next
+// window of the schedule starts.
+type LimitWindow struct {
+	After string `json:"after"`
+	Limit string `json:"limit"`
+}
+
+// LoadConfig reads the per-user config.json.
+func LoadConfig() (Config, error) {
+	path, err := configPath("config.json")
+	if err != nil {
+		return Config{}, fmt.Errorf("resolve config path: %w", err)
+	}
+	return ReadConfig(path)
+}
+
+// ReadConfig reads a config file. A missing file is an empty config.
+func ReadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Config{}, nil
+	}
+	if err != nil {
+		return Config{}, fmt.Errorf("read config: %w", err)
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("decode config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// ControlDir returns the directory where running transfers listen for control
+// requests, one socket per process.
+func ControlDir() (string, error) {
+	return configPath("control")
+}
@@ -0,0 +1,36 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadConfig(t *testing.T) {
+	dir := t.TempDir()
+	cfg, err := ReadConfig(filepath.Join(dir, "missing.json"))
+	if err != nil || len(cfg.LimitSchedule) != 0 {
+		t.Fatalf("missing config = %+v, %v", cfg, err)
+	}
+
+	path := filepath.Join(dir, "config.json")
+	doc := `{"limit_schedule": [{"after": "08:00", "limit": "5MB/s"}, {"after": "19:00", "limit": "unlimited"}]}`
+	if err := os.WriteFile(path, []byte(doc), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	cfg, err = ReadConfig(path)
+	if err != nil {
+		t.Fatalf("ReadConfig() error = %v", err)
+	}
+	want := []LimitWindow{{After: "08:00", Limit: "5MB/s"}, {After: "19:00", Limit: "unlimited"}}
+	if len(cfg.LimitSchedule) != 2 || cfg.LimitSchedule[0] != want[0] || cfg.LimitSchedule[1] != want[1] {
+		t.Fatalf("schedule = %+v", cfg.LimitSchedule)
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if _, err := ReadConfig(path); err == nil {
+		t.Fatal("expected a decode error")
+	}
+}
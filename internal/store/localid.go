@@ -2,6 +2,7 @@
 package store
 
 import (
+	"encoding/hex"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -37,17 +38,51 @@ func LoadOrCreatePeerID(generate func() (string, error)) (string, error) {
 	return id, nil
 }
 
+// LoadOrCreateIdentitySeed loads the persisted identity key seed or writes a new one.
+func LoadOrCreateIdentitySeed(generate func() ([]byte, error)) ([]byte, error) {
+	path, err := configPath("identity_key")
+	if err != nil {
+		return nil, fmt.Errorf("resolve identity key path: %w", err)
+	}
+	if data, readErr := os.ReadFile(path); readErr == nil {
+		seed, decErr := hex.DecodeString(strings.TrimSpace(string(data)))
+		if decErr != nil {
+			return nil, fmt.Errorf("decode identity key file: %w", decErr)
+		}
+		return seed, nil
+	} else if !os.IsNotExist(readErr) {
+		return nil, fmt.Errorf("read identity key file: %w", readErr)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create identity key directory: %w", err)
+	}
+	seed, err := generate()
+	if err != nil {
+		return nil, fmt.Errorf("generate identity key: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(seed)+"\n"), 0o600); err != nil {
+		return nil, fmt.Errorf("write identity key file: %w", err)
+	}
+	return seed, nil
+}
+
 func peerIDPath() (string, error) {
+	return configPath("peer_id")
+}
+
+// configPath resolves a file inside the per-user SnapSync config directory.
+func configPath(name string) (string, error) {
 	if runtime.GOOS == "windows" {
 		appData := os.Getenv("APPDATA")
 		if appData == "" {
 			return "", fmt.Errorf("APPDATA is not set")
 		}
-		return filepath.Join(appData, "SnapSync", "peer_id"), nil
+		return filepath.Join(appData, "SnapSync", name), nil
 	}
 	home, err := os.UserHomeDir()
 	if err != nil {
 		return "", fmt.Errorf("resolve user home dir: %w", err)
 	}
-	return filepath.Join(home, ".config", "snapsync", "peer_id"), nil
+	return filepath.Join(home, ".config", "snapsync", name), nil
 }
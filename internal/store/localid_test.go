@@ -34,3 +34,22 @@ func TestLoadOrCreatePeerIDPersistsValue(t *testing.T) {
 		t.Fatalf("expected peer_id file to exist: %v", err)
 	}
 }
+
+func TestLoadOrCreateIdentitySeedPersistsValue(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("path behavior differs on windows in this environment")
+	}
+	t.Setenv("HOME", t.TempDir())
+	want := []byte{1, 2, 3, 4}
+	seed, err := LoadOrCreateIdentitySeed(func() ([]byte, error) { return want, nil })
+	if err != nil {
+		t.Fatalf("LoadOrCreateIdentitySeed() error = %v", err)
+	}
+	again, err := LoadOrCreateIdentitySeed(func() ([]byte, error) { return []byte{9}, nil })
+	if err != nil {
+		t.Fatalf("LoadOrCreateIdentitySeed() second call error = %v", err)
+	}
+	if string(seed) != string(want) || string(again) != string(want) {
+		t.Fatalf("expected persisted seed %x, got %x and %x", want, seed, again)
+	}
+}
@@ -0,0 +1,187 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	apperrors "snapsync/internal/errors"
+)
+
+// TrustedPeer is one persisted trusted-peer record.
+type TrustedPeer struct {
+	PeerID      string    `json:"peer_id"`
+	Name        string    `json:"name,omitempty"`
+	Fingerprint string    `json:"fingerprint"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+// TrustStore persists trusted peers as a JSON document.
+type TrustStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewTrustStore opens a trust store backed by path.
+func NewTrustStore(path string) *TrustStore {
+	return &TrustStore{path: path}
+}
+
+// OpenTrustStore opens the per-user trusted peers database.
+func OpenTrustStore() (*TrustStore, error) {
+	path, err := configPath("trusted_peers.json")
+	if err != nil {
+		return nil, fmt.Errorf("resolve trust store path: %w", err)
+	}
+	return NewTrustStore(path), nil
+}
+
+// List returns trusted peers sorted by peer ID.
+func (s *TrustStore) List() ([]TrustedPeer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load()
+}
+
+// Lookup returns the trusted record for peerID.
+func (s *TrustStore) Lookup(peerID string) (TrustedPeer, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	peers, err := s.load()
+	if err != nil {
+		return TrustedPeer{}, false, err
+	}
+	for _, p := range peers {
+		if p.PeerID == peerID {
+			return p, true, nil
+		}
+	}
+	return TrustedPeer{}, false, nil
+}
+
+// Add inserts or replaces a trusted peer, keeping the original first-seen time.
+func (s *TrustStore) Add(peer TrustedPeer) error {
+	if peer.PeerID == "" || peer.Fingerprint == "" {
+		return fmt.Errorf("trusted peer requires peer id and fingerprint: %w", apperrors.ErrUsage)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	peers, err := s.load()
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	if peer.FirstSeen.IsZero() {
+		peer.FirstSeen = now
+	}
+	if peer.LastSeen.IsZero() {
+		peer.LastSeen = peer.FirstSeen
+	}
+	replaced := false
+	for i, p := range peers {
+		if p.PeerID == peer.PeerID {
+			peer.FirstSeen = p.FirstSeen
+			peers[i] = peer
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		peers = append(peers, peer)
+	}
+	return s.save(peers)
+}
+
+// Remove deletes peerID and reports whether it was present.
+func (s *TrustStore) Remove(peerID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	peers, err := s.load()
+	if err != nil {
+		return false, err
+	}
+	kept := peers[:0]
+	for _, p := range peers {
+		if p.PeerID != peerID {
+			kept = append(kept, p)
+		}
+	}
+	if len(kept) == len(peers) {
+		return false, nil
+	}
+	return true, s.save(kept)
+}
+
+// touchInterval is how stale a last-seen time must be before Touch rewrites the
+// store, so a busy receiver does not rewrite the file for every transfer.
+const touchInterval = time.Minute
+
+// Touch records that peerID was seen at the given time. Times within
+// touchInterval of the recorded one are not written.
+func (s *TrustStore) Touch(peerID string, seen time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	peers, err := s.load()
+	if err != nil {
+		return err
+	}
+	for i := range peers {
+		if peers[i].PeerID == peerID {
+			if since := seen.Sub(peers[i].LastSeen); since >= 0 && since < touchInterval {
+				return nil
+			}
+			peers[i].LastSeen = seen.UTC()
+			return s.save(peers)
+		}
+	}
+	return nil
+}
+
+func (s *TrustStore) load() ([]TrustedPeer, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read trust store: %w", err)
+	}
+	var peers []TrustedPeer
+	if err := json.Unmarshal(data, &peers); err != nil {
+		return nil, fmt.Errorf("decode trust store: %w", err)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].PeerID < peers[j].PeerID })
+	return peers, nil
+}
+
+func (s *TrustStore) save(peers []TrustedPeer) error {
+	data, err := json.MarshalIndent(peers, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode trust store: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return fmt.Errorf("create trust store directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".trusted-peers-*.tmp")
+	if err != nil {
+		return fmt.Errorf("create trust store temp file: %w", err)
+	}
+	tmpName := tmp.Name()
+	defer func() { _ = os.Remove(tmpName) }()
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("write trust store temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close trust store temp file: %w", err)
+	}
+	if err := os.Rename(tmpName, s.path); err != nil {
+		return fmt.Errorf("rename trust store temp file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package store
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	apperrors "snapsync/internal/errors"
+)
+
+func TestTrustStoreAddLookupTouchRemove(t *testing.T) {
+	s := NewTrustStore(filepath.Join(t.TempDir(), "trusted_peers.json"))
+	first := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := s.Add(TrustedPeer{PeerID: "abc123def456", Name: "Laptop", Fingerprint: "ff00", FirstSeen: first}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if err := s.Add(TrustedPeer{PeerID: "abc123def456", Name: "Laptop 2", Fingerprint: "ff01"}); err != nil {
+		t.Fatalf("Add(replace) error = %v", err)
+	}
+	if err := s.Add(TrustedPeer{PeerID: "abc123def456"}); !errors.Is(err, apperrors.ErrUsage) {
+		t.Fatalf("Add(no fingerprint) error = %v, want usage error", err)
+	}
+	got, ok, err := s.Lookup("abc123def456")
+	if err != nil || !ok {
+		t.Fatalf("Lookup() = %v, %v", ok, err)
+	}
+	if got.Fingerprint != "ff01" || got.Name != "Laptop 2" || !got.FirstSeen.Equal(first) {
+		t.Fatalf("unexpected record after replace: %#v", got)
+	}
+
+	seen := first.Add(time.Hour)
+	if err := s.Touch("abc123def456", seen); err != nil {
+		t.Fatalf("Touch() error = %v", err)
+	}
+	got, _, _ = s.Lookup("abc123def456")
+	if !got.LastSeen.Equal(seen) {
+		t.Fatalf("last seen got %v want %v", got.LastSeen, seen)
+	}
+	if err := s.Touch("abc123def456", seen.Add(10*time.Second)); err != nil {
+		t.Fatalf("Touch(recent) error = %v", err)
+	}
+	got, _, _ = s.Lookup("abc123def456")
+	if !got.LastSeen.Equal(seen) {
+		t.Fatalf("recent touch rewrote last seen: got %v want %v", got.LastSeen, seen)
+	}
+
+	removed, err := s.Remove("abc123def456")
+	if err != nil || !removed {
+		t.Fatalf("Remove() = %v, %v", removed, err)
+	}
+	peers, err := s.List()
+	if err != nil || len(peers) != 0 {
+		t.Fatalf("expected empty store, got %v err=%v", peers, err)
+	}
+}
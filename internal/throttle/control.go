@@ -0,0 +1,68 @@
+package throttle
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	apperrors "snapsync/internal/errors"
+)
+
+// controlTimeout bounds one control request on either end.
+const controlTimeout = 2 * time.Second
+
+// ServeControl answers rate requests on ln until ln is closed. A client sends one
+// line, either a rate to set or an empty line to ask, and gets back the rate now
+// in force or a line starting with "error: ".
+func ServeControl(ln net.Listener, l *Limiter) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go serveControlConn(conn, l)
+	}
+}
+
+func serveControlConn(conn net.Conn, l *Limiter) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(controlTimeout))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return
+	}
+	if v := strings.TrimSpace(line); v != "" {
+		rate, err := ParseRate(v)
+		if err != nil {
+			fmt.Fprintf(conn, "error: %v\n", err)
+			return
+		}
+		l.SetRate(rate)
+	}
+	fmt.Fprintln(conn, FormatRate(l.Rate()))
+}
+
+// Control asks the limiter serving the unix socket at path to use rate, or only
+// reports its rate when rate is empty. It returns the rate now in force.
+func Control(path, rate string) (string, error) {
+	conn, err := net.DialTimeout("unix", path, controlTimeout)
+	if err != nil {
+		return "", fmt.Errorf("connect to %s: %w: %w", path, err, apperrors.ErrNetwork)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(controlTimeout))
+	if _, err := fmt.Fprintln(conn, rate); err != nil {
+		return "", fmt.Errorf("send to %s: %w: %w", path, err, apperrors.ErrNetwork)
+	}
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("read reply from %s: %w: %w", path, err, apperrors.ErrNetwork)
+	}
+	reply = strings.TrimSpace(reply)
+	if msg, ok := strings.CutPrefix(reply, "error: "); ok {
+		return "", fmt.Errorf("%s rejected the rate: %s: %w", path, msg, apperrors.ErrUsage)
+	}
+	return reply, nil
+}
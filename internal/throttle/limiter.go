@@ -0,0 +1,146 @@
+// Package throttle limits transfer bandwidth with a token bucket whose rate can
+// change while transfers run, by hand or on a time-of-day schedule.
+package throttle
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	apperrors "snapsync/internal/errors"
+)
+
+// Unlimited is the rate of a limiter that never waits.
+const Unlimited int64 = 0
+
+// maxWaitStep bounds each sleep so waiters notice rate changes promptly.
+const maxWaitStep = 100 * time.Millisecond
+
+// burstWindow is how much unused rate a limiter may save up for a burst.
+const burstWindow = 250 * time.Millisecond
+
+// Limiter is a token bucket shared by every connection it is attached to. A nil
+// Limiter never waits.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   int64
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a limiter passing rate bytes per second, or Unlimited.
+func NewLimiter(rate int64) *Limiter {
+	return &Limiter{rate: max(rate, 0), last: time.Now()}
+}
+
+// SetRate changes the rate, including for transfers waiting on the limiter.
+func (l *Limiter) SetRate(rate int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	l.rate = max(rate, 0)
+	l.tokens = min(l.tokens, 0)
+}
+
+// Rate returns the current rate in bytes per second, or Unlimited.
+func (l *Limiter) Rate() int64 {
+	if l == nil {
+		return Unlimited
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// Wait blocks until n more bytes fit within the rate. Waiters share one balance,
+// so concurrent connections together stay under the rate.
+func (l *Limiter) Wait(n int) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	if l.rate == Unlimited {
+		return
+	}
+	l.tokens -= float64(n)
+	for l.tokens < 0 && l.rate != Unlimited {
+		wait := time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+		l.mu.Unlock()
+		time.Sleep(min(wait, maxWaitStep))
+		l.mu.Lock()
+		l.refill(time.Now())
+	}
+}
+
+func (l *Limiter) refill(now time.Time) {
+	elapsed := now.Sub(l.last)
+	l.last = now
+	if l.rate == Unlimited {
+		l.tokens = 0
+		return
+	}
+	l.tokens = min(l.tokens+elapsed.Seconds()*float64(l.rate), burstWindow.Seconds()*float64(l.rate))
+}
+
+var sizeUnits = []struct {
+	suffix string
+	scale  int64
+}{
+	{"tib", 1 << 40}, {"tb", 1 << 40}, {"t", 1 << 40},
+	{"gib", 1 << 30}, {"gb", 1 << 30}, {"g", 1 << 30},
+	{"mib", 1 << 20}, {"mb", 1 << 20}, {"m", 1 << 20},
+	{"kib", 1 << 10}, {"kb", 1 << 10}, {"k", 1 << 10},
+	{"b", 1},
+}
+
+// ParseSize parses a byte count such as 2GB, 512KiB, or 100. Units are powers of
+// 1024, as in progress output.
+func ParseSize(s string) (int64, error) {
+	v := strings.ToLower(strings.TrimSpace(s))
+	scale := int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(v, u.suffix) {
+			v, scale = strings.TrimSpace(strings.TrimSuffix(v, u.suffix)), u.scale
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || n < 0 || n*float64(scale) > 1<<62 {
+		return 0, fmt.Errorf("invalid size %q, want a byte count such as 2GB: %w", s, apperrors.ErrUsage)
+	}
+	return int64(n * float64(scale)), nil
+}
+
+// ParseRate parses a rate such as 20MB/s, 512KiB, or unlimited into bytes per
+// second, in the units of ParseSize; 0 means Unlimited.
+func ParseRate(s string) (int64, error) {
+	v := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(s)), "/s")
+	if v == "unlimited" || v == "none" || v == "off" {
+		return Unlimited, nil
+	}
+	rate, err := ParseSize(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q, want a size per second such as 20MB/s or unlimited: %w", s, apperrors.ErrUsage)
+	}
+	return rate, nil
+}
+
+// FormatRate renders a rate for people, in the units ParseRate accepts.
+func FormatRate(rate int64) string {
+	if rate <= Unlimited {
+		return "unlimited"
+	}
+	units := []string{"B", "KB", "MB", "GB"}
+	val := float64(rate)
+	u := 0
+	for val >= 1024 && u < len(units)-1 {
+		val /= 1024
+		u++
+	}
+	return strconv.FormatFloat(math.Round(val*10)/10, 'f', -1, 64) + units[u] + "/s"
+}
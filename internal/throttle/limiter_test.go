@@ -0,0 +1,107 @@
+package throttle
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	apperrors "snapsync/internal/errors"
+)
+
+func TestParseSize(t *testing.T) {
+	cases := map[string]int64{"2GB": 2 << 30, "1tib": 1 << 40, "512 KiB": 512 << 10, "100": 100, "0": 0}
+	for in, want := range cases {
+		if got, err := ParseSize(in); err != nil || got != want {
+			t.Fatalf("ParseSize(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	for _, bad := range []string{"", "big", "-1MB", "10PB", "2GB/s"} {
+		if _, err := ParseSize(bad); !errors.Is(err, apperrors.ErrUsage) {
+			t.Fatalf("ParseSize(%q) error = %v, want usage error", bad, err)
+		}
+	}
+}
+
+func TestParseAndFormatRate(t *testing.T) {
+	cases := map[string]int64{
+		"20MB/s":    20 << 20,
+		"512KiB":    512 << 10,
+		"1.5g":      3 << 29,
+		"100":       100,
+		"unlimited": Unlimited,
+		"0":         Unlimited,
+	}
+	for in, want := range cases {
+		got, err := ParseRate(in)
+		if err != nil || got != want {
+			t.Fatalf("ParseRate(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	for _, bad := range []string{"", "fast", "-1MB", "10PB"} {
+		if _, err := ParseRate(bad); !errors.Is(err, apperrors.ErrUsage) {
+			t.Fatalf("ParseRate(%q) error = %v, want usage error", bad, err)
+		}
+	}
+	if got := FormatRate(20 << 20); got != "20MB/s" {
+		t.Fatalf("FormatRate = %q", got)
+	}
+	if got := FormatRate(1536); got != "1.5KB/s" {
+		t.Fatalf("FormatRate = %q", got)
+	}
+	if got := FormatRate(Unlimited); got != "unlimited" {
+		t.Fatalf("FormatRate = %q", got)
+	}
+}
+
+func TestLimiterPacesWaitersAndFollowsRateChanges(t *testing.T) {
+	l := NewLimiter(100 << 10)
+	l.Wait(25 << 10) // the burst allowance starts empty, so this already waits
+	start := time.Now()
+	l.Wait(20 << 10)
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("20KiB at 100KiB/s took %s", elapsed)
+	}
+
+	l.SetRate(1)
+	released := make(chan struct{})
+	go func() {
+		l.Wait(1 << 20)
+		close(released)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	l.SetRate(Unlimited)
+	select {
+	case <-released:
+	case <-time.After(time.Second):
+		t.Fatal("waiter did not notice the limit being lifted")
+	}
+
+	var none *Limiter
+	none.Wait(1 << 30)
+}
+
+func TestControlSetsAndReportsRate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "c.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	defer ln.Close()
+	l := NewLimiter(Unlimited)
+	go ServeControl(ln, l)
+
+	if got, err := Control(path, ""); err != nil || got != "unlimited" {
+		t.Fatalf("query = %q, %v", got, err)
+	}
+	if got, err := Control(path, "5MB/s"); err != nil || got != "5MB/s" {
+		t.Fatalf("set = %q, %v", got, err)
+	}
+	if l.Rate() != 5<<20 {
+		t.Fatalf("limiter rate = %d", l.Rate())
+	}
+	if _, err := Control(path, "soon"); !errors.Is(err, apperrors.ErrUsage) {
+		t.Fatalf("bad rate error = %v", err)
+	}
+}
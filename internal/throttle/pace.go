@@ -0,0 +1,65 @@
+package throttle
+
+import "io"
+
+// maxPiece caps how many bytes a paced reader or writer moves per Wait.
+const maxPiece = 32 << 10
+
+// Reader paces reads from r through l. A nil l returns r unchanged.
+func Reader(r io.Reader, l *Limiter) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &pacedReader{r: r, l: l}
+}
+
+// Writer paces writes to w through l. A nil l returns w unchanged.
+func Writer(w io.Writer, l *Limiter) io.Writer {
+	if l == nil {
+		return w
+	}
+	return &pacedWriter{w: w, l: l}
+}
+
+type pacedReader struct {
+	r io.Reader
+	l *Limiter
+}
+
+// Read reads at most one piece, then waits for it to fit the rate; the kernel
+// buffers fill meanwhile and slow the peer down.
+func (p *pacedReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b[:min(len(b), p.l.piece())])
+	p.l.Wait(n)
+	return n, err
+}
+
+type pacedWriter struct {
+	w io.Writer
+	l *Limiter
+}
+
+// Write sends b a piece at a time, waiting before each one.
+func (p *pacedWriter) Write(b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		piece := b[written:][:min(len(b)-written, p.l.piece())]
+		p.l.Wait(len(piece))
+		n, err := p.w.Write(piece)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// piece returns how much to move per Wait: at most a quarter second at the
+// current rate, so a slow limit still shows the peer steady progress.
+func (l *Limiter) piece() int {
+	rate := l.Rate()
+	if rate == Unlimited {
+		return maxPiece
+	}
+	return int(min(max(rate/4, 1), maxPiece))
+}
@@ -0,0 +1,109 @@
+package throttle
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	apperrors "snapsync/internal/errors"
+)
+
+// Window sets the rate from Start, a time of day, until the next window starts.
+type Window struct {
+	// Start is the offset from local midnight.
+	Start time.Duration
+	// Rate is in bytes per second, or Unlimited.
+	Rate int64
+}
+
+// ParseWindow parses a window starting at clock, written HH:MM, with a rate in the
+// form ParseRate accepts.
+func ParseWindow(clock, rate string) (Window, error) {
+	at, err := time.Parse("15:04", clock)
+	if err != nil {
+		return Window{}, fmt.Errorf("invalid time of day %q, want HH:MM such as 19:00: %w", clock, apperrors.ErrUsage)
+	}
+	r, err := ParseRate(rate)
+	if err != nil {
+		return Window{}, err
+	}
+	return Window{Start: time.Duration(at.Hour())*time.Hour + time.Duration(at.Minute())*time.Minute, Rate: r}, nil
+}
+
+// Schedule repeats its windows daily. The last window of a day runs until the
+// first window of the next.
+type Schedule struct {
+	windows []Window
+}
+
+// NewSchedule orders windows into a daily schedule. Of windows starting at the
+// same time, the last one wins.
+func NewSchedule(windows []Window) Schedule {
+	sorted := slices.Clone(windows)
+	slices.SortStableFunc(sorted, func(a, b Window) int { return int(a.Start - b.Start) })
+	s := Schedule{}
+	for _, w := range sorted {
+		if n := len(s.windows); n > 0 && s.windows[n-1].Start == w.Start {
+			s.windows[n-1] = w
+			continue
+		}
+		s.windows = append(s.windows, w)
+	}
+	return s
+}
+
+// Empty reports whether the schedule has no windows.
+func (s Schedule) Empty() bool { return len(s.windows) == 0 }
+
+// RateAt returns the rate in force at t, or Unlimited for an empty schedule.
+func (s Schedule) RateAt(t time.Time) int64 {
+	if s.Empty() {
+		return Unlimited
+	}
+	_, clock := sinceMidnight(t)
+	current := s.windows[len(s.windows)-1]
+	for _, w := range s.windows {
+		if w.Start > clock {
+			break
+		}
+		current = w
+	}
+	return current.Rate
+}
+
+// next returns when the next window after t starts.
+func (s Schedule) next(t time.Time) time.Time {
+	midnight, clock := sinceMidnight(t)
+	for _, w := range s.windows {
+		if w.Start > clock {
+			return midnight.Add(w.Start)
+		}
+	}
+	return midnight.AddDate(0, 0, 1).Add(s.windows[0].Start)
+}
+
+func sinceMidnight(t time.Time) (time.Time, time.Duration) {
+	y, m, d := t.Date()
+	midnight := time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	return midnight, t.Sub(midnight)
+}
+
+// Follow sets the limiter's rate from s now and again as each window starts, until
+// ctx ends. Rates set by hand in between last until the next window.
+func (l *Limiter) Follow(ctx context.Context, s Schedule) {
+	if s.Empty() {
+		return
+	}
+	for {
+		now := time.Now()
+		l.SetRate(s.RateAt(now))
+		timer := time.NewTimer(time.Until(s.next(now)))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package throttle
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	apperrors "snapsync/internal/errors"
+)
+
+func TestScheduleRateAtWrapsAroundMidnight(t *testing.T) {
+	night, err := ParseWindow("19:00", "unlimited")
+	if err != nil {
+		t.Fatalf("ParseWindow() error = %v", err)
+	}
+	day, err := ParseWindow("08:30", "2MB/s")
+	if err != nil {
+		t.Fatalf("ParseWindow() error = %v", err)
+	}
+	s := NewSchedule([]Window{night, day})
+	at := func(h, m int) time.Time { return time.Date(2026, 3, 4, h, m, 0, 0, time.Local) }
+
+	cases := []struct {
+		t    time.Time
+		want int64
+	}{
+		{at(3, 0), Unlimited},
+		{at(8, 30), 2 << 20},
+		{at(18, 59), 2 << 20},
+		{at(19, 0), Unlimited},
+	}
+	for _, tc := range cases {
+		if got := s.RateAt(tc.t); got != tc.want {
+			t.Fatalf("RateAt(%s) = %d, want %d", tc.t.Format("15:04"), got, tc.want)
+		}
+	}
+	if got := s.next(at(12, 0)); !got.Equal(at(19, 0)) {
+		t.Fatalf("next(12:00) = %s", got)
+	}
+	if got := s.next(at(20, 0)); !got.Equal(at(8, 30).AddDate(0, 0, 1)) {
+		t.Fatalf("next(20:00) = %s", got)
+	}
+	if got := NewSchedule(nil).RateAt(at(12, 0)); got != Unlimited {
+		t.Fatalf("empty schedule rate = %d", got)
+	}
+}
+
+func TestParseWindowRejectsBadClock(t *testing.T) {
+	for _, clock := range []string{"7pm", "25:00", ""} {
+		if _, err := ParseWindow(clock, "1MB"); !errors.Is(err, apperrors.ErrUsage) {
+			t.Fatalf("ParseWindow(%q) error = %v, want usage error", clock, err)
+		}
+	}
+}
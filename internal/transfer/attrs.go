@@ -0,0 +1,69 @@
+package transfer
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	apperrors "snapsync/internal/errors"
+)
+
+// PreserveAll honors every file attribute a sender offers.
+const PreserveAll = AttrMode | AttrModTime | AttrOwner
+
+// ParsePreserve turns a comma-separated list of mode, mtime, and owner, or all or
+// none, into the attribute bits a receiver applies.
+func ParsePreserve(list string) (uint8, error) {
+	var set uint8
+	for _, name := range strings.Split(list, ",") {
+		switch strings.TrimSpace(name) {
+		case "mode":
+			set |= AttrMode
+		case "mtime":
+			set |= AttrModTime
+		case "owner":
+			set |= AttrOwner
+		case "all":
+			set |= PreserveAll
+		case "none", "":
+		default:
+			return 0, fmt.Errorf("unknown attribute %q, want mode, mtime, owner, all, or none: %w", name, apperrors.ErrUsage)
+		}
+	}
+	return set, nil
+}
+
+// sourceAttrs captures the metadata of a source file for its OFFER.
+func sourceAttrs(info fs.FileInfo) FileAttrs {
+	attrs := FileAttrs{Set: AttrMode | AttrModTime, Mode: info.Mode().Perm(), ModTime: info.ModTime()}
+	if uid, gid, ok := fileOwner(info); ok {
+		attrs.Set |= AttrOwner
+		attrs.UID, attrs.GID = uid, gid
+	}
+	return attrs
+}
+
+// applyAttrs sets the offered attributes that preserve allows on a finalized file.
+// The content is already verified, so failures are reported on out instead of
+// failing the transfer; changing the owner usually needs root.
+func applyAttrs(path string, attrs FileAttrs, preserve uint8, out io.Writer) {
+	set := attrs.Set & preserve
+	if set&AttrOwner != 0 {
+		if err := os.Lchown(path, int(attrs.UID), int(attrs.GID)); err != nil {
+			_, _ = fmt.Fprintf(out, "Could not preserve owner of %s: %v\n", path, err)
+		}
+	}
+	if set&AttrMode != 0 {
+		if err := os.Chmod(path, attrs.Mode.Perm()); err != nil {
+			_, _ = fmt.Fprintf(out, "Could not preserve mode of %s: %v\n", path, err)
+		}
+	}
+	if set&AttrModTime != 0 {
+		if err := os.Chtimes(path, time.Time{}, attrs.ModTime); err != nil {
+			_, _ = fmt.Fprintf(out, "Could not preserve modification time of %s: %v\n", path, err)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package transfer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReceiverAppliesPreservedAttrs(t *testing.T) {
+	srcPath := filepath.Join(t.TempDir(), "build.sh")
+	if err := os.WriteFile(srcPath, []byte("#!/bin/sh\necho ok\n"), 0o755); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := os.Chmod(srcPath, 0o751); err != nil {
+		t.Fatalf("Chmod() error = %v", err)
+	}
+	mtime := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(srcPath, mtime, mtime); err != nil {
+		t.Fatalf("Chtimes() error = %v", err)
+	}
+
+	for _, preserve := range []uint8{0, AttrMode | AttrModTime} {
+		dstDir := t.TempDir()
+		addr, done := startReceiveOnce(t, ReceiverOptions{OutDir: dstDir, AutoAccept: true, Preserve: preserve, Out: ioDiscard{}})
+		sendErr := Send(SenderOptions{Path: srcPath, Address: addr, Out: ioDiscard{}})
+		if recvErr := <-done; sendErr != nil || recvErr != nil {
+			t.Fatalf("preserve=%d send err=%v recv err=%v", preserve, sendErr, recvErr)
+		}
+		info, err := os.Stat(filepath.Join(dstDir, "build.sh"))
+		if err != nil {
+			t.Fatalf("Stat() error = %v", err)
+		}
+		kept := info.Mode().Perm() == 0o751 && info.ModTime().Equal(mtime)
+		if kept != (preserve != 0) {
+			t.Fatalf("preserve=%d got mode %v mtime %v", preserve, info.Mode().Perm(), info.ModTime())
+		}
+	}
+}
+
+func TestParsePreserve(t *testing.T) {
+	if set, err := ParsePreserve("mode, mtime"); err != nil || set != AttrMode|AttrModTime {
+		t.Fatalf("ParsePreserve(mode, mtime) = %d, %v", set, err)
+	}
+	if set, err := ParsePreserve("all"); err != nil || set != PreserveAll {
+		t.Fatalf("ParsePreserve(all) = %d, %v", set, err)
+	}
+	if _, err := ParsePreserve("acl"); err == nil {
+		t.Fatal("expected unknown attribute to fail")
+	}
+}
@@ -0,0 +1,114 @@
+package transfer
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"runtime"
+	"syscall"
+
+	apperrors "snapsync/internal/errors"
+)
+
+// String describes the reason for people.
+func (r CancelReason) String() string {
+	switch r {
+	case CancelUser:
+		return "cancelled by user"
+	case CancelDiskFull:
+		return "disk full"
+	case CancelPolicy:
+		return "policy violation"
+	default:
+		return fmt.Sprintf("cancel reason %d", r)
+	}
+}
+
+// sentinel returns the application error a reason maps to. Unknown reasons from
+// newer peers count as a plain cancel.
+func (r CancelReason) sentinel() error {
+	switch r {
+	case CancelDiskFull:
+		return apperrors.ErrDiskFull
+	case CancelPolicy:
+		return apperrors.ErrPolicy
+	default:
+		return apperrors.ErrCancelled
+	}
+}
+
+// keepsPartial reports whether a receiver may keep the partial of a transfer
+// stopped for this reason. Data that broke a policy is never kept for resume.
+func (r CancelReason) keepsPartial() bool { return r != CancelPolicy }
+
+// cancelError reports a CANCEL frame from the peer, named by who.
+func cancelError(who string, payload []byte) error {
+	reason, msg, err := DecodeCancel(payload)
+	if err != nil {
+		return fmt.Errorf("decode %s cancel frame: %w", who, err)
+	}
+	if msg == "" {
+		msg = reason.String()
+	}
+	if reason == CancelPolicy {
+		// A policy cancel is also a rejection, so callers checking ErrRejected
+		// keep working.
+		return fmt.Errorf("%s stopped the transfer: %s: %w: %w", who, msg, apperrors.ErrPolicy, apperrors.ErrRejected)
+	}
+	return fmt.Errorf("%s stopped the transfer: %s: %w", who, msg, reason.sentinel())
+}
+
+// sendCancel tells the peer why this side is stopping. Peers that did not
+// negotiate CapCancel get the message in an ERROR frame.
+func sendCancel(w *bufio.Writer, agreed HelloPayload, reason CancelReason, msg string) error {
+	if msg == "" {
+		msg = reason.String()
+	}
+	if !agreed.Has(CapCancel) {
+		return sendErrorFrame(w, msg)
+	}
+	payload, err := EncodeCancel(reason, msg)
+	if err != nil {
+		return err
+	}
+	if err := WriteFrame(w, Frame{Type: TypeCancel, Payload: payload}); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// interrupted reports whether the cancel channel c has been closed. A nil c never is.
+func interrupted(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+// stopForUser tells the peer the local user cancelled and returns the local error.
+func stopForUser(w *bufio.Writer, agreed HelloPayload) error {
+	_ = sendCancel(w, agreed, CancelUser, "")
+	return fmt.Errorf("interrupted: %w", apperrors.ErrCancelled)
+}
+
+// diskFull reports whether err means the filesystem ran out of space.
+func diskFull(err error) bool {
+	if errors.Is(err, syscall.ENOSPC) {
+		return true
+	}
+	// ERROR_HANDLE_DISK_FULL and ERROR_DISK_FULL.
+	var errno syscall.Errno
+	return runtime.GOOS == "windows" && errors.As(err, &errno) && (errno == 39 || errno == 112)
+}
+
+// writeFailure returns the error for a failed write of received data, first
+// cancelling with CancelDiskFull when the disk is full so the sender stops too.
+func (s *receiverSession) writeFailure(w *bufio.Writer, what string, err error) error {
+	if diskFull(err) {
+		_ = sendCancel(w, s.hello, CancelDiskFull, "receiver is out of disk space")
+		return fmt.Errorf("%s: %w: %w", what, err, apperrors.ErrDiskFull)
+	}
+	return fmt.Errorf("%s: %w: %w", what, err, apperrors.ErrIO)
+}
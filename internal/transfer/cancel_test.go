@@ -0,0 +1,91 @@
+package transfer
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	apperrors "snapsync/internal/errors"
+	"snapsync/internal/throttle"
+)
+
+// cancelAfter returns a channel that closes after d.
+func cancelAfter(d time.Duration) <-chan struct{} {
+	c := make(chan struct{})
+	time.AfterFunc(d, func() { close(c) })
+	return c
+}
+
+func TestSenderCancelKeepsPartialForResume(t *testing.T) {
+	srcPath, data := stripeSource(t, 2)
+	outDir := t.TempDir()
+	opts := ReceiverOptions{OutDir: outDir, AutoAccept: true, Resume: true, Out: ioDiscard{}}
+
+	addr, done := startReceiver(t, opts)
+	err := Send(SenderOptions{Path: srcPath, Address: addr, Out: ioDiscard{}, Limit: throttle.NewLimiter(1 << 20), Cancel: cancelAfter(300 * time.Millisecond)})
+	if !errors.Is(err, apperrors.ErrCancelled) {
+		t.Fatalf("expected cancelled send, got %v", err)
+	}
+	if err := <-done; !errors.Is(err, apperrors.ErrCancelled) || errors.Is(err, apperrors.ErrNetwork) {
+		t.Fatalf("expected receiver to see the cancel, got %v", err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(outDir, "*.partial.snapsync")); len(matches) != 1 {
+		t.Fatalf("expected resume metadata after cancel, got %v", matches)
+	}
+
+	addr, done = startReceiver(t, opts)
+	if err := Send(SenderOptions{Path: srcPath, Address: addr, Out: ioDiscard{}}); err != nil {
+		t.Fatalf("resumed Send() error = %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("resumed receiver error = %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(outDir, filepath.Base(srcPath))); !bytes.Equal(got, data) {
+		t.Fatal("resumed file differs from source")
+	}
+}
+
+func TestReceiverCancelStopsSender(t *testing.T) {
+	srcPath, _ := stripeSource(t, 2)
+	outDir := t.TempDir()
+	addr, done := startReceiver(t, ReceiverOptions{OutDir: outDir, AutoAccept: true, Out: ioDiscard{}, Limit: throttle.NewLimiter(1 << 20), Cancel: cancelAfter(300 * time.Millisecond)})
+
+	err := Send(SenderOptions{Path: srcPath, Address: addr, Out: ioDiscard{}})
+	if !errors.Is(err, apperrors.ErrCancelled) {
+		t.Fatalf("expected sender to see the cancel, got %v", err)
+	}
+	if err := <-done; !errors.Is(err, apperrors.ErrCancelled) {
+		t.Fatalf("expected cancelled receiver, got %v", err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(outDir, "*.partial*")); len(matches) != 0 {
+		t.Fatalf("partial kept without resume: %v", matches)
+	}
+}
+
+func TestCancelReasonsMapToSentinels(t *testing.T) {
+	cases := []struct {
+		reason CancelReason
+		want   error
+	}{
+		{CancelUser, apperrors.ErrCancelled},
+		{CancelDiskFull, apperrors.ErrDiskFull},
+		{CancelPolicy, apperrors.ErrPolicy},
+		{CancelReason(99), apperrors.ErrCancelled},
+	}
+	for _, tc := range cases {
+		payload, err := EncodeCancel(tc.reason, "")
+		if err != nil {
+			t.Fatalf("EncodeCancel(%d) error = %v", tc.reason, err)
+		}
+		if err := cancelError("peer", payload); !errors.Is(err, tc.want) {
+			t.Fatalf("reason %d: got %v, want %v", tc.reason, err, tc.want)
+		}
+	}
+	if !diskFull(&os.PathError{Op: "write", Path: "x", Err: syscall.ENOSPC}) {
+		t.Fatal("ENOSPC not recognised as a full disk")
+	}
+}
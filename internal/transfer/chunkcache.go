@@ -0,0 +1,138 @@
+package transfer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"snapsync/internal/hash"
+)
+
+// chunkCacheDir resolves where the sender keeps block digests of files it has sent.
+var chunkCacheDir = func() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("resolve user cache dir: %w", err)
+	}
+	return filepath.Join(dir, "snapsync", "chunks"), nil
+}
+
+// chunkCacheSaveInterval is how many new block digests pass between cache saves.
+const chunkCacheSaveInterval = 64
+
+// chunkCache is the sender's record of block digests for one source file, so a
+// resumed transfer does not have to reread the prefix the receiver already holds.
+type chunkCache struct {
+	path    string
+	Source  string    `json:"source"`
+	Size    uint64    `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Alg     uint8     `json:"algorithm"`
+	Block   uint64    `json:"block_size"`
+	Leaves  [][]byte  `json:"leaves"`
+	dirty   int
+}
+
+// openChunkCache loads the cached digests for source, discarding them when the file
+// changed or they were made with a different algorithm or block size.
+func openChunkCache(source string, info os.FileInfo, alg hash.Algorithm) *chunkCache {
+	abs, err := filepath.Abs(source)
+	if err != nil {
+		abs = source
+	}
+	c := &chunkCache{Source: abs, Size: uint64(info.Size()), ModTime: info.ModTime().UTC(), Alg: uint8(alg), Block: IntegrityBlockSize}
+	dir, err := chunkCacheDir()
+	if err != nil {
+		return c
+	}
+	sum := sha256.Sum256([]byte(abs))
+	c.path = filepath.Join(dir, hex.EncodeToString(sum[:16])+".json")
+
+	b, err := os.ReadFile(c.path)
+	if err != nil {
+		return c
+	}
+	var prior chunkCache
+	if json.Unmarshal(b, &prior) != nil || prior.Source != c.Source || prior.Size != c.Size ||
+		!prior.ModTime.Equal(c.ModTime) || prior.Alg != c.Alg || prior.Block != c.Block {
+		return c
+	}
+	for _, leaf := range prior.Leaves {
+		if leaf != nil && len(leaf) != alg.Size() {
+			return c
+		}
+	}
+	c.Leaves = prior.Leaves
+	return c
+}
+
+// prefix returns the cached digests for the first n blocks, or nil if any are missing.
+func (c *chunkCache) prefix(n int) [][]byte {
+	if n > len(c.Leaves) {
+		return nil
+	}
+	for _, leaf := range c.Leaves[:n] {
+		if leaf == nil {
+			return nil
+		}
+	}
+	return c.Leaves[:n]
+}
+
+// leaf returns the cached digest of block i, or nil if it is unknown.
+func (c *chunkCache) leaf(i int) []byte {
+	if i >= len(c.Leaves) {
+		return nil
+	}
+	return c.Leaves[i]
+}
+
+// set caches the digest of block i. Striped streams complete blocks out of order.
+func (c *chunkCache) set(i int, digest []byte) {
+	for len(c.Leaves) <= i {
+		c.Leaves = append(c.Leaves, nil)
+	}
+	c.Leaves[i] = digest
+	c.dirty++
+	c.maybeSave()
+}
+
+func (c *chunkCache) maybeSave() {
+	if c.dirty >= chunkCacheSaveInterval {
+		c.save()
+	}
+}
+
+// save writes the cache; failures only cost a prefix reread on the next resume.
+func (c *chunkCache) save() {
+	if c.path == "" || c.dirty == 0 {
+		return
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o700); err != nil {
+		return
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		_ = os.Remove(tmp)
+		return
+	}
+	c.dirty = 0
+}
+
+// remove drops the cache once the receiver has verified the file.
+func (c *chunkCache) remove() {
+	if c.path != "" {
+		_ = os.Remove(c.path)
+	}
+}
@@ -0,0 +1,63 @@
+package transfer
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"snapsync/internal/hash"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "snapsync-chunks-")
+	if err != nil {
+		panic(err)
+	}
+	chunkCacheDir = func() (string, error) { return dir, nil }
+	code := m.Run()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestChunkCacheRoundTripAndInvalidation(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "src.bin")
+	if err := os.WriteFile(src, bytes.Repeat([]byte("c"), 1024), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	info, _ := os.Stat(src)
+	leaves := make([][]byte, chunkCacheSaveInterval)
+	for i := range leaves {
+		leaves[i] = bytes.Repeat([]byte{byte(i)}, hash.BLAKE3.Size())
+	}
+
+	cache := openChunkCache(src, info, hash.BLAKE3)
+	for i, leaf := range leaves {
+		cache.set(i, leaf)
+	}
+	reopened := openChunkCache(src, info, hash.BLAKE3)
+	if got := reopened.prefix(2); len(got) != 2 || !bytes.Equal(got[1], leaves[1]) {
+		t.Fatalf("expected cached leaves, got %d", len(got))
+	}
+	if reopened.prefix(len(leaves)+1) != nil {
+		t.Fatal("expected no prefix beyond cached leaves")
+	}
+	if got := openChunkCache(src, info, hash.SHA256).prefix(1); got != nil {
+		t.Fatal("expected cache for another algorithm to be ignored")
+	}
+
+	later := info.ModTime().Add(time.Minute)
+	if err := os.Chtimes(src, later, later); err != nil {
+		t.Fatalf("Chtimes() error = %v", err)
+	}
+	changed, _ := os.Stat(src)
+	if got := openChunkCache(src, changed, hash.BLAKE3).prefix(1); got != nil {
+		t.Fatal("expected cache for a modified file to be ignored")
+	}
+
+	reopened.remove()
+	if got := openChunkCache(src, info, hash.BLAKE3).prefix(1); got != nil {
+		t.Fatal("expected removed cache to be empty")
+	}
+}
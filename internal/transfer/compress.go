@@ -0,0 +1,85 @@
+package transfer
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+
+	apperrors "snapsync/internal/errors"
+)
+
+// compressor deflates DATA payloads for the wire. Chunks that do not shrink go out
+// as plain DATA, so already-compressed files cost only the attempt.
+type compressor struct {
+	buf  bytes.Buffer
+	w    *flate.Writer
+	raw  uint64
+	wire uint64
+}
+
+// newWireCompressor returns a compressor when the sender asked for compression and
+// the receiver negotiated it, or nil to send plain DATA.
+func newWireCompressor(opts SenderOptions, agreed HelloPayload) *compressor {
+	if !opts.Compress || !agreed.Has(CapCompression) {
+		return nil
+	}
+	w, _ := flate.NewWriter(io.Discard, flate.BestSpeed)
+	return &compressor{w: w}
+}
+
+// frame returns the frame carrying chunk. A nil compressor always sends plain DATA.
+// The returned payload is only valid until the next call.
+func (c *compressor) frame(chunk []byte) Frame {
+	if c == nil {
+		return Frame{Type: TypeData, Payload: chunk}
+	}
+	c.raw += uint64(len(chunk))
+	c.buf.Reset()
+	c.w.Reset(&c.buf)
+	_, _ = c.w.Write(chunk)
+	_ = c.w.Close()
+	if c.buf.Len() >= len(chunk) {
+		c.wire += uint64(len(chunk))
+		return Frame{Type: TypeData, Payload: chunk}
+	}
+	c.wire += uint64(c.buf.Len())
+	return Frame{Type: TypeCompressed, Payload: c.buf.Bytes()}
+}
+
+// report prints how much compression saved.
+func (c *compressor) report(out io.Writer) {
+	if c == nil || c.raw == 0 {
+		return
+	}
+	_, _ = fmt.Fprintf(out, "Compressed %d bytes to %d on the wire (%.1f%% saved)\n", c.raw, c.wire, 100*(1-float64(c.wire)/float64(c.raw)))
+}
+
+// decompressor inflates compressed DATA payloads.
+type decompressor struct {
+	r   io.ReadCloser
+	out []byte
+}
+
+// data returns the file bytes a DATA or compressed DATA frame carries. Inflated
+// payloads are capped at MaxChunkSize, like plain DATA.
+func (d *decompressor) data(frame Frame) ([]byte, error) {
+	if frame.Type == TypeData {
+		return frame.Payload, nil
+	}
+	src := bytes.NewReader(frame.Payload)
+	if d.r == nil {
+		d.r = flate.NewReader(src)
+		d.out = make([]byte, MaxChunkSize+1)
+	} else if err := d.r.(flate.Resetter).Reset(src, nil); err != nil {
+		return nil, fmt.Errorf("reset decompressor: %w: %w", err, apperrors.ErrInvalidProtocol)
+	}
+	n, err := io.ReadFull(d.r, d.out)
+	switch {
+	case err == nil:
+		return nil, fmt.Errorf("compressed chunk exceeds %d bytes: %w", MaxChunkSize, apperrors.ErrInvalidProtocol)
+	case err != io.ErrUnexpectedEOF && err != io.EOF:
+		return nil, fmt.Errorf("inflate chunk: %w: %w", err, apperrors.ErrInvalidProtocol)
+	}
+	return d.out[:n], nil
+}
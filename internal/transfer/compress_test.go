@@ -0,0 +1,74 @@
+package transfer
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	apperrors "snapsync/internal/errors"
+)
+
+func TestCompressorSkipsChunksThatDoNotShrink(t *testing.T) {
+	comp := newWireCompressor(SenderOptions{Compress: true}, HelloPayload{Caps: CapCompression})
+	var inflate decompressor
+
+	text := bytes.Repeat([]byte("timestamp,level,message\n"), 4096)
+	frame := comp.frame(text)
+	if frame.Type != TypeCompressed || len(frame.Payload) >= len(text) {
+		t.Fatalf("expected compressed frame, got type=%d len=%d", frame.Type, len(frame.Payload))
+	}
+	if got, err := inflate.data(frame); err != nil || !bytes.Equal(got, text) {
+		t.Fatalf("inflate mismatch err=%v", err)
+	}
+
+	noise := make([]byte, 64*1024)
+	_, _ = rand.Read(noise)
+	if frame := comp.frame(noise); frame.Type != TypeData || !bytes.Equal(frame.Payload, noise) {
+		t.Fatalf("expected plain DATA for incompressible chunk, got type=%d", frame.Type)
+	}
+	if newWireCompressor(SenderOptions{Compress: true}, HelloPayload{}) != nil {
+		t.Fatal("expected no compression without the negotiated capability")
+	}
+}
+
+func TestDecompressorRejectsOversizedChunk(t *testing.T) {
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.BestCompression)
+	_, _ = w.Write(make([]byte, MaxChunkSize+1))
+	_ = w.Close()
+	var inflate decompressor
+	if _, err := inflate.data(Frame{Type: TypeCompressed, Payload: buf.Bytes()}); !errors.Is(err, apperrors.ErrInvalidProtocol) {
+		t.Fatalf("expected protocol error for oversized chunk, got %v", err)
+	}
+	if _, err := inflate.data(Frame{Type: TypeCompressed, Payload: []byte("not deflate")}); !errors.Is(err, apperrors.ErrInvalidProtocol) {
+		t.Fatalf("expected protocol error for corrupt chunk, got %v", err)
+	}
+}
+
+func TestCompressedTransfer(t *testing.T) {
+	srcPath := filepath.Join(t.TempDir(), "app.log")
+	srcData := bytes.Repeat([]byte("2024-01-01T00:00:00Z INFO request served in 12ms\n"), 200000) // ~10MB
+	if err := os.WriteFile(srcPath, srcData, 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	for _, streams := range []int{1, 3} {
+		dstDir := t.TempDir()
+		var out bytes.Buffer
+		addr, done := startReceiveOnce(t, ReceiverOptions{OutDir: dstDir, AutoAccept: true, Out: ioDiscard{}})
+		sendErr := Send(SenderOptions{Path: srcPath, Address: addr, Compress: true, Streams: streams, Out: &out})
+		if recvErr := <-done; sendErr != nil || recvErr != nil {
+			t.Fatalf("streams=%d send err=%v recv err=%v", streams, sendErr, recvErr)
+		}
+		if !strings.Contains(out.String(), "Compressed ") {
+			t.Fatalf("streams=%d expected compression report, got %q", streams, out.String())
+		}
+		if got, _ := os.ReadFile(filepath.Join(dstDir, "app.log")); !bytes.Equal(got, srcData) {
+			t.Fatalf("streams=%d content mismatch", streams)
+		}
+	}
+}
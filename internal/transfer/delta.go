@@ -0,0 +1,420 @@
+package transfer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	apperrors "snapsync/internal/errors"
+	"snapsync/internal/hash"
+	"snapsync/internal/progress"
+)
+
+// Delta block sizes bound how much signature a receiver sends for its basis file.
+const (
+	minDeltaBlock = 2 * 1024
+	maxDeltaBlock = 128 * 1024
+	// deltaStrongLen caps the strong digest kept per basis block.
+	deltaStrongLen = 16
+	// deltaPrealloc caps the signature slots reserved up front, since the block
+	// count comes from the receiver and only arriving SIGNATURE frames prove it.
+	deltaPrealloc = 64 * 1024
+)
+
+// deltaBlockSize picks a block size near the square root of the basis size, the
+// usual rsync trade-off between signature size and match granularity.
+func deltaBlockSize(size uint64) uint32 {
+	bs := uint32(math.Sqrt(float64(size))) &^ 1023
+	return min(max(bs, minDeltaBlock), maxDeltaBlock)
+}
+
+func deltaStrongSize(alg hash.Algorithm) uint8 {
+	return uint8(min(alg.Size(), deltaStrongLen))
+}
+
+// rollingSum is the rsync weak checksum, which slides one byte at a time.
+type rollingSum struct {
+	a, b uint32
+	n    uint32
+}
+
+func newRollingSum(block []byte) rollingSum {
+	r := rollingSum{n: uint32(len(block))}
+	for i, c := range block {
+		r.a += uint32(c)
+		r.b += uint32(len(block)-i) * uint32(c)
+	}
+	return r
+}
+
+// roll drops out from the front of the window and appends in.
+func (r *rollingSum) roll(out, in byte) {
+	r.a += uint32(in) - uint32(out)
+	r.b += r.a - r.n*uint32(out)
+}
+
+func (r rollingSum) sum() uint32 { return r.a&0xffff | r.b<<16 }
+
+func strongSum(alg hash.Algorithm, block []byte, n uint8) []byte {
+	h, _ := hash.New(alg)
+	_, _ = h.Write(block)
+	return h.Sum()[:n]
+}
+
+// deltaSignature indexes the receiver's basis blocks by weak checksum.
+type deltaSignature struct {
+	header DeltaHeader
+	alg    hash.Algorithm
+	strong [][]byte
+	table  map[uint32][]int
+}
+
+// match returns the basis block holding window, preferring want so unchanged runs
+// stay sequential, or -1.
+func (sig *deltaSignature) match(weak uint32, window []byte, want int) int {
+	candidates := sig.table[weak]
+	if len(candidates) == 0 {
+		return -1
+	}
+	strong := strongSum(sig.alg, window, sig.header.StrongLen)
+	found := -1
+	for _, idx := range candidates {
+		if string(sig.strong[idx]) == string(strong) {
+			if idx == want {
+				return idx
+			}
+			if found < 0 {
+				found = idx
+			}
+		}
+	}
+	return found
+}
+
+// diff scans src against the signature and reports the file as literal runs and
+// copies of basis blocks, in order. Literal runs are at most MaxChunkSize bytes and
+// only valid during the call.
+func (sig *deltaSignature) diff(src io.Reader, literal func([]byte) error, copyBlock func(offset uint64, data []byte) error) error {
+	bs := int(sig.header.BlockSize)
+	buf := make([]byte, 0, 4*MaxChunkSize)
+	eof := false
+	pos, lit := 0, 0
+	// fill reads until buf holds need bytes or the source ends, first dropping bytes
+	// that were already emitted.
+	fill := func(need int) error {
+		if need > cap(buf) {
+			n := copy(buf, buf[lit:])
+			buf, pos, need, lit = buf[:n], pos-lit, need-lit, 0
+		}
+		for len(buf) < need && !eof {
+			n, err := src.Read(buf[len(buf):cap(buf)])
+			buf = buf[:len(buf)+n]
+			if err == io.EOF {
+				eof = true
+			} else if err != nil {
+				return fmt.Errorf("read source file: %w: %w", err, apperrors.ErrIO)
+			}
+		}
+		return nil
+	}
+	flush := func(end int) error {
+		for lit < end {
+			n := min(end-lit, MaxChunkSize)
+			if err := literal(buf[lit : lit+n]); err != nil {
+				return err
+			}
+			lit += n
+		}
+		return nil
+	}
+
+	if sig.header.BlockCount() > 0 {
+		var sum rollingSum
+		fresh, want := true, 0
+		for {
+			if err := fill(pos + bs); err != nil {
+				return err
+			}
+			if len(buf)-pos < bs {
+				break
+			}
+			window := buf[pos : pos+bs]
+			if fresh {
+				sum, fresh = newRollingSum(window), false
+			}
+			if idx := sig.match(sum.sum(), window, want); idx >= 0 {
+				if err := flush(pos); err != nil {
+					return err
+				}
+				if err := copyBlock(uint64(idx)*uint64(bs), window); err != nil {
+					return err
+				}
+				pos += bs
+				lit, fresh, want = pos, true, idx+1
+				continue
+			}
+			if pos-lit >= MaxChunkSize {
+				if err := flush(pos); err != nil {
+					return err
+				}
+			}
+			if err := fill(pos + bs + 1); err != nil {
+				return err
+			}
+			if len(buf) <= pos+bs {
+				break
+			}
+			sum.roll(buf[pos], buf[pos+bs])
+			pos++
+		}
+	}
+	for {
+		if err := fill(lit + MaxChunkSize); err != nil {
+			return err
+		}
+		if lit == len(buf) {
+			return nil
+		}
+		if err := flush(len(buf)); err != nil {
+			return err
+		}
+	}
+}
+
+// sendDelta sends a file as literal DATA and COPY frames against the receiver's
+// existing copy, whose block signatures it requests with DELTA.
+func sendDelta(reader *bufio.Reader, writer *bufio.Writer, file *os.File, entry sourceEntry, agreed HelloPayload, opts SenderOptions) error {
+	alg := hash.Algorithm(agreed.Hashes[0])
+	sig, err := requestSignature(reader, writer, alg)
+	if err != nil {
+		return err
+	}
+	var blockSize uint64
+	chunked := agreed.Has(CapChunkDigests)
+	if chunked {
+		blockSize = IntegrityBlockSize
+	}
+	tree, err := hash.NewTree(alg, blockSize, nil)
+	if err != nil {
+		return fmt.Errorf("create sender hasher: %w", err)
+	}
+	if sig.header.BasisSize > 0 {
+		_, _ = fmt.Fprintf(opts.Out, "Comparing against the receiver's %d byte copy in %d byte blocks\n", sig.header.BasisSize, sig.header.BlockSize)
+	}
+
+	reporter := progress.NewReporter(opts.Observer, progress.Event{Direction: "sending", Peer: opts.peer, Name: entry.name, Total: entry.size})
+	comp := newWireCompressor(opts, agreed)
+	var sent, reused uint64
+	announced := 0
+	// emit hashes bytes as they go out, so CHUNK digests never run ahead of the data.
+	emit := func(frame Frame, data []byte) error {
+		if interrupted(opts.Cancel) {
+			return stopForUser(writer, agreed)
+		}
+		if _, err := tree.Write(data); err != nil {
+			return fmt.Errorf("hash source chunk: %w", err)
+		}
+		if err := writePacedFrame(writer, frame, opts.Limit); err != nil {
+			return peerFailure(reader, fmt.Errorf("send delta frame: %w: %w", err, apperrors.ErrNetwork))
+		}
+		sent += uint64(len(data))
+		reporter.Update(sent)
+		if chunked {
+			if announced, err = sendChunkDigests(reader, writer, tree.Leaves(), announced); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	// Adjacent block copies are merged into one COPY frame.
+	var copyOffset uint64
+	var copyData []byte
+	flushCopy := func() error {
+		if len(copyData) == 0 {
+			return nil
+		}
+		reused += uint64(len(copyData))
+		err := emit(Frame{Type: TypeCopy, Payload: EncodeCopy(copyOffset, uint32(len(copyData)))}, copyData)
+		copyData = copyData[:0]
+		return err
+	}
+	literal := func(data []byte) error {
+		if err := flushCopy(); err != nil {
+			return err
+		}
+		return emit(comp.frame(data), data)
+	}
+	copyBlock := func(offset uint64, data []byte) error {
+		if len(copyData) > 0 && (copyOffset+uint64(len(copyData)) != offset || len(copyData)+len(data) > MaxChunkSize) {
+			if err := flushCopy(); err != nil {
+				return err
+			}
+		}
+		if len(copyData) == 0 {
+			copyOffset = offset
+		}
+		copyData = append(copyData, data...)
+		return nil
+	}
+	if err := sig.diff(file, literal, copyBlock); err != nil {
+		return err
+	}
+	if err := flushCopy(); err != nil {
+		return err
+	}
+	tree.Finish()
+	if chunked {
+		if _, err := sendChunkDigests(reader, writer, tree.Leaves(), announced); err != nil {
+			return err
+		}
+	}
+
+	digest := tree.Root()
+	if err := sendDone(reader, writer, agreed, alg, digest); err != nil {
+		return err
+	}
+	e := verifiedEvent("sending", entry.name, alg, digest)
+	e.Peer = opts.peer
+	opts.Observer.Observe(e)
+	reporter.Done(sent, entry.name)
+	_, _ = fmt.Fprintf(opts.Out, "Delta reused %d of %d bytes from the receiver's copy\n", reused, sent)
+	comp.report(opts.Out)
+	_, _ = fmt.Fprintln(opts.Out, "Transfer complete.")
+	_, _ = fmt.Fprintln(opts.Out, "Integrity verified.")
+	_, _ = fmt.Fprintf(opts.Out, "%s: %x\n", alg, digest)
+	return nil
+}
+
+// requestSignature sends DELTA and reads the receiver's basis signature.
+func requestSignature(reader *bufio.Reader, writer *bufio.Writer, alg hash.Algorithm) (*deltaSignature, error) {
+	if err := WriteFrame(writer, Frame{Type: TypeDelta}); err != nil {
+		return nil, fmt.Errorf("send delta request: %w: %w", err, apperrors.ErrNetwork)
+	}
+	if err := writer.Flush(); err != nil {
+		return nil, fmt.Errorf("flush delta request: %w: %w", err, apperrors.ErrNetwork)
+	}
+	resp, err := readFrame(reader, writer)
+	if err != nil {
+		return nil, fmt.Errorf("read delta reply: %w: %w", err, apperrors.ErrNetwork)
+	}
+	switch resp.Type {
+	case TypeDelta:
+	case TypeError:
+		return nil, receiverError(resp.Payload)
+	case TypeCancel:
+		return nil, cancelError("receiver", resp.Payload)
+	default:
+		return nil, fmt.Errorf("unexpected delta reply frame type %d: %w", resp.Type, apperrors.ErrInvalidProtocol)
+	}
+	header, err := DecodeDeltaHeader(resp.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("decode delta reply: %w", err)
+	}
+	if header.BasisSize > 0 && header.StrongLen != deltaStrongSize(alg) {
+		return nil, fmt.Errorf("receiver signed blocks with %d byte digests: %w", header.StrongLen, apperrors.ErrInvalidProtocol)
+	}
+	sig := &deltaSignature{header: header, alg: alg, strong: make([][]byte, 0, min(header.BlockCount(), deltaPrealloc)), table: map[uint32][]int{}}
+	for len(sig.strong) < header.BlockCount() {
+		frame, err := readFrame(reader, writer)
+		if err != nil {
+			return nil, fmt.Errorf("read signature frame: %w: %w", err, apperrors.ErrNetwork)
+		}
+		if frame.Type != TypeSignature {
+			return nil, fmt.Errorf("expected SIGNATURE, got %d: %w", frame.Type, apperrors.ErrInvalidProtocol)
+		}
+		weak, strong, err := DecodeSignature(frame.Payload, int(header.StrongLen))
+		if err != nil {
+			return nil, fmt.Errorf("decode signature frame: %w", err)
+		}
+		if len(sig.strong)+len(weak) > header.BlockCount() {
+			return nil, fmt.Errorf("receiver signed more blocks than announced: %w", apperrors.ErrInvalidProtocol)
+		}
+		for i := range weak {
+			sig.table[weak[i]] = append(sig.table[weak[i]], len(sig.strong))
+			sig.strong = append(sig.strong, strong[i])
+		}
+	}
+	return sig, nil
+}
+
+// deltaBasis is the receiver's existing copy of a file, read by COPY frames.
+type deltaBasis struct {
+	file   *os.File
+	size   uint64
+	buf    []byte
+	reused uint64
+}
+
+// sendSignature answers a DELTA request with the block signature of the file at
+// path. Without a readable regular file the signature is empty, and the sender
+// falls back to literal data.
+func (s *receiverSession) sendSignature(path string, alg hash.Algorithm) (*deltaBasis, error) {
+	basis := &deltaBasis{}
+	if file, err := os.Open(path); err == nil {
+		if info, statErr := file.Stat(); statErr == nil && info.Mode().IsRegular() {
+			basis.file, basis.size = file, uint64(info.Size())
+		} else {
+			_ = file.Close()
+		}
+	}
+	header := DeltaHeader{}
+	if basis.size > 0 {
+		header = DeltaHeader{BlockSize: deltaBlockSize(basis.size), BasisSize: basis.size, StrongLen: deltaStrongSize(alg)}
+	}
+	if err := WriteFrame(s.writer, Frame{Type: TypeDelta, Payload: EncodeDeltaHeader(header)}); err != nil {
+		basis.close()
+		return nil, fmt.Errorf("send delta reply: %w: %w", err, apperrors.ErrNetwork)
+	}
+
+	block := make([]byte, header.BlockSize)
+	perFrame := MaxControlPayload / (4 + int(header.StrongLen))
+	var weak []uint32
+	var strong [][]byte
+	for i := 0; i < header.BlockCount(); i++ {
+		if _, err := basis.file.ReadAt(block, int64(i)*int64(header.BlockSize)); err != nil {
+			basis.close()
+			return nil, fmt.Errorf("read basis file: %w: %w", err, apperrors.ErrIO)
+		}
+		weak = append(weak, newRollingSum(block).sum())
+		strong = append(strong, strongSum(alg, block, header.StrongLen))
+		if len(weak) == perFrame || i == header.BlockCount()-1 {
+			if err := WriteFrame(s.writer, Frame{Type: TypeSignature, Payload: EncodeSignature(weak, strong)}); err != nil {
+				basis.close()
+				return nil, fmt.Errorf("send signature frame: %w: %w", err, apperrors.ErrNetwork)
+			}
+			weak, strong = weak[:0], strong[:0]
+		}
+	}
+	if err := s.writer.Flush(); err != nil {
+		basis.close()
+		return nil, fmt.Errorf("flush signature frames: %w: %w", err, apperrors.ErrNetwork)
+	}
+	return basis, nil
+}
+
+// read returns the basis bytes a COPY frame names.
+func (b *deltaBasis) read(payload []byte) ([]byte, error) {
+	offset, length, err := DecodeCopy(payload)
+	if err != nil {
+		return nil, err
+	}
+	if offset > b.size || uint64(length) > b.size-offset {
+		return nil, fmt.Errorf("copy of %d bytes at %d is outside the %d byte basis: %w", length, offset, b.size, apperrors.ErrInvalidProtocol)
+	}
+	if b.buf == nil {
+		b.buf = make([]byte, MaxChunkSize)
+	}
+	if _, err := b.file.ReadAt(b.buf[:length], int64(offset)); err != nil {
+		return nil, fmt.Errorf("read basis file: %w: %w", err, apperrors.ErrIO)
+	}
+	b.reused += uint64(length)
+	return b.buf[:length], nil
+}
+
+func (b *deltaBasis) close() {
+	if b != nil && b.file != nil {
+		_ = b.file.Close()
+	}
+}
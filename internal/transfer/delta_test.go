@@ -0,0 +1,141 @@
+package transfer
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	apperrors "snapsync/internal/errors"
+	"snapsync/internal/hash"
+)
+
+// signBytes builds the signature a receiver would send for basis.
+func signBytes(basis []byte, alg hash.Algorithm) *deltaSignature {
+	header := DeltaHeader{BlockSize: deltaBlockSize(uint64(len(basis))), BasisSize: uint64(len(basis)), StrongLen: deltaStrongSize(alg)}
+	sig := &deltaSignature{header: header, alg: alg, table: map[uint32][]int{}}
+	bs := int(header.BlockSize)
+	for i := 0; i < header.BlockCount(); i++ {
+		block := basis[i*bs : (i+1)*bs]
+		weak := newRollingSum(block).sum()
+		sig.table[weak] = append(sig.table[weak], i)
+		sig.strong = append(sig.strong, strongSum(alg, block, header.StrongLen))
+	}
+	return sig
+}
+
+func TestDeltaDiffRebuildsEditedFile(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	basis := make([]byte, 3<<20)
+	rng.Read(basis)
+	edited := append([]byte{}, basis[:1<<20]...)
+	edited = append(edited, []byte("inserted bytes shift everything after them")...)
+	edited = append(edited, basis[1<<20:2<<20]...)
+	edited = append(edited, bytes.Repeat([]byte{0xEE}, 5000)...)
+	edited = append(edited, basis[2<<20+5000:3<<20-777]...)
+
+	sig := signBytes(basis, hash.BLAKE3)
+	var rebuilt []byte
+	var reused int
+	err := sig.diff(bytes.NewReader(edited), func(data []byte) error {
+		if len(data) > MaxChunkSize {
+			t.Fatalf("literal run of %d bytes exceeds a chunk", len(data))
+		}
+		rebuilt = append(rebuilt, data...)
+		return nil
+	}, func(offset uint64, data []byte) error {
+		if !bytes.Equal(basis[offset:offset+uint64(len(data))], data) {
+			t.Fatalf("copy at %d does not match the basis", offset)
+		}
+		rebuilt = append(rebuilt, data...)
+		reused += len(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("diff() error = %v", err)
+	}
+	if !bytes.Equal(rebuilt, edited) {
+		t.Fatal("rebuilt file differs from the edited source")
+	}
+	if reused < len(edited)*9/10 {
+		t.Fatalf("expected most of the file reused, got %d of %d bytes", reused, len(edited))
+	}
+}
+
+func TestDeltaTransferReusesExistingFile(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	data := make([]byte, 9<<20+321)
+	rng.Read(data)
+	srcPath := filepath.Join(t.TempDir(), "disk.img")
+	if err := os.WriteFile(srcPath, data, 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	dstDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dstDir, "disk.img"), data, 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	copy(data[5<<20:], "a few changed blocks")
+	copy(data[8<<20:], bytes.Repeat([]byte{0}, 100000))
+	if err := os.WriteFile(srcPath, data, 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	for _, compress := range []bool{false, true} {
+		var sendOut, recvOut bytes.Buffer
+		addr, done := startReceiveOnce(t, ReceiverOptions{OutDir: dstDir, AutoAccept: true, Overwrite: true, Resume: true, Out: &recvOut})
+		sendErr := Send(SenderOptions{Path: srcPath, Address: addr, Resume: true, Delta: true, Compress: compress, Out: &sendOut})
+		if recvErr := <-done; sendErr != nil || recvErr != nil {
+			t.Fatalf("send err=%v recv err=%v", sendErr, recvErr)
+		}
+		if got, _ := os.ReadFile(filepath.Join(dstDir, "disk.img")); !bytes.Equal(got, data) {
+			t.Fatal("content mismatch after delta transfer")
+		}
+		if !strings.Contains(sendOut.String(), "Delta reused") || !strings.Contains(recvOut.String(), "Reused ") {
+			t.Fatalf("expected delta reports, sender %q receiver %q", sendOut.String(), recvOut.String())
+		}
+	}
+}
+
+func TestDeltaWithoutBasisSendsLiterals(t *testing.T) {
+	srcPath, srcData := stripeSource(t, 2)
+	dstDir := t.TempDir()
+	var sendOut bytes.Buffer
+	addr, done := startReceiveOnce(t, ReceiverOptions{OutDir: dstDir, AutoAccept: true, Out: ioDiscard{}})
+	sendErr := Send(SenderOptions{Path: srcPath, Address: addr, Delta: true, Out: &sendOut})
+	if recvErr := <-done; sendErr != nil || recvErr != nil {
+		t.Fatalf("send err=%v recv err=%v", sendErr, recvErr)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dstDir, "striped.bin")); !bytes.Equal(got, srcData) {
+		t.Fatal("content mismatch")
+	}
+	if !strings.Contains(sendOut.String(), "Delta reused 0 of") {
+		t.Fatalf("expected no reuse without a basis, got %q", sendOut.String())
+	}
+}
+
+func TestRequestSignatureSurvivesHugeBasisSize(t *testing.T) {
+	sender, receiver := net.Pipe()
+	defer func() { _ = sender.Close() }()
+	go func() {
+		defer func() { _ = receiver.Close() }()
+		r, w := bufio.NewReader(receiver), bufio.NewWriter(receiver)
+		if _, err := ReadFrame(r); err != nil {
+			return
+		}
+		// A hostile receiver announces far more blocks than it will ever sign.
+		header := DeltaHeader{BlockSize: minDeltaBlock, BasisSize: math.MaxUint64, StrongLen: deltaStrongSize(hash.BLAKE3)}
+		_ = WriteFrame(w, Frame{Type: TypeDelta, Payload: EncodeDeltaHeader(header)})
+		_ = WriteFrame(w, Frame{Type: TypeDone})
+		_ = w.Flush()
+	}()
+	_, err := requestSignature(bufio.NewReader(sender), bufio.NewWriter(sender), hash.BLAKE3)
+	if !errors.Is(err, apperrors.ErrInvalidProtocol) {
+		t.Fatalf("expected protocol error, got %v", err)
+	}
+}
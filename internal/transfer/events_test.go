@@ -0,0 +1,140 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	apperrors "snapsync/internal/errors"
+	"snapsync/internal/progress"
+)
+
+// decodeEvents parses an NDJSON event stream.
+func decodeEvents(t *testing.T, data []byte) []progress.Event {
+	t.Helper()
+	var events []progress.Event
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for dec.More() {
+		var e progress.Event
+		if err := dec.Decode(&e); err != nil {
+			t.Fatalf("decode event: %v", err)
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+// recorder collects the events an observer sees.
+type recorder struct {
+	mu     sync.Mutex
+	events []progress.Event
+}
+
+func (r *recorder) Observe(e progress.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+}
+
+// eventTypes lists the types of events, collapsing runs of progress events.
+func eventTypes(events []progress.Event) string {
+	var types []string
+	for _, e := range events {
+		if e.Type == progress.EventProgress && len(types) > 0 && types[len(types)-1] == e.Type {
+			continue
+		}
+		types = append(types, e.Type)
+	}
+	return strings.Join(types, ",")
+}
+
+func TestObserverEventsFollowTransfer(t *testing.T) {
+	srcPath, _ := stripeSource(t, 2)
+	outDir := t.TempDir()
+	var recvEvents, sendOut bytes.Buffer
+	var rec recorder
+	addr, done := startReceiver(t, ReceiverOptions{OutDir: outDir, AutoAccept: true, Out: ioDiscard{}, Observer: progress.NewJSONStream(&recvEvents)})
+	if err := Send(SenderOptions{Path: srcPath, Address: addr, Out: &sendOut, Observer: &rec}); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("receiver error = %v", err)
+	}
+	if strings.Contains(sendOut.String(), "inst:") {
+		t.Fatalf("progress text written despite an observer: %q", sendOut.String())
+	}
+
+	sent, received := rec.events, decodeEvents(t, recvEvents.Bytes())
+	const want = "offer,accepted,progress,verified,done"
+	if got := eventTypes(sent); got != want {
+		t.Fatalf("sender events = %s, want %s", got, want)
+	}
+	if got := eventTypes(received); got != want {
+		t.Fatalf("receiver events = %s, want %s", got, want)
+	}
+	info, _ := os.Stat(srcPath)
+	last := received[len(received)-1]
+	if last.Direction != "receiving" || last.Name != "striped.bin" || last.Bytes != uint64(info.Size()) || last.OutputPath != filepath.Join(outDir, "striped.bin") {
+		t.Fatalf("unexpected done event %+v", last)
+	}
+	sentDigest, recvDigest := sent[len(sent)-2], received[len(received)-2]
+	if sentDigest.Digest == "" || sentDigest.Digest != recvDigest.Digest || sentDigest.Algorithm != recvDigest.Algorithm {
+		t.Fatalf("verified events disagree: %+v and %+v", sentDigest, recvDigest)
+	}
+}
+
+func TestShareEventsNameEachPullingPeer(t *testing.T) {
+	srcPath, _ := stripeSource(t, 2)
+	prev := senderChunkMutator
+	senderChunkMutator = func(chunk []byte) {
+		if chunk[0] == 1 {
+			chunk[0] ^= 0xFF
+		}
+	}
+	defer func() { senderChunkMutator = prev }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var rec recorder
+	addr, done := startShare(t, ctx, ShareOptions{Paths: []string{srcPath}, AcceptAll: true, Out: ioDiscard{}, Observer: &rec})
+	opts := GetOptions{Address: addr, OutDir: t.TempDir(), Out: ioDiscard{}}
+	if err := Get(opts, "striped.bin"); !errors.Is(err, apperrors.ErrIntegrity) {
+		t.Fatalf("expected the corrupted pull to fail, got %v", err)
+	}
+	senderChunkMutator = prev
+	if err := Get(opts, "striped.bin"); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Share() error = %v", err)
+	}
+
+	// Each pull's rows are keyed by its own peer, and the failed pull's error event
+	// names that peer so its row is cleared.
+	failed := rec.events[0].Peer
+	var last string
+	var failures int
+	for _, e := range rec.events {
+		if e.Direction != "sending" || e.Peer == "" {
+			t.Fatalf("event without a sending peer: %+v", e)
+		}
+		if e.Type == progress.EventError {
+			failures++
+			if e.Peer != failed {
+				t.Fatalf("error event names %q, want the failed pull %q", e.Peer, failed)
+			}
+			continue
+		}
+		last = e.Peer
+	}
+	if failures != 1 || last == failed {
+		t.Fatalf("expected one error event and a second pull with its own peer, got %d errors, peers %q and %q", failures, failed, last)
+	}
+}
@@ -0,0 +1,469 @@
+package transfer
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+
+	apperrors "snapsync/internal/errors"
+	"snapsync/internal/hash"
+	"snapsync/internal/progress"
+	"snapsync/internal/throttle"
+)
+
+// fanoutQueue is how many chunks a slow receiver may lag behind the source read
+// before it holds back the others.
+const fanoutQueue = 8
+
+// FanoutTarget is one receiver of a fan-out send.
+type FanoutTarget struct {
+	// Label names the receiver in messages and results, such as its peer id.
+	Label      string
+	Address    string
+	VerifyPeer func(PeerIdentity) error
+}
+
+// FanoutResult is the outcome of a fan-out send for one target.
+type FanoutResult struct {
+	Label   string
+	Address string
+	// Sent counts the file bytes written to this receiver, excluding resumed prefixes.
+	Sent uint64
+	Err  error
+}
+
+// fanoutPeer is the connection to one fan-out target.
+type fanoutPeer struct {
+	target FanoutTarget
+	conn   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+	agreed HelloPayload
+	out    io.Writer
+	// offset is where the receiver resumes the file being sent.
+	offset uint64
+	sent   uint64
+	err    error
+}
+
+// fail records the first error for the peer and drops its connection.
+func (p *fanoutPeer) fail(err error) {
+	if p.err != nil {
+		return
+	}
+	p.err = err
+	if p.conn != nil {
+		_ = p.conn.Close()
+	}
+}
+
+// SendFanout sends one file or directory to every target at once. The source is
+// read once and each chunk is shared by the per-receiver connections, which keep
+// their own resume offsets, digests, and compression. A receiver that fails is
+// dropped without stopping the others. The error reports problems with the source
+// itself; per-receiver failures are in the results.
+func SendFanout(opts SenderOptions, targets []FanoutTarget) ([]FanoutResult, error) {
+	if opts.Path == "" || len(targets) == 0 {
+		return nil, fmt.Errorf("missing required sender options: %w", apperrors.ErrUsage)
+	}
+	if opts.Path == StdinPath || opts.Code != "" || opts.Streams > 1 || opts.Delta {
+		return nil, fmt.Errorf("fan-out sends cannot stream stdin, pair, stripe, or use deltas: %w", apperrors.ErrUsage)
+	}
+	if opts.Out == nil {
+		opts.Out = io.Discard
+	}
+	entries, isDir, err := collectSources(opts.Path, opts.OverrideName, opts.Out)
+	if err != nil {
+		return nil, err
+	}
+	sessionID, err := loadOrCreateSessionID(opts.Path)
+	if err != nil {
+		return nil, fmt.Errorf("prepare session id: %w", err)
+	}
+
+	out := &lockedWriter{w: opts.Out}
+	opts.Out = out
+	if opts.Observer == nil {
+		opts.Observer = progress.NewPlain(out)
+	}
+	peers := make([]*fanoutPeer, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		p := &fanoutPeer{target: target, out: &prefixWriter{w: out, prefix: "[" + target.Label + "] ", start: true}}
+		peers[i] = p
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dial := opts
+			dial.Address, dial.VerifyPeer, dial.Out = target.Address, target.VerifyPeer, p.out
+			conn, reader, writer, agreed, err := dialReceiver(dial, p.out)
+			if err != nil {
+				p.fail(err)
+				return
+			}
+			p.conn, p.reader, p.writer, p.agreed = conn, reader, writer, agreed
+			if opts.Compress && !agreed.Has(CapCompression) {
+				_, _ = fmt.Fprintln(p.out, "Receiver does not support compression; sending uncompressed.")
+			}
+			if isDir && !agreed.Has(CapMultiFile) {
+				p.fail(fmt.Errorf("receiver does not support directory transfers: %w", apperrors.ErrInvalidProtocol))
+			}
+		}()
+	}
+	wg.Wait()
+	defer func() {
+		for _, p := range peers {
+			if p.conn != nil {
+				_ = p.conn.Close()
+			}
+		}
+	}()
+
+	batch := BatchPayload{Name: sourceName(opts.Path, opts.OverrideName), SessionID: sessionID}
+	if isDir {
+		for _, entry := range entries {
+			if entry.dir {
+				batch.Dirs++
+				continue
+			}
+			batch.Files++
+			batch.TotalBytes += entry.size
+		}
+		eachPeer(peers, func(p *fanoutPeer) error { return sendBatch(p.reader, p.writer, batch) })
+	}
+	for _, entry := range entries {
+		switch {
+		case entry.dir:
+			payload, encErr := EncodeMkdir(entry.name)
+			if encErr != nil {
+				return nil, fmt.Errorf("encode mkdir payload: %w", encErr)
+			}
+			eachPeer(peers, func(p *fanoutPeer) error {
+				if err := WriteFrame(p.writer, Frame{Type: TypeMkdir, Payload: payload}); err != nil {
+					return fmt.Errorf("send mkdir frame: %w: %w", err, apperrors.ErrNetwork)
+				}
+				return nil
+			})
+		case isDir:
+			if err := fanoutFile(peers, entry, entrySessionID(sessionID, entry.name), opts); err != nil {
+				return nil, fmt.Errorf("send %s: %w", entry.name, err)
+			}
+		default:
+			if err := fanoutFile(peers, entry, sessionID, opts); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if isDir {
+		eachPeer(peers, func(p *fanoutPeer) error {
+			if err := p.writer.Flush(); err != nil {
+				return fmt.Errorf("flush directory frames: %w: %w", err, apperrors.ErrNetwork)
+			}
+			_, _ = fmt.Fprintf(p.out, "Directory complete: %d files, %d directories, %d bytes.\n", batch.Files, batch.Dirs, batch.TotalBytes)
+			return nil
+		})
+	}
+
+	results := make([]FanoutResult, len(peers))
+	failed := false
+	for i, p := range peers {
+		results[i] = FanoutResult{Label: p.target.Label, Address: p.target.Address, Sent: p.sent, Err: p.err}
+		failed = failed || p.err != nil
+	}
+	if !failed {
+		_ = os.Remove(sessionPath(opts.Path))
+	}
+	return results, nil
+}
+
+// eachPeer runs step for every peer still alive, concurrently, and fails the
+// peers whose step returns an error.
+func eachPeer(peers []*fanoutPeer, step func(p *fanoutPeer) error) {
+	var wg sync.WaitGroup
+	for _, p := range peers {
+		if p.err != nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := step(p); err != nil {
+				p.fail(err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// fanoutChunk is one read of the source, shared read-only by every stream.
+type fanoutChunk struct {
+	pos  uint64
+	data []byte
+}
+
+// fanoutStream carries one file to one peer.
+type fanoutStream struct {
+	peer      *fanoutPeer
+	offset    uint64
+	alg       hash.Algorithm
+	chunked   bool
+	tree      *hash.Tree
+	comp      *compressor
+	limit     *throttle.Limiter
+	announced int
+	chunks    chan fanoutChunk
+	observer  progress.Observer
+	// abort, set before chunks is closed, means the source failed mid-read.
+	abort error
+}
+
+// fanoutFile offers entry to every live peer, then reads it once from the lowest
+// resume offset and feeds each chunk to the peers that still need it.
+func fanoutFile(peers []*fanoutPeer, entry sourceEntry, sessionID string, opts SenderOptions) error {
+	eachPeer(peers, func(p *fanoutPeer) error {
+		offer := OfferPayload{Name: entry.name, Size: entry.size, SessionID: sessionID}
+		if p.agreed.Has(CapMetadata) {
+			offer.Attrs = entry.attrs
+		}
+		opts.Observer.Observe(progress.Event{Type: progress.EventOffer, Direction: "sending", Name: entry.name, Peer: p.target.Label, Total: entry.size})
+		offset, err := sendOffer(p.reader, p.writer, offer)
+		if err != nil {
+			return err
+		}
+		if !opts.Resume {
+			offset = 0
+		}
+		if offset > entry.size {
+			return fmt.Errorf("receiver resume offset %d exceeds file size %d: %w", offset, entry.size, apperrors.ErrInvalidProtocol)
+		}
+		if p.agreed.Has(CapChunkDigests) && offset%IntegrityBlockSize != 0 {
+			return fmt.Errorf("receiver resume offset %d is not block aligned: %w", offset, apperrors.ErrInvalidProtocol)
+		}
+		p.offset = offset
+		opts.Observer.Observe(progress.Event{Type: progress.EventAccepted, Direction: "sending", Name: entry.name, Peer: p.target.Label, Total: entry.size, Offset: offset})
+		return nil
+	})
+
+	file, err := os.Open(entry.path)
+	if err != nil {
+		return fmt.Errorf("open source file: %w: %w", err, apperrors.ErrIO)
+	}
+	defer func() { _ = file.Close() }()
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("stat source file: %w: %w", err, apperrors.ErrIO)
+	}
+
+	// Hashing a resumed prefix can take a while, so ping the peers waiting for data.
+	pings := map[*fanoutPeer]func() error{}
+	for _, p := range peers {
+		if p.err == nil {
+			pings[p] = startKeepalive(p.reader, p.writer, p.agreed)
+		}
+	}
+	var prepared []*fanoutStream
+	var prepErr error
+	for _, p := range peers {
+		if p.err != nil {
+			continue
+		}
+		s, err := newFanoutStream(p, file, info, entry, opts)
+		if err != nil {
+			prepErr = err
+			break
+		}
+		prepared = append(prepared, s)
+	}
+	for p, stop := range pings {
+		if err := stop(); err != nil {
+			p.fail(err)
+		}
+	}
+	if prepErr != nil {
+		return prepErr
+	}
+	var streams []*fanoutStream
+	start := entry.size
+	for _, s := range prepared {
+		if s.peer.err == nil {
+			streams = append(streams, s)
+			start = min(start, s.offset)
+		}
+	}
+	if len(streams) == 0 {
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	for _, s := range streams {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.run(entry)
+		}()
+	}
+	if _, err := file.Seek(int64(start), io.SeekStart); err != nil {
+		err = fmt.Errorf("seek source file: %w: %w", err, apperrors.ErrIO)
+		for _, s := range streams {
+			s.abort = err
+			close(s.chunks)
+		}
+		wg.Wait()
+		return err
+	}
+	reporter := progress.NewReporter(opts.Observer, progress.Event{Direction: "sending", Name: entry.name, Total: entry.size})
+	pos := start
+	var readErr error
+	for {
+		if interrupted(opts.Cancel) {
+			readErr = fmt.Errorf("interrupted: %w", apperrors.ErrCancelled)
+			break
+		}
+		// Each chunk gets its own buffer because streams consume it at their own pace.
+		buf := make([]byte, MaxChunkSize)
+		n, err := io.ReadFull(file, buf)
+		if n > 0 {
+			for _, s := range streams {
+				s.chunks <- fanoutChunk{pos: pos, data: buf[:n]}
+			}
+			pos += uint64(n)
+			reporter.Update(pos)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			readErr = fmt.Errorf("read source file: %w: %w", err, apperrors.ErrIO)
+			break
+		}
+	}
+	for _, s := range streams {
+		s.abort = readErr
+		close(s.chunks)
+	}
+	wg.Wait()
+	if readErr != nil {
+		return readErr
+	}
+	reporter.Done(pos, entry.name)
+	return nil
+}
+
+// newFanoutStream prepares the digest state of one peer at its resume offset.
+func newFanoutStream(p *fanoutPeer, file *os.File, info os.FileInfo, entry sourceEntry, opts SenderOptions) (*fanoutStream, error) {
+	offset := p.offset
+	s := &fanoutStream{peer: p, offset: offset, alg: hash.Algorithm(p.agreed.Hashes[0]), chunked: p.agreed.Has(CapChunkDigests), chunks: make(chan fanoutChunk, fanoutQueue), observer: opts.Observer, limit: opts.Limit}
+	var blockSize uint64
+	var prefix [][]byte
+	if s.chunked {
+		blockSize = IntegrityBlockSize
+		prefix = openChunkCache(entry.path, info, s.alg).prefix(int(offset / IntegrityBlockSize))
+	}
+	tree, err := hash.NewTree(s.alg, blockSize, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("create sender hasher: %w", err)
+	}
+	if prefix == nil && offset > 0 {
+		if err := hashPrefix(file, offset, tree); err != nil {
+			return nil, err
+		}
+	}
+	if offset > 0 {
+		_, _ = fmt.Fprintf(p.out, "Resuming at offset %d (%.2f%%)\n", offset, (float64(offset)/float64(entry.size))*100)
+		opts.Observer.Observe(progress.Event{Type: progress.EventResume, Direction: "sending", Name: entry.name, Peer: p.target.Label, Total: entry.size, Offset: offset})
+	}
+	s.tree = tree
+	s.announced = len(tree.Leaves())
+	s.comp = newWireCompressor(opts, p.agreed)
+	return s, nil
+}
+
+// run writes the chunks past the stream's offset to its peer and finishes the file
+// with DONE. After a failure it keeps draining so the source read never blocks.
+func (s *fanoutStream) run(entry sourceEntry) {
+	p := s.peer
+	for c := range s.chunks {
+		if p.err != nil || c.pos+uint64(len(c.data)) <= s.offset {
+			continue
+		}
+		chunk := c.data[s.offset-min(s.offset, c.pos):]
+		if _, err := s.tree.Write(chunk); err != nil {
+			p.fail(fmt.Errorf("hash source chunk: %w", err))
+			continue
+		}
+		if senderChunkMutator != nil {
+			mut := append([]byte{}, chunk...)
+			senderChunkMutator(mut)
+			chunk = mut
+		}
+		if err := writePacedFrame(p.writer, s.comp.frame(chunk), s.limit); err != nil {
+			p.fail(peerFailure(p.reader, fmt.Errorf("send data frame: %w: %w", err, apperrors.ErrNetwork)))
+			continue
+		}
+		p.sent += uint64(len(chunk))
+		if s.chunked {
+			next, err := sendChunkDigests(p.reader, p.writer, s.tree.Leaves(), s.announced)
+			s.announced = next
+			if err != nil {
+				p.fail(err)
+			}
+		}
+	}
+	switch {
+	case s.abort != nil && p.err == nil && errors.Is(s.abort, apperrors.ErrCancelled):
+		p.fail(stopForUser(p.writer, p.agreed))
+	case s.abort != nil:
+		p.fail(s.abort)
+	}
+	if p.err != nil {
+		return
+	}
+	s.tree.Finish()
+	if s.chunked {
+		if _, err := sendChunkDigests(p.reader, p.writer, s.tree.Leaves(), s.announced); err != nil {
+			p.fail(err)
+			return
+		}
+	}
+	digest := s.tree.Root()
+	if err := sendDone(p.reader, p.writer, p.agreed, s.alg, digest); err != nil {
+		p.fail(err)
+		return
+	}
+	e := verifiedEvent("sending", entry.name, s.alg, digest)
+	e.Peer = p.target.Label
+	s.observer.Observe(e)
+	s.comp.report(p.out)
+	_, _ = fmt.Fprintf(p.out, "%s verified, %s: %x\n", entry.name, s.alg, digest)
+}
+
+// prefixWriter starts every output line with prefix, so messages from concurrent
+// receivers stay attributable.
+type prefixWriter struct {
+	w      io.Writer
+	prefix string
+	start  bool
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	var buf bytes.Buffer
+	for _, line := range bytes.SplitAfter(b, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		if p.start {
+			buf.WriteString(p.prefix)
+		}
+		buf.Write(line)
+		p.start = line[len(line)-1] == '\n'
+	}
+	if _, err := p.w.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,111 @@
+package transfer
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	apperrors "snapsync/internal/errors"
+)
+
+func TestFanoutResumesEachReceiverIndependently(t *testing.T) {
+	srcPath, srcData := stripeSource(t, 9)
+	fresh, resumed := t.TempDir(), t.TempDir()
+
+	// Leave the second receiver with one verified block from a corrupted attempt.
+	prev := senderChunkMutator
+	senderChunkMutator = func(chunk []byte) {
+		if chunk[0] == 5 {
+			chunk[0] ^= 0xFF
+		}
+	}
+	addr, done := startReceiver(t, ReceiverOptions{OutDir: resumed, AutoAccept: true, Resume: true, Out: ioDiscard{}})
+	if err := Send(SenderOptions{Path: srcPath, Address: addr, Resume: true, Out: ioDiscard{}}); !errors.Is(err, apperrors.ErrIntegrity) {
+		t.Fatalf("expected corrupted send to fail, got %v", err)
+	}
+	<-done
+	senderChunkMutator = prev
+
+	addrA, doneA := startReceiver(t, ReceiverOptions{OutDir: fresh, AutoAccept: true, Resume: true, Out: ioDiscard{}})
+	addrB, doneB := startReceiver(t, ReceiverOptions{OutDir: resumed, AutoAccept: true, Resume: true, Out: ioDiscard{}})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	deadAddr := ln.Addr().String()
+	_ = ln.Close()
+
+	out := &bytes.Buffer{}
+	results, err := SendFanout(SenderOptions{Path: srcPath, Resume: true, Out: out}, []FanoutTarget{
+		{Label: "a", Address: addrA}, {Label: "b", Address: addrB}, {Label: "gone", Address: deadAddr},
+	})
+	if err != nil {
+		t.Fatalf("SendFanout() error = %v", err)
+	}
+	if errA, errB := <-doneA, <-doneB; errA != nil || errB != nil {
+		t.Fatalf("receiver errors a=%v b=%v", errA, errB)
+	}
+	if results[0].Err != nil || results[0].Sent != uint64(len(srcData)) {
+		t.Fatalf("unexpected result for fresh receiver: %+v", results[0])
+	}
+	if results[1].Err != nil || results[1].Sent != uint64(len(srcData))-IntegrityBlockSize {
+		t.Fatalf("unexpected result for resumed receiver: %+v", results[1])
+	}
+	if !errors.Is(results[2].Err, apperrors.ErrNetwork) {
+		t.Fatalf("expected unreachable receiver to fail, got %+v", results[2])
+	}
+	for _, dir := range []string{fresh, resumed} {
+		if got, _ := os.ReadFile(filepath.Join(dir, "striped.bin")); !bytes.Equal(got, srcData) {
+			t.Fatalf("content mismatch in %s", dir)
+		}
+	}
+	if !strings.Contains(out.String(), "[b] Resuming at offset 4194304") {
+		t.Fatalf("expected per-receiver resume message, got %q", out.String())
+	}
+}
+
+func TestFanoutDirectory(t *testing.T) {
+	srcDir := filepath.Join(t.TempDir(), "release")
+	if err := os.MkdirAll(filepath.Join(srcDir, "bin"), 0o755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(srcDir, "logs"), 0o755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "bin", "tool"), []byte("binary"), 0o755); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	var targets []FanoutTarget
+	var dirs []string
+	var dones []<-chan error
+	for _, label := range []string{"a", "b", "c"} {
+		dir := t.TempDir()
+		addr, done := startReceiver(t, ReceiverOptions{OutDir: dir, AutoAccept: true, Out: ioDiscard{}})
+		targets = append(targets, FanoutTarget{Label: label, Address: addr})
+		dirs = append(dirs, dir)
+		dones = append(dones, done)
+	}
+	results, err := SendFanout(SenderOptions{Path: srcDir, Compress: true, Out: ioDiscard{}}, targets)
+	if err != nil {
+		t.Fatalf("SendFanout() error = %v", err)
+	}
+	for i, r := range results {
+		if r.Err != nil {
+			t.Fatalf("result %d error = %v", i, r.Err)
+		}
+		if err := <-dones[i]; err != nil {
+			t.Fatalf("receiver %d error = %v", i, err)
+		}
+		if got, err := os.ReadFile(filepath.Join(dirs[i], "release", "bin", "tool")); err != nil || string(got) != "binary" {
+			t.Fatalf("receiver %d file = %q, %v", i, got, err)
+		}
+		if info, err := os.Stat(filepath.Join(dirs[i], "release", "logs")); err != nil || !info.IsDir() {
+			t.Fatalf("receiver %d missing empty directory: %v", i, err)
+		}
+	}
+}
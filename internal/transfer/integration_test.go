@@ -2,6 +2,9 @@ package transfer
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -9,7 +12,12 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
+	apperrors "snapsync/internal/errors"
+	"snapsync/internal/hash"
+	"snapsync/internal/identity"
+	"snapsync/internal/policy"
 	"snapsync/internal/resume"
 )
 
@@ -50,6 +58,293 @@ func TestSendReceiveIntegritySuccess(t *testing.T) {
 	}
 }
 
+func TestSendDirectoryRecreatesTree(t *testing.T) {
+	srcRoot := filepath.Join(t.TempDir(), "project")
+	dstDir := t.TempDir()
+	files := map[string][]byte{
+		"readme.txt":           []byte("hello"),
+		"src/main.go":          bytes.Repeat([]byte("package main\n"), 1024*100),
+		"src/nested/empty.bin": {},
+	}
+	for rel, data := range files {
+		p := filepath.Join(srcRoot, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatalf("MkdirAll() error = %v", err)
+		}
+		if err := os.WriteFile(p, data, 0o644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(srcRoot, "docs", "drafts"), 0o755); err != nil {
+		t.Fatalf("MkdirAll(empty) error = %v", err)
+	}
+
+	listenAddr, done := startReceiver(t, ReceiverOptions{OutDir: dstDir, AutoAccept: true, Resume: true, Out: ioDiscard{}})
+	sendErr := Send(SenderOptions{Path: srcRoot, Address: listenAddr, Resume: true, Out: ioDiscard{}})
+	recvErr := <-done
+	if sendErr != nil {
+		t.Fatalf("Send() error = %v", sendErr)
+	}
+	if recvErr != nil {
+		t.Fatalf("receiver error = %v", recvErr)
+	}
+	for rel, want := range files {
+		got, err := os.ReadFile(filepath.Join(dstDir, "project", filepath.FromSlash(rel)))
+		if err != nil {
+			t.Fatalf("ReadFile(%s) error = %v", rel, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("content mismatch for %s", rel)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(dstDir, "project", "docs", "drafts")); err != nil || !info.IsDir() {
+		t.Fatalf("expected empty directory recreated, err=%v", err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dstDir, "project", "src", "*.partial*")); len(matches) != 0 {
+		t.Fatalf("expected no partial artifacts, got %v", matches)
+	}
+}
+
+func TestEncryptedTransferWithPeerVerification(t *testing.T) {
+	srcPath := filepath.Join(t.TempDir(), "secret.bin")
+	dstDir := t.TempDir()
+	srcData := bytes.Repeat([]byte("classified"), 100000)
+	if err := os.WriteFile(srcPath, srcData, 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	senderID, _ := identity.Generate("sender")
+	receiverID, _ := identity.Generate("receiver")
+
+	var seenSender PeerIdentity
+	listenAddr, done := startReceiver(t, ReceiverOptions{OutDir: dstDir, AutoAccept: true, Resume: true, Out: ioDiscard{}, Identity: &receiverID, RequireEncryption: true,
+		VerifyPeer: func(p PeerIdentity) error { seenSender = p; return nil }})
+	sendErr := Send(SenderOptions{Path: srcPath, Address: listenAddr, Resume: true, Out: ioDiscard{}, Identity: &senderID,
+		VerifyPeer: func(p PeerIdentity) error {
+			if p.Fingerprint != receiverID.Fingerprint() {
+				return fmt.Errorf("unexpected receiver fingerprint %s", p.Fingerprint)
+			}
+			return nil
+		}})
+	recvErr := <-done
+	if sendErr != nil || recvErr != nil {
+		t.Fatalf("send err=%v recv err=%v", sendErr, recvErr)
+	}
+	if seenSender.Fingerprint != senderID.Fingerprint() {
+		t.Fatalf("receiver saw fingerprint %s want %s", seenSender.Fingerprint, senderID.Fingerprint())
+	}
+	got, _ := os.ReadFile(filepath.Join(dstDir, "secret.bin"))
+	if !bytes.Equal(got, srcData) {
+		t.Fatal("content mismatch")
+	}
+}
+
+func TestReceiverRefusesPlaintextOrUnauthorizedPeers(t *testing.T) {
+	srcPath := filepath.Join(t.TempDir(), "x.bin")
+	if err := os.WriteFile(srcPath, []byte("payload"), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	receiverID, _ := identity.Generate("receiver")
+	senderID, _ := identity.Generate("sender")
+
+	listenAddr, done := startReceiver(t, ReceiverOptions{OutDir: t.TempDir(), AutoAccept: true, Out: ioDiscard{}, Identity: &receiverID, RequireEncryption: true})
+	sendErr := Send(SenderOptions{Path: srcPath, Address: listenAddr, Out: ioDiscard{}})
+	if recvErr := <-done; !errors.Is(recvErr, apperrors.ErrAuth) {
+		t.Fatalf("expected receiver auth error for plaintext sender, got %v", recvErr)
+	}
+	if sendErr == nil {
+		t.Fatal("expected plaintext sender to fail")
+	}
+
+	listenAddr, done = startReceiver(t, ReceiverOptions{OutDir: t.TempDir(), AutoAccept: true, Out: ioDiscard{}, Identity: &receiverID,
+		VerifyPeer: func(PeerIdentity) error { return fmt.Errorf("not trusted") }})
+	sendErr = Send(SenderOptions{Path: srcPath, Address: listenAddr, Out: ioDiscard{}, Identity: &senderID})
+	if recvErr := <-done; !errors.Is(recvErr, apperrors.ErrAuth) {
+		t.Fatalf("expected receiver auth error for untrusted sender, got %v", recvErr)
+	}
+	if sendErr == nil {
+		t.Fatal("expected untrusted sender to fail")
+	}
+}
+
+func TestTrustPolicyAcceptsTrustedAndRejectsUntrusted(t *testing.T) {
+	srcPath := filepath.Join(t.TempDir(), "trusted.bin")
+	if err := os.WriteFile(srcPath, []byte("trusted payload"), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	receiverID, _ := identity.Generate("receiver")
+	trustedID, _ := identity.Generate("friend")
+	strangerID, _ := identity.Generate("stranger")
+	trust := func(p PeerIdentity) (bool, error) { return p.Fingerprint == trustedID.Fingerprint(), nil }
+	opts := ReceiverOptions{OutDir: t.TempDir(), Resume: true, Out: ioDiscard{}, Identity: &receiverID, Trust: trust, RejectUntrusted: true,
+		Prompt: func(string, uint64, string) (bool, error) {
+			t.Fatal("trusted or rejected peers must not be prompted")
+			return false, nil
+		}}
+
+	listenAddr, done := startReceiver(t, opts)
+	if err := Send(SenderOptions{Path: srcPath, Address: listenAddr, Out: ioDiscard{}, Identity: &trustedID}); err != nil {
+		t.Fatalf("Send(trusted) error = %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("receiver error for trusted peer = %v", err)
+	}
+
+	listenAddr, done = startReceiver(t, opts)
+	sendErr := Send(SenderOptions{Path: srcPath, Address: listenAddr, Out: ioDiscard{}, Identity: &strangerID})
+	if !errors.Is(sendErr, apperrors.ErrRejected) || apperrors.ExitCode(sendErr) != 5 {
+		t.Fatalf("expected untrusted sender rejection with exit code 5, got %v", sendErr)
+	}
+	if err := <-done; !errors.Is(err, apperrors.ErrRejected) {
+		t.Fatalf("expected receiver rejection, got %v", err)
+	}
+
+	// Auto-accept does not override the untrusted reject policy.
+	opts.AutoAccept = true
+	listenAddr, done = startReceiver(t, opts)
+	if err := Send(SenderOptions{Path: srcPath, Address: listenAddr, Out: ioDiscard{}, Identity: &strangerID}); !errors.Is(err, apperrors.ErrRejected) {
+		t.Fatalf("expected untrusted sender rejection with auto-accept, got %v", err)
+	}
+	if err := <-done; !errors.Is(err, apperrors.ErrRejected) {
+		t.Fatalf("expected receiver rejection with auto-accept, got %v", err)
+	}
+}
+
+func TestReceivePolicyRefusesAndRoutesByPeer(t *testing.T) {
+	srcDir := t.TempDir()
+	allowed := filepath.Join(srcDir, "notes.txt")
+	denied := filepath.Join(srcDir, "setup.exe")
+	for _, path := range []string{allowed, denied} {
+		if err := os.WriteFile(path, []byte("policy payload"), 0o644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+	receiverID, _ := identity.Generate("receiver")
+	senderID, _ := identity.Generate("friend")
+	rules, err := policy.New(policy.Rules{DenyExtensions: []string{"exe"}, PeerDirs: map[string]string{"friend": "from-friend"}})
+	if err != nil {
+		t.Fatalf("policy.New() error = %v", err)
+	}
+	outDir := t.TempDir()
+	// The trust store pins "friend" to its key; only then does the ID route files.
+	trust := func(p PeerIdentity) (bool, error) { return p.Fingerprint == senderID.Fingerprint(), nil }
+	opts := ReceiverOptions{OutDir: outDir, AutoAccept: true, Resume: true, Out: ioDiscard{}, Identity: &receiverID, Policy: rules, Trust: trust}
+
+	listenAddr, done := startReceiver(t, opts)
+	sendErr := Send(SenderOptions{Path: denied, Address: listenAddr, Out: ioDiscard{}, Identity: &senderID})
+	if !errors.Is(sendErr, apperrors.ErrPolicy) || !strings.Contains(sendErr.Error(), `denied extension ".exe"`) {
+		t.Fatalf("expected explained policy refusal, got %v", sendErr)
+	}
+	if err := <-done; !errors.Is(err, apperrors.ErrPolicy) {
+		t.Fatalf("expected receiver policy refusal, got %v", err)
+	}
+
+	listenAddr, done = startReceiver(t, opts)
+	if err := Send(SenderOptions{Path: allowed, Address: listenAddr, Out: ioDiscard{}, Identity: &senderID}); err != nil {
+		t.Fatalf("Send(allowed) error = %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("receiver error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "from-friend", "notes.txt")); err != nil {
+		t.Fatalf("expected file in the peer's subdirectory: %v", err)
+	}
+
+	impostor, _ := identity.Generate("friend")
+	listenAddr, done = startReceiver(t, opts)
+	if err := Send(SenderOptions{Path: allowed, Address: listenAddr, Out: ioDiscard{}, Identity: &impostor}); err != nil {
+		t.Fatalf("Send(impostor) error = %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("receiver error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "notes.txt")); err != nil {
+		t.Fatalf("expected an unverified ID to get no subdirectory: %v", err)
+	}
+}
+
+func TestReceiveHooksVetoAndReportFiles(t *testing.T) {
+	srcDir := t.TempDir()
+	for _, name := range []string{"keep.txt", "veto.txt"} {
+		if err := os.WriteFile(filepath.Join(srcDir, name), []byte("hook payload"), 0o644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+	var offered []OfferedFile
+	var received []ReceivedFile
+	outDir := t.TempDir()
+	var out bytes.Buffer
+	opts := ReceiverOptions{OutDir: outDir, AutoAccept: true, Out: &out,
+		BeforeAccept: func(f OfferedFile) error {
+			offered = append(offered, f)
+			if f.Name == "veto.txt" {
+				return errors.New("ingest queue is full")
+			}
+			return nil
+		},
+		AfterReceive: func(f ReceivedFile) error {
+			received = append(received, f)
+			return errors.New("ingest job failed")
+		}}
+
+	listenAddr, done := startReceiver(t, opts)
+	sendErr := Send(SenderOptions{Path: filepath.Join(srcDir, "veto.txt"), Address: listenAddr, Out: ioDiscard{}})
+	if !errors.Is(sendErr, apperrors.ErrPolicy) || !strings.Contains(sendErr.Error(), "ingest queue is full") {
+		t.Fatalf("expected explained veto, got %v", sendErr)
+	}
+	if err := <-done; !errors.Is(err, apperrors.ErrRejected) {
+		t.Fatalf("expected receiver veto, got %v", err)
+	}
+
+	listenAddr, done = startReceiver(t, opts)
+	if err := Send(SenderOptions{Path: filepath.Join(srcDir, "keep.txt"), Address: listenAddr, Out: ioDiscard{}}); err != nil {
+		t.Fatalf("Send(keep) error = %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("a failed post-receive hook must not fail the transfer: %v", err)
+	}
+	final := filepath.Join(outDir, "keep.txt")
+	if _, err := os.Stat(final); err != nil {
+		t.Fatalf("received file missing after hook failure: %v", err)
+	}
+	if len(offered) != 2 || len(received) != 1 {
+		t.Fatalf("hooks saw %d offers and %d files", len(offered), len(received))
+	}
+	if f := received[0]; f.Path != final || f.Name != "keep.txt" || f.Size != 12 || f.Algorithm != hash.Default || len(f.Digest) != hash.Default.Size() || f.Peer == "" {
+		t.Fatalf("unexpected received file %+v", f)
+	}
+	if !strings.Contains(out.String(), "Post-receive hook for "+final+" failed: ingest job failed") {
+		t.Fatalf("hook failure not reported:\n%s", out.String())
+	}
+}
+
+func TestCodePairingTransferAndWrongCode(t *testing.T) {
+	srcPath := filepath.Join(t.TempDir(), "paired.bin")
+	dstDir := t.TempDir()
+	srcData := bytes.Repeat([]byte("pair"), 50000)
+	if err := os.WriteFile(srcPath, srcData, 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	listenAddr, done := startReceiver(t, ReceiverOptions{OutDir: dstDir, AutoAccept: true, Out: ioDiscard{}, Code: "7-purple-tiger"})
+	sendErr := Send(SenderOptions{Path: srcPath, Address: listenAddr, Out: ioDiscard{}, Code: "7-Purple-Tiger"})
+	if recvErr := <-done; sendErr != nil || recvErr != nil {
+		t.Fatalf("send err=%v recv err=%v", sendErr, recvErr)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dstDir, "paired.bin")); !bytes.Equal(got, srcData) {
+		t.Fatal("content mismatch")
+	}
+
+	listenAddr, done = startReceiver(t, ReceiverOptions{OutDir: t.TempDir(), AutoAccept: true, Out: ioDiscard{}, Code: "7-purple-tiger"})
+	sendErr = Send(SenderOptions{Path: srcPath, Address: listenAddr, Out: ioDiscard{}, Code: "8-purple-tiger"})
+	recvErr := <-done
+	if !errors.Is(sendErr, apperrors.ErrPairing) {
+		t.Fatalf("expected sender pairing error, got %v", sendErr)
+	}
+	if !errors.Is(recvErr, apperrors.ErrPairing) {
+		t.Fatalf("expected receiver pairing error, got %v", recvErr)
+	}
+}
+
 func TestResumeSuccessAfterInterruption(t *testing.T) {
 	srcDir := t.TempDir()
 	dstDir := t.TempDir()
@@ -158,20 +453,21 @@ func TestResumeRejectMismatchState(t *testing.T) {
 	<-done
 }
 
-func TestReceiverDeletesCorruptedFileOnHashMismatch(t *testing.T) {
+func TestReceiverKeepsVerifiedBlocksOnCorruption(t *testing.T) {
 	srcDir := t.TempDir()
 	dstDir := t.TempDir()
 	srcPath := filepath.Join(srcDir, "corrupt.bin")
-	srcData := bytes.Repeat([]byte("abcdef0123456789"), 1024*128) // 2MB
+	srcData := bytes.Repeat([]byte("abcdef0123456789"), 1024*640) // 10MB, three blocks
 	if err := os.WriteFile(srcPath, srcData, 0o644); err != nil {
 		t.Fatalf("WriteFile() error = %v", err)
 	}
 
 	prev := senderChunkMutator
+	chunks := 0
 	senderChunkMutator = func(chunk []byte) {
-		if len(chunk) > 0 {
+		chunks++
+		if chunks == 6 { // inside the second block
 			chunk[0] ^= 0xFF
-			senderChunkMutator = nil
 		}
 	}
 	defer func() { senderChunkMutator = prev }()
@@ -179,18 +475,317 @@ func TestReceiverDeletesCorruptedFileOnHashMismatch(t *testing.T) {
 	listenAddr, done := startReceiver(t, ReceiverOptions{OutDir: dstDir, AutoAccept: true, Resume: true, Out: ioDiscard{}})
 	sendErr := Send(SenderOptions{Path: srcPath, Address: listenAddr, Resume: true, Out: ioDiscard{}})
 	recvErr := <-done
-	if sendErr == nil {
-		t.Fatal("expected sender error due integrity failure")
+	if !errors.Is(sendErr, apperrors.ErrIntegrity) {
+		t.Fatalf("expected sender integrity error, got %v", sendErr)
 	}
-	if recvErr == nil {
-		t.Fatal("expected receiver error due integrity failure")
+	if !errors.Is(recvErr, apperrors.ErrIntegrity) || !strings.Contains(recvErr.Error(), "bytes 4194304-8388608") {
+		t.Fatalf("expected receiver to pinpoint the second block, got %v", recvErr)
 	}
 	paths, _ := resume.ResolvePaths(dstDir, "corrupt.bin", false)
-	if _, statErr := os.Stat(paths.Partial); !os.IsNotExist(statErr) {
-		t.Fatalf("expected corrupted partial removed, stat err=%v", statErr)
+	info, statErr := os.Stat(paths.Partial)
+	if statErr != nil || info.Size() != IntegrityBlockSize {
+		t.Fatalf("expected partial truncated to the verified block, info=%v err=%v", info, statErr)
 	}
-	if _, statErr := os.Stat(paths.Meta); !os.IsNotExist(statErr) {
-		t.Fatalf("expected corrupted meta removed, stat err=%v", statErr)
+	meta, err := resume.LoadMeta(paths.Meta)
+	if err != nil {
+		t.Fatalf("LoadMeta() error = %v", err)
+	}
+	if meta.ReceivedOffset != IntegrityBlockSize || len(meta.Leaves) != 1 {
+		t.Fatalf("unexpected meta after corruption: offset=%d leaves=%d", meta.ReceivedOffset, len(meta.Leaves))
+	}
+
+	senderChunkMutator = prev
+	listenAddr, done = startReceiver(t, ReceiverOptions{OutDir: dstDir, AutoAccept: true, Resume: true, Out: ioDiscard{}})
+	sendOut := &bytes.Buffer{}
+	sendErr = Send(SenderOptions{Path: srcPath, Address: listenAddr, Resume: true, Out: sendOut})
+	if recvErr := <-done; sendErr != nil || recvErr != nil {
+		t.Fatalf("resume send err=%v recv err=%v", sendErr, recvErr)
+	}
+	if !strings.Contains(sendOut.String(), "Resuming at offset 4194304") {
+		t.Fatalf("expected resume from the corrupted block, got %q", sendOut.String())
+	}
+	if got, _ := os.ReadFile(paths.Final); !bytes.Equal(got, srcData) {
+		t.Fatal("final file mismatch after resume")
+	}
+}
+
+func TestResumeReusesSavedBlockDigests(t *testing.T) {
+	srcPath := filepath.Join(t.TempDir(), "blocks.bin")
+	dstDir := t.TempDir()
+	srcData := bytes.Repeat([]byte("0123456789abcdef"), 1024*768) // 12MB
+	if err := os.WriteFile(srcPath, srcData, 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	session, err := loadOrCreateSessionID(srcPath)
+	if err != nil {
+		t.Fatalf("loadOrCreateSessionID() error = %v", err)
+	}
+	paths, _ := resume.ResolvePaths(dstDir, "blocks.bin", false)
+	// The saved digests cover two blocks; the partial holds them plus a stray half block.
+	// Zeroing the first block proves the receiver trusts its saved digest instead of rehashing.
+	partial := append(make([]byte, IntegrityBlockSize), srcData[IntegrityBlockSize:10*1024*1024]...)
+	if err := os.WriteFile(paths.Partial, partial, 0o644); err != nil {
+		t.Fatalf("WriteFile(partial) error = %v", err)
+	}
+	tree, _ := hash.NewTree(hash.Default, IntegrityBlockSize, nil)
+	_, _ = tree.Write(srcData[:2*IntegrityBlockSize])
+	meta := resume.Meta{ExpectedSize: uint64(len(srcData)), ReceivedOffset: uint64(len(partial)), OriginalName: "blocks.bin", SessionID: session,
+		Algorithm: uint8(hash.Default), BlockSize: IntegrityBlockSize, Leaves: tree.Leaves()}
+	if err := resume.SaveLeaves(paths.Meta, meta.Leaves, hash.Default.Size()); err != nil {
+		t.Fatalf("SaveLeaves() error = %v", err)
+	}
+	if err := resume.SaveMetaAtomic(paths.Meta, meta); err != nil {
+		t.Fatalf("SaveMetaAtomic() error = %v", err)
+	}
+
+	listenAddr, done := startReceiver(t, ReceiverOptions{OutDir: dstDir, AutoAccept: true, Resume: true, Out: ioDiscard{}})
+	sendOut := &bytes.Buffer{}
+	sendErr := Send(SenderOptions{Path: srcPath, Address: listenAddr, Resume: true, Out: sendOut})
+	if recvErr := <-done; sendErr != nil || recvErr != nil {
+		t.Fatalf("send err=%v recv err=%v", sendErr, recvErr)
+	}
+	if !strings.Contains(sendOut.String(), "Resuming at offset 8388608") {
+		t.Fatalf("expected block-aligned resume, got %q", sendOut.String())
+	}
+	got, _ := os.ReadFile(paths.Final)
+	if !bytes.Equal(got[2*IntegrityBlockSize:], srcData[2*IntegrityBlockSize:]) || !bytes.Equal(got[:IntegrityBlockSize], partial[:IntegrityBlockSize]) {
+		t.Fatal("expected only the blocks after the saved digests to be rewritten")
+	}
+}
+
+func TestResumeRestartsWhenLastSavedBlockChanged(t *testing.T) {
+	srcPath := filepath.Join(t.TempDir(), "blocks.bin")
+	dstDir := t.TempDir()
+	srcData := bytes.Repeat([]byte("0123456789abcdef"), 1024*768) // 12MB
+	if err := os.WriteFile(srcPath, srcData, 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	session, err := loadOrCreateSessionID(srcPath)
+	if err != nil {
+		t.Fatalf("loadOrCreateSessionID() error = %v", err)
+	}
+	paths, _ := resume.ResolvePaths(dstDir, "blocks.bin", false)
+	// The second saved block no longer matches its digest, as after a torn write.
+	partial := append([]byte(nil), srcData[:2*IntegrityBlockSize]...)
+	partial[IntegrityBlockSize+7] ^= 0xFF
+	if err := os.WriteFile(paths.Partial, partial, 0o644); err != nil {
+		t.Fatalf("WriteFile(partial) error = %v", err)
+	}
+	tree, _ := hash.NewTree(hash.Default, IntegrityBlockSize, nil)
+	_, _ = tree.Write(srcData[:2*IntegrityBlockSize])
+	meta := resume.Meta{ExpectedSize: uint64(len(srcData)), ReceivedOffset: uint64(len(partial)), OriginalName: "blocks.bin", SessionID: session,
+		Algorithm: uint8(hash.Default), BlockSize: IntegrityBlockSize, Leaves: tree.Leaves()}
+	if err := resume.SaveLeaves(paths.Meta, meta.Leaves, hash.Default.Size()); err != nil {
+		t.Fatalf("SaveLeaves() error = %v", err)
+	}
+	if err := resume.SaveMetaAtomic(paths.Meta, meta); err != nil {
+		t.Fatalf("SaveMetaAtomic() error = %v", err)
+	}
+
+	listenAddr, done := startReceiver(t, ReceiverOptions{OutDir: dstDir, AutoAccept: true, Resume: true, Out: ioDiscard{}})
+	sendOut := &bytes.Buffer{}
+	sendErr := Send(SenderOptions{Path: srcPath, Address: listenAddr, Resume: true, Out: sendOut})
+	if recvErr := <-done; sendErr != nil || recvErr != nil {
+		t.Fatalf("send err=%v recv err=%v", sendErr, recvErr)
+	}
+	if strings.Contains(sendOut.String(), "Resuming") {
+		t.Fatalf("expected a restart from zero, got %q", sendOut.String())
+	}
+	if got, _ := os.ReadFile(paths.Final); !bytes.Equal(got, srcData) {
+		t.Fatal("final file mismatch after restart")
+	}
+}
+
+func TestTransferUsesNegotiatedHashAlgorithm(t *testing.T) {
+	srcPath := filepath.Join(t.TempDir(), "algo.bin")
+	if err := os.WriteFile(srcPath, bytes.Repeat([]byte("xyz"), 500000), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	for _, alg := range hash.Algorithms() {
+		var out bytes.Buffer
+		listenAddr, done := startReceiver(t, ReceiverOptions{OutDir: t.TempDir(), AutoAccept: true, Out: &out})
+		if err := Send(SenderOptions{Path: srcPath, Address: listenAddr, Out: ioDiscard{}, Hash: alg}); err != nil {
+			t.Fatalf("Send(%s) error = %v", alg, err)
+		}
+		if err := <-done; err != nil {
+			t.Fatalf("receiver error with %s = %v", alg, err)
+		}
+		if !strings.Contains(out.String(), alg.String()+": ") {
+			t.Fatalf("expected receiver to report %s digest, got %q", alg, out.String())
+		}
+	}
+}
+
+func TestReceiverAcceptsLegacySender(t *testing.T) {
+	dstDir := t.TempDir()
+	listenAddr, done := startReceiver(t, ReceiverOptions{OutDir: dstDir, AutoAccept: true, Out: ioDiscard{}})
+	conn, err := net.Dial("tcp", listenAddr)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	content := []byte("legacy v1 sender payload")
+	offer, _ := EncodeOffer("legacy.txt", uint64(len(content)), "legacy-session")
+	_ = WriteFrame(conn, Frame{Type: TypeHello})
+	_ = WriteFrame(conn, Frame{Type: TypeOffer, Payload: offer})
+	if resp, err := ReadFrame(conn); err != nil || resp.Type != TypeAccept {
+		t.Fatalf("expected ACCEPT without HELLO reply, got type %d err %v", resp.Type, err)
+	}
+	digest := sha256.Sum256(content)
+	legacyDone := make([]byte, 2+len(digest))
+	binary.BigEndian.PutUint16(legacyDone[:2], uint16(len(digest)))
+	copy(legacyDone[2:], digest[:])
+	_ = WriteFrame(conn, Frame{Type: TypeData, Payload: content})
+	_ = WriteFrame(conn, Frame{Type: TypeDone, Payload: legacyDone})
+	if resp, err := ReadFrame(conn); err != nil || resp.Type != TypeVerified {
+		t.Fatalf("expected VERIFIED, got type %d err %v", resp.Type, err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("receiver error = %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(dstDir, "legacy.txt"))
+	if err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("legacy transfer output mismatch: %q err=%v", got, err)
+	}
+}
+
+// v1MaxPayload is the v1.0 receiver's payload limit per frame type; v1.0 knew no
+// other sender frames.
+var v1MaxPayload = map[uint16]int{TypeHello: 0, TypeOffer: MaxControlPayload, TypeData: MaxChunkSize, TypeDone: 2 + 32}
+
+func TestSenderFallsBackToEmptyHelloForV1Receiver(t *testing.T) {
+	srcPath := filepath.Join(t.TempDir(), "old.txt")
+	content := []byte("sent to a v1.0 receiver")
+	if err := os.WriteFile(srcPath, content, 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	dstDir := t.TempDir()
+	// A v1.0 receiver drops the connection on any frame over its limits, so the
+	// proxy checks each sender frame before the current receiver sees it.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer func() { _ = ln.Close() }()
+	done := make(chan error, 1)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			first, err := ReadFrame(conn)
+			if err != nil || len(first.Payload) > v1MaxPayload[first.Type] {
+				_ = conn.Close()
+				continue
+			}
+			recvAddr, recvDone := startReceiver(t, ReceiverOptions{OutDir: dstDir, AutoAccept: true, Out: ioDiscard{}})
+			upstream, err := net.Dial("tcp", recvAddr)
+			if err != nil {
+				done <- err
+				return
+			}
+			go func() { _, _ = io.Copy(conn, upstream) }()
+			for frame := first; ; {
+				limit, known := v1MaxPayload[frame.Type]
+				if !known || len(frame.Payload) > limit {
+					done <- fmt.Errorf("frame type %d with %d bytes is beyond v1", frame.Type, len(frame.Payload))
+					return
+				}
+				_ = WriteFrame(upstream, frame)
+				if frame.Type == TypeDone {
+					break
+				}
+				if frame, err = ReadFrame(conn); err != nil {
+					done <- err
+					return
+				}
+			}
+			done <- <-recvDone
+			return
+		}
+	}()
+
+	out := &bytes.Buffer{}
+	if err := Send(SenderOptions{Path: srcPath, Address: ln.Addr().String(), Out: out}); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("v1 receiver error = %v", err)
+	}
+	if !strings.Contains(out.String(), "retrying as a v1 sender") {
+		t.Fatalf("expected fallback notice, got %q", out.String())
+	}
+	if got, err := os.ReadFile(filepath.Join(dstDir, "old.txt")); err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("v1 transfer output mismatch: %q err=%v", got, err)
+	}
+}
+
+func TestSenderRetriesWhenReceiverDiesBeforeVerified(t *testing.T) {
+	srcPath := filepath.Join(t.TempDir(), "unconfirmed.txt")
+	if err := os.WriteFile(srcPath, []byte("never confirmed"), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	recvAddr, recvDone := startReceiver(t, ReceiverOptions{OutDir: t.TempDir(), AutoAccept: true, Resume: true, Out: ioDiscard{}})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer func() { _ = ln.Close() }()
+	// The proxy drops both connections where the receiver would verify DONE, as if
+	// the receiver died before answering.
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		upstream, err := net.Dial("tcp", recvAddr)
+		if err != nil {
+			return
+		}
+		defer func() { _ = upstream.Close() }()
+		go func() { _, _ = io.Copy(conn, upstream) }()
+		for {
+			frame, err := ReadFrame(conn)
+			if err != nil || frame.Type == TypeDone {
+				return
+			}
+			_ = WriteFrame(upstream, frame)
+		}
+	}()
+
+	out := &bytes.Buffer{}
+	err = Send(SenderOptions{Path: srcPath, Address: ln.Addr().String(), Resume: true, Out: out})
+	<-recvDone
+	if !errors.Is(err, apperrors.ErrNetwork) {
+		t.Fatalf("expected network error, got %v", err)
+	}
+	if strings.Contains(out.String(), "Integrity verified") {
+		t.Fatalf("unconfirmed transfer reported as verified: %q", out.String())
+	}
+	if _, err := os.Stat(sessionPath(srcPath)); err != nil {
+		t.Fatalf("expected session file kept for resume, got %v", err)
+	}
+}
+
+func TestReceiverRefusesIncompatibleHello(t *testing.T) {
+	listenAddr, done := startReceiver(t, ReceiverOptions{OutDir: t.TempDir(), AutoAccept: true, Out: ioDiscard{}})
+	conn, err := net.Dial("tcp", listenAddr)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	payload, _ := EncodeHello(HelloPayload{MinVersion: ProtocolVersion + 1, MaxVersion: ProtocolVersion + 5, Hashes: []uint8{uint8(hash.SHA256)}})
+	if err := WriteFrame(conn, Frame{Type: TypeHello, Payload: payload}); err != nil {
+		t.Fatalf("WriteFrame() error = %v", err)
+	}
+	resp, err := ReadFrame(conn)
+	if err != nil || resp.Type != TypeError {
+		t.Fatalf("expected ERROR reply, got type %d err %v", resp.Type, err)
+	}
+	if err := <-done; !errors.Is(err, apperrors.ErrInvalidProtocol) {
+		t.Fatalf("expected invalid protocol error, got %v", err)
 	}
 }
 
@@ -218,6 +813,29 @@ func startReceiver(t *testing.T, opts ReceiverOptions) (string, <-chan error) {
 	return ln.Addr().String(), done
 }
 
+// startListening runs start in the background with an OnListening hook and
+// returns the address it listens on once it is ready.
+func startListening(t *testing.T, name string, start func(onListening func(net.Addr) (func(), error)) error) (string, <-chan error) {
+	t.Helper()
+	addrCh := make(chan string, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- start(func(addr net.Addr) (func(), error) {
+			addrCh <- addr.String()
+			return nil, nil
+		})
+	}()
+	select {
+	case addr := <-addrCh:
+		return addr, done
+	case err := <-done:
+		t.Fatalf("%s() error = %v", name, err)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s() did not start listening", name)
+	}
+	return "", done
+}
+
 func sendPartial(path, addr string, cutoff int64) error {
 	file, err := os.Open(path)
 	if err != nil {
@@ -335,3 +953,114 @@ func TestSessionMismatchRejectedUnlessForceRestart(t *testing.T) {
 	_ = conn2.Close()
 	<-done2
 }
+
+// dropConn fails the connection once limit bytes have been read, like a dropped link.
+type dropConn struct {
+	net.Conn
+	limit int
+}
+
+func (c *dropConn) Read(p []byte) (int, error) {
+	if c.limit <= 0 {
+		_ = c.Conn.Close()
+		return 0, io.ErrUnexpectedEOF
+	}
+	n, err := c.Conn.Read(p[:min(len(p), c.limit)])
+	c.limit -= n
+	return n, err
+}
+
+func TestSendRetriesAfterDroppedConnection(t *testing.T) {
+	srcPath, srcData := stripeSource(t, 10)
+	dstDir := t.TempDir()
+	var delays []time.Duration
+	prevSleep := retrySleep
+	retrySleep = func(d time.Duration, _ <-chan struct{}) { delays = append(delays, d) }
+	defer func() { retrySleep = prevSleep }()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer func() { _ = ln.Close() }()
+	opts := ReceiverOptions{OutDir: dstDir, AutoAccept: true, Resume: true, Out: ioDiscard{}}
+	done := make(chan error, 1)
+	go func() {
+		for attempt := 0; attempt < 2; attempt++ {
+			conn, err := ln.Accept()
+			if err != nil {
+				done <- err
+				return
+			}
+			if attempt == 0 {
+				conn = &dropConn{Conn: conn, limit: 6 << 20}
+			}
+			err = HandleConnection(conn, opts)
+			_ = conn.Close()
+			if attempt == 1 {
+				done <- err
+			}
+		}
+	}()
+
+	out := &bytes.Buffer{}
+	sendErr := Send(SenderOptions{Path: srcPath, Address: ln.Addr().String(), Resume: true, Retries: 3, RetryBackoff: 10 * time.Millisecond, Out: out})
+	if recvErr := <-done; sendErr != nil || recvErr != nil {
+		t.Fatalf("send err=%v recv err=%v", sendErr, recvErr)
+	}
+	if len(delays) != 1 || delays[0] < 5*time.Millisecond || delays[0] > 10*time.Millisecond {
+		t.Fatalf("expected one jittered retry delay, got %v", delays)
+	}
+	if !strings.Contains(out.String(), "Resuming at offset 4194304") {
+		t.Fatalf("expected retry to resume after the verified block, got %q", out.String())
+	}
+	if got, _ := os.ReadFile(filepath.Join(dstDir, "striped.bin")); !bytes.Equal(got, srcData) {
+		t.Fatal("final file mismatch after retry")
+	}
+}
+
+func TestSendDoesNotRetryRejection(t *testing.T) {
+	srcPath, _ := stripeSource(t, 1)
+	prevSleep := retrySleep
+	retrySleep = func(time.Duration, <-chan struct{}) { t.Fatal("a rejected transfer must not be retried") }
+	defer func() { retrySleep = prevSleep }()
+
+	addr, done := startReceiver(t, ReceiverOptions{OutDir: t.TempDir(), Out: ioDiscard{}})
+	err := Send(SenderOptions{Path: srcPath, Address: addr, Retries: 3, Out: ioDiscard{}})
+	<-done
+	if !errors.Is(err, apperrors.ErrRejected) {
+		t.Fatalf("expected rejection, got %v", err)
+	}
+}
+
+func TestCancelInterruptsRetryBackoff(t *testing.T) {
+	srcPath, _ := stripeSource(t, 1)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close() // every dial is refused, which is retried
+
+	cancel := make(chan struct{})
+	time.AfterFunc(100*time.Millisecond, func() { close(cancel) })
+	start := time.Now()
+	err = Send(SenderOptions{Path: srcPath, Address: addr, Retries: 3, RetryBackoff: time.Hour, Cancel: cancel, Out: ioDiscard{}})
+	if !errors.Is(err, apperrors.ErrCancelled) {
+		t.Fatalf("expected cancellation during backoff, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("cancel took %v to interrupt the backoff", elapsed)
+	}
+}
+
+func TestRetryDelayBacksOffExponentially(t *testing.T) {
+	for attempt, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		if got := retryDelay(time.Second, attempt); got < want/2 || got > want {
+			t.Fatalf("retryDelay(attempt %d) = %v, want within [%v, %v]", attempt, got, want/2, want)
+		}
+	}
+	if got := retryDelay(time.Second, 20); got > maxRetryBackoff {
+		t.Fatalf("retryDelay() = %v exceeds cap", got)
+	}
+}
@@ -0,0 +1,90 @@
+package transfer
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"fmt"
+	"io"
+
+	apperrors "snapsync/internal/errors"
+	"snapsync/internal/pake"
+)
+
+// clientPairing runs SPAKE2 with the receiver and returns the encrypted channel keyed by the code.
+func clientPairing(reader *bufio.Reader, writer *bufio.Writer, raw io.Writer, code string) (*secureChannel, error) {
+	state, msg, err := pake.Start(pake.RoleClient, code)
+	if err != nil {
+		return nil, err
+	}
+	if err := WriteFrame(writer, Frame{Type: TypePake, Payload: msg}); err != nil {
+		return nil, fmt.Errorf("send pairing message: %w: %w", err, apperrors.ErrNetwork)
+	}
+	if err := writer.Flush(); err != nil {
+		return nil, fmt.Errorf("flush pairing message: %w: %w", err, apperrors.ErrNetwork)
+	}
+	resp, err := ReadFrame(reader)
+	if err != nil {
+		return nil, fmt.Errorf("read pairing response: %w: %w", err, apperrors.ErrNetwork)
+	}
+	if resp.Type == TypeError {
+		msg, _ := DecodeError(resp.Payload)
+		return nil, fmt.Errorf("receiver refused pairing: %s: %w", msg, apperrors.ErrPairing)
+	}
+	if resp.Type != TypePake || len(resp.Payload) != pake.ElementSize+32 {
+		return nil, fmt.Errorf("unexpected pairing response type %d: %w", resp.Type, apperrors.ErrInvalidProtocol)
+	}
+	secret, err := state.Finish(resp.Payload[:pake.ElementSize])
+	if err != nil {
+		return nil, fmt.Errorf("finish pairing: %w: %w", err, apperrors.ErrInvalidProtocol)
+	}
+	if !hmac.Equal(resp.Payload[pake.ElementSize:], pake.Confirm(secret, pake.RoleServer)) {
+		_ = sendErrorFrame(writer, "pairing code mismatch")
+		return nil, fmt.Errorf("receiver confirmation failed: %w", apperrors.ErrPairing)
+	}
+	if err := WriteFrame(writer, Frame{Type: TypePake, Payload: pake.Confirm(secret, pake.RoleClient)}); err != nil {
+		return nil, fmt.Errorf("send pairing confirmation: %w: %w", err, apperrors.ErrNetwork)
+	}
+	if err := writer.Flush(); err != nil {
+		return nil, fmt.Errorf("flush pairing confirmation: %w: %w", err, apperrors.ErrNetwork)
+	}
+	c2s, s2c, err := sessionKeys(secret, []byte("snapsync-pairing"))
+	if err != nil {
+		return nil, err
+	}
+	return newSecureChannel(reader, raw, c2s, s2c)
+}
+
+// serverPairing answers a sender pairing message and returns the encrypted channel keyed by the code.
+func serverPairing(reader *bufio.Reader, writer *bufio.Writer, raw io.Writer, first Frame, code string) (*secureChannel, error) {
+	state, msg, err := pake.Start(pake.RoleServer, code)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := state.Finish(first.Payload)
+	if err != nil {
+		_ = sendErrorFrame(writer, "invalid pairing message")
+		return nil, fmt.Errorf("finish pairing: %w: %w", err, apperrors.ErrInvalidProtocol)
+	}
+	payload := append(append([]byte{}, msg...), pake.Confirm(secret, pake.RoleServer)...)
+	if err := WriteFrame(writer, Frame{Type: TypePake, Payload: payload}); err != nil {
+		return nil, fmt.Errorf("send pairing response: %w: %w", err, apperrors.ErrNetwork)
+	}
+	if err := writer.Flush(); err != nil {
+		return nil, fmt.Errorf("flush pairing response: %w: %w", err, apperrors.ErrNetwork)
+	}
+	resp, err := ReadFrame(reader)
+	if err != nil {
+		return nil, fmt.Errorf("read pairing confirmation: %w: %w", err, apperrors.ErrNetwork)
+	}
+	if resp.Type == TypeError {
+		return nil, fmt.Errorf("sender rejected pairing confirmation: %w", apperrors.ErrPairing)
+	}
+	if resp.Type != TypePake || !hmac.Equal(resp.Payload, pake.Confirm(secret, pake.RoleClient)) {
+		return nil, fmt.Errorf("sender confirmation failed: %w", apperrors.ErrPairing)
+	}
+	c2s, s2c, err := sessionKeys(secret, []byte("snapsync-pairing"))
+	if err != nil {
+		return nil, err
+	}
+	return newSecureChannel(reader, raw, s2c, c2s)
+}
@@ -0,0 +1,15 @@
+package transfer
+
+import (
+	"os"
+	"syscall"
+)
+
+// fallocKeepSize reserves blocks without changing the file size, so resume never
+// mistakes reserved space for received bytes.
+const fallocKeepSize = 0x1
+
+// preallocate reserves length bytes of file starting at offset.
+func preallocate(file *os.File, offset, length int64) error {
+	return syscall.Fallocate(int(file.Fd()), fallocKeepSize, offset, length)
+}
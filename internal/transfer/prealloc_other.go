@@ -0,0 +1,8 @@
+//go:build !linux
+
+package transfer
+
+import "os"
+
+// preallocate does nothing where the platform has no fallocate.
+func preallocate(*os.File, int64, int64) error { return nil }
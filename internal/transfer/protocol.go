@@ -2,26 +2,46 @@
 package transfer
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/binary"
 	"fmt"
 	"io"
+	"io/fs"
+	"math"
+	"time"
 
 	apperrors "snapsync/internal/errors"
+	"snapsync/internal/hash"
+	"snapsync/internal/resume"
+	"snapsync/internal/throttle"
 )
 
 const (
 	// Magic marks SnapSync wire frames.
 	Magic = "SSYN"
-	// ProtocolVersion is the current SnapSync wire protocol version.
-	ProtocolVersion uint16 = 1
+	// ProtocolVersion is the newest protocol revision this build negotiates in HELLO.
+	ProtocolVersion uint16 = 2
+	// MinProtocolVersion is the oldest protocol revision this build still speaks.
+	MinProtocolVersion uint16 = 1
+	// FrameVersion is written in every frame header. The header layout is frozen so
+	// older peers can always parse frames; features are negotiated in HELLO instead.
+	FrameVersion uint16 = 1
 	// HeaderSize is the fixed protocol header length in bytes.
 	HeaderSize = 16
 	// MaxChunkSize is the max DATA payload bytes per frame.
 	MaxChunkSize = 1024 * 1024
 	// MaxControlPayload is the max control payload size.
 	MaxControlPayload = 4096
-	// HashSize is the raw final digest size in bytes.
-	HashSize = 32
+	// IntegrityBlockSize is the span of file bytes covered by one CHUNK digest.
+	IntegrityBlockSize = 4 * 1024 * 1024
+	// MaxStreams is the most connections one striped file transfer may use.
+	MaxStreams = 64
+	// AttachKeySize is the length of the key a STRIPE plan hands the sender for
+	// authenticating its ATTACH frames.
+	AttachKeySize = 32
+	// UnknownSize is the OFFER size of a stream whose length is only known at DONE.
+	UnknownSize = math.MaxUint64
 )
 
 const (
@@ -37,8 +57,99 @@ const (
 	TypeDone uint16 = 5
 	// TypeError carries receiver/sender error messages.
 	TypeError uint16 = 6
+	// TypeBatch announces a multi-entry directory transfer.
+	TypeBatch uint16 = 7
+	// TypeMkdir announces an empty directory entry.
+	TypeMkdir uint16 = 8
+	// TypeVerified confirms a file passed integrity checks and was finalized.
+	TypeVerified uint16 = 9
+	// TypeHandshake carries secure channel key exchange and peer authentication.
+	TypeHandshake uint16 = 10
+	// TypePake carries short-code SPAKE2 pairing messages.
+	TypePake uint16 = 11
+	// TypeChunk carries the digest of one integrity block.
+	TypeChunk uint16 = 12
+	// TypeStripe requests, and answers with, the byte ranges of a striped transfer.
+	TypeStripe uint16 = 13
+	// TypeAttach joins an extra connection to one range of a striped transfer.
+	TypeAttach uint16 = 14
+	// TypeCompressed carries a DATA payload compressed with DEFLATE.
+	TypeCompressed uint16 = 15
+	// TypeDelta asks for, and answers with, the layout of the receiver's basis file.
+	TypeDelta uint16 = 16
+	// TypeSignature carries the rolling and strong checksums of basis blocks.
+	TypeSignature uint16 = 17
+	// TypeCopy tells the receiver to copy a byte range of the basis file.
+	TypeCopy uint16 = 18
+	// TypeList asks a sharing peer for its shared entries; each reply lists one,
+	// and an empty reply ends the listing.
+	TypeList uint16 = 19
+	// TypeRequest asks a sharing peer to send one shared entry by name.
+	TypeRequest uint16 = 20
+	// TypePing keeps a connection alive while its sender is busy; it carries a
+	// sequence number.
+	TypePing uint16 = 21
+	// TypePong answers a PING with the same sequence number.
+	TypePong uint16 = 22
+	// TypeCancel stops a transfer from either side with a reason code and message.
+	TypeCancel uint16 = 23
 )
 
+// Capability flags advertised in HELLO.
+const (
+	// Bit 0 is unused: whether a connection is encrypted is settled by the
+	// handshake before HELLO, so it is never negotiated here.
+	_ uint32 = 1 << iota
+	// CapMultiFile means the peer supports BATCH directory transfers.
+	CapMultiFile
+	// CapCompression means the peer accepts DEFLATE-compressed DATA payloads.
+	CapCompression
+	// CapChunkDigests means files are verified per integrity block with CHUNK frames
+	// and DONE carries the tree root.
+	CapChunkDigests
+	// CapStriping means one file may be striped across several connections.
+	CapStriping
+	// CapStreaming means OFFER may carry UnknownSize; DONE then marks the end of the
+	// stream. Streams are always verified with CHUNK digests and never resume.
+	CapStreaming
+	// CapMetadata means OFFER may carry file attributes after the session id.
+	CapMetadata
+	// CapDelta means a file may be rebuilt from the receiver's existing copy with
+	// DELTA, SIGNATURE, and COPY frames.
+	CapDelta
+	// CapKeepalive means a busy peer may send PING frames, which must be answered
+	// with PONG, while the other side waits.
+	CapKeepalive
+	// CapCancel means the peer understands CANCEL frames; others get ERROR instead.
+	CapCancel
+)
+
+// CancelReason says why a CANCEL frame stopped a transfer.
+type CancelReason uint8
+
+// Reasons a CANCEL frame may carry.
+const (
+	// CancelUser means a person stopped the transfer, for example with Ctrl-C.
+	CancelUser CancelReason = 1 + iota
+	// CancelDiskFull means the receiver ran out of space.
+	CancelDiskFull
+	// CancelPolicy means the transfer broke a rule of the cancelling side.
+	CancelPolicy
+)
+
+// File attributes an OFFER may carry, as bits of FileAttrs.Set.
+const (
+	// AttrMode is the permission bits, including the executable bits.
+	AttrMode uint8 = 1 << iota
+	// AttrModTime is the modification time.
+	AttrModTime
+	// AttrOwner is the numeric owner and group.
+	AttrOwner
+)
+
+// offerAttrsSize is the length of the optional attribute block ending an OFFER.
+const offerAttrsSize = 1 + 4 + 8 + 4 + 4
+
 // Frame is a protocol frame.
 type Frame struct {
 	Type    uint16
@@ -50,6 +161,39 @@ type OfferPayload struct {
 	Name      string
 	Size      uint64
 	SessionID string
+	Attrs     FileAttrs
+}
+
+// FileAttrs is the source file metadata sent with an OFFER. Only the attributes
+// flagged in Set are meaningful.
+type FileAttrs struct {
+	Set     uint8
+	Mode    fs.FileMode
+	ModTime time.Time
+	UID     uint32
+	GID     uint32
+}
+
+// HelloPayload represents a decoded HELLO payload. Senders list supported versions,
+// capabilities, and hash algorithms in preference order; the receiver answers with
+// the single negotiated version and hash and the shared capabilities.
+type HelloPayload struct {
+	MinVersion uint16
+	MaxVersion uint16
+	Caps       uint32
+	Hashes     []uint8
+}
+
+// Has reports whether every capability in caps was negotiated.
+func (h HelloPayload) Has(caps uint32) bool { return h.Caps&caps == caps }
+
+// BatchPayload represents decoded BATCH payload data.
+type BatchPayload struct {
+	Name       string
+	TotalBytes uint64
+	Files      uint32
+	Dirs       uint32
+	SessionID  string
 }
 
 // WriteFrame writes one protocol frame to the stream.
@@ -59,7 +203,7 @@ func WriteFrame(w io.Writer, frame Frame) error {
 	}
 	header := make([]byte, HeaderSize)
 	copy(header[0:4], []byte(Magic))
-	binary.BigEndian.PutUint16(header[4:6], ProtocolVersion)
+	binary.BigEndian.PutUint16(header[4:6], FrameVersion)
 	binary.BigEndian.PutUint16(header[6:8], frame.Type)
 	binary.BigEndian.PutUint32(header[8:12], uint32(len(frame.Payload)))
 	if _, err := w.Write(header); err != nil {
@@ -73,8 +217,24 @@ func WriteFrame(w io.Writer, frame Frame) error {
 	return nil
 }
 
+// writePacedFrame writes frame like WriteFrame, pacing DATA and compressed DATA
+// frames through l.
+func writePacedFrame(w io.Writer, frame Frame, l *throttle.Limiter) error {
+	if frame.Type == TypeData || frame.Type == TypeCompressed {
+		w = throttle.Writer(w, l)
+	}
+	return WriteFrame(w, frame)
+}
+
 // ReadFrame reads one protocol frame from the stream.
 func ReadFrame(r io.Reader) (Frame, error) {
+	return readPacedFrame(r, nil)
+}
+
+// readPacedFrame reads one frame like ReadFrame, reading only the payload of DATA
+// and compressed DATA frames at the pace of l, so control frames never wait behind
+// a bandwidth limit.
+func readPacedFrame(r io.Reader, l *throttle.Limiter) (Frame, error) {
 	header := make([]byte, HeaderSize)
 	if _, err := io.ReadFull(r, header); err != nil {
 		return Frame{}, fmt.Errorf("read frame header: %w", err)
@@ -82,8 +242,8 @@ func ReadFrame(r io.Reader) (Frame, error) {
 	if string(header[:4]) != Magic {
 		return Frame{}, fmt.Errorf("invalid magic: %w", apperrors.ErrInvalidProtocol)
 	}
-	if binary.BigEndian.Uint16(header[4:6]) != ProtocolVersion {
-		return Frame{}, fmt.Errorf("unsupported protocol version: %w", apperrors.ErrInvalidProtocol)
+	if v := binary.BigEndian.Uint16(header[4:6]); v != FrameVersion {
+		return Frame{}, fmt.Errorf("unsupported frame version %d: %w", v, apperrors.ErrInvalidProtocol)
 	}
 	if binary.BigEndian.Uint32(header[12:16]) != 0 {
 		return Frame{}, fmt.Errorf("reserved field must be zero: %w", apperrors.ErrInvalidProtocol)
@@ -95,19 +255,103 @@ func ReadFrame(r io.Reader) (Frame, error) {
 	}
 	payload := make([]byte, int(ln))
 	if ln > 0 {
-		if _, err := io.ReadFull(r, payload); err != nil {
+		src := r
+		if t == TypeData || t == TypeCompressed {
+			src = throttle.Reader(r, l)
+		}
+		if _, err := io.ReadFull(src, payload); err != nil {
 			return Frame{}, fmt.Errorf("read frame payload: %w", err)
 		}
 	}
 	return Frame{Type: t, Payload: payload}, nil
 }
 
+// EncodeHello builds a HELLO payload.
+func EncodeHello(h HelloPayload) ([]byte, error) {
+	if h.MinVersion == 0 || h.MinVersion > h.MaxVersion || len(h.Hashes) == 0 || len(h.Hashes) > 255 {
+		return nil, fmt.Errorf("invalid hello fields: %w", apperrors.ErrInvalidProtocol)
+	}
+	payload := make([]byte, 2+2+4+1+len(h.Hashes))
+	binary.BigEndian.PutUint16(payload[0:2], h.MinVersion)
+	binary.BigEndian.PutUint16(payload[2:4], h.MaxVersion)
+	binary.BigEndian.PutUint32(payload[4:8], h.Caps)
+	payload[8] = uint8(len(h.Hashes))
+	copy(payload[9:], h.Hashes)
+	return payload, nil
+}
+
+// DecodeHello parses a HELLO payload.
+func DecodeHello(payload []byte) (HelloPayload, error) {
+	if len(payload) < 10 {
+		return HelloPayload{}, fmt.Errorf("hello payload too short: %w", apperrors.ErrInvalidProtocol)
+	}
+	h := HelloPayload{
+		MinVersion: binary.BigEndian.Uint16(payload[0:2]),
+		MaxVersion: binary.BigEndian.Uint16(payload[2:4]),
+		Caps:       binary.BigEndian.Uint32(payload[4:8]),
+	}
+	n := int(payload[8])
+	if n == 0 || 9+n != len(payload) || h.MinVersion == 0 || h.MinVersion > h.MaxVersion {
+		return HelloPayload{}, fmt.Errorf("hello payload malformed: %w", apperrors.ErrInvalidProtocol)
+	}
+	h.Hashes = append([]uint8(nil), payload[9:]...)
+	return h, nil
+}
+
+// Negotiate picks the highest shared version, the first remote hash the local side
+// supports, and the intersection of capabilities.
+func Negotiate(local, remote HelloPayload) (HelloPayload, error) {
+	version := local.MaxVersion
+	if remote.MaxVersion < version {
+		version = remote.MaxVersion
+	}
+	if version < local.MinVersion || version < remote.MinVersion {
+		return HelloPayload{}, fmt.Errorf("no common protocol version (local %d-%d, remote %d-%d): %w",
+			local.MinVersion, local.MaxVersion, remote.MinVersion, remote.MaxVersion, apperrors.ErrInvalidProtocol)
+	}
+	for _, want := range remote.Hashes {
+		for _, have := range local.Hashes {
+			if want == have {
+				return HelloPayload{MinVersion: version, MaxVersion: version, Caps: local.Caps & remote.Caps, Hashes: []uint8{want}}, nil
+			}
+		}
+	}
+	return HelloPayload{}, fmt.Errorf("no common hash algorithm: %w", apperrors.ErrInvalidProtocol)
+}
+
+// legacyHello is assumed for v1 peers that send an empty HELLO and expect no reply.
+var legacyHello = HelloPayload{MinVersion: 1, MaxVersion: 1, Hashes: []uint8{uint8(hash.SHA256)}}
+
+// localHello describes what this build supports, listing the preferred hash first.
+func localHello(prefer hash.Algorithm) HelloPayload {
+	if !prefer.Supported() {
+		prefer = hash.Default
+	}
+	hashes := []uint8{uint8(prefer)}
+	for _, alg := range hash.Algorithms() {
+		if alg != prefer {
+			hashes = append(hashes, uint8(alg))
+		}
+	}
+	return HelloPayload{MinVersion: MinProtocolVersion, MaxVersion: ProtocolVersion, Caps: CapMultiFile | CapCompression | CapChunkDigests | CapStriping | CapStreaming | CapMetadata | CapDelta | CapKeepalive | CapCancel, Hashes: hashes}
+}
+
 // EncodeOffer builds OFFER payload.
 func EncodeOffer(name string, size uint64, sessionID string) ([]byte, error) {
+	return EncodeOfferAttrs(name, size, sessionID, FileAttrs{})
+}
+
+// EncodeOfferAttrs builds an OFFER payload that also carries file attributes. Empty
+// attributes are omitted, so the payload stays readable by peers without CapMetadata.
+func EncodeOfferAttrs(name string, size uint64, sessionID string, attrs FileAttrs) ([]byte, error) {
 	if len(name) == 0 || len(name) > 1024 || len(sessionID) == 0 || len(sessionID) > 128 {
 		return nil, fmt.Errorf("invalid offer fields: %w", apperrors.ErrInvalidProtocol)
 	}
-	payload := make([]byte, 2+len(name)+8+2+len(sessionID))
+	attrsLen := 0
+	if attrs.Set != 0 {
+		attrsLen = offerAttrsSize
+	}
+	payload := make([]byte, 2+len(name)+8+2+len(sessionID)+attrsLen)
 	off := 0
 	binary.BigEndian.PutUint16(payload[off:off+2], uint16(len(name)))
 	off += 2
@@ -118,6 +362,18 @@ func EncodeOffer(name string, size uint64, sessionID string) ([]byte, error) {
 	binary.BigEndian.PutUint16(payload[off:off+2], uint16(len(sessionID)))
 	off += 2
 	copy(payload[off:], []byte(sessionID))
+	off += len(sessionID)
+	if attrs.Set != 0 {
+		payload[off] = attrs.Set
+		binary.BigEndian.PutUint32(payload[off+1:off+5], uint32(attrs.Mode.Perm()))
+		var mtime int64
+		if !attrs.ModTime.IsZero() {
+			mtime = attrs.ModTime.UnixNano()
+		}
+		binary.BigEndian.PutUint64(payload[off+5:off+13], uint64(mtime))
+		binary.BigEndian.PutUint32(payload[off+13:off+17], attrs.UID)
+		binary.BigEndian.PutUint32(payload[off+17:off+21], attrs.GID)
+	}
 	return payload, nil
 }
 
@@ -138,10 +394,102 @@ func DecodeOffer(payload []byte) (OfferPayload, error) {
 	off += 8
 	sidLen := int(binary.BigEndian.Uint16(payload[off : off+2]))
 	off += 2
-	if sidLen <= 0 || off+sidLen != len(payload) {
+	if sidLen <= 0 || off+sidLen > len(payload) {
 		return OfferPayload{}, fmt.Errorf("offer session malformed: %w", apperrors.ErrInvalidProtocol)
 	}
-	return OfferPayload{Name: name, Size: size, SessionID: string(payload[off:])}, nil
+	offer := OfferPayload{Name: name, Size: size, SessionID: string(payload[off : off+sidLen])}
+	off += sidLen
+	switch len(payload) - off {
+	case 0:
+	case offerAttrsSize:
+		a := payload[off:]
+		offer.Attrs = FileAttrs{
+			Set:  a[0],
+			Mode: fs.FileMode(binary.BigEndian.Uint32(a[1:5])).Perm(),
+			UID:  binary.BigEndian.Uint32(a[13:17]),
+			GID:  binary.BigEndian.Uint32(a[17:21]),
+		}
+		if offer.Attrs.Set&AttrModTime != 0 {
+			offer.Attrs.ModTime = time.Unix(0, int64(binary.BigEndian.Uint64(a[5:13])))
+		}
+	default:
+		return OfferPayload{}, fmt.Errorf("offer attributes malformed: %w", apperrors.ErrInvalidProtocol)
+	}
+	return offer, nil
+}
+
+// EncodeBatch builds BATCH payload describing a directory transfer.
+func EncodeBatch(b BatchPayload) ([]byte, error) {
+	if len(b.Name) == 0 || len(b.Name) > 1024 || len(b.SessionID) == 0 || len(b.SessionID) > 128 {
+		return nil, fmt.Errorf("invalid batch fields: %w", apperrors.ErrInvalidProtocol)
+	}
+	payload := make([]byte, 2+len(b.Name)+8+4+4+2+len(b.SessionID))
+	off := 0
+	binary.BigEndian.PutUint16(payload[off:off+2], uint16(len(b.Name)))
+	off += 2
+	copy(payload[off:off+len(b.Name)], []byte(b.Name))
+	off += len(b.Name)
+	binary.BigEndian.PutUint64(payload[off:off+8], b.TotalBytes)
+	off += 8
+	binary.BigEndian.PutUint32(payload[off:off+4], b.Files)
+	off += 4
+	binary.BigEndian.PutUint32(payload[off:off+4], b.Dirs)
+	off += 4
+	binary.BigEndian.PutUint16(payload[off:off+2], uint16(len(b.SessionID)))
+	off += 2
+	copy(payload[off:], []byte(b.SessionID))
+	return payload, nil
+}
+
+// DecodeBatch parses BATCH payload.
+func DecodeBatch(payload []byte) (BatchPayload, error) {
+	if len(payload) < 20 {
+		return BatchPayload{}, fmt.Errorf("batch payload too short: %w", apperrors.ErrInvalidProtocol)
+	}
+	off := 0
+	nameLen := int(binary.BigEndian.Uint16(payload[off : off+2]))
+	off += 2
+	if nameLen <= 0 || off+nameLen+8+4+4+2 > len(payload) {
+		return BatchPayload{}, fmt.Errorf("batch payload malformed: %w", apperrors.ErrInvalidProtocol)
+	}
+	b := BatchPayload{Name: string(payload[off : off+nameLen])}
+	off += nameLen
+	b.TotalBytes = binary.BigEndian.Uint64(payload[off : off+8])
+	off += 8
+	b.Files = binary.BigEndian.Uint32(payload[off : off+4])
+	off += 4
+	b.Dirs = binary.BigEndian.Uint32(payload[off : off+4])
+	off += 4
+	sidLen := int(binary.BigEndian.Uint16(payload[off : off+2]))
+	off += 2
+	if sidLen <= 0 || off+sidLen != len(payload) {
+		return BatchPayload{}, fmt.Errorf("batch session malformed: %w", apperrors.ErrInvalidProtocol)
+	}
+	b.SessionID = string(payload[off:])
+	return b, nil
+}
+
+// EncodeMkdir builds MKDIR payload carrying a slash-separated relative path.
+func EncodeMkdir(name string) ([]byte, error) {
+	if len(name) == 0 || len(name) > 1024 {
+		return nil, fmt.Errorf("invalid mkdir path length: %w", apperrors.ErrInvalidProtocol)
+	}
+	payload := make([]byte, 2+len(name))
+	binary.BigEndian.PutUint16(payload[:2], uint16(len(name)))
+	copy(payload[2:], []byte(name))
+	return payload, nil
+}
+
+// DecodeMkdir parses MKDIR payload.
+func DecodeMkdir(payload []byte) (string, error) {
+	if len(payload) < 2 {
+		return "", fmt.Errorf("mkdir payload too short: %w", apperrors.ErrInvalidProtocol)
+	}
+	ln := int(binary.BigEndian.Uint16(payload[:2]))
+	if ln <= 0 || ln+2 != len(payload) {
+		return "", fmt.Errorf("mkdir payload malformed: %w", apperrors.ErrInvalidProtocol)
+	}
+	return string(payload[2:]), nil
 }
 
 // EncodeAccept builds ACCEPT payload containing resume offset and session id.
@@ -166,30 +514,288 @@ func DecodeAccept(payload []byte) (uint64, string, error) {
 	return offset, string(payload[10:]), nil
 }
 
-// EncodeDone encodes the DONE payload carrying the final digest.
-func EncodeDone(hash []byte) ([]byte, error) {
-	if len(hash) != HashSize {
-		return nil, fmt.Errorf("invalid done hash length: %w", apperrors.ErrInvalidProtocol)
+// EncodeDone encodes the DONE payload as the digest algorithm, digest length, and digest.
+func EncodeDone(alg hash.Algorithm, digest []byte) ([]byte, error) {
+	if !alg.Supported() || len(digest) != alg.Size() {
+		return nil, fmt.Errorf("invalid done digest for %s: %w", alg, apperrors.ErrInvalidProtocol)
 	}
-	payload := make([]byte, 2+HashSize)
-	binary.BigEndian.PutUint16(payload[:2], uint16(HashSize))
-	copy(payload[2:], hash)
+	payload := make([]byte, 2+len(digest))
+	payload[0] = uint8(alg)
+	payload[1] = uint8(len(digest))
+	copy(payload[2:], digest)
 	return payload, nil
 }
 
-// DecodeDone decodes the DONE payload carrying the final digest.
-func DecodeDone(payload []byte) ([]byte, error) {
-	if len(payload) != 2+HashSize {
-		return nil, fmt.Errorf("invalid done payload length: %w", apperrors.ErrInvalidProtocol)
+// DecodeDone decodes the DONE payload. v1 peers send a 16-bit length and a SHA-256
+// digest; their leading zero byte never names an algorithm.
+func DecodeDone(payload []byte) (hash.Algorithm, []byte, error) {
+	if len(payload) < 2 {
+		return 0, nil, fmt.Errorf("invalid done payload length: %w", apperrors.ErrInvalidProtocol)
+	}
+	alg := hash.Algorithm(payload[0])
+	size := int(payload[1])
+	if payload[0] == 0 {
+		alg = hash.SHA256
+		size = int(binary.BigEndian.Uint16(payload[:2]))
+	}
+	if !alg.Supported() {
+		return 0, nil, fmt.Errorf("unknown done hash algorithm %d: %w", payload[0], apperrors.ErrInvalidProtocol)
 	}
-	if int(binary.BigEndian.Uint16(payload[:2])) != HashSize {
-		return nil, fmt.Errorf("invalid done hash len field: %w", apperrors.ErrInvalidProtocol)
+	if size != alg.Size() || len(payload) != 2+size {
+		return 0, nil, fmt.Errorf("invalid done hash len field: %w", apperrors.ErrInvalidProtocol)
+	}
+	return alg, append([]byte(nil), payload[2:]...), nil
+}
+
+// EncodeChunkDigest builds a CHUNK payload: block index then digest.
+func EncodeChunkDigest(index uint64, digest []byte) ([]byte, error) {
+	if len(digest) == 0 || len(digest) > hash.MaxSize {
+		return nil, fmt.Errorf("invalid chunk digest length: %w", apperrors.ErrInvalidProtocol)
+	}
+	payload := make([]byte, 8+len(digest))
+	binary.BigEndian.PutUint64(payload[:8], index)
+	copy(payload[8:], digest)
+	return payload, nil
+}
+
+// DecodeChunkDigest parses a CHUNK payload.
+func DecodeChunkDigest(payload []byte) (uint64, []byte, error) {
+	if len(payload) <= 8 || len(payload) > 8+hash.MaxSize {
+		return 0, nil, fmt.Errorf("invalid chunk payload length: %w", apperrors.ErrInvalidProtocol)
+	}
+	return binary.BigEndian.Uint64(payload[:8]), append([]byte(nil), payload[8:]...), nil
+}
+
+// EncodeStripeRequest builds the sender's STRIPE payload: desired stream count and
+// whether to discard the receiver's resume state.
+func EncodeStripeRequest(streams int, restart bool) ([]byte, error) {
+	if streams < 1 || streams > MaxStreams {
+		return nil, fmt.Errorf("invalid stripe stream count %d: %w", streams, apperrors.ErrInvalidProtocol)
+	}
+	payload := []byte{uint8(streams), 0}
+	if restart {
+		payload[1] = 1
+	}
+	return payload, nil
+}
+
+// DecodeStripeRequest parses the sender's STRIPE payload.
+func DecodeStripeRequest(payload []byte) (int, bool, error) {
+	if len(payload) != 2 || payload[0] == 0 || payload[0] > MaxStreams || payload[1] > 1 {
+		return 0, false, fmt.Errorf("invalid stripe request: %w", apperrors.ErrInvalidProtocol)
+	}
+	return int(payload[0]), payload[1] == 1, nil
+}
+
+// EncodeStripePlan builds the receiver's STRIPE payload listing each range as
+// start, end, and the offset the sender should resume from, followed by the key
+// that authenticates ATTACH for this transfer.
+func EncodeStripePlan(ranges []resume.Range, key []byte) ([]byte, error) {
+	if len(ranges) == 0 || len(ranges) > MaxStreams || len(key) != AttachKeySize {
+		return nil, fmt.Errorf("invalid stripe plan with %d ranges: %w", len(ranges), apperrors.ErrInvalidProtocol)
+	}
+	payload := make([]byte, 1+24*len(ranges), 1+24*len(ranges)+AttachKeySize)
+	payload[0] = uint8(len(ranges))
+	for i, r := range ranges {
+		off := 1 + 24*i
+		binary.BigEndian.PutUint64(payload[off:off+8], r.Start)
+		binary.BigEndian.PutUint64(payload[off+8:off+16], r.End)
+		binary.BigEndian.PutUint64(payload[off+16:off+24], r.Offset)
+	}
+	return append(payload, key...), nil
+}
+
+// DecodeStripePlan parses the receiver's STRIPE payload into its ranges and attach
+// key. Ranges must be contiguous, in order, and each resume offset must fall
+// inside its range.
+func DecodeStripePlan(payload []byte) ([]resume.Range, []byte, error) {
+	if len(payload) < 1+24+AttachKeySize || int(payload[0]) > MaxStreams || len(payload) != 1+24*int(payload[0])+AttachKeySize {
+		return nil, nil, fmt.Errorf("invalid stripe plan length: %w", apperrors.ErrInvalidProtocol)
+	}
+	ranges := make([]resume.Range, payload[0])
+	for i := range ranges {
+		off := 1 + 24*i
+		r := resume.Range{
+			Start:  binary.BigEndian.Uint64(payload[off : off+8]),
+			End:    binary.BigEndian.Uint64(payload[off+8 : off+16]),
+			Offset: binary.BigEndian.Uint64(payload[off+16 : off+24]),
+		}
+		if r.Start >= r.End || r.Offset < r.Start || r.Offset > r.End || (i > 0 && r.Start != ranges[i-1].End) {
+			return nil, nil, fmt.Errorf("invalid stripe range %d: %w", i, apperrors.ErrInvalidProtocol)
+		}
+		ranges[i] = r
+	}
+	return ranges, append([]byte(nil), payload[len(payload)-AttachKeySize:]...), nil
+}
+
+// AttachMAC authenticates an ATTACH for range index of sessionID with the key from
+// the transfer's STRIPE plan.
+func AttachMAC(key []byte, index int, sessionID string) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte{uint8(index)})
+	_, _ = mac.Write([]byte(sessionID))
+	return mac.Sum(nil)
+}
+
+// EncodeAttach builds ATTACH payload: range index, the AttachMAC, then session id.
+func EncodeAttach(index int, sessionID string, mac []byte) ([]byte, error) {
+	if index < 0 || index >= MaxStreams || len(sessionID) == 0 || len(sessionID) > 128 || len(mac) != sha256.Size {
+		return nil, fmt.Errorf("invalid attach fields: %w", apperrors.ErrInvalidProtocol)
+	}
+	payload := append([]byte{uint8(index)}, mac...)
+	return append(payload, sessionID...), nil
+}
+
+// DecodeAttach parses ATTACH payload into range index, session id, and MAC.
+func DecodeAttach(payload []byte) (int, string, []byte, error) {
+	const head = 1 + sha256.Size
+	if len(payload) < head+1 || len(payload) > head+128 || int(payload[0]) >= MaxStreams {
+		return 0, "", nil, fmt.Errorf("invalid attach payload: %w", apperrors.ErrInvalidProtocol)
+	}
+	return int(payload[0]), string(payload[head:]), append([]byte(nil), payload[1:head]...), nil
+}
+
+// DeltaHeader is the receiver's DELTA reply describing its basis file. BlockCount
+// full blocks follow in SIGNATURE frames; a short tail block is never matched.
+type DeltaHeader struct {
+	BlockSize uint32
+	BasisSize uint64
+	StrongLen uint8
+}
+
+// BlockCount is the number of signed basis blocks.
+func (h DeltaHeader) BlockCount() int {
+	if h.BlockSize == 0 {
+		return 0
+	}
+	return int(h.BasisSize / uint64(h.BlockSize))
+}
+
+// EncodeDeltaHeader builds the receiver's DELTA payload.
+func EncodeDeltaHeader(h DeltaHeader) []byte {
+	payload := make([]byte, 13)
+	binary.BigEndian.PutUint32(payload[0:4], h.BlockSize)
+	binary.BigEndian.PutUint64(payload[4:12], h.BasisSize)
+	payload[12] = h.StrongLen
+	return payload
+}
+
+// DecodeDeltaHeader parses the receiver's DELTA payload. An empty basis has no blocks.
+func DecodeDeltaHeader(payload []byte) (DeltaHeader, error) {
+	if len(payload) != 13 {
+		return DeltaHeader{}, fmt.Errorf("invalid delta payload: %w", apperrors.ErrInvalidProtocol)
+	}
+	h := DeltaHeader{BlockSize: binary.BigEndian.Uint32(payload[0:4]), BasisSize: binary.BigEndian.Uint64(payload[4:12]), StrongLen: payload[12]}
+	if h.BasisSize > 0 && (h.BlockSize < minDeltaBlock || h.BlockSize > maxDeltaBlock || h.StrongLen == 0 || int(h.StrongLen) > hash.MaxSize) {
+		return DeltaHeader{}, fmt.Errorf("invalid delta block layout: %w", apperrors.ErrInvalidProtocol)
 	}
-	h := make([]byte, HashSize)
-	copy(h, payload[2:])
 	return h, nil
 }
 
+// EncodeSignature builds a SIGNATURE payload of weak checksum and strong digest pairs.
+func EncodeSignature(weak []uint32, strong [][]byte) []byte {
+	var payload []byte
+	for i := range weak {
+		payload = binary.BigEndian.AppendUint32(payload, weak[i])
+		payload = append(payload, strong[i]...)
+	}
+	return payload
+}
+
+// DecodeSignature parses a SIGNATURE payload whose strong digests are strongLen bytes.
+func DecodeSignature(payload []byte, strongLen int) ([]uint32, [][]byte, error) {
+	entry := 4 + strongLen
+	if strongLen <= 0 || len(payload) == 0 || len(payload)%entry != 0 {
+		return nil, nil, fmt.Errorf("invalid signature payload: %w", apperrors.ErrInvalidProtocol)
+	}
+	n := len(payload) / entry
+	weak := make([]uint32, n)
+	strong := make([][]byte, n)
+	for i := 0; i < n; i++ {
+		e := payload[i*entry : (i+1)*entry]
+		weak[i] = binary.BigEndian.Uint32(e[:4])
+		strong[i] = e[4:]
+	}
+	return weak, strong, nil
+}
+
+// EncodeCopy builds COPY payload: basis offset then length.
+func EncodeCopy(offset uint64, length uint32) []byte {
+	payload := make([]byte, 12)
+	binary.BigEndian.PutUint64(payload[0:8], offset)
+	binary.BigEndian.PutUint32(payload[8:12], length)
+	return payload
+}
+
+// DecodeCopy parses COPY payload. Lengths are capped at MaxChunkSize, like DATA.
+func DecodeCopy(payload []byte) (uint64, uint32, error) {
+	if len(payload) != 12 {
+		return 0, 0, fmt.Errorf("invalid copy payload: %w", apperrors.ErrInvalidProtocol)
+	}
+	length := binary.BigEndian.Uint32(payload[8:12])
+	if length == 0 || length > MaxChunkSize {
+		return 0, 0, fmt.Errorf("invalid copy length %d: %w", length, apperrors.ErrInvalidProtocol)
+	}
+	return binary.BigEndian.Uint64(payload[0:8]), length, nil
+}
+
+// ShareEntry is one file or directory a sharing peer offers, as carried in LIST.
+type ShareEntry struct {
+	Name string
+	Size uint64
+	Dir  bool
+}
+
+// EncodeListEntry builds a LIST reply payload: size, directory flag, then name.
+func EncodeListEntry(e ShareEntry) ([]byte, error) {
+	if len(e.Name) == 0 || len(e.Name) > 1024 {
+		return nil, fmt.Errorf("invalid list entry name length: %w", apperrors.ErrInvalidProtocol)
+	}
+	payload := make([]byte, 11+len(e.Name))
+	binary.BigEndian.PutUint64(payload[0:8], e.Size)
+	if e.Dir {
+		payload[8] = 1
+	}
+	binary.BigEndian.PutUint16(payload[9:11], uint16(len(e.Name)))
+	copy(payload[11:], e.Name)
+	return payload, nil
+}
+
+// DecodeListEntry parses a non-empty LIST reply payload.
+func DecodeListEntry(payload []byte) (ShareEntry, error) {
+	if len(payload) < 12 || payload[8] > 1 {
+		return ShareEntry{}, fmt.Errorf("invalid list entry payload: %w", apperrors.ErrInvalidProtocol)
+	}
+	ln := int(binary.BigEndian.Uint16(payload[9:11]))
+	if ln+11 != len(payload) {
+		return ShareEntry{}, fmt.Errorf("list entry payload malformed: %w", apperrors.ErrInvalidProtocol)
+	}
+	return ShareEntry{Name: string(payload[11:]), Size: binary.BigEndian.Uint64(payload[0:8]), Dir: payload[8] == 1}, nil
+}
+
+// EncodeRequest builds REQUEST payload naming one shared entry.
+func EncodeRequest(name string) ([]byte, error) {
+	if len(name) == 0 || len(name) > 1024 {
+		return nil, fmt.Errorf("invalid request name length: %w", apperrors.ErrInvalidProtocol)
+	}
+	payload := make([]byte, 2+len(name))
+	binary.BigEndian.PutUint16(payload[:2], uint16(len(name)))
+	copy(payload[2:], name)
+	return payload, nil
+}
+
+// DecodeRequest parses REQUEST payload.
+func DecodeRequest(payload []byte) (string, error) {
+	if len(payload) < 2 {
+		return "", fmt.Errorf("request payload too short: %w", apperrors.ErrInvalidProtocol)
+	}
+	ln := int(binary.BigEndian.Uint16(payload[:2]))
+	if ln <= 0 || ln+2 != len(payload) {
+		return "", fmt.Errorf("request payload malformed: %w", apperrors.ErrInvalidProtocol)
+	}
+	return string(payload[2:]), nil
+}
+
 // EncodeError encodes an ERROR payload message.
 func EncodeError(msg string) ([]byte, error) {
 	if len(msg) == 0 || len(msg) > 1024 {
@@ -213,17 +819,43 @@ func DecodeError(payload []byte) (string, error) {
 	return string(payload[2:]), nil
 }
 
+// EncodeCancel encodes a CANCEL payload: the reason and an optional message.
+func EncodeCancel(reason CancelReason, msg string) ([]byte, error) {
+	if len(msg) > 1024 {
+		return nil, fmt.Errorf("cancel message too long: %w", apperrors.ErrInvalidProtocol)
+	}
+	payload := make([]byte, 3+len(msg))
+	payload[0] = byte(reason)
+	binary.BigEndian.PutUint16(payload[1:3], uint16(len(msg)))
+	copy(payload[3:], msg)
+	return payload, nil
+}
+
+// DecodeCancel decodes a CANCEL payload. Unknown reasons are returned as is.
+func DecodeCancel(payload []byte) (CancelReason, string, error) {
+	if len(payload) < 3 || payload[0] == 0 {
+		return 0, "", fmt.Errorf("cancel payload too short: %w", apperrors.ErrInvalidProtocol)
+	}
+	ln := int(binary.BigEndian.Uint16(payload[1:3]))
+	if ln+3 != len(payload) {
+		return 0, "", fmt.Errorf("cancel payload malformed: %w", apperrors.ErrInvalidProtocol)
+	}
+	return CancelReason(payload[0]), string(payload[3:]), nil
+}
+
 func maxPayloadByType(t uint16) int {
 	switch t {
-	case TypeHello:
+	case TypeVerified:
 		return 0
+	case TypePing, TypePong:
+		return 8
 	case TypeAccept:
 		return MaxControlPayload
 	case TypeDone:
-		return 2 + HashSize
-	case TypeOffer, TypeError:
+		return 2 + hash.MaxSize
+	case TypeHello, TypeOffer, TypeError, TypeBatch, TypeChunk, TypeStripe, TypeAttach, TypeMkdir, TypeHandshake, TypePake, TypeDelta, TypeSignature, TypeCopy, TypeList, TypeRequest, TypeCancel:
 		return MaxControlPayload
-	case TypeData:
+	case TypeData, TypeCompressed:
 		return MaxChunkSize
 	default:
 		return MaxControlPayload
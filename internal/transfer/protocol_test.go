@@ -3,10 +3,14 @@ package transfer
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"strings"
 	"testing"
+	"time"
 
 	apperrors "snapsync/internal/errors"
+	"snapsync/internal/hash"
+	"snapsync/internal/resume"
 )
 
 func TestFrameRoundTrip(t *testing.T) {
@@ -34,12 +38,55 @@ func TestReadFrameRejectsInvalidMagicAndVersion(t *testing.T) {
 	}
 
 	copy(header[:4], []byte(Magic))
-	binary.BigEndian.PutUint16(header[4:6], ProtocolVersion+1)
+	binary.BigEndian.PutUint16(header[4:6], 0)
 	if _, err := ReadFrame(bytes.NewReader(header)); err == nil || !strings.Contains(err.Error(), apperrors.ErrInvalidProtocol.Error()) {
 		t.Fatalf("expected invalid protocol error for bad version, got %v", err)
 	}
 }
 
+func TestReadFrameRejectsUnknownHeaderVersion(t *testing.T) {
+	header := make([]byte, HeaderSize)
+	copy(header[:4], []byte(Magic))
+	binary.BigEndian.PutUint16(header[4:6], FrameVersion+1)
+	binary.BigEndian.PutUint16(header[6:8], TypeVerified)
+	if _, err := ReadFrame(bytes.NewReader(header)); !errors.Is(err, apperrors.ErrInvalidProtocol) {
+		t.Fatalf("expected unknown header version to be refused, got %v", err)
+	}
+}
+
+func TestHelloRoundTripAndNegotiate(t *testing.T) {
+	sender := HelloPayload{MinVersion: 1, MaxVersion: 3, Caps: CapMultiFile | 1<<31, Hashes: []uint8{9, uint8(hash.SHA256)}}
+	payload, err := EncodeHello(sender)
+	if err != nil {
+		t.Fatalf("EncodeHello() error = %v", err)
+	}
+	decoded, err := DecodeHello(payload)
+	if err != nil {
+		t.Fatalf("DecodeHello() error = %v", err)
+	}
+	if decoded.MaxVersion != 3 || decoded.Caps != sender.Caps || !bytes.Equal(decoded.Hashes, sender.Hashes) {
+		t.Fatalf("hello mismatch got %#v want %#v", decoded, sender)
+	}
+
+	agreed, err := Negotiate(localHello(hash.Default), decoded)
+	if err != nil {
+		t.Fatalf("Negotiate() error = %v", err)
+	}
+	if agreed.MaxVersion != ProtocolVersion || agreed.Has(1<<31) || agreed.Has(CapCompression) || !agreed.Has(CapMultiFile) || agreed.Hashes[0] != uint8(hash.SHA256) {
+		t.Fatalf("unexpected negotiated hello %#v", agreed)
+	}
+
+	if _, err := Negotiate(localHello(hash.Default), HelloPayload{MinVersion: 99, MaxVersion: 99, Hashes: []uint8{uint8(hash.SHA256)}}); err == nil {
+		t.Fatal("expected disjoint version ranges to fail")
+	}
+	if _, err := Negotiate(localHello(hash.Default), HelloPayload{MinVersion: 1, MaxVersion: 1, Hashes: []uint8{200}}); err == nil {
+		t.Fatal("expected unknown hash algorithms to fail")
+	}
+	if _, err := DecodeHello([]byte{0, 1}); err == nil {
+		t.Fatal("expected short hello payload to fail")
+	}
+}
+
 func TestLengthLimitsRespected(t *testing.T) {
 	payload := make([]byte, MaxChunkSize+1)
 	if err := WriteFrame(&bytes.Buffer{}, Frame{Type: TypeData, Payload: payload}); err == nil {
@@ -70,30 +117,69 @@ func TestOfferEncodeDecodeIncludesSession(t *testing.T) {
 	}
 }
 
-func TestDoneEncodesDecodesRawHash(t *testing.T) {
-	raw := bytes.Repeat([]byte{0xAB}, HashSize)
-	payload, err := EncodeDone(raw)
+func TestOfferCarriesOptionalAttrs(t *testing.T) {
+	attrs := FileAttrs{Set: AttrMode | AttrModTime | AttrOwner, Mode: 0o755, ModTime: time.Unix(1700000000, 123), UID: 1000, GID: 100}
+	p, err := EncodeOfferAttrs("build.sh", 42, "0123456789abcdef0123456789abcdef", attrs)
 	if err != nil {
-		t.Fatalf("EncodeDone() error = %v", err)
+		t.Fatalf("EncodeOfferAttrs() error = %v", err)
 	}
-	got, err := DecodeDone(payload)
+	o, err := DecodeOffer(p)
 	if err != nil {
-		t.Fatalf("DecodeDone() error = %v", err)
+		t.Fatalf("DecodeOffer() error = %v", err)
 	}
-	if !bytes.Equal(raw, got) {
-		t.Fatalf("hash mismatch got %x want %x", got, raw)
+	if o.Name != "build.sh" || o.Attrs.Set != attrs.Set || o.Attrs.Mode != attrs.Mode || !o.Attrs.ModTime.Equal(attrs.ModTime) || o.Attrs.UID != 1000 || o.Attrs.GID != 100 {
+		t.Fatalf("unexpected offer attrs: %#v", o)
+	}
+	if _, err := DecodeOffer(p[:len(p)-1]); err == nil {
+		t.Fatal("expected truncated attributes to fail")
+	}
+	plain, _ := EncodeOfferAttrs("build.sh", 42, "0123456789abcdef0123456789abcdef", FileAttrs{})
+	if legacy, _ := EncodeOffer("build.sh", 42, "0123456789abcdef0123456789abcdef"); !bytes.Equal(plain, legacy) {
+		t.Fatal("expected offers without attributes to keep the legacy layout")
+	}
+}
+
+func TestDoneEncodesDecodesRawHash(t *testing.T) {
+	for _, alg := range hash.Algorithms() {
+		raw := bytes.Repeat([]byte{0xAB}, alg.Size())
+		payload, err := EncodeDone(alg, raw)
+		if err != nil {
+			t.Fatalf("EncodeDone(%s) error = %v", alg, err)
+		}
+		gotAlg, got, err := DecodeDone(payload)
+		if err != nil {
+			t.Fatalf("DecodeDone(%s) error = %v", alg, err)
+		}
+		if gotAlg != alg || !bytes.Equal(raw, got) {
+			t.Fatalf("digest mismatch got %s %x want %s %x", gotAlg, got, alg, raw)
+		}
+	}
+}
+
+func TestDoneDecodesLegacySHA256Payload(t *testing.T) {
+	legacy := make([]byte, 2+32)
+	binary.BigEndian.PutUint16(legacy[:2], 32)
+	alg, digest, err := DecodeDone(legacy)
+	if err != nil || alg != hash.SHA256 || len(digest) != 32 {
+		t.Fatalf("legacy DONE decode got %s len=%d err=%v", alg, len(digest), err)
 	}
 }
 
 func TestDoneRejectsMalformedPayload(t *testing.T) {
-	if _, err := DecodeDone([]byte{}); err == nil {
+	if _, _, err := DecodeDone([]byte{}); err == nil {
 		t.Fatal("expected malformed done payload failure")
 	}
-	bad := make([]byte, 2+HashSize)
+	bad := make([]byte, 2+32)
 	binary.BigEndian.PutUint16(bad[:2], 31)
-	if _, err := DecodeDone(bad); err == nil {
+	if _, _, err := DecodeDone(bad); err == nil {
 		t.Fatal("expected invalid hash length failure")
 	}
+	if _, _, err := DecodeDone([]byte{200, 1, 0}); err == nil {
+		t.Fatal("expected unknown algorithm failure")
+	}
+	if _, err := EncodeDone(hash.BLAKE3, make([]byte, 8)); err == nil {
+		t.Fatal("expected digest size mismatch failure")
+	}
 }
 
 func TestAcceptEncodesDecodesResumeOffset(t *testing.T) {
@@ -109,3 +195,139 @@ func TestAcceptEncodesDecodesResumeOffset(t *testing.T) {
 		t.Fatal("expected invalid accept payload failure")
 	}
 }
+
+func TestBatchAndMkdirRoundTrip(t *testing.T) {
+	in := BatchPayload{Name: "photos", TotalBytes: 1 << 40, Files: 12, Dirs: 3, SessionID: "0123456789abcdef0123456789abcdef"}
+	payload, err := EncodeBatch(in)
+	if err != nil {
+		t.Fatalf("EncodeBatch() error = %v", err)
+	}
+	out, err := DecodeBatch(payload)
+	if err != nil {
+		t.Fatalf("DecodeBatch() error = %v", err)
+	}
+	if out != in {
+		t.Fatalf("batch mismatch got %#v want %#v", out, in)
+	}
+	if _, err := DecodeBatch(payload[:len(payload)-1]); err == nil {
+		t.Fatal("expected truncated batch payload failure")
+	}
+
+	mkdir, err := EncodeMkdir("photos/empty")
+	if err != nil {
+		t.Fatalf("EncodeMkdir() error = %v", err)
+	}
+	name, err := DecodeMkdir(mkdir)
+	if err != nil || name != "photos/empty" {
+		t.Fatalf("DecodeMkdir() = %q, %v", name, err)
+	}
+}
+
+func TestDeltaFramesRoundTrip(t *testing.T) {
+	in := DeltaHeader{BlockSize: 64 * 1024, BasisSize: 1<<20 + 5, StrongLen: 16}
+	header, err := DecodeDeltaHeader(EncodeDeltaHeader(in))
+	if err != nil || header != in || header.BlockCount() != 16 {
+		t.Fatalf("DecodeDeltaHeader() = %+v, %v", header, err)
+	}
+	if _, err := DecodeDeltaHeader(EncodeDeltaHeader(DeltaHeader{BlockSize: 1, BasisSize: 10, StrongLen: 16})); err == nil {
+		t.Fatal("expected tiny delta blocks to fail")
+	}
+
+	strong := [][]byte{bytes.Repeat([]byte{1}, 16), bytes.Repeat([]byte{2}, 16)}
+	weak, gotStrong, err := DecodeSignature(EncodeSignature([]uint32{7, 9}, strong), 16)
+	if err != nil || len(weak) != 2 || weak[1] != 9 || !bytes.Equal(gotStrong[1], strong[1]) {
+		t.Fatalf("DecodeSignature() = %v, %v, %v", weak, gotStrong, err)
+	}
+	if _, _, err := DecodeSignature(make([]byte, 21), 16); err == nil {
+		t.Fatal("expected ragged signature payload to fail")
+	}
+
+	if offset, length, err := DecodeCopy(EncodeCopy(1<<40, 4096)); err != nil || offset != 1<<40 || length != 4096 {
+		t.Fatalf("DecodeCopy() = %d, %d, %v", offset, length, err)
+	}
+	if _, _, err := DecodeCopy(EncodeCopy(0, MaxChunkSize+1)); err == nil {
+		t.Fatal("expected oversized copy to fail")
+	}
+}
+
+func TestListAndRequestRoundTrip(t *testing.T) {
+	in := ShareEntry{Name: "photos", Size: 1 << 33, Dir: true}
+	payload, err := EncodeListEntry(in)
+	if err != nil {
+		t.Fatalf("EncodeListEntry() error = %v", err)
+	}
+	if got, err := DecodeListEntry(payload); err != nil || got != in {
+		t.Fatalf("DecodeListEntry() = %+v, %v", got, err)
+	}
+	if _, err := DecodeListEntry(payload[:len(payload)-1]); err == nil {
+		t.Fatal("expected truncated list entry to fail")
+	}
+
+	req, err := EncodeRequest("report.pdf")
+	if err != nil {
+		t.Fatalf("EncodeRequest() error = %v", err)
+	}
+	if name, err := DecodeRequest(req); err != nil || name != "report.pdf" {
+		t.Fatalf("DecodeRequest() = %q, %v", name, err)
+	}
+	if _, err := EncodeRequest(""); err == nil {
+		t.Fatal("expected empty request name to fail")
+	}
+}
+
+func TestStripeAndAttachRoundTrip(t *testing.T) {
+	req, err := EncodeStripeRequest(8, true)
+	if err != nil {
+		t.Fatalf("EncodeStripeRequest() error = %v", err)
+	}
+	if streams, restart, err := DecodeStripeRequest(req); err != nil || streams != 8 || !restart {
+		t.Fatalf("DecodeStripeRequest() = %d, %v, %v", streams, restart, err)
+	}
+	if _, err := EncodeStripeRequest(MaxStreams+1, false); err == nil {
+		t.Fatal("expected too many streams to fail")
+	}
+
+	in := []resume.Range{{Start: 0, End: 8 << 20, Offset: 4 << 20}, {Start: 8 << 20, End: 9 << 20, Offset: 8 << 20}}
+	key := bytes.Repeat([]byte{5}, AttachKeySize)
+	plan, err := EncodeStripePlan(in, key)
+	if err != nil {
+		t.Fatalf("EncodeStripePlan() error = %v", err)
+	}
+	out, gotKey, err := DecodeStripePlan(plan)
+	if err != nil || len(out) != 2 || out[0] != in[0] || out[1] != in[1] || !bytes.Equal(gotKey, key) {
+		t.Fatalf("DecodeStripePlan() = %+v, %x, %v", out, gotKey, err)
+	}
+	gap, _ := EncodeStripePlan([]resume.Range{in[0], {Start: 9 << 20, End: 10 << 20, Offset: 9 << 20}}, key)
+	if _, _, err := DecodeStripePlan(gap); err == nil {
+		t.Fatal("expected non-contiguous ranges to fail")
+	}
+
+	sid := "0123456789abcdef0123456789abcdef"
+	attach, err := EncodeAttach(3, sid, AttachMAC(key, 3, sid))
+	if err != nil {
+		t.Fatalf("EncodeAttach() error = %v", err)
+	}
+	if index, gotSID, mac, err := DecodeAttach(attach); err != nil || index != 3 || gotSID != sid || !bytes.Equal(mac, AttachMAC(key, 3, sid)) {
+		t.Fatalf("DecodeAttach() = %d, %q, %x, %v", index, gotSID, mac, err)
+	}
+	if bytes.Equal(AttachMAC(key, 4, sid), AttachMAC(key, 3, sid)) {
+		t.Fatal("expected the MAC to cover the range index")
+	}
+}
+
+func TestCancelRoundTrip(t *testing.T) {
+	payload, err := EncodeCancel(CancelDiskFull, "receiver disk full")
+	if err != nil {
+		t.Fatalf("EncodeCancel() error = %v", err)
+	}
+	if reason, msg, err := DecodeCancel(payload); err != nil || reason != CancelDiskFull || msg != "receiver disk full" {
+		t.Fatalf("DecodeCancel() = %d, %q, %v", reason, msg, err)
+	}
+	bare, _ := EncodeCancel(CancelUser, "")
+	if reason, msg, err := DecodeCancel(bare); err != nil || reason != CancelUser || msg != "" {
+		t.Fatalf("DecodeCancel(bare) = %d, %q, %v", reason, msg, err)
+	}
+	if _, _, err := DecodeCancel(payload[:len(payload)-1]); err == nil {
+		t.Fatal("expected truncated cancel to fail")
+	}
+}
@@ -9,141 +9,704 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 
 	apperrors "snapsync/internal/errors"
 	"snapsync/internal/hash"
+	"snapsync/internal/identity"
+	"snapsync/internal/policy"
 	"snapsync/internal/progress"
 	"snapsync/internal/resume"
+	"snapsync/internal/sanitize"
+	"snapsync/internal/throttle"
 )
 
 const resumeMetaUpdateBytes = 4 * 1024 * 1024
 
+// leafMetaSyncInterval is how many verified blocks pass between resume metadata saves.
+const leafMetaSyncInterval = 16
+
 // PromptFunc asks user whether to accept a transfer.
 type PromptFunc func(name string, size uint64, peer string) (bool, error)
 
+// TrustFunc reports whether an authenticated peer is trusted. An error refuses the connection.
+type TrustFunc func(peer PeerIdentity) (bool, error)
+
+// OfferedFile describes an offer for BeforeAccept. Name is a slash-separated path
+// within a directory transfer, and Size is UnknownSize for a stream. PeerID and
+// Fingerprint are empty for unauthenticated peers.
+type OfferedFile struct {
+	Name        string
+	Size        uint64
+	Peer        string
+	PeerID      string
+	Fingerprint string
+}
+
+// ReceivedFile describes a finalized file for AfterReceive.
+type ReceivedFile struct {
+	OfferedFile
+	Path      string
+	Algorithm hash.Algorithm
+	Digest    []byte
+	Duration  time.Duration
+}
+
 // ReceiverOptions configures receiver behavior.
 type ReceiverOptions struct {
-	Listen       string
-	OutDir       string
-	Overwrite    bool
-	AutoAccept   bool
-	Prompt       PromptFunc
-	Out          io.Writer
-	OnListening  func(addr net.Addr) (func(), error)
-	Resume       bool
-	KeepPartial  bool
-	ForceRestart bool
-	BreakLock    bool
+	Listen            string
+	OutDir            string
+	Overwrite         bool
+	AutoAccept        bool
+	Prompt            PromptFunc
+	Out               io.Writer
+	OnListening       func(addr net.Addr) (func(), error)
+	Resume            bool
+	KeepPartial       bool
+	ForceRestart      bool
+	BreakLock         bool
+	Identity          *identity.Identity
+	RequireEncryption bool
+	VerifyPeer        func(PeerIdentity) error
+	Code              string
+	Trust             TrustFunc
+	RejectUntrusted   bool
+	MaxConcurrent     int
+	// Sink, when set, receives the single incoming file instead of OutDir. Transfers
+	// to a sink never resume, stripe, or carry directories.
+	Sink io.Writer
+	// Preserve selects which offered file attributes (AttrMode, AttrModTime,
+	// AttrOwner) are applied to received files.
+	Preserve uint8
+	// Timeouts bounds the handshake, idle waits, and total length of each connection.
+	Timeouts Timeouts
+	// Limit paces every connection the receiver serves together. Nil means unlimited.
+	Limit *throttle.Limiter
+	// Cancel, once closed, stops transfers at the next frame and tells senders with
+	// CANCEL. Partials are kept when Resume is set.
+	Cancel <-chan struct{}
+	// Policy, when set, refuses peers and files its rules do not allow before any
+	// prompt, and places each peer's files in its own subdirectory of OutDir.
+	Policy *policy.Policy
+	// BeforeAccept, when set, may veto each offer that passed Policy; its error is
+	// the reason the sender is given.
+	BeforeAccept func(OfferedFile) error
+	// AfterReceive, when set, runs for each finalized file, in order, once the
+	// connection's transfers are over and its serve slot is released, so neither
+	// the sender nor other transfers wait on it. Its error is reported on Out and
+	// leaves the file in place.
+	AfterReceive func(ReceivedFile) error
+	// Observer receives each file's offer, accepted, resume, progress, verified,
+	// and done events. Defaults to progress.NewPlain(Out).
+	Observer progress.Observer
 }
 
 // ReceiveOnce listens and serves one incoming transfer.
 func ReceiveOnce(opts ReceiverOptions) error {
-	if opts.Listen == "" || opts.OutDir == "" {
-		return fmt.Errorf("missing required receiver options: %w", apperrors.ErrUsage)
+	ln, stop, err := listen(&opts)
+	if err != nil {
+		return err
+	}
+
+	conn, err := ln.Accept()
+	if err != nil {
+		stop()
+		return fmt.Errorf("accept connection: %w: %w", err, apperrors.ErrNetwork)
+	}
+	defer func() { _ = conn.Close() }()
+
+	// A striped sender opens more connections for the same transfer. Keep accepting
+	// until the transfer ends, but only to let them attach.
+	opts.Out = &lockedWriter{w: opts.Out}
+	active := &connSet{conns: map[net.Conn]struct{}{}}
+	var wg sync.WaitGroup
+	accepting := make(chan struct{})
+	extras := connPolicy{handshake: handshakeSlots(opts.Timeouts), attachOnly: true}
+	go func() {
+		defer close(accepting)
+		for {
+			extra, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			active.add(extra)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer active.remove(extra)
+				if err := handleConnection(extra, opts, extras); err != nil {
+					_, _ = fmt.Fprintf(opts.Out, "stream from %s failed: %v\n", extra.RemoteAddr(), err)
+				}
+			}()
+		}
+	}()
+	defer func() {
+		stop()
+		<-accepting
+		active.closeAll()
+		wg.Wait()
+	}()
+	return handleConnection(conn, opts, connPolicy{})
+}
+
+// listen validates options, opens the listener, and runs the on-listening callback.
+// The returned stop function closes the listener and ends advertisement.
+func listen(opts *ReceiverOptions) (net.Listener, func(), error) {
+	if opts.Listen == "" || (opts.OutDir == "" && opts.Sink == nil) {
+		return nil, nil, fmt.Errorf("missing required receiver options: %w", apperrors.ErrUsage)
 	}
 	if opts.Out == nil {
 		opts.Out = io.Discard
 	}
-	if err := os.MkdirAll(opts.OutDir, 0o755); err != nil {
-		return fmt.Errorf("create output dir: %w: %w", err, apperrors.ErrIO)
+	if opts.OutDir != "" {
+		if err := os.MkdirAll(opts.OutDir, 0o755); err != nil {
+			return nil, nil, fmt.Errorf("create output dir: %w: %w", err, apperrors.ErrIO)
+		}
 	}
-	ln, err := net.Listen("tcp", opts.Listen)
+	return openListener(opts.Listen, opts.OnListening, opts.Out)
+}
+
+// openListener listens on addr, runs the on-listening callback, and announces the
+// address on out.
+func openListener(addr string, onListening func(net.Addr) (func(), error), out io.Writer) (net.Listener, func(), error) {
+	ln, err := net.Listen("tcp", addr)
 	if err != nil {
-		return fmt.Errorf("listen on %s: %w: %w", opts.Listen, err, apperrors.ErrNetwork)
+		return nil, nil, fmt.Errorf("listen on %s: %w: %w", addr, err, apperrors.ErrNetwork)
 	}
-	defer func() { _ = ln.Close() }()
-	var stopAdvertise func()
-	if opts.OnListening != nil {
-		cleanup, cbErr := opts.OnListening(ln.Addr())
+	stopAdvertise := func() {}
+	if onListening != nil {
+		cleanup, cbErr := onListening(ln.Addr())
 		if cbErr != nil {
-			return fmt.Errorf("receiver on-listening callback: %w", cbErr)
+			_ = ln.Close()
+			return nil, nil, fmt.Errorf("on-listening callback: %w", cbErr)
+		}
+		if cleanup != nil {
+			stopAdvertise = cleanup
 		}
-		stopAdvertise = cleanup
-	}
-	if stopAdvertise != nil {
-		defer stopAdvertise()
 	}
-	_, _ = fmt.Fprintf(opts.Out, "listening on %s\n", ln.Addr().String())
+	_, _ = fmt.Fprintf(out, "listening on %s\n", ln.Addr().String())
+	return ln, func() {
+		stopAdvertise()
+		_ = ln.Close()
+	}, nil
+}
 
-	conn, err := ln.Accept()
-	if err != nil {
-		return fmt.Errorf("accept connection: %w: %w", err, apperrors.ErrNetwork)
+// connPolicy limits what one accepted connection may start.
+type connPolicy struct {
+	// handshake, when set, blocks until the connection may run greet and returns
+	// its release func.
+	handshake func() (func(), error)
+	// attachOnly refuses new transfers; the connection may only join a striped one.
+	attachOnly bool
+	// admit, when set, blocks until a new transfer may start and returns its release func.
+	admit func() (func(), error)
+	// observeErrors reports a failed connection to the observer as an error event
+	// naming the same peer as the transfer's other events.
+	observeErrors bool
+}
+
+// observeFailure reports err, if any, as an error event for peer when the policy
+// observes errors.
+func (p connPolicy) observeFailure(o progress.Observer, direction, peer string, err error) {
+	if !p.observeErrors || err == nil {
+		return
 	}
-	defer func() { _ = conn.Close() }()
-	return HandleConnection(conn, opts)
+	e := progress.ErrorEvent(err)
+	e.Direction, e.Peer = direction, peer
+	o.Observe(e)
+}
+
+// receiverSession carries per-connection receiver state.
+type receiverSession struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	writer  *bufio.Writer
+	peer    string
+	remote  *PeerIdentity
+	trusted bool
+	hello   HelloPayload
+	opts    ReceiverOptions
+	// received lists finalized files waiting for AfterReceive.
+	received []ReceivedFile
 }
 
 // HandleConnection serves one accepted connection transfer session.
 func HandleConnection(conn net.Conn, opts ReceiverOptions) error {
-	reader := bufio.NewReader(conn)
-	writer := bufio.NewWriter(conn)
+	return handleConnection(conn, opts, connPolicy{})
+}
+
+func handleConnection(conn net.Conn, opts ReceiverOptions, policy connPolicy) (err error) {
 	peer := conn.RemoteAddr().String()
+	defer func() { policy.observeFailure(opts.Observer, "receiving", peer, err) }()
+	s, err := policy.greet(conn, opts)
+	if err != nil {
+		return err
+	}
+	peer = s.peer
+	defer s.runAfterReceive()
+	first, err := readFrame(s.reader, s.writer)
+	if err != nil {
+		return fmt.Errorf("read offer frame: %w", err)
+	}
+	if first.Type == TypeAttach {
+		if !s.hello.Has(CapStriping | CapChunkDigests) {
+			return sendProtocolError(s.writer, "striping was not negotiated")
+		}
+		return s.attachStripe(s.conn, first)
+	}
+	if first.Type == TypeOffer || first.Type == TypeBatch {
+		if policy.attachOnly {
+			_ = sendErrorFrame(s.writer, "receiver busy")
+			return fmt.Errorf("transfer from %s refused while busy: %w", s.peer, apperrors.ErrRejected)
+		}
+		if policy.admit != nil {
+			release, err := policy.admit()
+			if err != nil {
+				_ = sendErrorFrame(s.writer, "receiver shutting down")
+				return err
+			}
+			defer release()
+		}
+	}
+	switch first.Type {
+	case TypeOffer:
+		return s.receiveFile(first, false)
+	case TypeBatch:
+		if !s.hello.Has(CapMultiFile) {
+			return sendProtocolError(s.writer, "directory transfers were not negotiated")
+		}
+		return s.receiveBatch(first)
+	default:
+		return sendProtocolError(s.writer, fmt.Sprintf("expected OFFER, got %d", first.Type))
+	}
+}
 
-	hello, err := ReadFrame(reader)
+// greet runs greet once the policy's handshake slot allows it.
+func (policy connPolicy) greet(conn net.Conn, opts ReceiverOptions) (*receiverSession, error) {
+	if policy.handshake == nil {
+		return greet(conn, opts)
+	}
+	release, err := policy.handshake()
 	if err != nil {
-		return fmt.Errorf("read hello frame: %w", err)
+		return nil, fmt.Errorf("greet %s: %w", conn.RemoteAddr(), err)
+	}
+	defer release()
+	return greet(conn, opts)
+}
+
+// greet answers a dialing peer: it secures the connection as configured and
+// negotiates capabilities, returning the session ready for the first request.
+// The session's connection enforces opts.Timeouts.
+func greet(raw net.Conn, opts ReceiverOptions) (*receiverSession, error) {
+	if tcp, ok := raw.(*net.TCPConn); ok && opts.Limit.Rate() != throttle.Unlimited {
+		// A small window keeps a limited receiver from hiding megabytes in flight,
+		// which the sender would otherwise wait out after DONE as a stalled peer.
+		_ = tcp.SetReadBuffer(limitedWindow)
+	}
+	if opts.Observer == nil {
+		opts.Observer = progress.NewPlain(opts.Out)
+	}
+	conn := withTimeouts(raw, opts.Timeouts)
+	s := &receiverSession{conn: conn, reader: bufio.NewReader(conn), writer: bufio.NewWriter(conn), peer: conn.RemoteAddr().String(), opts: opts}
+
+	hello, err := ReadFrame(s.reader)
+	if err != nil {
+		return nil, fmt.Errorf("read hello frame: %w: %w", err, apperrors.ErrNetwork)
+	}
+	if hello.Type == TypePake || hello.Type == TypeHandshake || opts.Code != "" || opts.RequireEncryption {
+		if err := s.secure(conn, hello); err != nil {
+			return nil, err
+		}
+		hello, err = ReadFrame(s.reader)
+		if err != nil {
+			return nil, fmt.Errorf("read hello frame: %w: %w", err, apperrors.ErrNetwork)
+		}
 	}
 	if hello.Type != TypeHello {
-		return sendProtocolError(writer, fmt.Sprintf("expected HELLO, got %d", hello.Type))
+		return nil, sendProtocolError(s.writer, fmt.Sprintf("expected HELLO, got %d", hello.Type))
+	}
+	if err := s.negotiate(hello); err != nil {
+		return nil, err
 	}
-	offerFrame, err := ReadFrame(reader)
+	conn.negotiated()
+	return s, nil
+}
+
+// negotiate answers a capability HELLO with the negotiated set. An empty HELLO comes
+// from a v1 peer, which gets no reply and only the legacy feature set.
+func (s *receiverSession) negotiate(frame Frame) error {
+	if len(frame.Payload) == 0 {
+		s.hello = legacyHello
+		return nil
+	}
+	remote, err := DecodeHello(frame.Payload)
 	if err != nil {
-		return fmt.Errorf("read offer frame: %w", err)
+		_ = sendProtocolError(s.writer, "invalid hello payload")
+		return fmt.Errorf("decode hello: %w", err)
 	}
-	if offerFrame.Type != TypeOffer {
-		return sendProtocolError(writer, fmt.Sprintf("expected OFFER, got %d", offerFrame.Type))
+	local := localHello(hash.Default)
+	if s.opts.Sink != nil {
+		// A sink takes one file in order with no basis, so directories, striping,
+		// and deltas are off.
+		local.Caps &^= CapMultiFile | CapStriping | CapDelta
 	}
-	offer, err := DecodeOffer(offerFrame.Payload)
+	agreed, err := Negotiate(local, remote)
 	if err != nil {
-		_ = sendProtocolError(writer, "invalid offer payload")
-		return fmt.Errorf("decode offer: %w", err)
+		_ = sendErrorFrame(s.writer, err.Error())
+		return fmt.Errorf("negotiate with %s: %w", s.peer, err)
+	}
+	payload, err := EncodeHello(agreed)
+	if err != nil {
+		return fmt.Errorf("encode hello reply: %w", err)
+	}
+	if err := WriteFrame(s.writer, Frame{Type: TypeHello, Payload: payload}); err != nil {
+		return fmt.Errorf("send hello reply: %w: %w", err, apperrors.ErrNetwork)
+	}
+	if err := s.writer.Flush(); err != nil {
+		return fmt.Errorf("flush hello reply: %w: %w", err, apperrors.ErrNetwork)
+	}
+	s.hello = agreed
+	return nil
+}
+
+// secure establishes the pairing or identity channel requested by the first frame.
+func (s *receiverSession) secure(conn net.Conn, first Frame) error {
+	switch {
+	case s.opts.Code != "":
+		if first.Type != TypePake {
+			_ = sendErrorFrame(s.writer, "pairing code required")
+			return fmt.Errorf("connection from %s did not pair: %w", s.peer, apperrors.ErrPairing)
+		}
+		channel, err := serverPairing(s.reader, s.writer, conn, first, s.opts.Code)
+		if err != nil {
+			return fmt.Errorf("pairing with %s: %w", s.peer, err)
+		}
+		_, _ = fmt.Fprintf(s.opts.Out, "Paired with %s using code\n", s.peer)
+		s.reader, s.writer = bufio.NewReader(channel), bufio.NewWriter(channel)
+		return nil
+	case first.Type == TypePake:
+		_ = sendErrorFrame(s.writer, "pairing not enabled")
+		return fmt.Errorf("unexpected pairing from %s: %w", s.peer, apperrors.ErrPairing)
+	case first.Type == TypeHandshake:
+		return s.acceptSecure(conn, first)
+	default:
+		_ = sendErrorFrame(s.writer, "encryption required")
+		return fmt.Errorf("plaintext connection from %s refused: %w", s.peer, apperrors.ErrAuth)
+	}
+}
+
+// acceptSecure completes the server handshake and switches to buffered encrypted streams.
+func (s *receiverSession) acceptSecure(conn net.Conn, first Frame) error {
+	if s.opts.Identity == nil {
+		_ = sendErrorFrame(s.writer, "encryption not supported")
+		return fmt.Errorf("encrypted connection from %s without local identity: %w", s.peer, apperrors.ErrAuth)
+	}
+	channel, remote, err := serverHandshake(s.reader, s.writer, conn, first, *s.opts.Identity)
+	if err != nil {
+		return fmt.Errorf("handshake with %s: %w", s.peer, err)
+	}
+	s.reader, s.writer = bufio.NewReader(channel), bufio.NewWriter(channel)
+	if s.opts.VerifyPeer != nil {
+		if err := s.opts.VerifyPeer(remote); err != nil {
+			_ = sendErrorFrame(s.writer, "peer not authorized")
+			return fmt.Errorf("verify sender %s: %w: %w", remote.PeerID, err, apperrors.ErrAuth)
+		}
+	}
+	if s.opts.Trust != nil {
+		trusted, err := s.opts.Trust(remote)
+		if err != nil {
+			_ = sendErrorFrame(s.writer, "peer not authorized")
+			return fmt.Errorf("check trust for sender %s: %w: %w", remote.PeerID, err, apperrors.ErrAuth)
+		}
+		s.trusted = trusted
+	}
+	_, _ = fmt.Fprintf(s.opts.Out, "Encrypted session with %s (fingerprint %s)\n", remote.PeerID, remote.Fingerprint)
+	if s.trusted {
+		_, _ = fmt.Fprintf(s.opts.Out, "Peer %s is trusted\n", remote.PeerID)
+	}
+	s.remote = &remote
+	s.peer = remote.PeerID + "@" + s.peer
+	return nil
+}
+
+// keepalive runs a slow local step while pinging the waiting peer.
+func (s *receiverSession) keepalive(work func() error) error {
+	return keepalive(s.reader, s.writer, s.hello, work)
+}
+
+func (s *receiverSession) receiveBatch(frame Frame) error {
+	batch, err := DecodeBatch(frame.Payload)
+	if err != nil {
+		_ = sendProtocolError(s.writer, "invalid batch payload")
+		return fmt.Errorf("decode batch: %w", err)
+	}
+	if err := s.admitPeer(); err != nil {
+		return err
+	}
+	if err := s.acceptTransfer(batch.Name+"/", batch.TotalBytes); err != nil {
+		return err
 	}
+	if err := WriteFrame(s.writer, Frame{Type: TypeAccept, Payload: EncodeAccept(0, batch.SessionID)}); err != nil {
+		return fmt.Errorf("send batch accept frame: %w", err)
+	}
+	if err := s.writer.Flush(); err != nil {
+		return fmt.Errorf("flush batch accept frame: %w: %w", err, apperrors.ErrNetwork)
+	}
+	_, _ = fmt.Fprintf(s.opts.Out, "Receiving directory %s (%d files, %d directories, %d bytes)\n", batch.Name, batch.Files, batch.Dirs, batch.TotalBytes)
 
-	accept := opts.AutoAccept
-	if !opts.AutoAccept {
-		if opts.Prompt == nil {
-			accept = false
-		} else {
-			choice, promptErr := opts.Prompt(offer.Name, offer.Size, peer)
-			if promptErr != nil {
-				_ = sendErrorFrame(writer, "receiver prompt failed")
-				return fmt.Errorf("prompt accept transfer: %w", promptErr)
+	var files, dirs uint32
+	for files < batch.Files || dirs < batch.Dirs {
+		if interrupted(s.opts.Cancel) {
+			return stopForUser(s.writer, s.hello)
+		}
+		entry, err := readFrame(s.reader, s.writer)
+		if err != nil {
+			return fmt.Errorf("read batch entry after %d of %d files: %w: %w", files, batch.Files, err, apperrors.ErrNetwork)
+		}
+		switch entry.Type {
+		case TypeCancel:
+			return cancelError("sender", entry.Payload)
+		case TypeOffer:
+			if files == batch.Files {
+				_ = sendErrorFrame(s.writer, "more files than announced")
+				return fmt.Errorf("batch exceeded announced file count: %w", apperrors.ErrInvalidProtocol)
+			}
+			if err := s.receiveFile(entry, true); err != nil {
+				return err
+			}
+			files++
+		case TypeMkdir:
+			if dirs == batch.Dirs {
+				_ = sendErrorFrame(s.writer, "more directories than announced")
+				return fmt.Errorf("batch exceeded announced directory count: %w", apperrors.ErrInvalidProtocol)
+			}
+			name, decErr := DecodeMkdir(entry.Payload)
+			if decErr != nil {
+				_ = sendProtocolError(s.writer, "invalid mkdir payload")
+				return fmt.Errorf("decode mkdir: %w", decErr)
+			}
+			dir := filepath.Join(s.opts.OutDir, sanitize.SafeRelativePath(name))
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				_ = sendErrorFrame(s.writer, "unable to create directory")
+				return fmt.Errorf("create directory %s: %w: %w", dir, err, apperrors.ErrIO)
 			}
-			accept = choice
+			dirs++
+		default:
+			return sendProtocolError(s.writer, fmt.Sprintf("expected OFFER or MKDIR, got %d", entry.Type))
 		}
 	}
+	_, _ = fmt.Fprintf(s.opts.Out, "Directory complete: %d files, %d directories.\n", files, dirs)
+	return nil
+}
+
+// acceptTransfer applies the receiver accept policy and reports rejections to the sender.
+// RejectUntrusted is checked first so AutoAccept never admits an untrusted peer
+// the receiver was told to refuse.
+func (s *receiverSession) acceptTransfer(name string, size uint64) error {
+	var accept bool
+	switch {
+	case !s.trusted && s.opts.RejectUntrusted:
+		_ = sendErrorFrame(s.writer, "untrusted peer")
+		return fmt.Errorf("transfer from untrusted peer %s rejected: %w", s.peer, apperrors.ErrRejected)
+	case s.opts.AutoAccept || s.trusted:
+		accept = true
+	case s.opts.Prompt != nil:
+		var choice bool
+		var promptErr error
+		// Ping the waiting peer so a slow answer does not look like a stall.
+		if err := s.keepalive(func() error {
+			choice, promptErr = s.opts.Prompt(name, size, s.peer)
+			return nil
+		}); err != nil {
+			return fmt.Errorf("sender %s went away during prompt: %w", s.peer, err)
+		}
+		if promptErr != nil {
+			_ = sendErrorFrame(s.writer, "receiver prompt failed")
+			return fmt.Errorf("prompt accept transfer: %w", promptErr)
+		}
+		accept = choice
+	}
 	if !accept {
-		_ = sendErrorFrame(writer, "transfer rejected")
+		_ = sendErrorFrame(s.writer, "transfer rejected")
 		return fmt.Errorf("transfer rejected by receiver: %w", apperrors.ErrRejected)
 	}
+	return nil
+}
+
+// admitPeer applies the receive policy to the sending peer and moves the
+// session's output into the peer's subdirectory.
+func (s *receiverSession) admitPeer() error {
+	if s.opts.Policy == nil {
+		return nil
+	}
+	peer := policy.Peer{Addr: s.conn.RemoteAddr()}
+	if s.remote != nil {
+		peer.ID, peer.Fingerprint, peer.Verified = s.remote.PeerID, s.remote.Fingerprint, s.trusted
+	}
+	if err := s.opts.Policy.AllowPeer(peer); err != nil {
+		return s.refuse(err)
+	}
+	if dir := s.opts.Policy.Subdir(peer); dir != "" {
+		s.opts.OutDir = filepath.Join(s.opts.OutDir, dir)
+	}
+	return nil
+}
+
+// admitFile applies the receive policy to one offered file.
+func (s *receiverSession) admitFile(offer OfferPayload) error {
+	if s.opts.Policy == nil {
+		return nil
+	}
+	if err := s.opts.Policy.AllowFile(offer.Name, offer.Size, offer.Size != UnknownSize); err != nil {
+		return s.refuse(err)
+	}
+	return nil
+}
+
+// vetoOffer gives BeforeAccept its say on one offer, pinging the sender meanwhile.
+func (s *receiverSession) vetoOffer(offer OfferPayload) error {
+	if s.opts.BeforeAccept == nil {
+		return nil
+	}
+	var veto error
+	if err := s.keepalive(func() error {
+		veto = s.opts.BeforeAccept(s.offered(offer))
+		return nil
+	}); err != nil {
+		return fmt.Errorf("sender %s went away during pre-accept hook: %w", s.peer, err)
+	}
+	if veto != nil {
+		return s.refuse(veto)
+	}
+	return nil
+}
 
-	paths, err := resume.ResolvePaths(opts.OutDir, offer.Name, opts.Overwrite)
+// offered describes offer from this session's peer.
+func (s *receiverSession) offered(offer OfferPayload) OfferedFile {
+	f := OfferedFile{Name: offer.Name, Size: offer.Size, Peer: s.peer}
+	if s.remote != nil {
+		f.PeerID, f.Fingerprint = s.remote.PeerID, s.remote.Fingerprint
+	}
+	return f
+}
+
+// event describes offer from this session's peer as an event of type kind, or as
+// the base of a reporter's events when kind is empty.
+func (s *receiverSession) event(kind string, offer OfferPayload) progress.Event {
+	e := progress.Event{Type: kind, Direction: "receiving", Name: offer.Name, Peer: s.peer, Total: offer.Size}
+	if offer.Size == UnknownSize {
+		e.Total = 0
+	}
+	return e
+}
+
+// runAfterReceive passes each file the connection finalized to AfterReceive.
+func (s *receiverSession) runAfterReceive() {
+	for _, f := range s.received {
+		if err := s.opts.AfterReceive(f); err != nil {
+			_, _ = fmt.Fprintf(s.opts.Out, "Post-receive hook for %s failed: %v\n", f.Path, err)
+		}
+	}
+}
+
+// refuse tells the sender why the policy refused its transfer.
+func (s *receiverSession) refuse(reason error) error {
+	_ = sendCancel(s.writer, s.hello, CancelPolicy, reason.Error())
+	return fmt.Errorf("transfer from %s refused: %w: %w: %w", s.peer, reason, apperrors.ErrPolicy, apperrors.ErrRejected)
+}
+
+// receiveFile serves one OFFER through DONE. Pre-accepted offers belong to an accepted batch.
+func (s *receiverSession) receiveFile(offerFrame Frame, preAccepted bool) error {
+	offer, err := DecodeOffer(offerFrame.Payload)
 	if err != nil {
-		_ = sendErrorFrame(writer, "unable to resolve output path")
+		_ = sendProtocolError(s.writer, "invalid offer payload")
+		return fmt.Errorf("decode offer: %w", err)
+	}
+	if offer.Size == UnknownSize && !s.hello.Has(CapStreaming) {
+		return sendProtocolError(s.writer, "streamed transfers were not negotiated")
+	}
+	s.opts.Observer.Observe(s.event(progress.EventOffer, offer))
+	if !preAccepted {
+		if err := s.admitPeer(); err != nil {
+			return err
+		}
+	}
+	if err := s.admitFile(offer); err != nil {
+		return err
+	}
+	if err := s.vetoOffer(offer); err != nil {
+		return err
+	}
+	if !preAccepted {
+		if err := s.acceptTransfer(offer.Name, offer.Size); err != nil {
+			return err
+		}
+	}
+	if offer.Size == UnknownSize || s.opts.Sink != nil {
+		return s.receiveStream(offer)
+	}
+
+	paths, err := resume.ResolvePaths(s.opts.OutDir, offer.Name, s.opts.Overwrite)
+	if err != nil {
+		_ = sendErrorFrame(s.writer, "unable to resolve output path")
 		return fmt.Errorf("resolve output paths: %w: %w", err, apperrors.ErrIO)
 	}
-	lock, err := resume.AcquireLock(paths.Lock, offer.SessionID, peer, opts.BreakLock)
+	if err := os.MkdirAll(filepath.Dir(paths.Final), 0o755); err != nil {
+		_ = sendErrorFrame(s.writer, "unable to create output directory")
+		return fmt.Errorf("create output directory: %w: %w", err, apperrors.ErrIO)
+	}
+	lock, err := resume.AcquireLock(paths.Lock, offer.SessionID, s.peer, s.opts.BreakLock)
 	if err != nil {
-		_ = sendErrorFrame(writer, err.Error())
+		_ = sendErrorFrame(s.writer, err.Error())
 		return err
 	}
 	defer lock.Release()
 
-	resumeOffset, err := prepareResumeState(paths, offer, opts)
+	resumeOffset, err := prepareResumeState(paths, offer, s.opts)
 	if err != nil {
-		_ = sendErrorFrame(writer, err.Error())
+		_ = sendErrorFrame(s.writer, err.Error())
 		return err
 	}
+	alg := hash.Algorithm(s.hello.Hashes[0])
+	chunked := s.hello.Has(CapChunkDigests)
+	var blockSize uint64
+	var prefix [][]byte
+	var prior resume.Meta
+	if chunked {
+		blockSize = IntegrityBlockSize
+		if err := s.keepalive(func() error {
+			var stale bool
+			if prior, stale = priorBlocks(paths, alg, offer.Size); stale {
+				// The partial no longer holds what its saved digests describe.
+				_ = os.Truncate(paths.Partial, 0)
+				resumeOffset = 0
+			}
+			prefix, resumeOffset = resumeLeaves(paths, prior, resumeOffset, alg, offer.Size)
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
 	if resumeOffset > 0 {
-		_, _ = fmt.Fprintf(opts.Out, "Resuming at offset %d (%.2f%%)\n", resumeOffset, (float64(resumeOffset)/float64(offer.Size))*100)
+		_, _ = fmt.Fprintf(s.opts.Out, "Resuming at offset %d (%.2f%%)\n", resumeOffset, (float64(resumeOffset)/float64(offer.Size))*100)
+		e := s.event(progress.EventResume, offer)
+		e.Offset = resumeOffset
+		s.opts.Observer.Observe(e)
+	}
+	if err := s.checkSpace(paths.Partial, resumeOffset, offer.Size); err != nil {
+		return err
 	}
 
-	if err := WriteFrame(writer, Frame{Type: TypeAccept, Payload: EncodeAccept(resumeOffset, offer.SessionID)}); err != nil {
+	if err := WriteFrame(s.writer, Frame{Type: TypeAccept, Payload: EncodeAccept(resumeOffset, offer.SessionID)}); err != nil {
 		return fmt.Errorf("send accept frame: %w", err)
 	}
-	if err := writer.Flush(); err != nil {
+	if err := s.writer.Flush(); err != nil {
 		return fmt.Errorf("flush accept frame: %w: %w", err, apperrors.ErrNetwork)
 	}
+	accepted := s.event(progress.EventAccepted, offer)
+	accepted.Offset = resumeOffset
+	s.opts.Observer.Observe(accepted)
 
 	file, err := os.OpenFile(filepath.Clean(paths.Partial), os.O_CREATE|os.O_RDWR, 0o644)
 	if err != nil {
@@ -153,110 +716,410 @@ func HandleConnection(conn net.Conn, opts ReceiverOptions) error {
 		_ = file.Close()
 		return fmt.Errorf("seek partial output file: %w: %w", err, apperrors.ErrIO)
 	}
+	if err := s.reserve(file, resumeOffset, offer.Size); err != nil {
+		_ = file.Close()
+		return err
+	}
 
 	cleanup := true
 	preservePartial := false
 	defer func() {
 		_ = file.Close()
-		if cleanup && !opts.KeepPartial && !preservePartial {
+		if cleanup && !s.opts.KeepPartial && !preservePartial {
 			_ = os.Remove(paths.Partial)
-			_ = os.Remove(paths.Meta)
+			resume.RemoveMeta(paths.Meta)
 		}
 	}()
 
 	meta := resume.Meta{ExpectedSize: offer.Size, ReceivedOffset: resumeOffset, OriginalName: offer.Name, SessionID: offer.SessionID}
+	if chunked {
+		meta.Algorithm, meta.BlockSize, meta.Leaves = uint8(alg), blockSize, prefix
+		if len(prior.Ranges) > 0 {
+			// Keep striped progress until the sender says whether it stripes again.
+			meta.Leaves, meta.Ranges = prior.Leaves, prior.Ranges
+		}
+	}
+	var leaves *resume.LeafFile
+	if chunked {
+		if leaves, err = resume.OpenLeafFile(paths.Meta, alg.Size()); err != nil {
+			return fmt.Errorf("open block digests: %w: %w", err, apperrors.ErrIO)
+		}
+		defer func() { _ = leaves.Close() }()
+		if err := leaves.PutAll(meta.Leaves); err != nil {
+			return s.writeFailure(s.writer, "write block digests", err)
+		}
+		if err := leaves.Sync(); err != nil {
+			return s.writeFailure(s.writer, "sync block digests", err)
+		}
+	}
 	if err := resume.SaveMetaAtomic(paths.Meta, meta); err != nil {
 		return fmt.Errorf("write initial resume metadata: %w: %w", err, apperrors.ErrIO)
 	}
-	hasher, err := hash.New()
+	tree, err := hash.NewTree(alg, blockSize, prefix)
 	if err != nil {
 		return fmt.Errorf("create receiver hasher: %w", err)
 	}
 
-	reporter := progress.NewReporter(opts.Out, "receiving", offer.Size)
+	reporter := progress.NewReporter(s.opts.Observer, s.event("", offer))
 	written := resumeOffset
+	verified := len(prefix)
 	lastMetaSync := resumeOffset
-	for written < offer.Size {
-		frame, readErr := ReadFrame(reader)
-		if readErr != nil {
-			preservePartial = true
-			return fmt.Errorf("read data frame: %w: %w", readErr, apperrors.ErrNetwork)
+	lastLeafSync := verified
+	saveProgress := func(offset uint64) error {
+		meta.ReceivedOffset = offset
+		if chunked {
+			// Verified digests were written to the leaf file as they arrived.
+			if err := leaves.Sync(); err != nil {
+				return err
+			}
+			meta.Leaves, meta.Ranges = tree.Leaves()[:verified], nil
 		}
-		if frame.Type != TypeData {
-			_ = sendErrorFrame(writer, "expected DATA frame")
-			return fmt.Errorf("expected DATA frame, got %d: %w", frame.Type, apperrors.ErrInvalidProtocol)
+		return resume.SaveMetaAtomic(paths.Meta, meta)
+	}
+	verifiedOffset := func() uint64 {
+		if end := uint64(verified) * IntegrityBlockSize; end < written {
+			return end
 		}
-		if written+uint64(len(frame.Payload)) > offer.Size {
-			_ = sendErrorFrame(writer, "received more data than offered")
-			return fmt.Errorf("received more bytes than expected: %w", apperrors.ErrInvalidProtocol)
+		return written
+	}
+
+	var inflate decompressor
+	var basis *deltaBasis
+	defer func() { basis.close() }()
+	// keepProgress records how far the partial got so a later transfer resumes there.
+	keepProgress := func() {
+		preservePartial = true
+		if file.Sync() == nil {
+			offset := written
+			if chunked {
+				offset = verifiedOffset()
+			}
+			_ = saveProgress(offset)
 		}
-		n, werr := file.Write(frame.Payload)
-		if werr != nil || n != len(frame.Payload) {
-			return fmt.Errorf("write output file: %w: %w", werr, apperrors.ErrIO)
+	}
+
+	var done Frame
+	data := newDataReader(s)
+receive:
+	for {
+		if interrupted(s.opts.Cancel) {
+			if s.opts.Resume {
+				keepProgress()
+			}
+			return stopForUser(s.writer, s.hello)
 		}
-		if resumeOffset == 0 {
-			if _, err := hasher.Write(frame.Payload); err != nil {
-				return fmt.Errorf("hash received chunk: %w", err)
+		frame, readErr := data.next()
+		if readErr != nil {
+			keepProgress()
+			return fmt.Errorf("read data frame: %w: %w", readErr, apperrors.ErrNetwork)
+		}
+		if frame.Type == TypeCompressed && s.hello.Has(CapCompression) {
+			plain, err := inflate.data(frame)
+			if err != nil {
+				_ = sendErrorFrame(s.writer, "invalid compressed data")
+				return err
 			}
+			frame = Frame{Type: TypeData, Payload: plain}
 		}
-		written += uint64(n)
-		reporter.Update(written)
-		if written-lastMetaSync >= resumeMetaUpdateBytes {
-			meta.ReceivedOffset = written
-			if err := resume.SaveMetaAtomic(paths.Meta, meta); err != nil {
-				return fmt.Errorf("periodic resume metadata update: %w: %w", err, apperrors.ErrIO)
+		if frame.Type == TypeCopy && basis != nil {
+			data, err := basis.read(frame.Payload)
+			if err != nil {
+				_ = sendErrorFrame(s.writer, "invalid copy frame")
+				return err
 			}
-			lastMetaSync = written
+			frame = Frame{Type: TypeData, Payload: data}
+		}
+		switch {
+		case frame.Type == TypeData:
+			if written+uint64(len(frame.Payload)) > offer.Size {
+				_ = sendErrorFrame(s.writer, "received more data than offered")
+				return fmt.Errorf("received more bytes than expected: %w", apperrors.ErrInvalidProtocol)
+			}
+			n, werr := file.Write(frame.Payload)
+			if werr != nil || n != len(frame.Payload) {
+				if diskFull(werr) && s.opts.Resume {
+					keepProgress()
+				}
+				return s.writeFailure(s.writer, "write output file", werr)
+			}
+			if chunked || resumeOffset == 0 {
+				if _, err := tree.Write(frame.Payload); err != nil {
+					return fmt.Errorf("hash received chunk: %w", err)
+				}
+			}
+			written += uint64(n)
+			reporter.Update(written)
+			if !chunked && written-lastMetaSync >= resumeMetaUpdateBytes {
+				if err := saveProgress(written); err != nil {
+					return s.writeFailure(s.writer, "periodic resume metadata update", err)
+				}
+				lastMetaSync = written
+			}
+		case frame.Type == TypeChunk && chunked:
+			index, digest, decErr := DecodeChunkDigest(frame.Payload)
+			if decErr != nil {
+				_ = sendProtocolError(s.writer, "invalid chunk payload")
+				return fmt.Errorf("decode chunk digest: %w", decErr)
+			}
+			if written == offer.Size && len(tree.Leaves()) == verified {
+				tree.Finish()
+			}
+			if index != uint64(verified) || verified >= len(tree.Leaves()) {
+				return sendProtocolError(s.writer, fmt.Sprintf("unexpected digest for block %d", index))
+			}
+			if subtle.ConstantTimeCompare(tree.Leaves()[verified], digest) != 1 {
+				start := index * IntegrityBlockSize
+				end := start + IntegrityBlockSize
+				if end > offer.Size {
+					end = offer.Size
+				}
+				// Keep the verified prefix so a retry resends only the damaged block onward.
+				if file.Truncate(int64(start)) == nil && file.Sync() == nil && saveProgress(start) == nil {
+					preservePartial = s.opts.Resume
+				}
+				msg := fmt.Sprintf("integrity check failed for bytes %d-%d", start, end)
+				_ = sendErrorFrame(s.writer, msg)
+				return fmt.Errorf("%s: %w", msg, apperrors.ErrIntegrity)
+			}
+			if err := leaves.Put(verified, digest); err != nil {
+				return s.writeFailure(s.writer, "record block digest", err)
+			}
+			verified++
+			if verified-lastLeafSync >= leafMetaSyncInterval {
+				if err := file.Sync(); err != nil {
+					return s.writeFailure(s.writer, "sync output file", err)
+				}
+				if err := saveProgress(verifiedOffset()); err != nil {
+					return s.writeFailure(s.writer, "periodic resume metadata update", err)
+				}
+				lastLeafSync = verified
+			}
+		case frame.Type == TypeStripe && chunked && s.hello.Has(CapStriping) && written == resumeOffset && verified == len(prefix):
+			preservePartial = true
+			if err := s.receiveStriped(frame, file, leaves, paths, meta, offer, reporter); err != nil {
+				return err
+			}
+			cleanup = false
+			return nil
+		case frame.Type == TypeDelta && s.hello.Has(CapDelta) && basis == nil && written == 0:
+			// The basis is the file the offer would replace, even when the
+			// result lands beside it because overwriting is off.
+			existing, err := resume.ResolvePaths(s.opts.OutDir, offer.Name, true)
+			if err != nil {
+				_ = sendErrorFrame(s.writer, "unable to resolve basis path")
+				return fmt.Errorf("resolve basis path: %w: %w", err, apperrors.ErrIO)
+			}
+			if basis, err = s.sendSignature(existing.Final, alg); err != nil {
+				return err
+			}
+		case frame.Type == TypeDone:
+			done = frame
+			break receive
+		case frame.Type == TypeCancel:
+			reason, _, _ := DecodeCancel(frame.Payload)
+			if reason.keepsPartial() && s.opts.Resume {
+				keepProgress()
+			}
+			return cancelError("sender", frame.Payload)
+		default:
+			_ = sendErrorFrame(s.writer, "expected DATA frame")
+			return fmt.Errorf("expected DATA frame, got %d: %w", frame.Type, apperrors.ErrInvalidProtocol)
 		}
 	}
-	meta.ReceivedOffset = written
-	if err := resume.SaveMetaAtomic(paths.Meta, meta); err != nil {
-		return fmt.Errorf("final resume metadata update: %w: %w", err, apperrors.ErrIO)
-	}
-
-	done, err := ReadFrame(reader)
-	if err != nil {
-		preservePartial = true
-		return fmt.Errorf("read done frame: %w: %w", err, apperrors.ErrNetwork)
+	if basis != nil && basis.reused > 0 {
+		_, _ = fmt.Fprintf(s.opts.Out, "Reused %d bytes of the existing file\n", basis.reused)
 	}
-	if done.Type != TypeDone {
-		return fmt.Errorf("expected DONE frame, got %d: %w", done.Type, apperrors.ErrInvalidProtocol)
+	if written != offer.Size {
+		return sendProtocolError(s.writer, fmt.Sprintf("DONE after %d of %d bytes", written, offer.Size))
 	}
-	expectedDigest, err := DecodeDone(done.Payload)
+	expectedDigest, err := s.doneDigest(done, alg)
 	if err != nil {
-		return fmt.Errorf("decode done payload: %w", err)
+		return err
 	}
 	var actualDigest []byte
-	if resumeOffset > 0 {
-		actualDigest, err = hashFile(paths.Partial)
+	switch {
+	case chunked:
+		if verified != blockCount(offer.Size) {
+			return sendProtocolError(s.writer, fmt.Sprintf("DONE after %d of %d block digests", verified, blockCount(offer.Size)))
+		}
+		actualDigest = hash.TreeRoot(alg, tree.Leaves()[:verified])
+	case resumeOffset > 0:
+		err = s.keepalive(func() (hashErr error) {
+			actualDigest, hashErr = hashFile(paths.Partial, alg)
+			return hashErr
+		})
 		if err != nil {
 			return fmt.Errorf("rehash resumed file: %w", err)
 		}
-	} else {
-		actualDigest = hasher.Sum()
+	default:
+		tree.Finish()
+		actualDigest = tree.Root()
 	}
-	if subtle.ConstantTimeCompare(expectedDigest, actualDigest) != 1 {
-		_ = sendErrorFrame(writer, "integrity check failed")
+	if err := s.finishFile(file, paths, offer, alg, expectedDigest, actualDigest, reporter, written); err != nil {
+		return err
+	}
+	cleanup = false
+	return nil
+}
+
+// doneDigest decodes DONE and checks it uses the negotiated algorithm.
+func (s *receiverSession) doneDigest(done Frame, alg hash.Algorithm) ([]byte, error) {
+	doneAlg, digest, err := DecodeDone(done.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("decode done payload: %w", err)
+	}
+	if doneAlg != alg {
+		_ = sendErrorFrame(s.writer, "unexpected digest algorithm")
+		return nil, fmt.Errorf("done digest uses %s, negotiated %s: %w", doneAlg, alg, apperrors.ErrInvalidProtocol)
+	}
+	return digest, nil
+}
+
+// finishFile compares the sender's digest, then finalizes the partial, applies the
+// preserved attributes, and confirms with VERIFIED.
+func (s *receiverSession) finishFile(file *os.File, paths resume.Paths, offer OfferPayload, alg hash.Algorithm, expected, actual []byte, reporter *progress.Reporter, written uint64) error {
+	if subtle.ConstantTimeCompare(expected, actual) != 1 {
+		_ = sendErrorFrame(s.writer, "integrity check failed")
 		return fmt.Errorf("integrity check failed: %w", apperrors.ErrIntegrity)
 	}
-	if err := file.Sync(); err != nil {
-		return fmt.Errorf("sync output file: %w: %w", err, apperrors.ErrIO)
+	// Syncing a large file to a slow disk can outlast the sender's idle timeout.
+	err := s.keepalive(func() error {
+		if err := file.Sync(); err != nil {
+			return fmt.Errorf("sync output file: %w: %w", err, apperrors.ErrIO)
+		}
+		if err := resume.Finalize(paths); err != nil {
+			return fmt.Errorf("finalize partial file: %w: %w", err, apperrors.ErrIO)
+		}
+		return nil
+	})
+	if diskFull(err) {
+		_ = sendCancel(s.writer, s.hello, CancelDiskFull, "receiver is out of disk space")
+		return fmt.Errorf("%w: %w", err, apperrors.ErrDiskFull)
 	}
-	if err := resume.Finalize(paths); err != nil {
-		return fmt.Errorf("finalize partial file: %w: %w", err, apperrors.ErrIO)
+	if err != nil {
+		return err
 	}
-	cleanup = false
-	reporter.Done(written, paths.Final)
-	_, _ = fmt.Fprintln(opts.Out, "Transfer complete.")
-	_, _ = fmt.Fprintln(opts.Out, "Integrity verified.")
-	_, _ = fmt.Fprintf(opts.Out, "blake3: %x\n", actualDigest)
+	applyAttrs(paths.Final, offer.Attrs, s.opts.Preserve, s.opts.Out)
+	if err := s.confirm(offer, alg, actual, reporter, written, paths.Final); err != nil {
+		return err
+	}
+	if s.opts.AfterReceive != nil {
+		f := ReceivedFile{OfferedFile: s.offered(offer), Path: paths.Final, Algorithm: alg, Digest: actual, Duration: reporter.Elapsed()}
+		f.Size = written
+		s.received = append(s.received, f)
+	}
+	return nil
+}
+
+// confirm sends VERIFIED and reports the completed transfer written to output.
+func (s *receiverSession) confirm(offer OfferPayload, alg hash.Algorithm, digest []byte, reporter *progress.Reporter, written uint64, output string) error {
+	if err := WriteFrame(s.writer, Frame{Type: TypeVerified}); err != nil {
+		return fmt.Errorf("send verified frame: %w: %w", err, apperrors.ErrNetwork)
+	}
+	if err := s.writer.Flush(); err != nil {
+		return fmt.Errorf("flush verified frame: %w: %w", err, apperrors.ErrNetwork)
+	}
+	e := verifiedEvent("receiving", offer.Name, alg, digest)
+	e.Peer = s.peer
+	s.opts.Observer.Observe(e)
+	reporter.Done(written, output)
+	_, _ = fmt.Fprintln(s.opts.Out, "Transfer complete.")
+	_, _ = fmt.Fprintln(s.opts.Out, "Integrity verified.")
+	_, _ = fmt.Fprintf(s.opts.Out, "%s: %x\n", alg, digest)
 	return nil
 }
 
+// priorBlocks loads resume metadata whose block digests can be reused for alg,
+// or returns an empty Meta when there is none. The last saved block of every run of
+// digests is hashed again from the partial, and stale reports that one no longer
+// matches, so nothing of the partial can be trusted.
+func priorBlocks(paths resume.Paths, alg hash.Algorithm, size uint64) (prior resume.Meta, stale bool) {
+	prior, err := resume.LoadMeta(paths.Meta)
+	if err != nil || prior.Algorithm != uint8(alg) || prior.BlockSize != IntegrityBlockSize || len(prior.Leaves) > blockCount(size) {
+		return resume.Meta{}, false
+	}
+	for _, leaf := range prior.Leaves {
+		if leaf != nil && len(leaf) != alg.Size() {
+			return resume.Meta{}, false
+		}
+	}
+	for i, leaf := range prior.Leaves {
+		if leaf == nil || i+1 < len(prior.Leaves) && prior.Leaves[i+1] != nil {
+			continue
+		}
+		if !blockMatches(paths.Partial, alg, i, leaf, size) {
+			return resume.Meta{}, true
+		}
+	}
+	return prior, false
+}
+
+// blockMatches reports whether block index of the file at path hashes to leaf.
+func blockMatches(path string, alg hash.Algorithm, index int, leaf []byte, size uint64) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer func() { _ = file.Close() }()
+	start := uint64(index) * IntegrityBlockSize
+	n := min(IntegrityBlockSize, size-start)
+	tree, err := hash.NewTree(alg, IntegrityBlockSize, nil)
+	if err != nil {
+		return false
+	}
+	if _, err := io.Copy(tree, io.NewSectionReader(file, int64(start), int64(n))); err != nil {
+		return false
+	}
+	tree.Finish()
+	return len(tree.Leaves()) == 1 && subtle.ConstantTimeCompare(tree.Leaves()[0], leaf) == 1
+}
+
+// resumeLeaves returns the digests of the full blocks below the resume offset and
+// the block-aligned offset they cover. Digests saved by the previous session are
+// reused as-is; a partial left by a legacy session is hashed once to rebuild them.
+func resumeLeaves(paths resume.Paths, prior resume.Meta, offset uint64, alg hash.Algorithm, size uint64) ([][]byte, uint64) {
+	n := offset / IntegrityBlockSize
+	if full := size / IntegrityBlockSize; n > full {
+		n = full
+	}
+	if n == 0 {
+		return nil, 0
+	}
+	if prior.Algorithm != 0 {
+		var have uint64
+		for have < n && have < uint64(len(prior.Leaves)) && prior.Leaves[have] != nil {
+			have++
+		}
+		return prior.Leaves[:have], have * IntegrityBlockSize
+	}
+
+	file, err := os.Open(paths.Partial)
+	if err != nil {
+		return nil, 0
+	}
+	defer func() { _ = file.Close() }()
+	tree, err := hash.NewTree(alg, IntegrityBlockSize, nil)
+	if err != nil {
+		return nil, 0
+	}
+	if _, err := io.CopyN(tree, file, int64(n*IntegrityBlockSize)); err != nil {
+		return nil, 0
+	}
+	return tree.Leaves(), n * IntegrityBlockSize
+}
+
+// blockCount is the number of integrity blocks, and CHUNK digests, for a file size.
+func blockCount(size uint64) int {
+	if size == 0 {
+		return 1
+	}
+	return int((size + IntegrityBlockSize - 1) / IntegrityBlockSize)
+}
+
 func prepareResumeState(paths resume.Paths, offer OfferPayload, opts ReceiverOptions) (uint64, error) {
 	if !opts.Resume {
 		_ = os.Remove(paths.Partial)
-		_ = os.Remove(paths.Meta)
+		resume.RemoveMeta(paths.Meta)
 		return 0, nil
 	}
 	partialInfo, partialErr := os.Stat(paths.Partial)
@@ -265,7 +1128,7 @@ func prepareResumeState(paths resume.Paths, offer OfferPayload, opts ReceiverOpt
 		return 0, nil
 	}
 	if errors.Is(partialErr, os.ErrNotExist) && metaErr == nil {
-		_ = os.Remove(paths.Meta)
+		resume.RemoveMeta(paths.Meta)
 		return 0, nil
 	}
 	if partialErr == nil && errors.Is(metaErr, os.ErrNotExist) {
@@ -277,7 +1140,7 @@ func prepareResumeState(paths resume.Paths, offer OfferPayload, opts ReceiverOpt
 	}
 	if metaErr != nil {
 		_ = os.Truncate(paths.Partial, 0)
-		_ = os.Remove(paths.Meta)
+		resume.RemoveMeta(paths.Meta)
 		return 0, nil
 	}
 	if meta.SessionID != offer.SessionID {
@@ -285,7 +1148,7 @@ func prepareResumeState(paths resume.Paths, offer OfferPayload, opts ReceiverOpt
 			return 0, fmt.Errorf("resume session mismatch: %w", apperrors.ErrRejected)
 		}
 		_ = os.Remove(paths.Partial)
-		_ = os.Remove(paths.Meta)
+		resume.RemoveMeta(paths.Meta)
 		return 0, nil
 	}
 	if meta.ExpectedSize != offer.Size {
@@ -293,7 +1156,7 @@ func prepareResumeState(paths resume.Paths, offer OfferPayload, opts ReceiverOpt
 			return 0, fmt.Errorf("resume size mismatch: %w", apperrors.ErrRejected)
 		}
 		_ = os.Remove(paths.Partial)
-		_ = os.Remove(paths.Meta)
+		resume.RemoveMeta(paths.Meta)
 		return 0, nil
 	}
 	size := uint64(partialInfo.Size())
@@ -308,13 +1171,16 @@ func prepareResumeState(paths resume.Paths, offer OfferPayload, opts ReceiverOpt
 	return offset, nil
 }
 
-func hashFile(path string) ([]byte, error) {
+func hashFile(path string, alg hash.Algorithm) ([]byte, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, fmt.Errorf("open file for integrity rehash: %w", err)
 	}
 	defer func() { _ = f.Close() }()
-	h, _ := hash.New()
+	h, err := hash.New(alg)
+	if err != nil {
+		return nil, err
+	}
 	buf := make([]byte, MaxChunkSize)
 	for {
 		n, rerr := f.Read(buf)
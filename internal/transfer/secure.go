@@ -0,0 +1,294 @@
+package transfer
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+
+	apperrors "snapsync/internal/errors"
+	"snapsync/internal/identity"
+)
+
+const (
+	handshakeLabel = "snapsync-handshake-v2"
+	maxRecordSize  = MaxChunkSize + HeaderSize
+	recordOverhead = 16
+)
+
+// PeerIdentity describes an authenticated remote peer.
+type PeerIdentity struct {
+	PeerID      string
+	PublicKey   ed25519.PublicKey
+	Fingerprint string
+}
+
+// secureChannel encrypts a byte stream as length-prefixed AES-GCM records.
+type secureChannel struct {
+	r       io.Reader
+	w       io.Writer
+	seal    cipher.AEAD
+	open    cipher.AEAD
+	sendSeq uint64
+	recvSeq uint64
+	pending []byte
+}
+
+func newSecureChannel(r io.Reader, w io.Writer, sendKey, recvKey []byte) (*secureChannel, error) {
+	seal, err := newGCM(sendKey)
+	if err != nil {
+		return nil, err
+	}
+	open, err := newGCM(recvKey)
+	if err != nil {
+		return nil, err
+	}
+	return &secureChannel{r: r, w: w, seal: seal, open: open}, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("create record cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("create record aead: %w", err)
+	}
+	return aead, nil
+}
+
+// Write seals p into one or more records.
+func (c *secureChannel) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > maxRecordSize {
+			n = maxRecordSize
+		}
+		record := make([]byte, 4, 4+n+recordOverhead)
+		record = c.seal.Seal(record, c.nonce(c.sendSeq), p[:n], nil)
+		binary.BigEndian.PutUint32(record[:4], uint32(len(record)-4))
+		c.sendSeq++
+		if _, err := c.w.Write(record); err != nil {
+			return written, fmt.Errorf("write secure record: %w", err)
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Read opens records on demand and serves their plaintext.
+func (c *secureChannel) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(c.r, header); err != nil {
+			return 0, err
+		}
+		ln := binary.BigEndian.Uint32(header)
+		if ln < recordOverhead || ln > maxRecordSize+recordOverhead {
+			return 0, fmt.Errorf("secure record length %d out of range: %w", ln, apperrors.ErrInvalidProtocol)
+		}
+		sealed := make([]byte, ln)
+		if _, err := io.ReadFull(c.r, sealed); err != nil {
+			return 0, fmt.Errorf("read secure record: %w", err)
+		}
+		plain, err := c.open.Open(sealed[:0], c.nonce(c.recvSeq), sealed, nil)
+		if err != nil {
+			return 0, fmt.Errorf("open secure record: %w", apperrors.ErrAuth)
+		}
+		c.recvSeq++
+		c.pending = plain
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *secureChannel) nonce(seq uint64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], seq)
+	return nonce
+}
+
+// clientHandshake authenticates to a receiver and returns the encrypted channel.
+// The raw reader must be the buffered reader used for all subsequent reads.
+func clientHandshake(reader *bufio.Reader, writer *bufio.Writer, raw io.Writer, id identity.Identity) (*secureChannel, PeerIdentity, error) {
+	eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, PeerIdentity{}, fmt.Errorf("generate ephemeral key: %w", err)
+	}
+	clientEph := eph.PublicKey().Bytes()
+	if err := WriteFrame(writer, Frame{Type: TypeHandshake, Payload: clientEph}); err != nil {
+		return nil, PeerIdentity{}, fmt.Errorf("send handshake: %w: %w", err, apperrors.ErrNetwork)
+	}
+	if err := writer.Flush(); err != nil {
+		return nil, PeerIdentity{}, fmt.Errorf("flush handshake: %w: %w", err, apperrors.ErrNetwork)
+	}
+
+	resp, err := ReadFrame(reader)
+	if err != nil {
+		return nil, PeerIdentity{}, fmt.Errorf("read handshake response: %w: %w", err, apperrors.ErrNetwork)
+	}
+	if resp.Type == TypeError {
+		msg, _ := DecodeError(resp.Payload)
+		return nil, PeerIdentity{}, fmt.Errorf("receiver refused handshake: %s: %w", msg, apperrors.ErrAuth)
+	}
+	if resp.Type != TypeHandshake || len(resp.Payload) < 32 {
+		return nil, PeerIdentity{}, fmt.Errorf("unexpected handshake response type %d: %w", resp.Type, apperrors.ErrInvalidProtocol)
+	}
+	serverEph := resp.Payload[:32]
+	remote, err := verifyAuth(resp.Payload[32:], "server", clientEph, serverEph)
+	if err != nil {
+		return nil, PeerIdentity{}, err
+	}
+
+	auth, err := signAuth(id, "client", clientEph, serverEph)
+	if err != nil {
+		return nil, PeerIdentity{}, err
+	}
+	if err := WriteFrame(writer, Frame{Type: TypeHandshake, Payload: auth}); err != nil {
+		return nil, PeerIdentity{}, fmt.Errorf("send handshake auth: %w: %w", err, apperrors.ErrNetwork)
+	}
+	if err := writer.Flush(); err != nil {
+		return nil, PeerIdentity{}, fmt.Errorf("flush handshake auth: %w: %w", err, apperrors.ErrNetwork)
+	}
+
+	c2s, s2c, err := deriveSessionKeys(eph, serverEph, clientEph, serverEph)
+	if err != nil {
+		return nil, PeerIdentity{}, err
+	}
+	channel, err := newSecureChannel(reader, raw, c2s, s2c)
+	if err != nil {
+		return nil, PeerIdentity{}, err
+	}
+	return channel, remote, nil
+}
+
+// serverHandshake answers a client handshake frame and returns the encrypted channel.
+func serverHandshake(reader *bufio.Reader, writer *bufio.Writer, raw io.Writer, first Frame, id identity.Identity) (*secureChannel, PeerIdentity, error) {
+	if len(first.Payload) != 32 {
+		return nil, PeerIdentity{}, fmt.Errorf("invalid client handshake length: %w", apperrors.ErrInvalidProtocol)
+	}
+	clientEph := first.Payload
+	eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, PeerIdentity{}, fmt.Errorf("generate ephemeral key: %w", err)
+	}
+	serverEph := eph.PublicKey().Bytes()
+	auth, err := signAuth(id, "server", clientEph, serverEph)
+	if err != nil {
+		return nil, PeerIdentity{}, err
+	}
+	if err := WriteFrame(writer, Frame{Type: TypeHandshake, Payload: append(append([]byte{}, serverEph...), auth...)}); err != nil {
+		return nil, PeerIdentity{}, fmt.Errorf("send handshake response: %w: %w", err, apperrors.ErrNetwork)
+	}
+	if err := writer.Flush(); err != nil {
+		return nil, PeerIdentity{}, fmt.Errorf("flush handshake response: %w: %w", err, apperrors.ErrNetwork)
+	}
+
+	resp, err := ReadFrame(reader)
+	if err != nil {
+		return nil, PeerIdentity{}, fmt.Errorf("read handshake auth: %w: %w", err, apperrors.ErrNetwork)
+	}
+	if resp.Type != TypeHandshake {
+		return nil, PeerIdentity{}, fmt.Errorf("unexpected handshake auth type %d: %w", resp.Type, apperrors.ErrInvalidProtocol)
+	}
+	remote, err := verifyAuth(resp.Payload, "client", clientEph, serverEph)
+	if err != nil {
+		return nil, PeerIdentity{}, err
+	}
+
+	c2s, s2c, err := deriveSessionKeys(eph, clientEph, clientEph, serverEph)
+	if err != nil {
+		return nil, PeerIdentity{}, err
+	}
+	channel, err := newSecureChannel(reader, raw, s2c, c2s)
+	if err != nil {
+		return nil, PeerIdentity{}, err
+	}
+	return channel, remote, nil
+}
+
+// signAuth encodes static public key, peer id and a signature over the handshake transcript.
+func signAuth(id identity.Identity, role string, clientEph, serverEph []byte) ([]byte, error) {
+	pub := id.PublicKey()
+	if len(id.PeerID) == 0 || len(id.PeerID) > 255 {
+		return nil, fmt.Errorf("invalid local peer id length: %w", apperrors.ErrUsage)
+	}
+	sig := ed25519.Sign(id.PrivateKey, handshakeTranscript(role, clientEph, serverEph, pub, id.PeerID))
+	payload := make([]byte, 0, ed25519.PublicKeySize+1+len(id.PeerID)+ed25519.SignatureSize)
+	payload = append(payload, pub...)
+	payload = append(payload, byte(len(id.PeerID)))
+	payload = append(payload, id.PeerID...)
+	payload = append(payload, sig...)
+	return payload, nil
+}
+
+func verifyAuth(payload []byte, role string, clientEph, serverEph []byte) (PeerIdentity, error) {
+	if len(payload) < ed25519.PublicKeySize+1 {
+		return PeerIdentity{}, fmt.Errorf("handshake auth too short: %w", apperrors.ErrInvalidProtocol)
+	}
+	pub := ed25519.PublicKey(append([]byte{}, payload[:ed25519.PublicKeySize]...))
+	off := ed25519.PublicKeySize
+	idLen := int(payload[off])
+	off++
+	if idLen == 0 || off+idLen+ed25519.SignatureSize != len(payload) {
+		return PeerIdentity{}, fmt.Errorf("handshake auth malformed: %w", apperrors.ErrInvalidProtocol)
+	}
+	peerID := string(payload[off : off+idLen])
+	off += idLen
+	if !ed25519.Verify(pub, handshakeTranscript(role, clientEph, serverEph, pub, peerID), payload[off:]) {
+		return PeerIdentity{}, fmt.Errorf("%s handshake signature invalid: %w", role, apperrors.ErrAuth)
+	}
+	return PeerIdentity{PeerID: peerID, PublicKey: pub, Fingerprint: identity.Fingerprint(pub)}, nil
+}
+
+// handshakeTranscript hashes every field behind its length, so no two different
+// sets of fields share a transcript.
+func handshakeTranscript(role string, clientEph, serverEph, pub []byte, peerID string) []byte {
+	h := sha256.New()
+	for _, field := range [][]byte{[]byte(handshakeLabel), []byte(role), clientEph, serverEph, pub, []byte(peerID)} {
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], uint32(len(field)))
+		_, _ = h.Write(size[:])
+		_, _ = h.Write(field)
+	}
+	return h.Sum(nil)
+}
+
+// deriveSessionKeys returns client-to-server and server-to-client record keys.
+func deriveSessionKeys(local *ecdh.PrivateKey, remoteEph, clientEph, serverEph []byte) ([]byte, []byte, error) {
+	remote, err := ecdh.X25519().NewPublicKey(remoteEph)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse remote ephemeral key: %w: %w", err, apperrors.ErrInvalidProtocol)
+	}
+	secret, err := local.ECDH(remote)
+	if err != nil {
+		return nil, nil, fmt.Errorf("derive shared secret: %w: %w", err, apperrors.ErrAuth)
+	}
+	return sessionKeys(secret, append(append([]byte{}, clientEph...), serverEph...))
+}
+
+// sessionKeys expands a shared secret with HKDF-SHA256 into client-to-server and
+// server-to-client record keys.
+func sessionKeys(secret, salt []byte) ([]byte, []byte, error) {
+	prk := hkdf.Extract(sha256.New, secret, salt)
+	c2s, s2c := make([]byte, 32), make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("snapsync c2s")), c2s); err != nil {
+		return nil, nil, fmt.Errorf("derive record key: %w", err)
+	}
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("snapsync s2c")), s2c); err != nil {
+		return nil, nil, fmt.Errorf("derive record key: %w", err)
+	}
+	return c2s, s2c, nil
+}
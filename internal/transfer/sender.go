@@ -2,19 +2,26 @@ package transfer
 
 import (
 	"bufio"
+	"cmp"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	mathrand "math/rand/v2"
 	"net"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	apperrors "snapsync/internal/errors"
 	"snapsync/internal/hash"
+	"snapsync/internal/identity"
 	"snapsync/internal/progress"
+	"snapsync/internal/throttle"
 )
 
 // SenderOptions configures sender behavior.
@@ -24,11 +31,71 @@ type SenderOptions struct {
 	OverrideName string
 	Out          io.Writer
 	Resume       bool
+	Identity     *identity.Identity
+	VerifyPeer   func(PeerIdentity) error
+	Code         string
+	Hash         hash.Algorithm
+	// Streams stripes each large file across this many connections when the receiver supports it.
+	Streams int
+	// Compress deflates DATA payloads when the receiver supports it.
+	Compress bool
+	// Delta sends only what differs from the receiver's existing copy of each file.
+	// It applies when the transfer does not resume and takes precedence over Streams.
+	Delta bool
+	// Input is read instead of a file when Path is StdinPath. Defaults to os.Stdin.
+	Input io.Reader
+	// Retries is how many times a transfer interrupted by a network failure is
+	// redialed and resumed before giving up. Streamed stdin is never retried.
+	Retries int
+	// RetryBackoff is the delay before the first retry; it doubles per attempt up
+	// to maxRetryBackoff, with jitter. Defaults to DefaultRetryBackoff.
+	RetryBackoff time.Duration
+	// Timeouts bounds the handshake, idle waits, and total length of each connection.
+	Timeouts Timeouts
+	// Limit paces every connection of the transfer together. Nil means unlimited.
+	Limit *throttle.Limiter
+	// Cancel, once closed, stops the transfer at the next frame and tells the
+	// receiver with CANCEL.
+	Cancel <-chan struct{}
+	// Observer receives each file's offer, accepted, resume, progress, verified,
+	// and done events. Defaults to progress.NewPlain(Out).
+	Observer progress.Observer
+	// peer names the receiver in events, so concurrent pulls of one share stay apart.
+	peer string
 }
 
+// DefaultRetryBackoff is the delay before the first retry when RetryBackoff is unset.
+const DefaultRetryBackoff = time.Second
+
+// maxRetryBackoff caps the delay between retries.
+const maxRetryBackoff = 30 * time.Second
+
+// retrySleep waits d between retries, returning early when cancel closes; tests
+// replace it.
+var retrySleep = func(d time.Duration, cancel <-chan struct{}) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-cancel:
+	}
+}
+
+// StdinPath is the send path that streams Input, of unknown length, instead of a file.
+const StdinPath = "-"
+
 var senderChunkMutator func([]byte)
 
-// Send streams one file to a receiver.
+// sourceEntry is one file or empty directory queued for sending.
+type sourceEntry struct {
+	path  string
+	name  string
+	dir   bool
+	size  uint64
+	attrs FileAttrs
+}
+
+// Send streams one file, or every file and empty directory below a directory, to a receiver.
 func Send(opts SenderOptions) error {
 	if opts.Path == "" || opts.Address == "" {
 		return fmt.Errorf("missing required sender options: %w", apperrors.ErrUsage)
@@ -36,95 +103,362 @@ func Send(opts SenderOptions) error {
 	if opts.Out == nil {
 		opts.Out = io.Discard
 	}
+	if opts.Observer == nil {
+		opts.Observer = progress.NewPlain(opts.Out)
+	}
+	if opts.Path == StdinPath {
+		return sendStdin(opts)
+	}
 
-	file, info, sendName, err := openSource(opts.Path, opts.OverrideName)
+	entries, isDir, err := collectSources(opts.Path, opts.OverrideName, opts.Out)
 	if err != nil {
 		return err
 	}
-	defer func() { _ = file.Close() }()
-	hasher, err := hash.New()
-	if err != nil {
-		return fmt.Errorf("create sender hasher: %w", err)
-	}
 
 	sessionID, err := loadOrCreateSessionID(opts.Path)
 	if err != nil {
 		return fmt.Errorf("prepare session id: %w", err)
 	}
 
-	conn, err := net.Dial("tcp", opts.Address)
+	for attempt := 0; ; attempt++ {
+		err = sendSession(opts, entries, isDir, sessionID)
+		if err == nil {
+			_ = os.Remove(sessionPath(opts.Path))
+			return nil
+		}
+		if attempt >= opts.Retries || !retryable(err) {
+			return err
+		}
+		delay := retryDelay(opts.RetryBackoff, attempt)
+		_, _ = fmt.Fprintf(opts.Out, "\nTransfer interrupted: %v\nRetrying in %s (%d of %d)\n", err, delay.Round(time.Millisecond), attempt+1, opts.Retries)
+		retrySleep(delay, opts.Cancel)
+		if interrupted(opts.Cancel) {
+			return fmt.Errorf("interrupted while waiting to retry: %w", apperrors.ErrCancelled)
+		}
+	}
+}
+
+// sendSession dials the receiver once and sends the collected sources. The
+// receiver's ACCEPT offsets let a later session continue where this one stopped.
+func sendSession(opts SenderOptions, entries []sourceEntry, isDir bool, sessionID string) error {
+	conn, reader, writer, agreed, err := dialReceiver(opts, opts.Out)
 	if err != nil {
-		return fmt.Errorf("dial receiver: %w: %w", err, apperrors.ErrNetwork)
+		return err
 	}
 	defer func() { _ = conn.Close() }()
+	return sendEntries(reader, writer, agreed, opts, entries, isDir, sessionID)
+}
+
+// retryable reports whether a failed session may be redialed: the network failed,
+// or the receiver still holds the lock of the dropped session. Refusals, integrity
+// and authentication failures, and protocol errors are final.
+func retryable(err error) bool {
+	for _, final := range []error{apperrors.ErrRejected, apperrors.ErrIntegrity, apperrors.ErrAuth, apperrors.ErrPairing, apperrors.ErrInvalidProtocol, apperrors.ErrUsage, apperrors.ErrIO} {
+		if errors.Is(err, final) {
+			return false
+		}
+	}
+	return errors.Is(err, apperrors.ErrNetwork) || errors.Is(err, apperrors.ErrLockBusy)
+}
+
+// retryDelay is the exponential backoff before retry attempt+1, jittered into the
+// upper half of the interval so many senders do not redial in step.
+func retryDelay(base time.Duration, attempt int) time.Duration {
+	if base <= 0 {
+		base = DefaultRetryBackoff
+	}
+	delay := base
+	for i := 0; i < attempt && delay < maxRetryBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxRetryBackoff)
+	return delay/2 + mathrand.N(delay/2+1)
+}
+
+// sendEntries sends collected sources over a negotiated connection: one OFFER for a
+// file, or a BATCH of OFFER and MKDIR frames for a directory.
+func sendEntries(reader *bufio.Reader, writer *bufio.Writer, agreed HelloPayload, opts SenderOptions, entries []sourceEntry, isDir bool, sessionID string) error {
+	if opts.Compress && !agreed.Has(CapCompression) {
+		_, _ = fmt.Fprintln(opts.Out, "Receiver does not support compression; sending uncompressed.")
+	}
+	if opts.Delta && !agreed.Has(CapDelta) {
+		_, _ = fmt.Fprintln(opts.Out, "Receiver does not support delta transfers; sending whole files.")
+	}
+	if isDir && !agreed.Has(CapMultiFile) {
+		return fmt.Errorf("receiver does not support directory transfers: %w", apperrors.ErrInvalidProtocol)
+	}
+	if !isDir {
+		return sendFile(reader, writer, entries[0], sessionID, agreed, opts)
+	}
+
+	batch := BatchPayload{Name: sourceName(opts.Path, opts.OverrideName), SessionID: sessionID}
+	for _, entry := range entries {
+		if entry.dir {
+			batch.Dirs++
+			continue
+		}
+		batch.Files++
+		batch.TotalBytes += entry.size
+	}
+	if err := sendBatch(reader, writer, batch); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.dir {
+			payload, encErr := EncodeMkdir(entry.name)
+			if encErr != nil {
+				return fmt.Errorf("encode mkdir payload: %w", encErr)
+			}
+			if err := WriteFrame(writer, Frame{Type: TypeMkdir, Payload: payload}); err != nil {
+				return fmt.Errorf("send mkdir frame: %w: %w", err, apperrors.ErrNetwork)
+			}
+			continue
+		}
+		if err := sendFile(reader, writer, entry, entrySessionID(sessionID, entry.name), agreed, opts); err != nil {
+			return fmt.Errorf("send %s: %w", entry.name, err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("flush directory frames: %w: %w", err, apperrors.ErrNetwork)
+	}
+	_, _ = fmt.Fprintf(opts.Out, "Directory complete: %d files, %d directories, %d bytes.\n", batch.Files, batch.Dirs, batch.TotalBytes)
+	return nil
+}
+
+// errHelloDropped marks a receiver that closed the connection instead of answering
+// HELLO, as v1.0 receivers do with any HELLO that carries a payload.
+var errHelloDropped = errors.New("receiver closed the connection on hello")
+
+// dialReceiver connects to the receiver, secures the connection as configured, and
+// exchanges HELLO. A plaintext receiver that drops the connection on HELLO is
+// redialed with the empty HELLO of v1.0 and spoken to as v1. Session notices go to
+// out.
+func dialReceiver(opts SenderOptions, out io.Writer) (net.Conn, *bufio.Reader, *bufio.Writer, HelloPayload, error) {
+	conn, reader, writer, agreed, err := dialHello(opts, out, false)
+	if errors.Is(err, errHelloDropped) && opts.Identity == nil && opts.Code == "" {
+		_, _ = fmt.Fprintln(out, "Receiver dropped HELLO; retrying as a v1 sender.")
+		return dialHello(opts, out, true)
+	}
+	return conn, reader, writer, agreed, err
+}
+
+// dialHello dials once for dialReceiver; legacy sends an empty HELLO, which gets no
+// reply, instead of negotiating.
+func dialHello(opts SenderOptions, out io.Writer, legacy bool) (net.Conn, *bufio.Reader, *bufio.Writer, HelloPayload, error) {
+	raw, err := net.DialTimeout("tcp", opts.Address, cmp.Or(opts.Timeouts.Handshake, DefaultHandshakeTimeout))
+	if err != nil {
+		return nil, nil, nil, HelloPayload{}, fmt.Errorf("dial receiver: %w: %w", err, apperrors.ErrNetwork)
+	}
+	if opts.Limit.Rate() != throttle.Unlimited {
+		boundUnsent(raw)
+	}
+	conn := withTimeouts(raw, opts.Timeouts)
 	reader := bufio.NewReader(conn)
 	writer := bufio.NewWriter(conn)
+	fail := func(err error) (net.Conn, *bufio.Reader, *bufio.Writer, HelloPayload, error) {
+		_ = conn.Close()
+		return nil, nil, nil, HelloPayload{}, err
+	}
+	if opts.Code != "" {
+		channel, pairErr := clientPairing(reader, writer, conn, opts.Code)
+		if pairErr != nil {
+			return fail(pairErr)
+		}
+		_, _ = fmt.Fprintln(out, "Paired with receiver using code.")
+		reader = bufio.NewReader(channel)
+		writer = bufio.NewWriter(channel)
+	} else if opts.Identity != nil {
+		channel, remote, hsErr := clientHandshake(reader, writer, conn, *opts.Identity)
+		if hsErr != nil {
+			return fail(hsErr)
+		}
+		if opts.VerifyPeer != nil {
+			if err := opts.VerifyPeer(remote); err != nil {
+				return fail(fmt.Errorf("verify receiver %s: %w: %w", remote.PeerID, err, apperrors.ErrAuth))
+			}
+		}
+		_, _ = fmt.Fprintf(out, "Encrypted session with %s (fingerprint %s)\n", remote.PeerID, remote.Fingerprint)
+		reader = bufio.NewReader(channel)
+		writer = bufio.NewWriter(channel)
+	}
 
-	if err := WriteFrame(writer, Frame{Type: TypeHello}); err != nil {
-		return fmt.Errorf("send hello: %w: %w", err, apperrors.ErrNetwork)
+	agreed := legacyHello
+	if legacy {
+		if err := WriteFrame(writer, Frame{Type: TypeHello}); err != nil {
+			return fail(fmt.Errorf("send hello: %w: %w", err, apperrors.ErrNetwork))
+		}
+	} else if agreed, err = sendHello(reader, writer, opts.Hash); err != nil {
+		return fail(err)
 	}
-	offerPayload, err := EncodeOffer(sendName, uint64(info.Size()), sessionID)
+	conn.negotiated()
+	return conn, reader, writer, agreed, nil
+}
+
+// sendHello advertises local capabilities and returns the receiver's negotiated set.
+func sendHello(reader *bufio.Reader, writer *bufio.Writer, prefer hash.Algorithm) (HelloPayload, error) {
+	local := localHello(prefer)
+	payload, err := EncodeHello(local)
 	if err != nil {
-		return fmt.Errorf("encode offer: %w", err)
+		return HelloPayload{}, fmt.Errorf("encode hello: %w", err)
 	}
-	if err := WriteFrame(writer, Frame{Type: TypeOffer, Payload: offerPayload}); err != nil {
-		return fmt.Errorf("send offer: %w: %w", err, apperrors.ErrNetwork)
+	if err := WriteFrame(writer, Frame{Type: TypeHello, Payload: payload}); err != nil {
+		return HelloPayload{}, fmt.Errorf("send hello: %w: %w", err, apperrors.ErrNetwork)
 	}
 	if err := writer.Flush(); err != nil {
-		return fmt.Errorf("flush offer frames: %w: %w", err, apperrors.ErrNetwork)
+		return HelloPayload{}, fmt.Errorf("flush hello: %w: %w", err, apperrors.ErrNetwork)
 	}
-
 	resp, err := ReadFrame(reader)
 	if err != nil {
-		return fmt.Errorf("read receiver response: %w: %w", err, apperrors.ErrNetwork)
+		var ne net.Error
+		if !errors.As(err, &ne) || !ne.Timeout() {
+			err = fmt.Errorf("%w: %w", err, errHelloDropped)
+		}
+		return HelloPayload{}, fmt.Errorf("read receiver hello: %w: %w", err, apperrors.ErrNetwork)
+	}
+	switch resp.Type {
+	case TypeHello:
+	case TypeError:
+		msg, _ := DecodeError(resp.Payload)
+		return HelloPayload{}, fmt.Errorf("receiver refused hello: %s: %w", msg, apperrors.ErrInvalidProtocol)
+	default:
+		return HelloPayload{}, fmt.Errorf("unexpected hello response frame type %d: %w", resp.Type, apperrors.ErrInvalidProtocol)
+	}
+	agreed, err := DecodeHello(resp.Payload)
+	if err != nil {
+		return HelloPayload{}, fmt.Errorf("decode receiver hello: %w", err)
+	}
+	if _, err := Negotiate(local, agreed); err != nil || agreed.MinVersion != agreed.MaxVersion || agreed.Caps&^local.Caps != 0 {
+		return HelloPayload{}, fmt.Errorf("receiver negotiated unsupported settings: %w", apperrors.ErrInvalidProtocol)
+	}
+	return agreed, nil
+}
+
+func sendBatch(reader *bufio.Reader, writer *bufio.Writer, batch BatchPayload) error {
+	payload, err := EncodeBatch(batch)
+	if err != nil {
+		return fmt.Errorf("encode batch: %w", err)
+	}
+	if err := WriteFrame(writer, Frame{Type: TypeBatch, Payload: payload}); err != nil {
+		return fmt.Errorf("send batch: %w: %w", err, apperrors.ErrNetwork)
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("flush batch frame: %w: %w", err, apperrors.ErrNetwork)
+	}
+	resp, err := readFrame(reader, writer)
+	if err != nil {
+		return fmt.Errorf("read receiver batch response: %w: %w", err, apperrors.ErrNetwork)
 	}
-	var resumeOffset uint64
 	switch resp.Type {
 	case TypeAccept:
-		off, sid, decErr := DecodeAccept(resp.Payload)
+		_, sid, decErr := DecodeAccept(resp.Payload)
 		if decErr != nil {
-			return fmt.Errorf("decode accept frame: %w", decErr)
+			return fmt.Errorf("decode batch accept frame: %w", decErr)
 		}
-		if sid != sessionID {
-			return fmt.Errorf("session mismatch receiver=%s sender=%s: %w", sid, sessionID, apperrors.ErrRejected)
+		if sid != batch.SessionID {
+			return fmt.Errorf("batch session mismatch receiver=%s sender=%s: %w", sid, batch.SessionID, apperrors.ErrRejected)
 		}
-		resumeOffset = off
+		return nil
 	case TypeError:
-		msg, decErr := DecodeError(resp.Payload)
-		if decErr != nil {
-			return fmt.Errorf("decode receiver error frame: %w", decErr)
-		}
-		if strings.Contains(strings.ToLower(msg), "lock") {
-			return fmt.Errorf("receiver lock busy: %s: %w", msg, apperrors.ErrLockBusy)
-		}
-		return fmt.Errorf("receiver rejected transfer: %s: %w", msg, apperrors.ErrRejected)
+		return receiverError(resp.Payload)
+	case TypeCancel:
+		return cancelError("receiver", resp.Payload)
 	default:
-		return fmt.Errorf("unexpected response frame type %d: %w", resp.Type, apperrors.ErrInvalidProtocol)
+		return fmt.Errorf("unexpected batch response frame type %d: %w", resp.Type, apperrors.ErrInvalidProtocol)
+	}
+}
+
+func sendFile(reader *bufio.Reader, writer *bufio.Writer, entry sourceEntry, sessionID string, agreed HelloPayload, opts SenderOptions) error {
+	file, err := os.Open(entry.path)
+	if err != nil {
+		return fmt.Errorf("open source file: %w: %w", err, apperrors.ErrIO)
+	}
+	defer func() { _ = file.Close() }()
+	alg := hash.Algorithm(agreed.Hashes[0])
+	chunked := agreed.Has(CapChunkDigests)
+
+	offer := OfferPayload{Name: entry.name, Size: entry.size, SessionID: sessionID}
+	if agreed.Has(CapMetadata) {
+		offer.Attrs = entry.attrs
+	}
+	opts.Observer.Observe(progress.Event{Type: progress.EventOffer, Direction: "sending", Peer: opts.peer, Name: entry.name, Total: entry.size})
+	resumeOffset, err := sendOffer(reader, writer, offer)
+	if err != nil {
+		return err
 	}
 	if !opts.Resume {
 		resumeOffset = 0
 	}
-	if resumeOffset > uint64(info.Size()) {
-		return fmt.Errorf("receiver resume offset %d exceeds file size %d: %w", resumeOffset, info.Size(), apperrors.ErrInvalidProtocol)
+	if resumeOffset > entry.size {
+		return fmt.Errorf("receiver resume offset %d exceeds file size %d: %w", resumeOffset, entry.size, apperrors.ErrInvalidProtocol)
 	}
-	if resumeOffset > 0 {
-		_, _ = fmt.Fprintf(opts.Out, "Resuming at offset %d (%.2f%%)\n", resumeOffset, (float64(resumeOffset)/float64(info.Size()))*100)
-		if err := hashPrefix(file, resumeOffset, hasher); err != nil {
-			return err
+	if chunked && resumeOffset%IntegrityBlockSize != 0 {
+		return fmt.Errorf("receiver resume offset %d is not block aligned: %w", resumeOffset, apperrors.ErrInvalidProtocol)
+	}
+	opts.Observer.Observe(progress.Event{Type: progress.EventAccepted, Direction: "sending", Peer: opts.peer, Name: entry.name, Total: entry.size, Offset: resumeOffset})
+
+	if opts.Delta && agreed.Has(CapDelta) && resumeOffset == 0 {
+		return sendDelta(reader, writer, file, entry, agreed, opts)
+	}
+	if opts.Streams > 1 && chunked && agreed.Has(CapStriping) && entry.size > IntegrityBlockSize {
+		return sendStriped(reader, writer, file, entry, sessionID, agreed, opts)
+	}
+
+	var tree *hash.Tree
+	var cache *chunkCache
+	if chunked {
+		info, statErr := file.Stat()
+		if statErr != nil {
+			return fmt.Errorf("stat source file: %w: %w", statErr, apperrors.ErrIO)
+		}
+		cache = openChunkCache(entry.path, info, alg)
+		defer cache.save()
+		prefix := cache.prefix(int(resumeOffset / IntegrityBlockSize))
+		if tree, err = hash.NewTree(alg, IntegrityBlockSize, prefix); err != nil {
+			return fmt.Errorf("create sender hasher: %w", err)
+		}
+		if prefix == nil && resumeOffset > 0 {
+			if err := hashResumedPrefix(reader, writer, agreed, file, resumeOffset, tree); err != nil {
+				return err
+			}
+		}
+	} else {
+		if tree, err = hash.NewTree(alg, 0, nil); err != nil {
+			return fmt.Errorf("create sender hasher: %w", err)
 		}
+		if resumeOffset > 0 {
+			if err := hashResumedPrefix(reader, writer, agreed, file, resumeOffset, tree); err != nil {
+				return err
+			}
+		}
+	}
+	if resumeOffset > 0 {
+		_, _ = fmt.Fprintf(opts.Out, "Resuming at offset %d (%.2f%%)\n", resumeOffset, (float64(resumeOffset)/float64(entry.size))*100)
+		opts.Observer.Observe(progress.Event{Type: progress.EventResume, Direction: "sending", Peer: opts.peer, Name: entry.name, Total: entry.size, Offset: resumeOffset})
 	}
 	if _, err := file.Seek(int64(resumeOffset), io.SeekStart); err != nil {
 		return fmt.Errorf("seek source file for resume: %w: %w", err, apperrors.ErrIO)
 	}
 
-	reporter := progress.NewReporter(opts.Out, "sending", uint64(info.Size()))
+	reporter := progress.NewReporter(opts.Observer, progress.Event{Direction: "sending", Peer: opts.peer, Name: entry.name, Total: entry.size})
 	buf := make([]byte, MaxChunkSize)
 	sent := resumeOffset
+	comp := newWireCompressor(opts, agreed)
+	announced := len(tree.Leaves())
+	sendDigests := func() error {
+		next, err := sendChunkDigests(reader, writer, tree.Leaves(), announced)
+		for ; announced < next; announced++ {
+			cache.set(announced, tree.Leaves()[announced])
+		}
+		return err
+	}
 	for {
+		if interrupted(opts.Cancel) {
+			return stopForUser(writer, agreed)
+		}
 		n, readErr := file.Read(buf)
 		if n > 0 {
 			chunk := buf[:n]
-			if _, err := hasher.Write(chunk); err != nil {
+			if _, err := tree.Write(chunk); err != nil {
 				return fmt.Errorf("hash source chunk: %w", err)
 			}
 			if senderChunkMutator != nil {
@@ -132,11 +466,16 @@ func Send(opts SenderOptions) error {
 				senderChunkMutator(mut)
 				chunk = mut
 			}
-			if err := WriteFrame(writer, Frame{Type: TypeData, Payload: chunk}); err != nil {
-				return fmt.Errorf("send data frame: %w: %w", err, apperrors.ErrNetwork)
+			if err := writePacedFrame(writer, comp.frame(chunk), opts.Limit); err != nil {
+				return peerFailure(reader, fmt.Errorf("send data frame: %w: %w", err, apperrors.ErrNetwork))
 			}
 			sent += uint64(n)
 			reporter.Update(sent)
+			if chunked {
+				if err := sendDigests(); err != nil {
+					return err
+				}
+			}
 		}
 		if readErr == io.EOF {
 			break
@@ -145,58 +484,221 @@ func Send(opts SenderOptions) error {
 			return fmt.Errorf("read source file: %w: %w", readErr, apperrors.ErrIO)
 		}
 	}
+	tree.Finish()
+	if chunked {
+		if err := sendDigests(); err != nil {
+			return err
+		}
+	}
+
+	digest := tree.Root()
+	if err := sendDone(reader, writer, agreed, alg, digest); err != nil {
+		return err
+	}
+	if chunked {
+		cache.remove()
+	}
+	e := verifiedEvent("sending", entry.name, alg, digest)
+	e.Peer = opts.peer
+	opts.Observer.Observe(e)
+
+	reporter.Done(sent, entry.name)
+	comp.report(opts.Out)
+	_, _ = fmt.Fprintln(opts.Out, "Transfer complete.")
+	_, _ = fmt.Fprintln(opts.Out, "Integrity verified.")
+	_, _ = fmt.Fprintf(opts.Out, "%s: %x\n", alg, digest)
+	return nil
+}
+
+// sendOffer offers one file and returns the resume offset the receiver accepted.
+func sendOffer(reader *bufio.Reader, writer *bufio.Writer, offer OfferPayload) (uint64, error) {
+	offerPayload, err := EncodeOfferAttrs(offer.Name, offer.Size, offer.SessionID, offer.Attrs)
+	if err != nil {
+		return 0, fmt.Errorf("encode offer: %w", err)
+	}
+	if err := WriteFrame(writer, Frame{Type: TypeOffer, Payload: offerPayload}); err != nil {
+		return 0, fmt.Errorf("send offer: %w: %w", err, apperrors.ErrNetwork)
+	}
+	if err := writer.Flush(); err != nil {
+		return 0, fmt.Errorf("flush offer frames: %w: %w", err, apperrors.ErrNetwork)
+	}
 
-	digest := hasher.Sum()
-	donePayload, err := EncodeDone(digest)
+	resp, err := readFrame(reader, writer)
+	if err != nil {
+		return 0, fmt.Errorf("read receiver response: %w: %w", err, apperrors.ErrNetwork)
+	}
+	switch resp.Type {
+	case TypeAccept:
+		off, sid, decErr := DecodeAccept(resp.Payload)
+		if decErr != nil {
+			return 0, fmt.Errorf("decode accept frame: %w", decErr)
+		}
+		if sid != offer.SessionID {
+			return 0, fmt.Errorf("session mismatch receiver=%s sender=%s: %w", sid, offer.SessionID, apperrors.ErrRejected)
+		}
+		return off, nil
+	case TypeError:
+		return 0, receiverError(resp.Payload)
+	case TypeCancel:
+		return 0, cancelError("receiver", resp.Payload)
+	default:
+		return 0, fmt.Errorf("unexpected response frame type %d: %w", resp.Type, apperrors.ErrInvalidProtocol)
+	}
+}
+
+// verifiedEvent reports the digest both sides agreed on for the named file.
+func verifiedEvent(direction, name string, alg hash.Algorithm, digest []byte) progress.Event {
+	return progress.Event{Type: progress.EventVerified, Direction: direction, Name: name, Algorithm: alg.String(), Digest: hex.EncodeToString(digest)}
+}
+
+// sendChunkDigests sends a CHUNK frame for each leaf from index from onward and
+// returns the index of the first leaf not sent.
+func sendChunkDigests(reader *bufio.Reader, writer *bufio.Writer, leaves [][]byte, from int) (int, error) {
+	for ; from < len(leaves); from++ {
+		payload, err := EncodeChunkDigest(uint64(from), leaves[from])
+		if err != nil {
+			return from, fmt.Errorf("encode chunk digest: %w", err)
+		}
+		if err := WriteFrame(writer, Frame{Type: TypeChunk, Payload: payload}); err != nil {
+			return from, peerFailure(reader, fmt.Errorf("send chunk frame: %w: %w", err, apperrors.ErrNetwork))
+		}
+	}
+	return from, nil
+}
+
+// sendDone sends the file digest and waits for the receiver to confirm it. Only a
+// v1 receiver may confirm by closing the connection; from any other, EOF means it
+// died before verifying and the transfer is worth retrying.
+func sendDone(reader *bufio.Reader, writer *bufio.Writer, agreed HelloPayload, alg hash.Algorithm, digest []byte) error {
+	donePayload, err := EncodeDone(alg, digest)
 	if err != nil {
 		return fmt.Errorf("encode done payload: %w", err)
 	}
 	if err := WriteFrame(writer, Frame{Type: TypeDone, Payload: donePayload}); err != nil {
-		return fmt.Errorf("send done frame: %w: %w", err, apperrors.ErrNetwork)
+		return peerFailure(reader, fmt.Errorf("send done frame: %w: %w", err, apperrors.ErrNetwork))
 	}
 	if err := writer.Flush(); err != nil {
-		return fmt.Errorf("flush transfer frames: %w: %w", err, apperrors.ErrNetwork)
+		return peerFailure(reader, fmt.Errorf("flush transfer frames: %w: %w", err, apperrors.ErrNetwork))
 	}
 
-	status, readErr := ReadFrame(reader)
+	status, readErr := readFrame(reader, writer)
 	if readErr == nil && status.Type == TypeError {
-		msg, _ := DecodeError(status.Payload)
-		return fmt.Errorf("integrity check failed on receiver: %s: %w", msg, apperrors.ErrIntegrity)
+		return integrityError(status.Payload)
 	}
-	if readErr != nil && !errors.Is(readErr, io.EOF) {
+	if readErr == nil && status.Type == TypeCancel {
+		return cancelError("receiver", status.Payload)
+	}
+	if readErr == nil && status.Type != TypeVerified {
+		return fmt.Errorf("unexpected completion frame type %d: %w", status.Type, apperrors.ErrInvalidProtocol)
+	}
+	if readErr != nil && (!errors.Is(readErr, io.EOF) || agreed.MaxVersion > 1) {
 		return fmt.Errorf("read receiver completion status: %w: %w", readErr, apperrors.ErrNetwork)
 	}
-
-	_ = os.Remove(sessionPath(opts.Path))
-	reporter.Done(sent, sendName)
-	_, _ = fmt.Fprintln(opts.Out, "Transfer complete.")
-	_, _ = fmt.Fprintln(opts.Out, "Integrity verified.")
-	_, _ = fmt.Fprintf(opts.Out, "blake3: %s\n", hasher.SumHex())
 	return nil
 }
 
-func openSource(path, overrideName string) (*os.File, os.FileInfo, string, error) {
-	file, err := os.Open(path)
+// peerFailure prefers the receiver's ERROR or CANCEL frame, such as a failed block
+// digest or a full disk, over the write error it caused by closing the connection
+// mid-stream.
+func peerFailure(reader *bufio.Reader, writeErr error) error {
+	frame, err := ReadFrame(reader)
+	for err == nil && (frame.Type == TypePing || frame.Type == TypePong) {
+		frame, err = ReadFrame(reader)
+	}
+	switch {
+	case err == nil && frame.Type == TypeError:
+		return integrityError(frame.Payload)
+	case err == nil && frame.Type == TypeCancel:
+		return cancelError("receiver", frame.Payload)
+	}
+	return writeErr
+}
+
+func integrityError(payload []byte) error {
+	msg, _ := DecodeError(payload)
+	return fmt.Errorf("integrity check failed on receiver: %s: %w", msg, apperrors.ErrIntegrity)
+}
+
+func receiverError(payload []byte) error {
+	msg, err := DecodeError(payload)
 	if err != nil {
-		return nil, nil, "", fmt.Errorf("open source file: %w: %w", err, apperrors.ErrIO)
+		return fmt.Errorf("decode receiver error frame: %w", err)
 	}
-	info, err := file.Stat()
+	if strings.Contains(strings.ToLower(msg), "lock") {
+		return fmt.Errorf("receiver lock busy: %s: %w", msg, apperrors.ErrLockBusy)
+	}
+	return fmt.Errorf("receiver rejected transfer: %s: %w", msg, apperrors.ErrRejected)
+}
+
+// collectSources resolves the send path into file and empty-directory entries.
+// Directory entries carry slash-separated names rooted at the directory name.
+func collectSources(path, overrideName string, out io.Writer) ([]sourceEntry, bool, error) {
+	info, err := os.Stat(path)
 	if err != nil {
-		_ = file.Close()
-		return nil, nil, "", fmt.Errorf("stat source file: %w: %w", err, apperrors.ErrIO)
+		return nil, false, fmt.Errorf("stat source path: %w: %w", err, apperrors.ErrIO)
+	}
+	root := sourceName(path, overrideName)
+	if info.Mode().IsRegular() {
+		return []sourceEntry{{path: path, name: root, size: uint64(info.Size()), attrs: sourceAttrs(info)}}, false, nil
 	}
-	if !info.Mode().IsRegular() {
-		_ = file.Close()
-		return nil, nil, "", fmt.Errorf("source is not a regular file: %w", apperrors.ErrUsage)
+	if !info.IsDir() {
+		return nil, false, fmt.Errorf("source is not a regular file or directory: %w", apperrors.ErrUsage)
 	}
-	name := filepath.Base(path)
+
+	var entries []sourceEntry
+	walkErr := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(path, p)
+		if err != nil {
+			return err
+		}
+		name := root
+		if rel != "." {
+			name = root + "/" + filepath.ToSlash(rel)
+		}
+		if d.IsDir() {
+			children, err := os.ReadDir(p)
+			if err != nil {
+				return err
+			}
+			if len(children) == 0 {
+				entries = append(entries, sourceEntry{path: p, name: name, dir: true})
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			_, _ = fmt.Fprintf(out, "Skipping non-regular file %s\n", name)
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entries = append(entries, sourceEntry{path: p, name: name, size: uint64(fi.Size()), attrs: sourceAttrs(fi)})
+		return nil
+	})
+	if walkErr != nil {
+		return nil, false, fmt.Errorf("walk source directory: %w: %w", walkErr, apperrors.ErrIO)
+	}
+	return entries, true, nil
+}
+
+func sourceName(path, overrideName string) string {
 	if overrideName != "" {
-		name = overrideName
+		return overrideName
 	}
-	return file, info, name, nil
+	return filepath.Base(path)
+}
+
+// entrySessionID derives a stable per-file session id from the directory session id.
+func entrySessionID(rootSessionID, name string) string {
+	sum := sha256.Sum256([]byte(rootSessionID + "\x00" + name))
+	return hex.EncodeToString(sum[:16])
 }
 
-func hashPrefix(file *os.File, offset uint64, hasher *hash.Hasher) error {
+func hashPrefix(file *os.File, offset uint64, hasher io.Writer) error {
 	if _, err := file.Seek(0, io.SeekStart); err != nil {
 		return fmt.Errorf("seek file for prefix hash: %w: %w", err, apperrors.ErrIO)
 	}
@@ -219,6 +721,12 @@ func hashPrefix(file *os.File, offset uint64, hasher *hash.Hasher) error {
 	return nil
 }
 
+// hashResumedPrefix hashes the bytes the receiver already has, pinging it meanwhile
+// because a large prefix can take longer than its idle timeout.
+func hashResumedPrefix(reader *bufio.Reader, writer *bufio.Writer, agreed HelloPayload, file *os.File, offset uint64, hasher io.Writer) error {
+	return keepalive(reader, writer, agreed, func() error { return hashPrefix(file, offset, hasher) })
+}
+
 func sessionPath(sourcePath string) string {
 	return sourcePath + ".snapsync.session"
 }
@@ -231,13 +739,20 @@ func loadOrCreateSessionID(sourcePath string) (string, error) {
 			return s, nil
 		}
 	}
-	buf := make([]byte, 16)
-	if _, err := rand.Read(buf); err != nil {
-		return "", fmt.Errorf("generate random session id: %w", err)
+	s, err := newSessionID()
+	if err != nil {
+		return "", err
 	}
-	s := hex.EncodeToString(buf)
 	if err := os.WriteFile(p, []byte(s+"\n"), 0o600); err != nil {
 		return "", fmt.Errorf("write session file: %w", err)
 	}
 	return s, nil
 }
+
+func newSessionID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generate random session id: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,207 @@
+package transfer
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	apperrors "snapsync/internal/errors"
+	"snapsync/internal/progress"
+)
+
+// DefaultMaxConcurrent is the number of transfers Serve handles at once when unset.
+const DefaultMaxConcurrent = 4
+
+// maxHandshakes bounds how many connections a listener secures and negotiates at
+// once, leaving room for every stream of a striped transfer.
+const maxHandshakes = 2 * MaxStreams
+
+// cancelGrace is how long shutdown waits for busy connections to stop on their own.
+const cancelGrace = time.Second
+
+// Serve keeps the listener open and handles incoming connections until ctx is cancelled.
+// On shutdown, in-flight transfers are cancelled and their partials are kept for resume.
+func Serve(ctx context.Context, opts ReceiverOptions) error {
+	if opts.Sink != nil {
+		return fmt.Errorf("a sink receives a single transfer and cannot serve: %w", apperrors.ErrUsage)
+	}
+	ln, stop, err := listen(&opts)
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	opts.Out = &lockedWriter{w: opts.Out}
+	if opts.Observer == nil {
+		opts.Observer = progress.NewPlain(opts.Out)
+	}
+	if opts.Prompt != nil {
+		opts.Prompt = serializePrompt(ctx, opts.Prompt)
+	}
+	if opts.Cancel == nil {
+		opts.Cancel = ctx.Done()
+	}
+	// Slots count transfers rather than connections, so the extra streams of a
+	// striped transfer never wait behind the transfer they belong to.
+	policy := connPolicy{handshake: handshakeSlots(opts.Timeouts), admit: transferSlots(ctx, opts.MaxConcurrent), observeErrors: true}
+	err = acceptLoop(ctx, ln, opts.Out, "transfer", func(conn net.Conn) error {
+		return handleConnection(conn, opts, policy)
+	})
+	if err == nil {
+		_, _ = fmt.Fprintln(opts.Out, "Receiver stopped.")
+	}
+	return err
+}
+
+// transferSlots returns an admit func that allows limit transfers at once, or
+// DefaultMaxConcurrent when limit is unset.
+func transferSlots(ctx context.Context, limit int) func() (func(), error) {
+	if limit <= 0 {
+		limit = DefaultMaxConcurrent
+	}
+	slots := make(chan struct{}, limit)
+	return func() (func(), error) {
+		select {
+		case slots <- struct{}{}:
+			return func() { <-slots }, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// handshakeSlots returns an admit func that lets maxHandshakes connections run
+// greet at once. Slots are taken before anything is read from the peer, since a
+// transfer slot can only be taken once greet shows what the connection is for. A
+// connection that cannot start its handshake within the handshake timeout is
+// refused.
+func handshakeSlots(t Timeouts) func() (func(), error) {
+	wait := cmp.Or(t.Handshake, DefaultHandshakeTimeout)
+	slots := make(chan struct{}, maxHandshakes)
+	return func() (func(), error) {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case slots <- struct{}{}:
+			return func() { <-slots }, nil
+		case <-timer.C:
+			return nil, fmt.Errorf("too many connections handshaking: %w", apperrors.ErrNetwork)
+		}
+	}
+}
+
+// acceptLoop hands each accepted connection to handle on its own goroutine until
+// ctx is cancelled. Handlers then get cancelGrace to tell their peers with CANCEL
+// before the open connections are closed. Failures are reported on out as failures
+// of what.
+func acceptLoop(ctx context.Context, ln net.Listener, out io.Writer, what string, handle func(net.Conn) error) error {
+	go func() {
+		<-ctx.Done()
+		_ = ln.Close()
+	}()
+	active := &connSet{conns: map[net.Conn]struct{}{}}
+	var wg sync.WaitGroup
+	defer func() {
+		finished := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(finished)
+		}()
+		select {
+		case <-finished:
+			return
+		case <-time.After(cancelGrace):
+		}
+		active.closeAll()
+		<-finished
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("accept connection: %w: %w", err, apperrors.ErrNetwork)
+		}
+		active.add(conn)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer active.remove(conn)
+			if err := handle(conn); err != nil {
+				_, _ = fmt.Fprintf(out, "%s from %s failed: %v\n", what, conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// connSet tracks open connections so shutdown can interrupt them.
+type connSet struct {
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+func (c *connSet) add(conn net.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conns[conn] = struct{}{}
+}
+
+func (c *connSet) remove(conn net.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.conns, conn)
+	_ = conn.Close()
+}
+
+func (c *connSet) closeAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for conn := range c.conns {
+		_ = conn.Close()
+	}
+}
+
+// lockedWriter serializes writes from concurrent transfers.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// serializePrompt asks one question at a time and gives up when ctx is cancelled.
+func serializePrompt(ctx context.Context, prompt PromptFunc) PromptFunc {
+	var mu sync.Mutex
+	return func(name string, size uint64, peer string) (bool, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		type answer struct {
+			ok  bool
+			err error
+		}
+		result := make(chan answer, 1)
+		go func() {
+			ok, err := prompt(name, size, peer)
+			result <- answer{ok: ok, err: err}
+		}()
+		select {
+		case a := <-result:
+			return a.ok, a.err
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"snapsync/internal/resume"
+)
+
+func startServe(t *testing.T, ctx context.Context, opts ReceiverOptions) (string, <-chan error) {
+	t.Helper()
+	return startListening(t, "Serve", func(onListening func(net.Addr) (func(), error)) error {
+		opts.Listen, opts.OnListening = "127.0.0.1:0", onListening
+		return Serve(ctx, opts)
+	})
+}
+
+func TestServeHandlesConcurrentTransfers(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	addr, done := startServe(t, ctx, ReceiverOptions{OutDir: dstDir, AutoAccept: true, Resume: true, Out: ioDiscard{}, MaxConcurrent: 2})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		path := filepath.Join(srcDir, fmt.Sprintf("file-%d.bin", i))
+		if err := os.WriteFile(path, bytes.Repeat([]byte{byte(i)}, 256*1024), 0o644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- Send(SenderOptions{Path: path, Address: addr, Resume: true, Out: ioDiscard{}})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	}
+	for i := 0; i < 4; i++ {
+		got, err := os.ReadFile(filepath.Join(dstDir, fmt.Sprintf("file-%d.bin", i)))
+		if err != nil || len(got) != 256*1024 || got[0] != byte(i) {
+			t.Fatalf("file %d not received intact: len=%d err=%v", i, len(got), err)
+		}
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Serve() after shutdown error = %v", err)
+	}
+}
+
+func TestServeShutdownPreservesPartial(t *testing.T) {
+	dstDir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	addr, done := startServe(t, ctx, ReceiverOptions{OutDir: dstDir, AutoAccept: true, Resume: true, Out: ioDiscard{}})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	_ = WriteFrame(conn, Frame{Type: TypeHello})
+	offer, _ := EncodeOffer("inflight.bin", 8*1024*1024, "session-inflight")
+	_ = WriteFrame(conn, Frame{Type: TypeOffer, Payload: offer})
+	if accept, err := ReadFrame(conn); err != nil || accept.Type != TypeAccept {
+		t.Fatalf("expected ACCEPT, got %v %v", accept.Type, err)
+	}
+	if err := WriteFrame(conn, Frame{Type: TypeData, Payload: make([]byte, 4096)}); err != nil {
+		t.Fatalf("WriteFrame() error = %v", err)
+	}
+
+	paths, _ := resume.ResolvePaths(dstDir, "inflight.bin", false)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if info, statErr := os.Stat(paths.Partial); statErr == nil && info.Size() == 4096 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("partial file was not written")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Serve() after shutdown error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve() did not stop after cancellation")
+	}
+	meta, err := resume.LoadMeta(paths.Meta)
+	if err != nil {
+		t.Fatalf("expected resume metadata after shutdown: %v", err)
+	}
+	if meta.ReceivedOffset != 4096 {
+		t.Fatalf("expected resume offset 4096, got %d", meta.ReceivedOffset)
+	}
+}
+
+func TestHandshakeSlotsBoundConcurrentGreets(t *testing.T) {
+	take := handshakeSlots(Timeouts{Handshake: 20 * time.Millisecond})
+	var releases []func()
+	for i := 0; i < maxHandshakes; i++ {
+		release, err := take()
+		if err != nil {
+			t.Fatalf("slot %d: %v", i, err)
+		}
+		releases = append(releases, release)
+	}
+	if _, err := take(); err == nil {
+		t.Fatal("expected a handshake beyond the limit to be refused")
+	}
+	releases[0]()
+	if _, err := take(); err != nil {
+		t.Fatalf("expected a released slot to be reusable, got %v", err)
+	}
+}
@@ -0,0 +1,383 @@
+package transfer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+
+	apperrors "snapsync/internal/errors"
+	"snapsync/internal/hash"
+	"snapsync/internal/identity"
+	"snapsync/internal/progress"
+	"snapsync/internal/throttle"
+)
+
+// ShareOptions configures a peer that serves files for others to pull.
+type ShareOptions struct {
+	Listen            string
+	Paths             []string
+	Out               io.Writer
+	OnListening       func(addr net.Addr) (func(), error)
+	Identity          *identity.Identity
+	RequireEncryption bool
+	Trust             TrustFunc
+	// AcceptAll serves every peer. Otherwise trusted peers are served, and others
+	// only when Prompt approves the request. RejectUntrusted refuses peers that are
+	// not trusted even when AcceptAll is set.
+	AcceptAll       bool
+	RejectUntrusted bool
+	Prompt          PromptFunc
+	MaxConcurrent   int
+	Compress        bool
+	Delta           bool
+	Timeouts        Timeouts
+	Limit           *throttle.Limiter
+	// Observer receives the events of every pull served. Defaults to
+	// progress.NewPlain(Out).
+	Observer progress.Observer
+}
+
+// GetOptions configures pulling from a sharing peer. The receive side behaves like
+// a receiver that accepted the transfer.
+type GetOptions struct {
+	Address      string
+	OutDir       string
+	Overwrite    bool
+	Resume       bool
+	KeepPartial  bool
+	ForceRestart bool
+	BreakLock    bool
+	Identity     *identity.Identity
+	VerifyPeer   func(PeerIdentity) error
+	Hash         hash.Algorithm
+	Preserve     uint8
+	Out          io.Writer
+	Timeouts     Timeouts
+	Limit        *throttle.Limiter
+	// Cancel, once closed, stops the transfer and tells the sharing peer with CANCEL.
+	Cancel <-chan struct{}
+	// Observer receives the transfer's events. Defaults to progress.NewPlain(Out).
+	Observer progress.Observer
+}
+
+// sharedPath is one shared file or directory, requested by its base name.
+type sharedPath struct {
+	name string
+	path string
+}
+
+// Share serves the shared paths to peers that ask for them until ctx is cancelled.
+// Each connection either lists the shares or pulls one of them, with the sharing
+// peer in the sender role.
+func Share(ctx context.Context, opts ShareOptions) error {
+	if opts.Listen == "" || len(opts.Paths) == 0 {
+		return fmt.Errorf("missing required share options: %w", apperrors.ErrUsage)
+	}
+	if opts.Out == nil {
+		opts.Out = io.Discard
+	}
+	shares, err := resolveShares(opts.Paths)
+	if err != nil {
+		return err
+	}
+	ln, stop, err := openListener(opts.Listen, opts.OnListening, opts.Out)
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	opts.Out = &lockedWriter{w: opts.Out}
+	if opts.Observer == nil {
+		opts.Observer = progress.NewPlain(opts.Out)
+	}
+	if opts.Prompt != nil {
+		opts.Prompt = serializePrompt(ctx, opts.Prompt)
+	}
+	greeting := ReceiverOptions{
+		Out:               opts.Out,
+		Identity:          opts.Identity,
+		RequireEncryption: opts.RequireEncryption,
+		Trust:             opts.Trust,
+		AutoAccept:        opts.AcceptAll,
+		RejectUntrusted:   opts.RejectUntrusted,
+		Prompt:            opts.Prompt,
+		Timeouts:          opts.Timeouts,
+		Limit:             opts.Limit,
+		Cancel:            ctx.Done(),
+		Observer:          opts.Observer,
+	}
+	admit := transferSlots(ctx, opts.MaxConcurrent)
+	policy := connPolicy{handshake: handshakeSlots(opts.Timeouts), observeErrors: true}
+	err = acceptLoop(ctx, ln, opts.Out, "request", func(conn net.Conn) (err error) {
+		peer := conn.RemoteAddr().String()
+		defer func() { policy.observeFailure(opts.Observer, "sending", peer, err) }()
+		s, err := policy.greet(conn, greeting)
+		if err != nil {
+			return err
+		}
+		peer = s.peer
+		return s.serveShare(shares, opts, admit)
+	})
+	if err == nil {
+		_, _ = fmt.Fprintln(opts.Out, "Sharing stopped.")
+	}
+	return err
+}
+
+// resolveShares checks that every shared path exists and has a distinct base name.
+func resolveShares(paths []string) ([]sharedPath, error) {
+	var shares []sharedPath
+	seen := map[string]bool{}
+	for _, p := range paths {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return nil, fmt.Errorf("resolve shared path %s: %w: %w", p, err, apperrors.ErrUsage)
+		}
+		if _, err := os.Stat(abs); err != nil {
+			return nil, fmt.Errorf("stat shared path: %w: %w", err, apperrors.ErrUsage)
+		}
+		name := filepath.Base(abs)
+		if seen[name] {
+			return nil, fmt.Errorf("two shared paths are both named %s: %w", name, apperrors.ErrUsage)
+		}
+		seen[name] = true
+		shares = append(shares, sharedPath{name: name, path: abs})
+	}
+	return shares, nil
+}
+
+// serveShare answers one LIST or REQUEST from a greeted peer.
+func (s *receiverSession) serveShare(shares []sharedPath, opts ShareOptions, admit func() (func(), error)) error {
+	frame, err := readFrame(s.reader, s.writer)
+	if err != nil {
+		return fmt.Errorf("read request frame: %w", err)
+	}
+	switch frame.Type {
+	case TypeList:
+		if !s.trusted && (s.opts.RejectUntrusted || !s.opts.AutoAccept && s.opts.Prompt == nil) {
+			_ = sendErrorFrame(s.writer, "untrusted peer")
+			return fmt.Errorf("listing for untrusted peer %s refused: %w", s.peer, apperrors.ErrRejected)
+		}
+		return s.sendList(shares)
+	case TypeRequest:
+	default:
+		return sendProtocolError(s.writer, fmt.Sprintf("expected LIST or REQUEST, got %d", frame.Type))
+	}
+
+	name, err := DecodeRequest(frame.Payload)
+	if err != nil {
+		_ = sendProtocolError(s.writer, "invalid request payload")
+		return fmt.Errorf("decode request: %w", err)
+	}
+	var share *sharedPath
+	for i := range shares {
+		if shares[i].name == name {
+			share = &shares[i]
+		}
+	}
+	if share == nil {
+		_ = sendErrorFrame(s.writer, "no such shared file")
+		return fmt.Errorf("peer %s requested unknown share %q: %w", s.peer, name, apperrors.ErrRejected)
+	}
+	entries, isDir, err := collectSources(share.path, "", io.Discard)
+	if err != nil {
+		_ = sendErrorFrame(s.writer, "shared file unavailable")
+		return err
+	}
+	summary := shareEntry(share.name, entries, isDir)
+	if err := s.acceptTransfer(summary.Name, summary.Size); err != nil {
+		return err
+	}
+	release, err := admit()
+	if err != nil {
+		_ = sendErrorFrame(s.writer, "sharing stopped")
+		return err
+	}
+	defer release()
+
+	_, _ = fmt.Fprintf(opts.Out, "Sending %s to %s\n", share.name, s.peer)
+	send := SenderOptions{Path: share.path, Out: opts.Out, Resume: true, Compress: opts.Compress, Delta: opts.Delta, Cancel: s.opts.Cancel, Observer: s.opts.Observer, Limit: s.opts.Limit, peer: s.peer}
+	return sendEntries(s.reader, s.writer, s.hello, send, entries, isDir, shareSessionID(share.path, entries))
+}
+
+// sendList answers LIST with one reply per share and an empty reply to end.
+func (s *receiverSession) sendList(shares []sharedPath) error {
+	for _, share := range shares {
+		entries, isDir, err := collectSources(share.path, "", io.Discard)
+		if err != nil {
+			_, _ = fmt.Fprintf(s.opts.Out, "Skipping unavailable share %s: %v\n", share.name, err)
+			continue
+		}
+		payload, err := EncodeListEntry(shareEntry(share.name, entries, isDir))
+		if err != nil {
+			return fmt.Errorf("encode list entry: %w", err)
+		}
+		if err := WriteFrame(s.writer, Frame{Type: TypeList, Payload: payload}); err != nil {
+			return fmt.Errorf("send list frame: %w: %w", err, apperrors.ErrNetwork)
+		}
+	}
+	if err := WriteFrame(s.writer, Frame{Type: TypeList}); err != nil {
+		return fmt.Errorf("send list end frame: %w: %w", err, apperrors.ErrNetwork)
+	}
+	if err := s.writer.Flush(); err != nil {
+		return fmt.Errorf("flush list frames: %w: %w", err, apperrors.ErrNetwork)
+	}
+	return nil
+}
+
+// shareEntry summarizes collected sources as one LIST entry.
+func shareEntry(name string, entries []sourceEntry, isDir bool) ShareEntry {
+	e := ShareEntry{Name: name, Dir: isDir}
+	for _, entry := range entries {
+		e.Size += entry.size
+	}
+	return e
+}
+
+// shareSessionID derives the session id of a shared path from its contents' names,
+// sizes, and modification times, so a repeated pull resumes until the share changes.
+func shareSessionID(path string, entries []sourceEntry) string {
+	h := sha256.New()
+	_, _ = io.WriteString(h, path)
+	for _, entry := range entries {
+		_, _ = io.WriteString(h, "\x00"+entry.name+"\x00")
+		_ = binary.Write(h, binary.BigEndian, entry.size)
+		_ = binary.Write(h, binary.BigEndian, entry.attrs.ModTime.UnixNano())
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// Get pulls the named share from a sharing peer into OutDir.
+func Get(opts GetOptions, name string) error {
+	if opts.Address == "" || opts.OutDir == "" || name == "" {
+		return fmt.Errorf("missing required get options: %w", apperrors.ErrUsage)
+	}
+	if opts.Out == nil {
+		opts.Out = io.Discard
+	}
+	if opts.Observer == nil {
+		opts.Observer = progress.NewPlain(opts.Out)
+	}
+	if err := os.MkdirAll(opts.OutDir, 0o755); err != nil {
+		return fmt.Errorf("create output dir: %w: %w", err, apperrors.ErrIO)
+	}
+	payload, err := EncodeRequest(name)
+	if err != nil {
+		return fmt.Errorf("encode request: %w: %w", err, apperrors.ErrUsage)
+	}
+	s, err := dialShare(opts, Frame{Type: TypeRequest, Payload: payload})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = s.conn.Close() }()
+
+	first, err := readFrame(s.reader, s.writer)
+	if err != nil {
+		return fmt.Errorf("read share response: %w: %w", err, apperrors.ErrNetwork)
+	}
+	switch first.Type {
+	case TypeOffer:
+		return s.receiveFile(first, false)
+	case TypeBatch:
+		if !s.hello.Has(CapMultiFile) {
+			return sendProtocolError(s.writer, "directory transfers were not negotiated")
+		}
+		return s.receiveBatch(first)
+	case TypeError:
+		return shareError(first.Payload)
+	case TypeCancel:
+		return cancelError("sharer", first.Payload)
+	default:
+		return sendProtocolError(s.writer, fmt.Sprintf("expected OFFER, got %d", first.Type))
+	}
+}
+
+// ListShares asks a sharing peer which files and directories it serves.
+func ListShares(opts GetOptions) ([]ShareEntry, error) {
+	if opts.Address == "" {
+		return nil, fmt.Errorf("missing required get options: %w", apperrors.ErrUsage)
+	}
+	if opts.Out == nil {
+		opts.Out = io.Discard
+	}
+	s, err := dialShare(opts, Frame{Type: TypeList})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = s.conn.Close() }()
+
+	var entries []ShareEntry
+	for {
+		frame, err := readFrame(s.reader, s.writer)
+		if err != nil {
+			return nil, fmt.Errorf("read list frame: %w: %w", err, apperrors.ErrNetwork)
+		}
+		switch {
+		case frame.Type == TypeList && len(frame.Payload) == 0:
+			return entries, nil
+		case frame.Type == TypeList:
+			entry, err := DecodeListEntry(frame.Payload)
+			if err != nil {
+				return nil, fmt.Errorf("decode list entry: %w", err)
+			}
+			entries = append(entries, entry)
+		case frame.Type == TypeError:
+			return nil, shareError(frame.Payload)
+		default:
+			return nil, fmt.Errorf("unexpected list frame type %d: %w", frame.Type, apperrors.ErrInvalidProtocol)
+		}
+	}
+}
+
+// dialShare connects to a sharing peer, sends the request frame, and returns a
+// receiver session for the reply with the transfer already accepted.
+func dialShare(opts GetOptions, request Frame) (*receiverSession, error) {
+	dial := SenderOptions{Address: opts.Address, Identity: opts.Identity, VerifyPeer: opts.VerifyPeer, Hash: opts.Hash, Timeouts: opts.Timeouts, Limit: opts.Limit}
+	conn, reader, writer, agreed, err := dialReceiver(dial, opts.Out)
+	if err != nil {
+		return nil, err
+	}
+	if err := WriteFrame(writer, request); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("send request frame: %w: %w", err, apperrors.ErrNetwork)
+	}
+	if err := writer.Flush(); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("flush request frame: %w: %w", err, apperrors.ErrNetwork)
+	}
+	return &receiverSession{
+		conn:   conn,
+		reader: reader,
+		writer: writer,
+		peer:   conn.RemoteAddr().String(),
+		hello:  agreed,
+		opts: ReceiverOptions{
+			OutDir:       opts.OutDir,
+			Overwrite:    opts.Overwrite,
+			AutoAccept:   true,
+			Out:          opts.Out,
+			Resume:       opts.Resume,
+			KeepPartial:  opts.KeepPartial,
+			ForceRestart: opts.ForceRestart,
+			BreakLock:    opts.BreakLock,
+			Preserve:     opts.Preserve,
+			Cancel:       opts.Cancel,
+			Observer:     opts.Observer,
+			Limit:        opts.Limit,
+		},
+	}, nil
+}
+
+func shareError(payload []byte) error {
+	msg, err := DecodeError(payload)
+	if err != nil {
+		return fmt.Errorf("decode share error frame: %w", err)
+	}
+	return fmt.Errorf("sharing peer refused request: %s: %w", msg, apperrors.ErrRejected)
+}
@@ -0,0 +1,133 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	apperrors "snapsync/internal/errors"
+	"snapsync/internal/identity"
+	"snapsync/internal/resume"
+)
+
+func startShare(t *testing.T, ctx context.Context, opts ShareOptions) (string, <-chan error) {
+	t.Helper()
+	return startListening(t, "Share", func(onListening func(net.Addr) (func(), error)) error {
+		opts.Listen, opts.OnListening = "127.0.0.1:0", onListening
+		return Share(ctx, opts)
+	})
+}
+
+func TestGetListsAndPullsShares(t *testing.T) {
+	srcPath, srcData := stripeSource(t, 2)
+	tree := filepath.Join(t.TempDir(), "album")
+	if err := os.MkdirAll(filepath.Join(tree, "empty"), 0o755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tree, "cover.jpg"), []byte("cover"), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	addr, done := startShare(t, ctx, ShareOptions{Paths: []string{srcPath, tree}, AcceptAll: true, Out: ioDiscard{}})
+
+	entries, err := ListShares(GetOptions{Address: addr})
+	if err != nil {
+		t.Fatalf("ListShares() error = %v", err)
+	}
+	want := []ShareEntry{{Name: "striped.bin", Size: uint64(len(srcData))}, {Name: "album", Size: 5, Dir: true}}
+	if len(entries) != len(want) || entries[0] != want[0] || entries[1] != want[1] {
+		t.Fatalf("ListShares() = %+v, want %+v", entries, want)
+	}
+
+	dstDir := t.TempDir()
+	for _, name := range []string{"striped.bin", "album"} {
+		if err := Get(GetOptions{Address: addr, OutDir: dstDir, Resume: true, Out: ioDiscard{}}, name); err != nil {
+			t.Fatalf("Get(%s) error = %v", name, err)
+		}
+	}
+	if got, err := os.ReadFile(filepath.Join(dstDir, "striped.bin")); err != nil || !bytes.Equal(got, srcData) {
+		t.Fatalf("pulled file mismatch err=%v", err)
+	}
+	if got, err := os.ReadFile(filepath.Join(dstDir, "album", "cover.jpg")); err != nil || string(got) != "cover" {
+		t.Fatalf("pulled directory file = %q, %v", got, err)
+	}
+	if info, err := os.Stat(filepath.Join(dstDir, "album", "empty")); err != nil || !info.IsDir() {
+		t.Fatalf("expected empty directory to be pulled, err=%v", err)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Share() error = %v", err)
+	}
+}
+
+func TestGetResumesInterruptedPull(t *testing.T) {
+	srcPath, srcData := stripeSource(t, 9)
+	prev := senderChunkMutator
+	senderChunkMutator = func(chunk []byte) {
+		if chunk[0] == 5 { // second integrity block
+			chunk[0] ^= 0xFF
+		}
+	}
+	defer func() { senderChunkMutator = prev }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	shareOut := &bytes.Buffer{}
+	addr, done := startShare(t, ctx, ShareOptions{Paths: []string{srcPath}, AcceptAll: true, Out: shareOut})
+	dstDir := t.TempDir()
+	opts := GetOptions{Address: addr, OutDir: dstDir, Resume: true, Out: ioDiscard{}}
+	if err := Get(opts, "striped.bin"); !errors.Is(err, apperrors.ErrIntegrity) {
+		t.Fatalf("expected integrity error on the corrupted pull, got %v", err)
+	}
+	paths, _ := resume.ResolvePaths(dstDir, "striped.bin", false)
+	if info, err := os.Stat(paths.Partial); err != nil || info.Size() != IntegrityBlockSize {
+		t.Fatalf("expected the verified block to be kept, info=%v err=%v", info, err)
+	}
+
+	senderChunkMutator = prev
+	if err := Get(opts, "striped.bin"); err != nil {
+		t.Fatalf("resumed Get() error = %v", err)
+	}
+	if got, _ := os.ReadFile(paths.Final); !bytes.Equal(got, srcData) {
+		t.Fatal("final file mismatch after resumed pull")
+	}
+	cancel()
+	<-done
+	if !strings.Contains(shareOut.String(), "Resuming at offset 4194304") {
+		t.Fatalf("expected the pull to resume after the verified block, got %q", shareOut.String())
+	}
+}
+
+func TestShareRefusesUntrustedPeersAndUnknownNames(t *testing.T) {
+	srcPath, _ := stripeSource(t, 1)
+	sharerID, _ := identity.Generate("sharer")
+	friendID, _ := identity.Generate("friend")
+	strangerID, _ := identity.Generate("stranger")
+	trust := func(p PeerIdentity) (bool, error) { return p.Fingerprint == friendID.Fingerprint(), nil }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	addr, done := startShare(t, ctx, ShareOptions{Paths: []string{srcPath}, Identity: &sharerID, RequireEncryption: true, Trust: trust, Out: ioDiscard{}})
+
+	if _, err := ListShares(GetOptions{Address: addr, Identity: &strangerID}); !errors.Is(err, apperrors.ErrRejected) {
+		t.Fatalf("expected untrusted listing to be refused, got %v", err)
+	}
+	if err := Get(GetOptions{Address: addr, OutDir: t.TempDir(), Identity: &strangerID}, "striped.bin"); !errors.Is(err, apperrors.ErrRejected) {
+		t.Fatalf("expected untrusted pull to be refused, got %v", err)
+	}
+	if err := Get(GetOptions{Address: addr, OutDir: t.TempDir(), Identity: &friendID}, "missing.bin"); !errors.Is(err, apperrors.ErrRejected) {
+		t.Fatalf("expected unknown share to be refused, got %v", err)
+	}
+	if err := Get(GetOptions{Address: addr, OutDir: t.TempDir(), Identity: &friendID}, "striped.bin"); err != nil {
+		t.Fatalf("trusted Get() error = %v", err)
+	}
+	cancel()
+	<-done
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package transfer
+
+import "net"
+
+// boundUnsent leaves the connection alone where the platform cannot cap its
+// unsent queue.
+func boundUnsent(net.Conn) {}
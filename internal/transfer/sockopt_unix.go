@@ -0,0 +1,30 @@
+//go:build linux || darwin
+
+package transfer
+
+import (
+	"net"
+	"runtime"
+	"syscall"
+)
+
+// boundUnsent caps the data a dialed connection queues in the kernel beyond what
+// is in flight, so a receiver reading under a rate limit never has more than a
+// window's worth to catch up on once the sender is done writing.
+func boundUnsent(conn net.Conn) {
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	raw, err := tcp.SyscallConn()
+	if err != nil {
+		return
+	}
+	opt := 0x19 // TCP_NOTSENT_LOWAT on Linux, missing from package syscall there.
+	if runtime.GOOS == "darwin" {
+		opt = 0x201
+	}
+	_ = raw.Control(func(fd uintptr) {
+		_ = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, opt, limitedWindow)
+	})
+}
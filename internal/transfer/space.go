@@ -0,0 +1,46 @@
+package transfer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	apperrors "snapsync/internal/errors"
+)
+
+// diskFree reports free space; tests replace it.
+var diskFree = freeSpace
+
+// checkSpace refuses an offer whose bytes past offset do not fit beside partial,
+// before anything is written. Blocks the partial already holds past offset, whether
+// written or preallocated by an earlier attempt, count toward the need, since free
+// space no longer includes them. The refusal is a disk-full CANCEL, which peers
+// without CANCEL support get as an ERROR frame. Filesystems that cannot report free
+// space are not checked.
+func (s *receiverSession) checkSpace(partial string, offset, size uint64) error {
+	dir := filepath.Dir(partial)
+	need := size - offset
+	if held := allocatedSpace(partial); held > offset {
+		need -= min(need, held-offset)
+	}
+	free, err := diskFree(dir)
+	if err != nil || need <= free {
+		return nil
+	}
+	msg := fmt.Sprintf("not enough disk space: need %d bytes, %d free", need, free)
+	_ = sendCancel(s.writer, s.hello, CancelDiskFull, msg)
+	return fmt.Errorf("%s in %s: %w", msg, dir, apperrors.ErrDiskFull)
+}
+
+// reserve preallocates the rest of a partial so the space cannot run out mid-transfer
+// and the file is laid out in one piece. Only a full disk is an error; filesystems
+// without preallocation just skip it.
+func (s *receiverSession) reserve(file *os.File, offset, size uint64) error {
+	if size <= offset {
+		return nil
+	}
+	if err := preallocate(file, int64(offset), int64(size-offset)); diskFull(err) {
+		return s.writeFailure(s.writer, "preallocate partial output file", err)
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !windows
+
+package transfer
+
+import "errors"
+
+// freeSpace cannot measure free space on this platform, so offers go unchecked.
+func freeSpace(string) (uint64, error) { return 0, errors.ErrUnsupported }
+
+// allocatedSpace is not measured here, so partials are not credited.
+func allocatedSpace(string) uint64 { return 0 }
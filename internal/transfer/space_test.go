@@ -0,0 +1,83 @@
+package transfer
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	apperrors "snapsync/internal/errors"
+	"snapsync/internal/hash"
+)
+
+func TestReceiverRefusesOfferLargerThanFreeSpace(t *testing.T) {
+	if _, err := freeSpace(t.TempDir()); err != nil {
+		t.Skipf("free space unavailable here: %v", err)
+	}
+	dir := t.TempDir()
+	addr, done := startReceiver(t, ReceiverOptions{OutDir: dir, AutoAccept: true, Resume: true, Out: ioDiscard{}})
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	hello, _ := EncodeHello(localHello(hash.Default))
+	_ = WriteFrame(conn, Frame{Type: TypeHello, Payload: hello})
+	if reply, err := ReadFrame(conn); err != nil || reply.Type != TypeHello {
+		t.Fatalf("expected HELLO, got %+v err=%v", reply, err)
+	}
+	offer, _ := EncodeOffer("huge.bin", 1<<62, "huge-session")
+	_ = WriteFrame(conn, Frame{Type: TypeOffer, Payload: offer})
+
+	reply, err := ReadFrame(conn)
+	if err != nil || reply.Type != TypeCancel {
+		t.Fatalf("expected CANCEL, got %+v err=%v", reply, err)
+	}
+	if reason, _, _ := DecodeCancel(reply.Payload); reason != CancelDiskFull {
+		t.Fatalf("cancel reason = %s, want disk full", reason)
+	}
+	if err := <-done; !errors.Is(err, apperrors.ErrDiskFull) {
+		t.Fatalf("expected disk-full refusal, got %v", err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "huge.bin*")); len(matches) != 0 {
+		t.Fatalf("refused offer left files behind: %v", matches)
+	}
+}
+
+func TestResumeCountsPreallocatedPartialAsFree(t *testing.T) {
+	srcPath := filepath.Join(t.TempDir(), "prealloc.bin")
+	srcData := bytes.Repeat([]byte("0123456789abcdef"), 512*1024) // 8MB
+	if err := os.WriteFile(srcPath, srcData, 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	dstDir := t.TempDir()
+	addr, done := startReceiver(t, ReceiverOptions{OutDir: dstDir, AutoAccept: true, Resume: true, Out: ioDiscard{}})
+	if err := sendPartial(srcPath, addr, 2*1024*1024); err != nil {
+		t.Fatalf("sendPartial() error = %v", err)
+	}
+	if err := <-done; err == nil {
+		t.Fatal("expected interrupted transfer to fail")
+	}
+	partials, _ := filepath.Glob(filepath.Join(dstDir, "*.partial"))
+	if len(partials) != 1 || allocatedSpace(partials[0]) < uint64(len(srcData)) {
+		t.Skip("partial was not preallocated on this filesystem")
+	}
+
+	// The preallocated blocks already hold the remainder, so a full disk must not
+	// refuse the resume.
+	orig := diskFree
+	diskFree = func(string) (uint64, error) { return 0, nil }
+	t.Cleanup(func() { diskFree = orig })
+
+	addr, done = startReceiver(t, ReceiverOptions{OutDir: dstDir, AutoAccept: true, Resume: true, Out: ioDiscard{}})
+	sendErr := Send(SenderOptions{Path: srcPath, Address: addr, Resume: true, Out: ioDiscard{}})
+	if recvErr := <-done; sendErr != nil || recvErr != nil {
+		t.Fatalf("resume near free-space limit: send=%v recv=%v", sendErr, recvErr)
+	}
+	got, err := os.ReadFile(filepath.Join(dstDir, "prealloc.bin"))
+	if err != nil || !bytes.Equal(got, srcData) {
+		t.Fatalf("resumed file mismatch: err=%v", err)
+	}
+}
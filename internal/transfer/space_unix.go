@@ -0,0 +1,24 @@
+//go:build linux || darwin
+
+package transfer
+
+import "syscall"
+
+// freeSpace returns the bytes available to this user on the filesystem holding dir.
+func freeSpace(dir string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
+
+// allocatedSpace returns the bytes of disk held by path, including blocks
+// preallocated past its size, or 0 if it does not exist.
+func allocatedSpace(path string) uint64 {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return 0
+	}
+	return uint64(st.Blocks) * 512
+}
@@ -0,0 +1,310 @@
+package transfer
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	apperrors "snapsync/internal/errors"
+	"snapsync/internal/hash"
+	"snapsync/internal/progress"
+	"snapsync/internal/resume"
+)
+
+// sendStdin streams opts.Input to the receiver as one file of unknown length. A
+// stream cannot be rewound, so it has no session file and never resumes.
+func sendStdin(opts SenderOptions) error {
+	if opts.Input == nil {
+		opts.Input = os.Stdin
+	}
+	name := opts.OverrideName
+	if name == "" {
+		name = "stdin"
+	}
+	sessionID, err := newSessionID()
+	if err != nil {
+		return fmt.Errorf("prepare session id: %w", err)
+	}
+
+	conn, reader, writer, agreed, err := dialReceiver(opts, opts.Out)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+	if !agreed.Has(CapStreaming | CapChunkDigests) {
+		return fmt.Errorf("receiver does not support streamed transfers: %w", apperrors.ErrInvalidProtocol)
+	}
+	if opts.Compress && !agreed.Has(CapCompression) {
+		_, _ = fmt.Fprintln(opts.Out, "Receiver does not support compression; sending uncompressed.")
+	}
+	opts.Observer.Observe(progress.Event{Type: progress.EventOffer, Direction: "sending", Name: name})
+	offset, err := sendOffer(reader, writer, OfferPayload{Name: name, Size: UnknownSize, SessionID: sessionID})
+	if err != nil {
+		return err
+	}
+	if offset != 0 {
+		return fmt.Errorf("receiver asked to resume a stream at offset %d: %w", offset, apperrors.ErrInvalidProtocol)
+	}
+	opts.Observer.Observe(progress.Event{Type: progress.EventAccepted, Direction: "sending", Name: name})
+
+	alg := hash.Algorithm(agreed.Hashes[0])
+	tree, err := hash.NewTree(alg, IntegrityBlockSize, nil)
+	if err != nil {
+		return fmt.Errorf("create sender hasher: %w", err)
+	}
+	reporter := progress.NewReporter(opts.Observer, progress.Event{Direction: "sending", Name: name})
+	comp := newWireCompressor(opts, agreed)
+	buf := make([]byte, MaxChunkSize)
+	var sent uint64
+	announced := 0
+	for {
+		if interrupted(opts.Cancel) {
+			return stopForUser(writer, agreed)
+		}
+		// Fill whole chunks so a slow pipe does not turn into many tiny frames, and
+		// ping the receiver while the pipe is quiet.
+		var n int
+		var readErr error
+		if err := keepalive(reader, writer, agreed, func() error {
+			n, readErr = io.ReadFull(opts.Input, buf)
+			return nil
+		}); err != nil {
+			return err
+		}
+		if n > 0 {
+			chunk := buf[:n]
+			if _, err := tree.Write(chunk); err != nil {
+				return fmt.Errorf("hash input chunk: %w", err)
+			}
+			if senderChunkMutator != nil {
+				mut := append([]byte{}, chunk...)
+				senderChunkMutator(mut)
+				chunk = mut
+			}
+			if err := writePacedFrame(writer, comp.frame(chunk), opts.Limit); err != nil {
+				return peerFailure(reader, fmt.Errorf("send data frame: %w: %w", err, apperrors.ErrNetwork))
+			}
+			sent += uint64(n)
+			reporter.Update(sent)
+			if announced, err = sendChunkDigests(reader, writer, tree.Leaves(), announced); err != nil {
+				return err
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return fmt.Errorf("read input stream: %w: %w", readErr, apperrors.ErrIO)
+		}
+	}
+	// The digest of the last, short block tells the receiver the stream has ended.
+	tree.Finish()
+	if _, err := sendChunkDigests(reader, writer, tree.Leaves(), announced); err != nil {
+		return err
+	}
+
+	digest := tree.Root()
+	if err := sendDone(reader, writer, agreed, alg, digest); err != nil {
+		return err
+	}
+	opts.Observer.Observe(verifiedEvent("sending", name, alg, digest))
+	reporter.Done(sent, name)
+	comp.report(opts.Out)
+	_, _ = fmt.Fprintln(opts.Out, "Transfer complete.")
+	_, _ = fmt.Fprintln(opts.Out, "Integrity verified.")
+	_, _ = fmt.Fprintf(opts.Out, "%s: %x\n", alg, digest)
+	return nil
+}
+
+// receiveStream serves an offer that never resumes: a stream of unknown length, or
+// any file when the receiver writes to Sink. With chunk digests each block reaches
+// the output only after its CHUNK digest verifies. A legacy sender's data is written
+// as it arrives and only DONE checks it, so a sink can already hold corrupt bytes
+// when that check fails.
+func (s *receiverSession) receiveStream(offer OfferPayload) error {
+	var file *os.File
+	var paths resume.Paths
+	complete := false
+	out, label := s.opts.Sink, "stdout"
+	if out == nil {
+		var err error
+		paths, err = resume.ResolvePaths(s.opts.OutDir, offer.Name, s.opts.Overwrite)
+		if err != nil {
+			_ = sendErrorFrame(s.writer, "unable to resolve output path")
+			return fmt.Errorf("resolve output paths: %w: %w", err, apperrors.ErrIO)
+		}
+		if err := os.MkdirAll(filepath.Dir(paths.Final), 0o755); err != nil {
+			_ = sendErrorFrame(s.writer, "unable to create output directory")
+			return fmt.Errorf("create output directory: %w: %w", err, apperrors.ErrIO)
+		}
+		lock, err := resume.AcquireLock(paths.Lock, offer.SessionID, s.peer, s.opts.BreakLock)
+		if err != nil {
+			_ = sendErrorFrame(s.writer, err.Error())
+			return err
+		}
+		defer lock.Release()
+		// A stream cannot be resumed, so drop whatever an earlier attempt left behind.
+		resume.RemoveMeta(paths.Meta)
+		file, err = os.OpenFile(filepath.Clean(paths.Partial), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+		if err != nil {
+			_ = sendErrorFrame(s.writer, "unable to open output file")
+			return fmt.Errorf("open partial output file: %w: %w", err, apperrors.ErrIO)
+		}
+		defer func() {
+			_ = file.Close()
+			if !complete && !s.opts.KeepPartial {
+				_ = os.Remove(paths.Partial)
+			}
+		}()
+		out, label = file, paths.Final
+	}
+
+	if err := WriteFrame(s.writer, Frame{Type: TypeAccept, Payload: EncodeAccept(0, offer.SessionID)}); err != nil {
+		return fmt.Errorf("send accept frame: %w", err)
+	}
+	if err := s.writer.Flush(); err != nil {
+		return fmt.Errorf("flush accept frame: %w: %w", err, apperrors.ErrNetwork)
+	}
+	s.opts.Observer.Observe(s.event(progress.EventAccepted, offer))
+	total := offer.Size
+	if total == UnknownSize {
+		total = 0
+		_, _ = fmt.Fprintf(s.opts.Out, "Receiving stream %s to %s\n", offer.Name, label)
+	}
+
+	alg := hash.Algorithm(s.hello.Hashes[0])
+	chunked := s.hello.Has(CapChunkDigests)
+	var blockSize uint64
+	if chunked {
+		blockSize = IntegrityBlockSize
+	}
+	tree, err := hash.NewTree(alg, blockSize, nil)
+	if err != nil {
+		return fmt.Errorf("create receiver hasher: %w", err)
+	}
+	reporter := progress.NewReporter(s.opts.Observer, s.event("", offer))
+	var inflate decompressor
+	var pending []byte
+	var written uint64
+	verified := 0
+	ended := false
+	emit := func(data []byte) error {
+		if _, err := out.Write(data); err != nil {
+			if !diskFull(err) {
+				_ = sendErrorFrame(s.writer, "unable to write output")
+			}
+			return s.writeFailure(s.writer, "write "+label, err)
+		}
+		return nil
+	}
+
+	var done Frame
+	data := newDataReader(s)
+receive:
+	for {
+		if interrupted(s.opts.Cancel) {
+			return stopForUser(s.writer, s.hello)
+		}
+		frame, err := data.next()
+		if err != nil {
+			return fmt.Errorf("read data frame: %w: %w", err, apperrors.ErrNetwork)
+		}
+		if frame.Type == TypeCompressed && s.hello.Has(CapCompression) {
+			plain, err := inflate.data(frame)
+			if err != nil {
+				_ = sendErrorFrame(s.writer, "invalid compressed data")
+				return err
+			}
+			frame = Frame{Type: TypeData, Payload: plain}
+		}
+		switch {
+		case frame.Type == TypeData:
+			if ended || written+uint64(len(frame.Payload)) > offer.Size {
+				_ = sendErrorFrame(s.writer, "received more data than offered")
+				return fmt.Errorf("received more bytes than expected: %w", apperrors.ErrInvalidProtocol)
+			}
+			if _, err := tree.Write(frame.Payload); err != nil {
+				return fmt.Errorf("hash received chunk: %w", err)
+			}
+			written += uint64(len(frame.Payload))
+			reporter.Update(written)
+			if !chunked {
+				// Legacy senders only digest the whole file; DONE is the one check,
+				// and it comes after these bytes are already out.
+				if err := emit(frame.Payload); err != nil {
+					return err
+				}
+				continue
+			}
+			pending = append(pending, frame.Payload...)
+			if len(tree.Leaves()) > verified+1 {
+				return sendProtocolError(s.writer, fmt.Sprintf("missing digest for block %d", verified))
+			}
+		case frame.Type == TypeChunk && chunked:
+			index, digest, decErr := DecodeChunkDigest(frame.Payload)
+			if decErr != nil {
+				_ = sendProtocolError(s.writer, "invalid chunk payload")
+				return fmt.Errorf("decode chunk digest: %w", decErr)
+			}
+			// A digest with no full block pending covers the short tail: the stream has ended.
+			if len(tree.Leaves()) == verified && !ended {
+				tree.Finish()
+				ended = true
+			}
+			if index != uint64(verified) || verified >= len(tree.Leaves()) {
+				return sendProtocolError(s.writer, fmt.Sprintf("unexpected digest for block %d", index))
+			}
+			n := min(len(pending), IntegrityBlockSize)
+			if subtle.ConstantTimeCompare(tree.Leaves()[verified], digest) != 1 {
+				start := index * IntegrityBlockSize
+				msg := fmt.Sprintf("integrity check failed for bytes %d-%d", start, start+uint64(n))
+				_ = sendErrorFrame(s.writer, msg)
+				return fmt.Errorf("%s: %w", msg, apperrors.ErrIntegrity)
+			}
+			if err := emit(pending[:n]); err != nil {
+				return err
+			}
+			pending = append(pending[:0], pending[n:]...)
+			verified++
+		case frame.Type == TypeDone:
+			done = frame
+			break receive
+		case frame.Type == TypeCancel:
+			return cancelError("sender", frame.Payload)
+		default:
+			_ = sendErrorFrame(s.writer, "expected DATA frame")
+			return fmt.Errorf("expected DATA frame, got %d: %w", frame.Type, apperrors.ErrInvalidProtocol)
+		}
+	}
+	if offer.Size != UnknownSize && written != offer.Size {
+		return sendProtocolError(s.writer, fmt.Sprintf("DONE after %d of %d bytes", written, offer.Size))
+	}
+	expectedDigest, err := s.doneDigest(done, alg)
+	if err != nil {
+		return err
+	}
+	var actualDigest []byte
+	if chunked {
+		if verified != blockCount(written) {
+			return sendProtocolError(s.writer, fmt.Sprintf("DONE after %d of %d block digests", verified, blockCount(written)))
+		}
+		actualDigest = hash.TreeRoot(alg, tree.Leaves()[:verified])
+	} else {
+		tree.Finish()
+		actualDigest = tree.Root()
+	}
+	if file != nil {
+		if err := s.finishFile(file, paths, offer, alg, expectedDigest, actualDigest, reporter, written); err != nil {
+			return err
+		}
+		complete = true
+		return nil
+	}
+	if subtle.ConstantTimeCompare(expectedDigest, actualDigest) != 1 {
+		_ = sendErrorFrame(s.writer, "integrity check failed")
+		return fmt.Errorf("integrity check failed: %w", apperrors.ErrIntegrity)
+	}
+	return s.confirm(offer, alg, actualDigest, reporter, written, label)
+}
@@ -0,0 +1,83 @@
+package transfer
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/iotest"
+
+	apperrors "snapsync/internal/errors"
+)
+
+func TestStreamOfUnknownLengthToDisk(t *testing.T) {
+	for _, size := range []int{0, 5, IntegrityBlockSize, 2*IntegrityBlockSize + 123} {
+		data := bytes.Repeat([]byte("0123456789abcdef"), size/16+1)[:size]
+		dstDir := t.TempDir()
+		addr, done := startReceiveOnce(t, ReceiverOptions{OutDir: dstDir, AutoAccept: true, Resume: true, Out: ioDiscard{}})
+		input := iotest.HalfReader(bytes.NewReader(data))
+		sendErr := Send(SenderOptions{Path: StdinPath, Input: input, OverrideName: "dump.sql", Address: addr, Resume: true, Out: ioDiscard{}})
+		if recvErr := <-done; sendErr != nil || recvErr != nil {
+			t.Fatalf("size=%d send err=%v recv err=%v", size, sendErr, recvErr)
+		}
+		if got, err := os.ReadFile(filepath.Join(dstDir, "dump.sql")); err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("size=%d content mismatch err=%v", size, err)
+		}
+		if leftovers, _ := filepath.Glob(filepath.Join(dstDir, "*.partial*")); len(leftovers) != 0 {
+			t.Fatalf("size=%d expected no resume state, found %v", size, leftovers)
+		}
+	}
+}
+
+func TestReceiveToSink(t *testing.T) {
+	srcPath, srcData := stripeSource(t, 9)
+	var sink bytes.Buffer
+	addr, done := startReceiveOnce(t, ReceiverOptions{Sink: &sink, AutoAccept: true, Resume: true, Out: ioDiscard{}})
+	sendErr := Send(SenderOptions{Path: srcPath, Address: addr, Resume: true, Streams: 3, Out: ioDiscard{}})
+	if recvErr := <-done; sendErr != nil || recvErr != nil {
+		t.Fatalf("send err=%v recv err=%v", sendErr, recvErr)
+	}
+	if !bytes.Equal(sink.Bytes(), srcData) {
+		t.Fatal("sink content mismatch")
+	}
+
+	sink.Reset()
+	addr, done = startReceiveOnce(t, ReceiverOptions{Sink: &sink, AutoAccept: true, Out: ioDiscard{}})
+	sendErr = Send(SenderOptions{Path: StdinPath, Input: bytes.NewReader(srcData), Address: addr, Out: ioDiscard{}})
+	if recvErr := <-done; sendErr != nil || recvErr != nil {
+		t.Fatalf("stream send err=%v recv err=%v", sendErr, recvErr)
+	}
+	if !bytes.Equal(sink.Bytes(), srcData) {
+		t.Fatal("streamed sink content mismatch")
+	}
+
+	addr, done = startReceiveOnce(t, ReceiverOptions{Sink: &sink, AutoAccept: true, Out: ioDiscard{}})
+	sendErr = Send(SenderOptions{Path: filepath.Dir(srcPath), Address: addr, Out: ioDiscard{}})
+	<-done
+	if !errors.Is(sendErr, apperrors.ErrInvalidProtocol) {
+		t.Fatalf("expected directory send to a sink to fail, got %v", sendErr)
+	}
+}
+
+func TestSinkWithholdsCorruptBlock(t *testing.T) {
+	_, srcData := stripeSource(t, 12)
+	prev := senderChunkMutator
+	senderChunkMutator = func(chunk []byte) {
+		if chunk[0] == 5 { // second integrity block
+			chunk[0] ^= 0xFF
+		}
+	}
+	defer func() { senderChunkMutator = prev }()
+
+	var sink bytes.Buffer
+	addr, done := startReceiveOnce(t, ReceiverOptions{Sink: &sink, AutoAccept: true, Out: ioDiscard{}})
+	sendErr := Send(SenderOptions{Path: StdinPath, Input: bytes.NewReader(srcData), Address: addr, Out: ioDiscard{}})
+	recvErr := <-done
+	if !errors.Is(sendErr, apperrors.ErrIntegrity) || !errors.Is(recvErr, apperrors.ErrIntegrity) {
+		t.Fatalf("expected integrity errors, send=%v recv=%v", sendErr, recvErr)
+	}
+	if !bytes.Equal(sink.Bytes(), srcData[:IntegrityBlockSize]) {
+		t.Fatalf("expected only the verified first block on the sink, got %d bytes", sink.Len())
+	}
+}
@@ -0,0 +1,760 @@
+package transfer
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+
+	apperrors "snapsync/internal/errors"
+	"snapsync/internal/hash"
+	"snapsync/internal/progress"
+	"snapsync/internal/resume"
+)
+
+// A striped transfer starts like any chunked one: OFFER, then ACCEPT on the primary
+// connection. The sender then sends STRIPE with the number of streams it wants and
+// the receiver answers with block-aligned ranges covering the file, reusing the
+// ranges of an interrupted striped session so each resumes where it stopped.
+// Range 0 stays on the primary connection; every other range gets its own
+// connection that sends ATTACH after HELLO. The plan carries a random key known
+// only to the two ends of the primary connection, and each ATTACH carries a MAC of
+// its range and session id under that key, so knowing the session id is not enough
+// to join. Each range carries DATA and CHUNK frames for its blocks and ends with
+// VERIFIED from the receiver. Once all ranges are verified, the sender sends DONE
+// with the tree root on the primary connection.
+
+// stripedFile is the receiver state shared by the connections of one striped transfer.
+type stripedFile struct {
+	mu       sync.Mutex
+	file     *os.File
+	leaves   *resume.LeafFile
+	paths    resume.Paths
+	meta     resume.Meta
+	alg      hash.Algorithm
+	peerID   string
+	key      []byte
+	claimed  []bool
+	conns    []net.Conn
+	closed   bool
+	failed   error
+	unsaved  int
+	received uint64
+	reporter *progress.Reporter
+	wg       sync.WaitGroup
+}
+
+// stripes maps session ids to the striped transfers attached connections may join.
+var stripes = struct {
+	sync.Mutex
+	m map[string]*stripedFile
+}{m: map[string]*stripedFile{}}
+
+// receiveStriped takes over a chunked transfer when the sender asks to stripe it.
+// It answers with the range plan, serves range 0 on this connection, and checks the
+// tree root once the sender reports every range done.
+func (s *receiverSession) receiveStriped(frame Frame, file *os.File, leaves *resume.LeafFile, paths resume.Paths, meta resume.Meta, offer OfferPayload, reporter *progress.Reporter) error {
+	streams, restart, err := DecodeStripeRequest(frame.Payload)
+	if err != nil {
+		_ = sendProtocolError(s.writer, "invalid stripe request")
+		return fmt.Errorf("decode stripe request: %w", err)
+	}
+	if offer.Size <= IntegrityBlockSize {
+		return sendProtocolError(s.writer, "file too small to stripe")
+	}
+	if restart {
+		meta.Leaves, meta.Ranges = nil, nil
+	}
+	meta.Leaves, meta.Ranges = planStripes(meta, offer.Size, streams)
+	if err := leaves.PutAll(meta.Leaves); err != nil {
+		return s.writeFailure(s.writer, "write block digests", err)
+	}
+	st := &stripedFile{file: file, leaves: leaves, paths: paths, meta: meta, alg: hash.Algorithm(meta.Algorithm), key: make([]byte, AttachKeySize), claimed: make([]bool, len(meta.Ranges)), reporter: reporter}
+	if _, err := rand.Read(st.key); err != nil {
+		return fmt.Errorf("generate attach key: %w", err)
+	}
+	if s.remote != nil {
+		st.peerID = s.remote.PeerID
+	}
+	for _, r := range meta.Ranges {
+		st.received += r.Offset - r.Start
+	}
+	if err := st.save(); err != nil {
+		return fmt.Errorf("write striped resume metadata: %w: %w", err, apperrors.ErrIO)
+	}
+	plan, err := EncodeStripePlan(meta.Ranges, st.key)
+	if err != nil {
+		return fmt.Errorf("encode stripe plan: %w", err)
+	}
+
+	stripes.Lock()
+	if _, busy := stripes.m[offer.SessionID]; busy {
+		stripes.Unlock()
+		_ = sendErrorFrame(s.writer, "striped transfer already in progress")
+		return fmt.Errorf("striped session %s already active: %w", offer.SessionID, apperrors.ErrLockBusy)
+	}
+	stripes.m[offer.SessionID] = st
+	stripes.Unlock()
+	finished := false
+	defer func() {
+		stripes.Lock()
+		delete(stripes.m, offer.SessionID)
+		stripes.Unlock()
+		st.shutdown(!finished)
+	}()
+	if err := st.claim(0, nil); err != nil {
+		return err
+	}
+	defer st.wg.Done()
+
+	if err := WriteFrame(s.writer, Frame{Type: TypeStripe, Payload: plan}); err != nil {
+		return fmt.Errorf("send stripe plan: %w: %w", err, apperrors.ErrNetwork)
+	}
+	if err := s.writer.Flush(); err != nil {
+		return fmt.Errorf("flush stripe plan: %w: %w", err, apperrors.ErrNetwork)
+	}
+	_, _ = fmt.Fprintf(s.opts.Out, "Receiving %s over %d streams\n", offer.Name, len(meta.Ranges))
+
+	if err := st.receiveRange(0, s); err != nil {
+		return st.cause(err)
+	}
+	done, err := readFrame(s.reader, s.writer)
+	if err != nil {
+		return st.cause(fmt.Errorf("read done frame: %w: %w", err, apperrors.ErrNetwork))
+	}
+	if done.Type != TypeDone {
+		return sendProtocolError(s.writer, fmt.Sprintf("expected DONE, got %d", done.Type))
+	}
+	expected, err := s.doneDigest(done, st.alg)
+	if err != nil {
+		return err
+	}
+	st.mu.Lock()
+	digests := st.meta.Leaves
+	complete := st.failed == nil
+	for _, leaf := range digests {
+		complete = complete && leaf != nil
+	}
+	st.mu.Unlock()
+	if !complete {
+		return sendProtocolError(s.writer, "DONE before every range was verified")
+	}
+	if err := s.finishFile(file, paths, offer, st.alg, expected, hash.TreeRoot(st.alg, digests), reporter, offer.Size); err != nil {
+		return err
+	}
+	finished = true
+	return nil
+}
+
+// attachStripe serves one extra connection of a striped transfer.
+func (s *receiverSession) attachStripe(conn net.Conn, frame Frame) error {
+	index, sessionID, mac, err := DecodeAttach(frame.Payload)
+	if err != nil {
+		_ = sendProtocolError(s.writer, "invalid attach payload")
+		return fmt.Errorf("decode attach: %w", err)
+	}
+	stripes.Lock()
+	st := stripes.m[sessionID]
+	stripes.Unlock()
+	if st == nil {
+		_ = sendErrorFrame(s.writer, "no striped transfer for session")
+		return fmt.Errorf("attach from %s to unknown session: %w", s.peer, apperrors.ErrRejected)
+	}
+	if !hmac.Equal(mac, AttachMAC(st.key, index, sessionID)) || st.peerID != "" && (s.remote == nil || s.remote.PeerID != st.peerID) {
+		_ = sendErrorFrame(s.writer, "peer not authorized")
+		return fmt.Errorf("attach from %s by a different peer: %w", s.peer, apperrors.ErrAuth)
+	}
+	if err := st.claim(index, conn); err != nil {
+		_ = sendErrorFrame(s.writer, err.Error())
+		return err
+	}
+	defer st.wg.Done()
+
+	st.mu.Lock()
+	offset := st.meta.Ranges[index].Offset
+	st.mu.Unlock()
+	if err := WriteFrame(s.writer, Frame{Type: TypeAccept, Payload: EncodeAccept(offset, sessionID)}); err != nil {
+		return fmt.Errorf("send attach accept frame: %w: %w", err, apperrors.ErrNetwork)
+	}
+	if err := s.writer.Flush(); err != nil {
+		return fmt.Errorf("flush attach accept frame: %w: %w", err, apperrors.ErrNetwork)
+	}
+	return st.receiveRange(index, s)
+}
+
+// claim reserves a range for one connection. Each claim must be paired with wg.Done.
+func (st *stripedFile) claim(index int, conn net.Conn) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	switch {
+	case st.closed:
+		return fmt.Errorf("striped transfer already ended: %w", apperrors.ErrRejected)
+	case index >= len(st.claimed):
+		return fmt.Errorf("stripe range %d out of %d: %w", index, len(st.claimed), apperrors.ErrInvalidProtocol)
+	case st.claimed[index]:
+		return fmt.Errorf("stripe range %d already attached: %w", index, apperrors.ErrInvalidProtocol)
+	}
+	st.claimed[index] = true
+	if conn != nil {
+		st.conns = append(st.conns, conn)
+	}
+	st.wg.Add(1)
+	return nil
+}
+
+// receiveRange writes one range's DATA from the session at its offsets and verifies
+// each block's CHUNK digest, then confirms the range with VERIFIED.
+func (st *stripedFile) receiveRange(index int, s *receiverSession) error {
+	writer, compressed := s.writer, s.hello.Has(CapCompression)
+	st.mu.Lock()
+	r := st.meta.Ranges[index]
+	size := st.meta.ExpectedSize
+	st.mu.Unlock()
+	tree, err := hash.NewTree(st.alg, IntegrityBlockSize, nil)
+	if err != nil {
+		return fmt.Errorf("create range hasher: %w", err)
+	}
+	var inflate decompressor
+	data := newDataReader(s)
+	pos := r.Offset
+	first := int(r.Offset / IntegrityBlockSize)
+	next := first
+	last := int((r.End + IntegrityBlockSize - 1) / IntegrityBlockSize)
+	for next < last {
+		if interrupted(s.opts.Cancel) {
+			return stopForUser(writer, s.hello)
+		}
+		frame, err := data.next()
+		if err != nil {
+			_ = st.checkpoint()
+			return fmt.Errorf("read range %d: %w: %w", index, err, apperrors.ErrNetwork)
+		}
+		if frame.Type == TypeCompressed && compressed {
+			plain, err := inflate.data(frame)
+			if err != nil {
+				_ = sendErrorFrame(writer, "invalid compressed data")
+				return err
+			}
+			frame = Frame{Type: TypeData, Payload: plain}
+		}
+		switch frame.Type {
+		case TypeData:
+			if pos+uint64(len(frame.Payload)) > r.End {
+				_ = sendErrorFrame(writer, "received more data than the range holds")
+				return fmt.Errorf("range %d overran its end: %w", index, apperrors.ErrInvalidProtocol)
+			}
+			if _, err := st.file.WriteAt(frame.Payload, int64(pos)); err != nil {
+				return s.writeFailure(writer, "write output file", err)
+			}
+			_, _ = tree.Write(frame.Payload)
+			pos += uint64(len(frame.Payload))
+			st.progress(uint64(len(frame.Payload)))
+		case TypeChunk:
+			block, digest, decErr := DecodeChunkDigest(frame.Payload)
+			if decErr != nil {
+				_ = sendProtocolError(writer, "invalid chunk payload")
+				return fmt.Errorf("decode chunk digest: %w", decErr)
+			}
+			if pos == r.End && len(tree.Leaves()) == next-first {
+				tree.Finish()
+			}
+			if block != uint64(next) || next-first >= len(tree.Leaves()) {
+				return sendProtocolError(writer, fmt.Sprintf("unexpected digest for block %d", block))
+			}
+			if subtle.ConstantTimeCompare(tree.Leaves()[next-first], digest) != 1 {
+				start := block * IntegrityBlockSize
+				end := min(start+IntegrityBlockSize, size)
+				msg := fmt.Sprintf("integrity check failed for bytes %d-%d", start, end)
+				err := fmt.Errorf("%s: %w", msg, apperrors.ErrIntegrity)
+				st.fail(err)
+				_ = sendErrorFrame(writer, msg)
+				return err
+			}
+			if err := st.verify(index, next, digest, min(uint64(next+1)*IntegrityBlockSize, r.End)); err != nil {
+				return fmt.Errorf("update striped resume metadata: %w: %w", err, apperrors.ErrIO)
+			}
+			next++
+		case TypeCancel:
+			return cancelError("sender", frame.Payload)
+		default:
+			return sendProtocolError(writer, fmt.Sprintf("expected DATA or CHUNK, got %d", frame.Type))
+		}
+	}
+	if err := WriteFrame(writer, Frame{Type: TypeVerified}); err != nil {
+		return fmt.Errorf("send range verified frame: %w: %w", err, apperrors.ErrNetwork)
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("flush range verified frame: %w: %w", err, apperrors.ErrNetwork)
+	}
+	return nil
+}
+
+func (st *stripedFile) progress(n uint64) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.received += n
+	st.reporter.Update(st.received)
+}
+
+// verify records a verified block and saves resume metadata every few blocks.
+func (st *stripedFile) verify(index, block int, digest []byte, offset uint64) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if err := st.leaves.Put(block, digest); err != nil {
+		return err
+	}
+	st.meta.Leaves[block] = digest
+	st.meta.Ranges[index].Offset = offset
+	st.unsaved++
+	if st.unsaved < leafMetaSyncInterval {
+		return nil
+	}
+	return st.saveLocked()
+}
+
+// fail records the first range failure so the primary connection can report it.
+func (st *stripedFile) fail(err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.failed == nil {
+		st.failed = err
+	}
+	_ = st.saveLocked()
+}
+
+// cause prefers a failure recorded by another range over the primary's own error,
+// which is usually just the sender hanging up in response.
+func (st *stripedFile) cause(err error) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.failed != nil {
+		return st.failed
+	}
+	return err
+}
+
+func (st *stripedFile) checkpoint() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.saveLocked()
+}
+
+func (st *stripedFile) save() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.saveLocked()
+}
+
+// saveLocked syncs verified blocks and their digests to disk before recording them
+// in the metadata.
+func (st *stripedFile) saveLocked() error {
+	if err := st.file.Sync(); err != nil {
+		return err
+	}
+	if err := st.leaves.Sync(); err != nil {
+		return err
+	}
+	st.meta.ReceivedOffset = verifiedPrefix(st.meta.Leaves, st.meta.ExpectedSize)
+	st.unsaved = 0
+	return resume.SaveMetaAtomic(st.paths.Meta, st.meta)
+}
+
+// shutdown stops attached connections and waits for their handlers, saving progress
+// when the transfer did not finish.
+func (st *stripedFile) shutdown(save bool) {
+	st.mu.Lock()
+	st.closed = true
+	for _, conn := range st.conns {
+		_ = conn.Close()
+	}
+	st.mu.Unlock()
+	st.wg.Wait()
+	if save {
+		_ = st.checkpoint()
+	}
+}
+
+// planStripes returns per-block digests and ranges for a striped transfer. The ranges
+// of an interrupted striped session are kept so each resumes at its first unverified
+// block; otherwise the blocks after the verified prefix are split evenly.
+func planStripes(meta resume.Meta, size uint64, streams int) ([][]byte, []resume.Range) {
+	blocks := blockCount(size)
+	leaves := make([][]byte, blocks)
+	copy(leaves, meta.Leaves)
+	if validRanges(meta.Ranges, size) {
+		ranges := append([]resume.Range(nil), meta.Ranges...)
+		for i := range ranges {
+			ranges[i].Offset = ranges[i].End
+			for b := ranges[i].Start / IntegrityBlockSize; b*IntegrityBlockSize < ranges[i].End; b++ {
+				if leaves[b] == nil {
+					ranges[i].Offset = b * IntegrityBlockSize
+					break
+				}
+			}
+		}
+		return leaves, ranges
+	}
+
+	done := 0
+	for done < blocks && leaves[done] != nil {
+		done++
+	}
+	for i := done; i < blocks; i++ {
+		leaves[i] = nil
+	}
+	remaining := blocks - done
+	if remaining == 0 {
+		return leaves, []resume.Range{{Start: 0, End: size, Offset: size}}
+	}
+	n := min(streams, remaining)
+	ranges := make([]resume.Range, 0, n)
+	next := done
+	for i := 0; i < n; i++ {
+		count := remaining / n
+		if i < remaining%n {
+			count++
+		}
+		start := uint64(next) * IntegrityBlockSize
+		end := min(uint64(next+count)*IntegrityBlockSize, size)
+		ranges = append(ranges, resume.Range{Start: start, End: end, Offset: start})
+		next += count
+	}
+	ranges[0].Start = 0
+	return leaves, ranges
+}
+
+// validRanges reports whether saved ranges tile the file on block boundaries.
+func validRanges(ranges []resume.Range, size uint64) bool {
+	if len(ranges) == 0 || len(ranges) > MaxStreams {
+		return false
+	}
+	var next uint64
+	for _, r := range ranges {
+		if r.Start != next || r.End <= r.Start || r.Start%IntegrityBlockSize != 0 {
+			return false
+		}
+		next = r.End
+	}
+	return next == size
+}
+
+// verifiedPrefix is the length of the leading run of verified blocks, in bytes.
+func verifiedPrefix(leaves [][]byte, size uint64) uint64 {
+	var n uint64
+	for int(n) < len(leaves) && leaves[n] != nil {
+		n++
+	}
+	return min(n*IntegrityBlockSize, size)
+}
+
+// sendStriped sends one accepted file over several connections.
+func sendStriped(reader *bufio.Reader, writer *bufio.Writer, file *os.File, entry sourceEntry, sessionID string, agreed HelloPayload, opts SenderOptions) error {
+	alg := hash.Algorithm(agreed.Hashes[0])
+	request, err := EncodeStripeRequest(opts.Streams, !opts.Resume)
+	if err != nil {
+		return fmt.Errorf("encode stripe request: %w", err)
+	}
+	if err := WriteFrame(writer, Frame{Type: TypeStripe, Payload: request}); err != nil {
+		return fmt.Errorf("send stripe request: %w: %w", err, apperrors.ErrNetwork)
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("flush stripe request: %w: %w", err, apperrors.ErrNetwork)
+	}
+	resp, err := readFrame(reader, writer)
+	if err != nil {
+		return fmt.Errorf("read stripe plan: %w: %w", err, apperrors.ErrNetwork)
+	}
+	switch resp.Type {
+	case TypeStripe:
+	case TypeError:
+		return receiverError(resp.Payload)
+	case TypeCancel:
+		return cancelError("receiver", resp.Payload)
+	default:
+		return fmt.Errorf("unexpected stripe response frame type %d: %w", resp.Type, apperrors.ErrInvalidProtocol)
+	}
+	ranges, key, err := DecodeStripePlan(resp.Payload)
+	if err != nil {
+		return fmt.Errorf("decode stripe plan: %w", err)
+	}
+	if ranges[0].Start != 0 || ranges[len(ranges)-1].End != entry.size {
+		return fmt.Errorf("stripe plan does not cover the file: %w", apperrors.ErrInvalidProtocol)
+	}
+	for _, r := range ranges {
+		if r.Start%IntegrityBlockSize != 0 || (r.Offset%IntegrityBlockSize != 0 && r.Offset != r.End) {
+			return fmt.Errorf("stripe plan is not block aligned: %w", apperrors.ErrInvalidProtocol)
+		}
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("stat source file: %w: %w", err, apperrors.ErrIO)
+	}
+	ss := &stripeSender{
+		file:    file,
+		alg:     alg,
+		size:    entry.size,
+		session: sessionID,
+		key:     key,
+		opts:    opts,
+		cache:   openChunkCache(entry.path, info, alg),
+		leaves:  make([][]byte, blockCount(entry.size)),
+	}
+	defer ss.cache.save()
+	for _, r := range ranges {
+		ss.sent += r.Offset - r.Start
+	}
+	if ss.sent > 0 {
+		_, _ = fmt.Fprintf(opts.Out, "Resuming striped transfer with %d of %d bytes verified\n", ss.sent, entry.size)
+	}
+	ss.reporter = progress.NewReporter(opts.Observer, progress.Event{Direction: "sending", Peer: opts.peer, Name: entry.name, Total: entry.size})
+
+	errs := make(chan error, len(ranges))
+	for i, r := range ranges {
+		go func() {
+			if i == 0 {
+				errs <- ss.sendRange(0, r, reader, writer, agreed)
+				return
+			}
+			errs <- ss.attach(i, r)
+		}()
+	}
+	var firstErr error
+	for range ranges {
+		if err := <-errs; err != nil && firstErr == nil {
+			firstErr = err
+			ss.abort()
+		}
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+
+	root := hash.TreeRoot(alg, ss.leaves)
+	if err := sendDone(reader, writer, agreed, alg, root); err != nil {
+		return err
+	}
+	ss.cache.remove()
+	e := verifiedEvent("sending", entry.name, alg, root)
+	e.Peer = opts.peer
+	opts.Observer.Observe(e)
+	ss.reporter.Done(entry.size, entry.name)
+	(&compressor{raw: ss.raw, wire: ss.wire}).report(opts.Out)
+	_, _ = fmt.Fprintln(opts.Out, "Transfer complete.")
+	_, _ = fmt.Fprintln(opts.Out, "Integrity verified.")
+	_, _ = fmt.Fprintf(opts.Out, "%s: %x\n", alg, root)
+	return nil
+}
+
+// stripeSender is the sender state shared by the streams of one striped file.
+type stripeSender struct {
+	file     *os.File
+	alg      hash.Algorithm
+	size     uint64
+	session  string
+	key      []byte
+	opts     SenderOptions
+	mu       sync.Mutex
+	cache    *chunkCache
+	leaves   [][]byte
+	sent     uint64
+	reporter *progress.Reporter
+	conns    []net.Conn
+	aborted  bool
+	raw      uint64
+	wire     uint64
+}
+
+// attach opens an extra connection for range i and streams it.
+func (ss *stripeSender) attach(i int, r resume.Range) error {
+	if r.Offset == r.End {
+		return ss.fillVerified(r)
+	}
+	conn, reader, writer, agreed, err := dialReceiver(ss.opts, io.Discard)
+	if err != nil {
+		return fmt.Errorf("open stream %d: %w", i, err)
+	}
+	defer func() { _ = conn.Close() }()
+	ss.mu.Lock()
+	if ss.aborted {
+		ss.mu.Unlock()
+		return fmt.Errorf("stream %d aborted: %w", i, apperrors.ErrNetwork)
+	}
+	ss.conns = append(ss.conns, conn)
+	ss.mu.Unlock()
+	if !agreed.Has(CapStriping|CapChunkDigests) || hash.Algorithm(agreed.Hashes[0]) != ss.alg {
+		return fmt.Errorf("stream %d negotiated different settings: %w", i, apperrors.ErrInvalidProtocol)
+	}
+	payload, err := EncodeAttach(i, ss.session, AttachMAC(ss.key, i, ss.session))
+	if err != nil {
+		return fmt.Errorf("encode attach: %w", err)
+	}
+	if err := WriteFrame(writer, Frame{Type: TypeAttach, Payload: payload}); err != nil {
+		return fmt.Errorf("send attach: %w: %w", err, apperrors.ErrNetwork)
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("flush attach: %w: %w", err, apperrors.ErrNetwork)
+	}
+	resp, err := readFrame(reader, writer)
+	if err != nil {
+		return fmt.Errorf("read attach response: %w: %w", err, apperrors.ErrNetwork)
+	}
+	switch resp.Type {
+	case TypeAccept:
+		offset, sid, decErr := DecodeAccept(resp.Payload)
+		if decErr != nil {
+			return fmt.Errorf("decode attach accept frame: %w", decErr)
+		}
+		if sid != ss.session || offset != r.Offset {
+			return fmt.Errorf("stream %d accepted at %d, planned %d: %w", i, offset, r.Offset, apperrors.ErrInvalidProtocol)
+		}
+	case TypeError:
+		return receiverError(resp.Payload)
+	case TypeCancel:
+		return cancelError("receiver", resp.Payload)
+	default:
+		return fmt.Errorf("unexpected attach response frame type %d: %w", resp.Type, apperrors.ErrInvalidProtocol)
+	}
+	return ss.sendRange(i, r, reader, writer, agreed)
+}
+
+// sendRange streams one range with its block digests and waits for VERIFIED.
+func (ss *stripeSender) sendRange(i int, r resume.Range, reader *bufio.Reader, writer *bufio.Writer, agreed HelloPayload) error {
+	comp := newWireCompressor(ss.opts, agreed)
+	if comp != nil {
+		defer func() {
+			ss.mu.Lock()
+			ss.raw += comp.raw
+			ss.wire += comp.wire
+			ss.mu.Unlock()
+		}()
+	}
+	if err := ss.fillVerified(r); err != nil {
+		return err
+	}
+	tree, err := hash.NewTree(ss.alg, IntegrityBlockSize, nil)
+	if err != nil {
+		return fmt.Errorf("create sender hasher: %w", err)
+	}
+	first := int(r.Offset / IntegrityBlockSize)
+	announced := 0
+	sendDigests := func() error {
+		for ; announced < len(tree.Leaves()); announced++ {
+			block := first + announced
+			leaf := tree.Leaves()[announced]
+			payload, encErr := EncodeChunkDigest(uint64(block), leaf)
+			if encErr != nil {
+				return fmt.Errorf("encode chunk digest: %w", encErr)
+			}
+			if err := WriteFrame(writer, Frame{Type: TypeChunk, Payload: payload}); err != nil {
+				return peerFailure(reader, fmt.Errorf("send chunk frame: %w: %w", err, apperrors.ErrNetwork))
+			}
+			ss.mu.Lock()
+			ss.leaves[block] = leaf
+			ss.cache.set(block, leaf)
+			ss.mu.Unlock()
+		}
+		return nil
+	}
+
+	section := io.NewSectionReader(ss.file, int64(r.Offset), int64(r.End-r.Offset))
+	buf := make([]byte, MaxChunkSize)
+	for {
+		if interrupted(ss.opts.Cancel) {
+			return stopForUser(writer, agreed)
+		}
+		n, readErr := section.Read(buf)
+		if n > 0 {
+			chunk := buf[:n]
+			_, _ = tree.Write(chunk)
+			ss.mu.Lock()
+			if senderChunkMutator != nil {
+				mut := append([]byte{}, chunk...)
+				senderChunkMutator(mut)
+				chunk = mut
+			}
+			ss.mu.Unlock()
+			if err := writePacedFrame(writer, comp.frame(chunk), ss.opts.Limit); err != nil {
+				return peerFailure(reader, fmt.Errorf("send data frame: %w: %w", err, apperrors.ErrNetwork))
+			}
+			ss.mu.Lock()
+			ss.sent += uint64(n)
+			ss.reporter.Update(ss.sent)
+			ss.mu.Unlock()
+			if err := sendDigests(); err != nil {
+				return err
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return fmt.Errorf("read source file: %w: %w", readErr, apperrors.ErrIO)
+		}
+	}
+	if r.Offset < r.End {
+		tree.Finish()
+	}
+	if err := sendDigests(); err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return peerFailure(reader, fmt.Errorf("flush stream %d: %w: %w", i, err, apperrors.ErrNetwork))
+	}
+	status, err := readFrame(reader, writer)
+	if err != nil {
+		return fmt.Errorf("read stream %d status: %w: %w", i, err, apperrors.ErrNetwork)
+	}
+	switch status.Type {
+	case TypeVerified:
+		return nil
+	case TypeError:
+		return integrityError(status.Payload)
+	case TypeCancel:
+		return cancelError("receiver", status.Payload)
+	default:
+		return fmt.Errorf("unexpected stream %d status frame type %d: %w", i, status.Type, apperrors.ErrInvalidProtocol)
+	}
+}
+
+// fillVerified collects the digests of the range's blocks the receiver already holds,
+// from the chunk cache or by hashing them when the cache lacks them.
+func (ss *stripeSender) fillVerified(r resume.Range) error {
+	for start := r.Start; start < r.Offset; start += IntegrityBlockSize {
+		block := int(start / IntegrityBlockSize)
+		ss.mu.Lock()
+		leaf := ss.cache.leaf(block)
+		ss.mu.Unlock()
+		if leaf == nil {
+			h, err := hash.New(ss.alg)
+			if err != nil {
+				return fmt.Errorf("create sender hasher: %w", err)
+			}
+			end := min(start+IntegrityBlockSize, ss.size)
+			if _, err := io.Copy(h, io.NewSectionReader(ss.file, int64(start), int64(end-start))); err != nil {
+				return fmt.Errorf("read verified block %d: %w: %w", block, err, apperrors.ErrIO)
+			}
+			leaf = h.Sum()
+		}
+		ss.mu.Lock()
+		ss.leaves[block] = leaf
+		ss.cache.set(block, leaf)
+		ss.mu.Unlock()
+	}
+	return nil
+}
+
+// abort closes every extra stream so the others stop after the first failure.
+func (ss *stripeSender) abort() {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.aborted = true
+	for _, conn := range ss.conns {
+		_ = conn.Close()
+	}
+}
@@ -0,0 +1,167 @@
+package transfer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	apperrors "snapsync/internal/errors"
+	"snapsync/internal/resume"
+)
+
+// startReceiveOnce runs ReceiveOnce, which also accepts the extra streams of a striped transfer.
+func startReceiveOnce(t *testing.T, opts ReceiverOptions) (string, <-chan error) {
+	t.Helper()
+	return startListening(t, "ReceiveOnce", func(onListening func(net.Addr) (func(), error)) error {
+		opts.Listen, opts.OnListening = "127.0.0.1:0", onListening
+		return ReceiveOnce(opts)
+	})
+}
+
+// stripeSource writes a file whose MiB-sized chunks each repeat their own index byte.
+func stripeSource(t *testing.T, mib int) (string, []byte) {
+	t.Helper()
+	var data []byte
+	for i := 0; i < mib; i++ {
+		data = append(data, bytes.Repeat([]byte{byte(i)}, 1024*1024)...)
+	}
+	data = append(data, []byte("tail")...)
+	path := filepath.Join(t.TempDir(), "striped.bin")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	return path, data
+}
+
+func TestStripedTransferAcrossStreams(t *testing.T) {
+	srcPath, srcData := stripeSource(t, 18)
+	dstDir := t.TempDir()
+	var recvOut bytes.Buffer
+	addr, done := startReceiveOnce(t, ReceiverOptions{OutDir: dstDir, AutoAccept: true, Resume: true, Out: &recvOut})
+	sendErr := Send(SenderOptions{Path: srcPath, Address: addr, Resume: true, Streams: 3, Out: ioDiscard{}})
+	if recvErr := <-done; sendErr != nil || recvErr != nil {
+		t.Fatalf("send err=%v recv err=%v", sendErr, recvErr)
+	}
+	if !strings.Contains(recvOut.String(), "over 3 streams") {
+		t.Fatalf("expected striped receive, got %q", recvOut.String())
+	}
+	if got, _ := os.ReadFile(filepath.Join(dstDir, "striped.bin")); !bytes.Equal(got, srcData) {
+		t.Fatal("content mismatch after striped transfer")
+	}
+}
+
+func TestStripedResumeKeepsRangeProgress(t *testing.T) {
+	srcPath, srcData := stripeSource(t, 18)
+	dstDir := t.TempDir()
+
+	prev := senderChunkMutator
+	senderChunkMutator = func(chunk []byte) {
+		if chunk[0] == 13 { // block 3, in the second of three ranges
+			chunk[0] ^= 0xFF
+		}
+	}
+	defer func() { senderChunkMutator = prev }()
+
+	addr, done := startReceiveOnce(t, ReceiverOptions{OutDir: dstDir, AutoAccept: true, Resume: true, Out: ioDiscard{}})
+	sendErr := Send(SenderOptions{Path: srcPath, Address: addr, Resume: true, Streams: 3, Out: ioDiscard{}})
+	recvErr := <-done
+	if !errors.Is(sendErr, apperrors.ErrIntegrity) || !errors.Is(recvErr, apperrors.ErrIntegrity) {
+		t.Fatalf("expected integrity errors, send=%v recv=%v", sendErr, recvErr)
+	}
+	paths, _ := resume.ResolvePaths(dstDir, "striped.bin", false)
+	meta, err := resume.LoadMeta(paths.Meta)
+	if err != nil {
+		t.Fatalf("LoadMeta() error = %v", err)
+	}
+	if len(meta.Ranges) != 3 || meta.Ranges[1].Offset >= 4*IntegrityBlockSize {
+		t.Fatalf("expected per-range progress stopping before the corrupt block, got %+v", meta.Ranges)
+	}
+
+	senderChunkMutator = prev
+	var sendOut bytes.Buffer
+	addr, done = startReceiveOnce(t, ReceiverOptions{OutDir: dstDir, AutoAccept: true, Resume: true, Out: ioDiscard{}})
+	sendErr = Send(SenderOptions{Path: srcPath, Address: addr, Resume: true, Streams: 3, Out: &sendOut})
+	if recvErr := <-done; sendErr != nil || recvErr != nil {
+		t.Fatalf("resume send err=%v recv err=%v", sendErr, recvErr)
+	}
+	if !strings.Contains(sendOut.String(), "Resuming striped transfer") {
+		t.Fatalf("expected striped resume, got %q", sendOut.String())
+	}
+	if got, _ := os.ReadFile(paths.Final); !bytes.Equal(got, srcData) {
+		t.Fatal("content mismatch after striped resume")
+	}
+}
+
+func TestServeStripesBeyondConcurrencyLimit(t *testing.T) {
+	srcPath, srcData := stripeSource(t, 10)
+	dstDir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	addr, done := startServe(t, ctx, ReceiverOptions{OutDir: dstDir, AutoAccept: true, Resume: true, Out: ioDiscard{}, MaxConcurrent: 1})
+	if err := Send(SenderOptions{Path: srcPath, Address: addr, Resume: true, Streams: 4, Out: ioDiscard{}}); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dstDir, "striped.bin")); !bytes.Equal(got, srcData) {
+		t.Fatal("content mismatch after striped transfer")
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Serve() after shutdown error = %v", err)
+	}
+}
+
+func TestAttachRequiresPlanKey(t *testing.T) {
+	st := &stripedFile{key: bytes.Repeat([]byte{1}, AttachKeySize), claimed: make([]bool, 2)}
+	stripes.Lock()
+	stripes.m["forged-session"] = st
+	stripes.Unlock()
+	defer func() {
+		stripes.Lock()
+		delete(stripes.m, "forged-session")
+		stripes.Unlock()
+	}()
+	a, b := net.Pipe()
+	defer func() { _ = a.Close() }()
+	defer func() { _ = b.Close() }()
+	go func() { _, _ = io.Copy(io.Discard, b) }()
+	s := &receiverSession{conn: a, reader: bufio.NewReader(a), writer: bufio.NewWriter(a), peer: "intruder"}
+
+	// Knowing the session id is not enough without the key from the plan.
+	forged, _ := EncodeAttach(1, "forged-session", AttachMAC(bytes.Repeat([]byte{2}, AttachKeySize), 1, "forged-session"))
+	if err := s.attachStripe(a, Frame{Type: TypeAttach, Payload: forged}); !errors.Is(err, apperrors.ErrAuth) {
+		t.Fatalf("expected forged attach to be refused, got %v", err)
+	}
+	if st.claimed[1] {
+		t.Fatal("forged attach claimed a range")
+	}
+}
+
+func TestPlanStripesSplitsAndReusesRanges(t *testing.T) {
+	size := uint64(10*IntegrityBlockSize + 100)
+	leaf := bytes.Repeat([]byte{1}, 32)
+	leaves, ranges := planStripes(resume.Meta{Leaves: [][]byte{leaf, leaf}}, size, 4)
+	if len(leaves) != 11 || len(ranges) != 4 {
+		t.Fatalf("unexpected plan: %d leaves, %d ranges", len(leaves), len(ranges))
+	}
+	if ranges[0].Start != 0 || ranges[0].Offset != 2*IntegrityBlockSize || ranges[3].End != size {
+		t.Fatalf("unexpected ranges %+v", ranges)
+	}
+	for i := 1; i < len(ranges); i++ {
+		if ranges[i].Start != ranges[i-1].End || ranges[i].Offset != ranges[i].Start {
+			t.Fatalf("ranges not contiguous: %+v", ranges)
+		}
+	}
+
+	leaves[ranges[2].Start/IntegrityBlockSize] = leaf
+	_, reused := planStripes(resume.Meta{Leaves: leaves, Ranges: ranges}, size, 2)
+	if len(reused) != 4 || reused[2].Offset != ranges[2].Start+IntegrityBlockSize {
+		t.Fatalf("expected saved ranges reused with progress, got %+v", reused)
+	}
+}
@@ -0,0 +1,286 @@
+package transfer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	apperrors "snapsync/internal/errors"
+	"snapsync/internal/throttle"
+)
+
+const (
+	// DefaultHandshakeTimeout bounds connecting, securing, and negotiating HELLO.
+	DefaultHandshakeTimeout = 10 * time.Second
+	// DefaultIdleTimeout bounds each wait for the peer to send or take data.
+	DefaultIdleTimeout = 30 * time.Second
+)
+
+// limitedWindow bounds the bytes queued between the peers while one of them is
+// rate limited.
+const limitedWindow = 128 << 10
+
+// keepaliveInterval is how often a busy side pings a waiting peer. It must stay
+// well below the peer's idle timeout; tests shorten it.
+var keepaliveInterval = 5 * time.Second
+
+// Timeouts bounds how long a connection may wait on its peer. A connection that
+// exceeds one fails with ErrNetwork, which leaves partial files for resume.
+type Timeouts struct {
+	// Handshake limits connecting, securing, and negotiating HELLO. Defaults to
+	// DefaultHandshakeTimeout.
+	Handshake time.Duration
+	// Idle limits each read or write once HELLO is negotiated. Defaults to
+	// DefaultIdleTimeout. Peers that negotiate keepalives ping while a prompt or
+	// slow disk keeps them from answering.
+	Idle time.Duration
+	// Total limits the whole connection. Zero means no limit.
+	Total time.Duration
+}
+
+// timedConn sets a fresh deadline before every read and write, so a silent peer
+// fails the connection instead of hanging it.
+type timedConn struct {
+	net.Conn
+	t Timeouts
+	// handshakeEnd is the deadline until negotiated is called.
+	handshakeEnd time.Time
+	// end is the Total deadline, zero when unlimited.
+	end time.Time
+}
+
+// withTimeouts wraps conn, starting the handshake and total clocks. Zero fields of
+// t take their defaults.
+func withTimeouts(conn net.Conn, t Timeouts) *timedConn {
+	if t.Handshake <= 0 {
+		t.Handshake = DefaultHandshakeTimeout
+	}
+	if t.Idle <= 0 {
+		t.Idle = DefaultIdleTimeout
+	}
+	now := time.Now()
+	c := &timedConn{Conn: conn, t: t, handshakeEnd: now.Add(t.Handshake)}
+	if t.Total > 0 {
+		c.end = now.Add(t.Total)
+	}
+	return c
+}
+
+// negotiated ends the handshake phase; from then on every read and write gets
+// the idle timeout.
+func (c *timedConn) negotiated() { c.handshakeEnd = time.Time{} }
+
+func (c *timedConn) deadline() time.Time {
+	d := c.handshakeEnd
+	if d.IsZero() {
+		d = time.Now().Add(c.t.Idle)
+	}
+	if !c.end.IsZero() && c.end.Before(d) {
+		d = c.end
+	}
+	return d
+}
+
+// Read reads from the peer, failing once the current deadline passes.
+func (c *timedConn) Read(p []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(c.deadline()); err != nil {
+		return 0, err
+	}
+	n, err := c.Conn.Read(p)
+	return n, c.stalled(err)
+}
+
+// Write writes to the peer, failing once a deadline passes with no progress. A
+// peer that reads slowly, say under a rate limit, earns a fresh deadline for
+// each part of p it takes.
+func (c *timedConn) Write(p []byte) (int, error) {
+	written := 0
+	for {
+		if err := c.Conn.SetWriteDeadline(c.deadline()); err != nil {
+			return written, err
+		}
+		n, err := c.Conn.Write(p[written:])
+		written += n
+		if n > 0 && written < len(p) && errors.Is(err, os.ErrDeadlineExceeded) {
+			continue
+		}
+		return written, c.stalled(err)
+	}
+}
+
+// stalled names the limit behind a deadline error.
+func (c *timedConn) stalled(err error) error {
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		return err
+	}
+	switch {
+	case !c.end.IsZero() && !time.Now().Before(c.end):
+		return fmt.Errorf("connection exceeded its %s limit: %w", c.t.Total, err)
+	case !c.handshakeEnd.IsZero():
+		return fmt.Errorf("handshake did not finish within %s: %w", c.t.Handshake, err)
+	default:
+		return fmt.Errorf("peer stalled for %s: %w", c.t.Idle, err)
+	}
+}
+
+// readFrame reads the next frame, answering any PING on the way with a PONG and
+// skipping the PONGs that answer a dataReader's pings.
+func readFrame(reader *bufio.Reader, writer *bufio.Writer) (Frame, error) {
+	return nextFrame(reader, reader, writer, nil)
+}
+
+// nextFrame is readFrame reading from src, which draws from reader, with DATA
+// payloads paced through l.
+func nextFrame(src io.Reader, reader *bufio.Reader, writer *bufio.Writer, l *throttle.Limiter) (Frame, error) {
+	for {
+		frame, err := readPacedFrame(src, l)
+		if err != nil {
+			return frame, err
+		}
+		switch frame.Type {
+		case TypePing:
+			if err := WriteFrame(writer, Frame{Type: TypePong, Payload: frame.Payload}); err != nil {
+				return Frame{}, fmt.Errorf("send pong: %w", err)
+			}
+			if err := writer.Flush(); err != nil {
+				return Frame{}, fmt.Errorf("flush pong: %w", err)
+			}
+		case TypePong:
+		default:
+			return frame, nil
+		}
+	}
+}
+
+// dataReader reads the frames of a receive loop, taking DATA payloads at the pace
+// of the session's limit while control frames arrive as fast as they come. A
+// limited receiver drains the sender's socket slowly, so a sender that has
+// written everything could wait on the final reply for longer than its idle
+// timeout. While the limit holds, the receiver therefore pings a sender that
+// negotiated keepalives every keepaliveInterval, without waiting for the PONGs.
+type dataReader struct {
+	s    *receiverSession
+	seq  uint64
+	last time.Time
+}
+
+func newDataReader(s *receiverSession) *dataReader {
+	return &dataReader{s: s, last: time.Now()}
+}
+
+// next returns the next frame that is not a PING or PONG.
+func (d *dataReader) next() (Frame, error) {
+	return nextFrame(d, d.s.reader, d.s.writer, d.s.opts.Limit)
+}
+
+// Read pings the sender when one is due, then reads from the session.
+func (d *dataReader) Read(p []byte) (int, error) {
+	if d.s.hello.Has(CapKeepalive) && d.s.opts.Limit.Rate() != throttle.Unlimited && time.Since(d.last) >= keepaliveInterval {
+		d.last = time.Now()
+		d.seq++
+		if err := WriteFrame(d.s.writer, Frame{Type: TypePing, Payload: binary.BigEndian.AppendUint64(nil, d.seq)}); err != nil {
+			return 0, fmt.Errorf("send ping: %w", err)
+		}
+		if err := d.s.writer.Flush(); err != nil {
+			return 0, fmt.Errorf("flush ping: %w", err)
+		}
+	}
+	return d.s.reader.Read(p)
+}
+
+// startKeepalive pings the peer every keepaliveInterval while a local step, such
+// as a prompt or a long hash, keeps this side from sending. Each PING waits for
+// its PONG. The returned stop func ends the pings and reports whether the peer
+// went away; nothing else may use reader or writer until it returns. Peers that
+// did not negotiate CapKeepalive are never pinged.
+func startKeepalive(reader *bufio.Reader, writer *bufio.Writer, agreed HelloPayload) func() error {
+	if !agreed.Has(CapKeepalive) {
+		return func() error { return nil }
+	}
+	quit := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		ticker := time.NewTicker(keepaliveInterval)
+		defer ticker.Stop()
+		for seq := uint64(1); ; seq++ {
+			select {
+			case <-quit:
+				done <- nil
+				return
+			case <-ticker.C:
+			}
+			if err := ping(reader, writer, seq); err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+	return func() error {
+		close(quit)
+		return <-done
+	}
+}
+
+// keepalive runs work under startKeepalive and returns the first failure.
+func keepalive(reader *bufio.Reader, writer *bufio.Writer, agreed HelloPayload, work func() error) error {
+	stop := startKeepalive(reader, writer, agreed)
+	err := work()
+	if stopErr := stop(); err == nil {
+		err = stopErr
+	}
+	return err
+}
+
+// ping sends one PING and waits for the matching PONG.
+func ping(reader *bufio.Reader, writer *bufio.Writer, seq uint64) error {
+	payload := binary.BigEndian.AppendUint64(nil, seq)
+	if err := WriteFrame(writer, Frame{Type: TypePing, Payload: payload}); err != nil {
+		return fmt.Errorf("send ping: %w: %w", err, apperrors.ErrNetwork)
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("flush ping: %w: %w", err, apperrors.ErrNetwork)
+	}
+	var frame Frame
+	for {
+		var err error
+		if frame, err = ReadFrame(reader); err != nil {
+			return fmt.Errorf("read pong: %w: %w", err, apperrors.ErrNetwork)
+		}
+		// A limited peer pings while it drains data, and PONGs for this side's
+		// dataReader pings may still be on the way.
+		if frame.Type == TypePing {
+			if err := WriteFrame(writer, Frame{Type: TypePong, Payload: frame.Payload}); err != nil {
+				return fmt.Errorf("send pong: %w: %w", err, apperrors.ErrNetwork)
+			}
+			if err := writer.Flush(); err != nil {
+				return fmt.Errorf("flush pong: %w: %w", err, apperrors.ErrNetwork)
+			}
+			continue
+		}
+		if frame.Type != TypePong || bytes.Equal(frame.Payload, payload) {
+			break
+		}
+	}
+	switch {
+	case frame.Type == TypePong:
+		return nil
+	case frame.Type == TypeError:
+		// A streamed block may fail verification while the sender waits on its input.
+		msg, _ := DecodeError(frame.Payload)
+		if strings.Contains(msg, "integrity") {
+			return integrityError(frame.Payload)
+		}
+		return fmt.Errorf("peer failed while waiting: %s: %w", msg, apperrors.ErrRejected)
+	case frame.Type == TypeCancel:
+		return cancelError("peer", frame.Payload)
+	default:
+		return fmt.Errorf("expected PONG, got %d: %w", frame.Type, apperrors.ErrInvalidProtocol)
+	}
+}
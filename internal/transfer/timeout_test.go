@@ -0,0 +1,158 @@
+package transfer
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	apperrors "snapsync/internal/errors"
+	"snapsync/internal/identity"
+	"snapsync/internal/resume"
+	"snapsync/internal/throttle"
+)
+
+func TestReceiverDropsStalledSenderAndKeepsPartial(t *testing.T) {
+	dir := t.TempDir()
+	addr, done := startReceiver(t, ReceiverOptions{OutDir: dir, AutoAccept: true, Resume: true, Out: ioDiscard{}, Timeouts: Timeouts{Idle: 200 * time.Millisecond}})
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	_ = WriteFrame(conn, Frame{Type: TypeHello})
+	offer, _ := EncodeOffer("stall.bin", 3*MaxChunkSize, "stalled-session")
+	_ = WriteFrame(conn, Frame{Type: TypeOffer, Payload: offer})
+	if accept, err := ReadFrame(conn); err != nil || accept.Type != TypeAccept {
+		t.Fatalf("expected ACCEPT, got %+v err=%v", accept, err)
+	}
+	_ = WriteFrame(conn, Frame{Type: TypeData, Payload: make([]byte, MaxChunkSize)})
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, apperrors.ErrNetwork) || !strings.Contains(err.Error(), "stalled") {
+			t.Fatalf("expected a stall error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("receiver did not give up on a silent sender")
+	}
+	paths, _ := resume.ResolvePaths(dir, "stall.bin", false)
+	if meta, err := resume.LoadMeta(paths.Meta); err != nil || meta.ReceivedOffset != MaxChunkSize {
+		t.Fatalf("expected progress to be saved for resume, meta=%+v err=%v", meta, err)
+	}
+	if _, err := os.Stat(paths.Lock); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the lock to be released, stat err=%v", err)
+	}
+}
+
+func TestReceiverTimesOutSilentHandshake(t *testing.T) {
+	addr, done := startReceiver(t, ReceiverOptions{OutDir: t.TempDir(), AutoAccept: true, Out: ioDiscard{}, Timeouts: Timeouts{Handshake: 100 * time.Millisecond}})
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	select {
+	case err := <-done:
+		if !errors.Is(err, apperrors.ErrNetwork) || !strings.Contains(err.Error(), "handshake did not finish") {
+			t.Fatalf("expected a handshake timeout, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("receiver waited forever for HELLO")
+	}
+}
+
+func TestKeepaliveCoversSlowPrompt(t *testing.T) {
+	prev := keepaliveInterval
+	keepaliveInterval = 20 * time.Millisecond
+	defer func() { keepaliveInterval = prev }()
+	srcPath, _ := stripeSource(t, 1)
+	slowPrompt := func(string, uint64, string) (bool, error) {
+		time.Sleep(400 * time.Millisecond)
+		return true, nil
+	}
+	idle := Timeouts{Idle: 150 * time.Millisecond}
+
+	addr, done := startReceiver(t, ReceiverOptions{OutDir: t.TempDir(), Prompt: slowPrompt, Out: ioDiscard{}, Timeouts: idle})
+	if err := Send(SenderOptions{Path: srcPath, Address: addr, Out: ioDiscard{}, Timeouts: idle}); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("receiver error = %v", err)
+	}
+
+	// Keepalives do not extend the total limit.
+	addr, done = startReceiver(t, ReceiverOptions{OutDir: t.TempDir(), Prompt: slowPrompt, Out: ioDiscard{}, Timeouts: idle})
+	err := Send(SenderOptions{Path: srcPath, Address: addr, Out: ioDiscard{}, Timeouts: Timeouts{Idle: idle.Idle, Total: 200 * time.Millisecond}})
+	if !errors.Is(err, apperrors.ErrNetwork) || !strings.Contains(err.Error(), "exceeded its") {
+		t.Fatalf("expected the total limit to end the send, got %v", err)
+	}
+	<-done
+}
+
+func TestRateLimitsPaceTransfersWithoutStalling(t *testing.T) {
+	prev := keepaliveInterval
+	keepaliveInterval = 100 * time.Millisecond
+	defer func() { keepaliveInterval = prev }()
+	srcPath, data := stripeSource(t, 2)
+	idle := Timeouts{Idle: 500 * time.Millisecond}
+
+	outDir := t.TempDir()
+	addr, done := startReceiver(t, ReceiverOptions{OutDir: outDir, AutoAccept: true, Out: ioDiscard{}, Timeouts: idle, Limit: throttle.NewLimiter(1 << 20)})
+	start := time.Now()
+	if err := Send(SenderOptions{Path: srcPath, Address: addr, Out: ioDiscard{}, Timeouts: idle}); err != nil {
+		t.Fatalf("Send() to a limited receiver error = %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("receiver error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 1500*time.Millisecond {
+		t.Fatalf("2MiB at 1MiB/s took only %s", elapsed)
+	}
+	if got, _ := os.ReadFile(filepath.Join(outDir, filepath.Base(srcPath))); !bytes.Equal(got, data) {
+		t.Fatal("received file differs from source")
+	}
+
+	addr, done = startReceiver(t, ReceiverOptions{OutDir: t.TempDir(), AutoAccept: true, Out: ioDiscard{}, Timeouts: idle})
+	start = time.Now()
+	if err := Send(SenderOptions{Path: srcPath, Address: addr, Out: ioDiscard{}, Timeouts: idle, Limit: throttle.NewLimiter(4 << 20)}); err != nil {
+		t.Fatalf("limited Send() error = %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("receiver error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Fatalf("2MiB at 4MiB/s took only %s", elapsed)
+	}
+}
+
+func TestRateLimitSkipsHandshake(t *testing.T) {
+	srcPath := filepath.Join(t.TempDir(), "slow.bin")
+	data := bytes.Repeat([]byte("z"), 1500)
+	if err := os.WriteFile(srcPath, data, 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	receiverID, _ := identity.Generate("receiver")
+	senderID, _ := identity.Generate("sender")
+	// At 1000 B/s the secure handshake alone would outlast this timeout if it were paced.
+	fast := Timeouts{Handshake: 100 * time.Millisecond}
+	outDir := t.TempDir()
+	addr, done := startReceiver(t, ReceiverOptions{OutDir: outDir, AutoAccept: true, Out: ioDiscard{}, Identity: &receiverID, Timeouts: fast})
+	start := time.Now()
+	if err := Send(SenderOptions{Path: srcPath, Address: addr, Out: ioDiscard{}, Identity: &senderID, Timeouts: fast, Limit: throttle.NewLimiter(1000)}); err != nil {
+		t.Fatalf("limited Send() error = %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("receiver error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Fatalf("1500 bytes at 1000 B/s took only %s", elapsed)
+	}
+	if got, _ := os.ReadFile(filepath.Join(outDir, "slow.bin")); !bytes.Equal(got, data) {
+		t.Fatal("received file differs from source")
+	}
+}